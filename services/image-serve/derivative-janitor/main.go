@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/okebinda/internal/awsconfig"
+	"github.com/okebinda/internal/keys"
+	"github.com/okebinda/internal/logging"
+	"github.com/okebinda/internal/storage"
+	"go.uber.org/zap"
+)
+
+var logger *zap.SugaredLogger
+
+// pageSize is the number of objects listed, and deleted, at a time
+const pageSize = 1000
+
+// deadlineMargin is how long before the function times out the janitor stops, leaving the rest to its next run
+const deadlineMargin = 10 * time.Second
+
+// defaultOperations are the operations whose derivatives are checked if CLEANUP_OPERATIONS is not set
+const defaultOperations = "ratio,crop"
+
+// Buckets defines a cache bucket of derivatives and the buckets their source images are read from
+type Buckets struct {
+	Destination string
+	Source      string
+	Fallback    string
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call, on a schedule
+func Handler(ctx context.Context, event events.CloudWatchEvent) error {
+
+	// initialize logger
+	lc, _ := lambdacontext.FromContext(ctx)
+	logger = logging.New(lc.AwsRequestID)
+	defer logger.Sync()
+
+	// get environment parameters
+	derivativeTemplate, err := keys.FromEnv("DERIVATIVE_KEY_TEMPLATE", keys.DefaultDerivativeTemplate, keys.DerivativeVariables)
+	if err != nil {
+		logger.Errorf("Could not parse DERIVATIVE_KEY_TEMPLATE: %v", err)
+		return err
+	}
+	operations, err := cleanupOperations()
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	targets := []Buckets{{
+		Destination: os.Getenv("AWS_S3_BUCKET_DESTINATION"),
+		Source:      os.Getenv("AWS_S3_BUCKET_SOURCE"),
+		Fallback:    os.Getenv("AWS_S3_BUCKET_FALLBACK"),
+	}}
+	if sandboxDestination := os.Getenv("SANDBOX_AWS_S3_BUCKET_DESTINATION"); sandboxDestination != "" {
+		targets = append(targets, Buckets{
+			Destination: sandboxDestination,
+			Source:      os.Getenv("SANDBOX_AWS_S3_BUCKET_SOURCE"),
+		})
+	}
+	for _, buckets := range targets {
+		// without a source bucket every derivative would look orphaned
+		if buckets.Destination == "" || buckets.Source == "" {
+			err = fmt.Errorf("source and destination buckets must both be set: %s, %s", buckets.Source, buckets.Destination)
+			logger.Error(err)
+			return err
+		}
+	}
+
+	// initialize S3 client
+	svc := storage.Client(awsconfig.NewContextSession(ctx))
+
+	for _, buckets := range targets {
+		for _, operation := range operations {
+			matcher, err := derivativeTemplate.Matcher(map[string]string{"operation": operation}, "size", "key")
+			if err != nil {
+				logger.Errorf("Could not parse DERIVATIVE_KEY_TEMPLATE: %v", err)
+				return err
+			}
+			purged, complete, err := purgeOrphans(ctx, svc, buckets, matcher)
+			logger.Infow("Orphaned derivatives purged.",
+				"bucket", buckets.Destination,
+				"operation", operation,
+				"purged", purged,
+				"complete", complete,
+			)
+			if err != nil {
+				logger.Errorf("Failed to purge orphaned derivatives: %s, %s, %v", buckets.Destination, operation, err)
+				return err
+			}
+			if !complete {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// purgeOrphans deletes the derivatives of an operation in a cache bucket whose source image no longer exists,
+// returning the number deleted, and false if the function ran out of time before the whole operation was listed
+func purgeOrphans(ctx context.Context, svc s3iface.S3API, buckets Buckets, matcher *keys.Matcher) (int, bool, error) {
+	purged := 0
+	token := ""
+
+	// many derivatives share a source, so each source is checked once per run
+	exists := map[string]bool{}
+	for {
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < deadlineMargin {
+			return purged, false, nil
+		}
+		objects, next, err := storage.ListObjects(svc, buckets.Destination, matcher.Prefix(), token, pageSize)
+		if err != nil {
+			return purged, true, err
+		}
+		var objectKeys []string
+		for _, object := range objects {
+			values, ok := matcher.Match(object.Key)
+			if !ok {
+				continue
+			}
+			imageKey := values["key"]
+			found, checked := exists[imageKey]
+			if !checked {
+				if found, err = sourceExists(svc, buckets, imageKey); err != nil {
+					return purged, true, err
+				}
+				exists[imageKey] = found
+			}
+			if !found {
+				objectKeys = append(objectKeys, object.ObjectKey)
+			}
+		}
+		if err = storage.DeleteObjects(svc, buckets.Destination, objectKeys); err != nil {
+			return purged, true, err
+		}
+		purged += len(objectKeys)
+		if next == "" {
+			return purged, true, nil
+		}
+		token = next
+	}
+}
+
+// sourceExists tests if a derivative's source image exists in the source bucket, or in the fallback bucket, from
+// which image-serve copies it forward when it is next requested
+func sourceExists(svc s3iface.S3API, buckets Buckets, imageKey string) (bool, error) {
+	for _, bucketName := range []string{buckets.Source, buckets.Fallback} {
+		if bucketName == "" {
+			continue
+		}
+		_, err := storage.HeadObject(svc, bucketName, imageKey)
+		if err == nil {
+			return true, nil
+		}
+		if !storage.IsNotFound(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// cleanupOperations returns the derivative operations in the CLEANUP_OPERATIONS env parameter (comma separated),
+// or ratio and crop if it is not set
+func cleanupOperations() ([]string, error) {
+	value := os.Getenv("CLEANUP_OPERATIONS")
+	if value == "" {
+		value = defaultOperations
+	}
+	var operations []string
+	for _, operation := range strings.Split(value, ",") {
+		operation = strings.TrimSpace(operation)
+		if !knownOperation(operation) {
+			return nil, fmt.Errorf("CLEANUP_OPERATIONS: unknown operation: %s, allowed: %s", operation, strings.Join(keys.DerivativeOperations, ", "))
+		}
+		operations = append(operations, operation)
+	}
+	return operations, nil
+}
+
+// knownOperation tests if an operation is one image-serve caches derivatives under
+func knownOperation(operation string) bool {
+	for _, known := range keys.DerivativeOperations {
+		if operation == known {
+			return true
+		}
+	}
+	return false
+}
+
+func main() {
+	lambda.Start(Handler)
+}
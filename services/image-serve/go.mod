@@ -8,5 +8,8 @@ require (
 	github.com/awslabs/aws-lambda-go-api-proxy v0.9.0
 	github.com/disintegration/imaging v1.6.2
 	github.com/go-chi/chi v4.1.2+incompatible
+	github.com/okebinda/internal v0.0.0-00010101000000-000000000000
 	go.uber.org/zap v1.16.0
 )
+
+replace github.com/okebinda/internal => ../internal
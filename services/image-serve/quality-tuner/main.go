@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/okebinda/internal/analytics"
+	"github.com/okebinda/internal/awsconfig"
+	"github.com/okebinda/internal/config"
+	"github.com/okebinda/internal/imageproc"
+	"github.com/okebinda/internal/logging"
+	"go.uber.org/zap"
+)
+
+var logger *zap.SugaredLogger
+
+// service is the service whose encoder quality is tuned, as it records its metrics
+const service = "image-serve"
+
+// Bounds defines the lowest and highest quality a file type may be tuned to
+type Bounds struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// defaultBounds are the file types tuned, and their bounds, if QUALITY_BOUNDS is not set
+var defaultBounds = map[string]Bounds{"image/jpeg": {Min: 60, Max: 90}}
+
+// Tuning defines how qualities are tuned: to keep the mean SSIM of encoded images at least Target, lowering the
+// quality by Step while it stays above Target plus Tolerance, once MinSamples images were measured at a quality
+type Tuning struct {
+	Target     float64
+	Tolerance  float64
+	Step       int
+	MinSamples float64
+}
+
+// Stats defines the measurements of the images encoded at a quality
+type Stats struct {
+	Samples       float64
+	SSIM          float64
+	BytesPerPixel float64
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call, on a schedule
+func Handler(ctx context.Context, event events.CloudWatchEvent) error {
+
+	// initialize logger
+	lc, _ := lambdacontext.FromContext(ctx)
+	logger = logging.New(lc.AwsRequestID)
+	defer logger.Sync()
+
+	// get environment parameters
+	source := os.Getenv("QUALITY_CONFIG")
+	if source == "" {
+		logger.Info("QUALITY_CONFIG is not set, nothing to tune.")
+		return nil
+	}
+	namespace := os.Getenv("METRICS_NAMESPACE")
+	bounds := defaultBounds
+	if document := os.Getenv("QUALITY_BOUNDS"); document != "" {
+		bounds = map[string]Bounds{}
+		if err := json.Unmarshal([]byte(document), &bounds); err != nil {
+			logger.Errorf("Could not parse QUALITY_BOUNDS: %v", err)
+			return err
+		}
+	}
+	for fileType, b := range bounds {
+		if !imageproc.IsLossy(fileType) || b.Min < 1 || b.Min > b.Max || b.Max > 100 {
+			err := fmt.Errorf("QUALITY_BOUNDS: %s: must be a lossy type with 1 <= min <= max <= 100", fileType)
+			logger.Error(err)
+			return err
+		}
+	}
+	windowHours, err := strconv.Atoi(os.Getenv("WINDOW_HOURS"))
+	if err != nil {
+		logger.Errorf("Could not convert WINDOW_HOURS to int: %v", err)
+		return err
+	}
+	var tuning Tuning
+	if tuning.Target, err = strconv.ParseFloat(os.Getenv("TARGET_SSIM"), 64); err != nil {
+		logger.Errorf("Could not convert TARGET_SSIM to float64: %v", err)
+		return err
+	}
+	if tuning.Tolerance, err = strconv.ParseFloat(os.Getenv("SSIM_TOLERANCE"), 64); err != nil {
+		logger.Errorf("Could not convert SSIM_TOLERANCE to float64: %v", err)
+		return err
+	}
+	if tuning.Step, err = strconv.Atoi(os.Getenv("QUALITY_STEP")); err != nil || tuning.Step < 1 {
+		err = fmt.Errorf("could not convert QUALITY_STEP to a positive int: %s", os.Getenv("QUALITY_STEP"))
+		logger.Error(err)
+		return err
+	}
+	if tuning.MinSamples, err = strconv.ParseFloat(os.Getenv("MIN_SAMPLES"), 64); err != nil {
+		logger.Errorf("Could not convert MIN_SAMPLES to float64: %v", err)
+		return err
+	}
+
+	// initialize AWS session
+	sess := awsconfig.NewContextSession(ctx)
+	svc := cloudwatch.New(sess)
+
+	// read the qualities chosen last time, if any
+	qualities := map[string]int{}
+	document, err := config.Load(sess, source)
+	if err == nil {
+		err = json.Unmarshal(document, &qualities)
+	}
+	if err != nil && !config.IsNotFound(err) {
+		logger.Errorf("Could not load encoder qualities: %v", err)
+		return err
+	}
+
+	// tune each file type from the images measured over the window
+	end := time.Now().Truncate(time.Minute)
+	start := end.Add(-time.Duration(windowHours) * time.Hour)
+	changed := false
+	for fileType, b := range bounds {
+		current, ok := qualities[fileType]
+		if !ok {
+			current = imageproc.DefaultQuality
+		}
+		current = clamp(current, b)
+		measure := func(quality int) (Stats, error) {
+			return qualityStats(svc, namespace, fileType, quality, start, end)
+		}
+		next, stats, err := tune(measure, current, b, tuning)
+		if err != nil {
+			logger.Errorf("Failed to read quality metrics: %s, %v", fileType, err)
+			return err
+		}
+
+		logger.Infow("Encoder quality tuned.",
+			"file_type", fileType,
+			"quality", current,
+			"samples", stats.Samples,
+			"ssim", stats.SSIM,
+			"bytes_per_pixel", stats.BytesPerPixel,
+			"next_quality", next,
+		)
+
+		if existing, ok := qualities[fileType]; !ok || existing != next {
+			qualities[fileType] = next
+			changed = true
+		}
+		if err = analytics.RecordQuality(service, fileType, next); err != nil {
+			logger.Errorf("Error recording quality metric: %s", err)
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+	document, err = json.Marshal(qualities)
+	if err != nil {
+		return err
+	}
+	if err = config.Save(sess, source, document); err != nil {
+		logger.Errorf("Failed to save encoder qualities: %v", err)
+		return err
+	}
+	return nil
+}
+
+// tune chooses the next quality for a file type from the measurements of images encoded at the current quality:
+// raised by a step if their mean SSIM is below the target, lowered by a step if it is above the target plus the
+// tolerance, unless images already measured at the lower quality fell below the target, or else kept; returns
+// the next quality and the current quality's measurements
+func tune(measure func(int) (Stats, error), current int, b Bounds, tuning Tuning) (int, Stats, error) {
+	stats, err := measure(current)
+	if err != nil || stats.Samples < tuning.MinSamples {
+		return current, stats, err
+	}
+	switch {
+	case stats.SSIM < tuning.Target:
+		return clamp(current+tuning.Step, b), stats, nil
+	case stats.SSIM > tuning.Target+tuning.Tolerance:
+		lower := clamp(current-tuning.Step, b)
+		if lower == current {
+			return current, stats, nil
+		}
+		lowerStats, err := measure(lower)
+		if err != nil {
+			return current, stats, err
+		}
+		if lowerStats.Samples >= tuning.MinSamples && lowerStats.SSIM < tuning.Target {
+			return current, stats, nil
+		}
+		return lower, stats, nil
+	}
+	return current, stats, nil
+}
+
+// clamp limits a quality to its bounds
+func clamp(quality int, b Bounds) int {
+	if quality < b.Min {
+		return b.Min
+	}
+	if quality > b.Max {
+		return b.Max
+	}
+	return quality
+}
+
+// qualityStats reads the number, mean SSIM, and mean bytes per pixel of the images of a file type encoded at a
+// quality over a time range
+func qualityStats(svc *cloudwatch.CloudWatch, namespace, fileType string, quality int, start, end time.Time) (Stats, error) {
+	dimensions := []*cloudwatch.Dimension{
+		{Name: aws.String("Service"), Value: aws.String(service)},
+		{Name: aws.String("FileType"), Value: aws.String(fileType)},
+		{Name: aws.String("Quality"), Value: aws.String(strconv.Itoa(quality))},
+	}
+	var stats Stats
+	for _, metricName := range []string{"SSIM", "BytesPerPixel"} {
+		output, err := svc.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
+			Namespace:  aws.String(namespace),
+			MetricName: aws.String(metricName),
+			Dimensions: dimensions,
+			StartTime:  aws.Time(start),
+			EndTime:    aws.Time(end),
+			Period:     aws.Int64(int64(end.Sub(start).Seconds())),
+			Statistics: []*string{aws.String(cloudwatch.StatisticAverage), aws.String(cloudwatch.StatisticSampleCount)},
+		})
+		if err != nil {
+			return Stats{}, err
+		}
+
+		// weigh the mean of each datapoint by its samples
+		var samples, sum float64
+		for _, datapoint := range output.Datapoints {
+			count := aws.Float64Value(datapoint.SampleCount)
+			samples += count
+			sum += aws.Float64Value(datapoint.Average) * count
+		}
+		if samples == 0 {
+			continue
+		}
+		if metricName == "SSIM" {
+			stats.Samples, stats.SSIM = samples, sum/samples
+		} else {
+			stats.BytesPerPixel = sum / samples
+		}
+	}
+	return stats, nil
+}
+
+func main() {
+	lambda.Start(Handler)
+}
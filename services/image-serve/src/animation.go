@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/okebinda/internal/analytics"
+	"github.com/okebinda/internal/httpresp"
+	"github.com/okebinda/internal/imageproc"
+	"github.com/okebinda/internal/problem"
+)
+
+// animationResponse rejects GIF source images whose frame count, decoded pixels, or duration exceed the limits
+// in the ANIMATION_MAX_FRAMES, ANIMATION_MAX_PIXELS, and ANIMATION_MAX_DURATION_MS env parameters with a 422,
+// before any frames are decoded; returns true if the request was rejected
+func animationResponse(w http.ResponseWriter, sess *session.Session, bucket, imageKey, localFile string) bool {
+	limits, err := imageproc.AnimationLimitsFromEnv()
+	if err != nil {
+		logger.Errorf("Could not read animation limits: %v", err)
+		serverErrorResponse(w)
+		return true
+	}
+	animation, err := imageproc.ScanGIF(localFile)
+	if err != nil {
+		logger.Infof("Could not scan GIF: %v", err)
+		return false
+	}
+	if err = limits.Check(animation); err == nil {
+		return false
+	}
+
+	logger.Errorf("Animation is too large: %v", err)
+	recordEvent(sess, analytics.Event{
+		EventType: analytics.EventDerivativeRejected,
+		Bucket:    bucket,
+		FileKey:   imageKey,
+		FileType:  "image/gif",
+		Reason:    "animation_too_large",
+	})
+	err = httpresp.Reject(w, language, requestID, 422, problem.AnimationTooLarge, fmt.Sprintf("Animation is too large: %v", err), map[string]interface{}{
+		"frames":      animation.Frames,
+		"pixels":      animation.Pixels,
+		"duration_ms": animation.Duration.Milliseconds(),
+	})
+	if err != nil {
+		logger.Errorf("Error generating response: %s", err)
+	}
+	return true
+}
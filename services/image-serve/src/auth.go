@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/awslabs/aws-lambda-go-api-proxy/core"
+	"github.com/okebinda/internal/auth"
+	"github.com/okebinda/internal/httpresp"
+	"github.com/okebinda/internal/problem"
+)
+
+// authProviders maps the values of the SERVE_AUTH env parameter to the constructors of their providers
+var authProviders = map[string]func() (auth.Provider, error){
+	"none":      newNoneProvider,
+	"signature": newSignatureProvider,
+	"jwt":       newJWTProvider,
+	"cognito":   newCognitoProvider,
+}
+
+// newNoneProvider configures serving every request
+func newNoneProvider() (auth.Provider, error) {
+	return auth.None{}, nil
+}
+
+// newSignatureProvider configures requiring URLs signed with the secret in the URL_SIGNING_SECRET env parameter
+func newSignatureProvider() (auth.Provider, error) {
+	secret := os.Getenv("URL_SIGNING_SECRET")
+	if secret == "" {
+		return nil, fmt.Errorf("URL_SIGNING_SECRET is not set")
+	}
+	return auth.Signature{Secret: secret}, nil
+}
+
+// newJWTProvider configures requiring tokens signed with the secret in the JWT_SECRET env parameter (HS256) or
+// the PEM encoded key in JWT_PUBLIC_KEY (RS256), issued by JWT_ISSUER and for JWT_AUDIENCE, if set, and valid for
+// at most JWT_MAX_LIFETIME_MINUTES (default 1440)
+func newJWTProvider() (auth.Provider, error) {
+	maxLifetimeMinutes, err := envInt("JWT_MAX_LIFETIME_MINUTES", int(auth.DefaultMaxTokenLifetime/time.Minute))
+	if err != nil || maxLifetimeMinutes < 1 {
+		return nil, fmt.Errorf("could not convert JWT_MAX_LIFETIME_MINUTES to a positive int: %s", os.Getenv("JWT_MAX_LIFETIME_MINUTES"))
+	}
+	provider := auth.JWT{
+		Secret:      os.Getenv("JWT_SECRET"),
+		Issuer:      os.Getenv("JWT_ISSUER"),
+		Audience:    os.Getenv("JWT_AUDIENCE"),
+		MaxLifetime: time.Duration(maxLifetimeMinutes) * time.Minute,
+	}
+	if publicKey := os.Getenv("JWT_PUBLIC_KEY"); publicKey != "" {
+		key, err := auth.ParseRSAPublicKey(publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse JWT_PUBLIC_KEY: %v", err)
+		}
+		provider.PublicKey = key
+	}
+	if provider.Secret == "" && provider.PublicKey == nil {
+		return nil, fmt.Errorf("neither JWT_SECRET nor JWT_PUBLIC_KEY is set")
+	}
+	return provider, nil
+}
+
+// newCognitoProvider configures requiring the claims of an API Gateway Cognito authorizer, of a user in one of the
+// groups in the COGNITO_REQUIRED_GROUPS env parameter (comma-separated), if set
+func newCognitoProvider() (auth.Provider, error) {
+	provider := auth.Cognito{Claims: authorizerClaims}
+	for _, group := range strings.Split(os.Getenv("COGNITO_REQUIRED_GROUPS"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			provider.Groups = append(provider.Groups, group)
+		}
+	}
+	return provider, nil
+}
+
+// authorizerClaims reads the claims the API Gateway authorizer verified, if any
+func authorizerClaims(r *http.Request) map[string]interface{} {
+	ctx, ok := core.GetAPIGatewayContextFromContext(r.Context())
+	if !ok {
+		return nil
+	}
+	claims, _ := ctx.Authorizer["claims"].(map[string]interface{})
+	return claims
+}
+
+// authProvider returns the name of the provider selected by the SERVE_AUTH env parameter, "none" by default, or
+// "signature" if URL_SIGNATURE_REQUIRED is "true"
+func authProvider() string {
+	if name := os.Getenv("SERVE_AUTH"); name != "" {
+		return name
+	}
+	if os.Getenv("URL_SIGNATURE_REQUIRED") == "true" {
+		return "signature"
+	}
+	return "none"
+}
+
+// authenticate rejects requests the provider selected by the SERVE_AUTH env parameter denies, so only clients
+// the application authorizes can generate images
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authenticated(w, r, authProvider()); ok {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// originalAuthProvider returns the name of the provider selected by the ORIGINAL_AUTH env parameter, or else the
+// one selected for other requests
+func originalAuthProvider() string {
+	if name := os.Getenv("ORIGINAL_AUTH"); name != "" {
+		return name
+	}
+	return authProvider()
+}
+
+// authenticateOriginal rejects requests for original images the provider selected by the ORIGINAL_AUTH env
+// parameter denies, identifying the principal of those it allows; originals are never served unauthenticated, so
+// they are not found if the provider is "none"
+func authenticateOriginal(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := originalAuthProvider()
+		if name == "none" {
+			logger.Info("Originals are not served without authentication, set ORIGINAL_AUTH.")
+			userErrorResponse(w, 404, problem.NotFound, "Not found.")
+			return
+		}
+		provider, ok := authenticated(w, r, name)
+		if !ok {
+			return
+		}
+		principal = ""
+		if identifier, ok := provider.(auth.Identifier); ok {
+			principal = identifier.Subject(r)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticated authenticates a request with a provider, returning it, and false if the request was denied or
+// could not be authenticated and a response has been written
+func authenticated(w http.ResponseWriter, r *http.Request, name string) (auth.Provider, bool) {
+	newProvider, ok := authProviders[name]
+	if !ok {
+		logger.Errorf("Unknown auth provider: %s", name)
+		serverErrorResponse(w)
+		return nil, false
+	}
+	provider, err := newProvider()
+	if err != nil {
+		logger.Errorf("Could not configure %s authentication: %v", name, err)
+		serverErrorResponse(w)
+		return nil, false
+	}
+	if err = provider.Authenticate(r); err != nil {
+		denial, ok := err.(auth.Denial)
+		if !ok {
+			logger.Errorf("Could not authenticate request: %v", err)
+			serverErrorResponse(w)
+			return nil, false
+		}
+		logger.Infow("Request denied.",
+			"auth", name,
+			"path", r.URL.Path,
+			"problem", denial.Problem,
+		)
+		if err := httpresp.Reject(w, language, requestID, denial.Code, denial.Problem, denial.Message, nil); err != nil {
+			logger.Errorf("Error generating response: %s", err)
+		}
+		return nil, false
+	}
+	return provider, true
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/okebinda/internal/analytics"
+	"github.com/okebinda/internal/imageproc"
+	"github.com/okebinda/internal/keys"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/storage"
+)
+
+// derivative describes how to generate a derived image: its transformation and, optionally, the size to name it
+// after if it differs from the requested size, and the format (mime type) and JPEG quality to encode it with
+type derivative struct {
+	transform func(image.Image) image.Image
+	size      string
+	format    string
+	quality   int
+}
+
+// parameterError is an operation parameter rejected by parse with a more specific problem than a bad parameter
+// format; its message is returned to the user as it is
+type parameterError struct {
+	problem string
+	message string
+}
+
+func (e *parameterError) Error() string {
+	return e.message
+}
+
+// deriveImage transforms an image by an operation taking a single path parameter, e.g. /width/400/{key}, and
+// saves it to an S3 bucket; parse validates the parameter and returns the derivative, or an error message to
+// return to the user, or a *parameterError
+func (s *Service) deriveImage(w http.ResponseWriter, r *http.Request, operation string, parse func(string) (derivative, error)) {
+
+	// get environment parameters
+	derivativeTemplate, err := keys.FromEnv("DERIVATIVE_KEY_TEMPLATE", keys.DefaultDerivativeTemplate, keys.DerivativeVariables)
+	if err != nil {
+		logger.Errorf("Could not parse DERIVATIVE_KEY_TEMPLATE: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+	sourceBucket := os.Getenv("AWS_S3_BUCKET_SOURCE")
+	destinationBucket := os.Getenv("AWS_S3_BUCKET_DESTINATION")
+	region := os.Getenv("REGION")
+	keyValidation, err := keys.ValidationFromEnv()
+	if err != nil {
+		logger.Errorf("Could not read KEY_VALIDATION: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+
+	// get path parameters
+	size := chi.URLParam(r, "size")
+
+	// get path parameters (chi doesn't support greedy path parameters)
+	rePath := regexp.MustCompile(fmt.Sprintf(`^/%s/[^/]+/`, operation))
+	imageKey := rePath.ReplaceAllString(r.URL.Path, "")
+
+	logger.Infow("Request parameters",
+		"size", size,
+		"imageKey", imageKey,
+	)
+
+	// simple sanity check
+	if size == "" || imageKey == "" {
+		errorMessage := fmt.Sprintf("Missing parameters, cannot complete request; size: %s, image_key: %s", size, imageKey)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.MissingParameters, errorMessage)
+		return
+	}
+
+	// check key format
+	if err = keys.Validate(imageKey, keyValidation); err != nil {
+		errorMessage := fmt.Sprintf("Bad parameter format, cannot complete request; image_key: %v", err)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterFormat, errorMessage)
+		return
+	}
+
+	// reject geo-restricted requests
+	if geoResponse(w, r, imageKey) {
+		return
+	}
+
+	// serve sandbox images from the sandbox buckets
+	sourceBucket, destinationBucket, ok := sandboxBuckets(w, imageKey, sourceBucket, destinationBucket)
+	if !ok {
+		return
+	}
+
+	// reject hotlinked requests, or watermark them
+	watermark, rejected := s.hotlinkResponse(w, r)
+	if rejected {
+		return
+	}
+
+	// check operation parameter
+	d, err := parse(size)
+	if perr, ok := err.(*parameterError); ok {
+		logger.Error(perr.message)
+		userErrorResponse(w, 400, perr.problem, perr.message)
+		return
+	}
+	if err != nil {
+		errorMessage := fmt.Sprintf("Bad parameter format, cannot complete request; %s: %s, %v", operation, size, err)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterFormat, errorMessage)
+		return
+	}
+	if d.size != "" {
+		size = d.size
+	}
+
+	// check chained transformations
+	suffix, chain, err := queryTransforms(r)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Bad parameter value, cannot complete request; %v", err)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterValue, errorMessage)
+		return
+	}
+	page, pageSuffix, err := queryPage(r)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Bad parameter value, cannot complete request; %v", err)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterValue, errorMessage)
+		return
+	}
+	suffix += pageSuffix
+	if watermark {
+		suffix += watermarkSuffix
+	}
+
+	// initialize AWS session
+	sess := s.Session
+
+	// assign file names
+	resizedFileKey, err := derivativeTemplate.Render(map[string]string{
+		"operation": operation,
+		"size":      size + suffix,
+		"key":       imageKey,
+	})
+	if err != nil {
+		errorMessage := fmt.Sprintf("Could not generate derivative key: %v", err)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.DerivativeKeyFailed, errorMessage)
+		return
+	}
+	localFile := fmt.Sprintf("/tmp/%s", filepath.Base(imageKey))
+	redirectURL, err := s.derivativeURL(destinationBucket, resizedFileKey, region)
+	if err != nil {
+		logger.Errorf("Could not generate derivative URL: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+
+	// copy the source image forward if it is only in the fallback bucket
+	if !s.fallbackSource(w, sourceBucket, imageKey) {
+		return
+	}
+
+	// reject embargoed images, before any cached derivative of them is served
+	if s.embargoResponse(w, sourceBucket, imageKey) {
+		return
+	}
+
+	// serve previously resized image
+	if s.cachedResponse(w, r, destinationBucket, resizedFileKey, redirectURL) {
+		return
+	}
+
+	// reject encrypted files, which can only be downloaded
+	if s.encryptedResponse(w, sourceBucket, imageKey) {
+		return
+	}
+
+	// generate each derivative once, letting concurrent requests wait for it
+	unlock, ok := s.derivativeLock(w, r, destinationBucket, resizedFileKey, redirectURL)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	// limit concurrent processing of the source image
+	release, ok := sourceSlot(w, sess, sourceBucket, imageKey)
+	if !ok {
+		return
+	}
+	defer release()
+
+	// create local temp file
+	file, err := os.Create(localFile)
+	if err != nil {
+		logger.Errorf("os.Create() error: %s", err)
+		serverErrorResponse(w)
+		return
+	}
+
+	// download file from S3
+	_, err = storage.DownloadFile(s.S3, file, sourceBucket, imageKey)
+	if err != nil {
+		logger.Errorf("S3 downloader error: %s, %s", imageKey, err)
+		close(file)
+		if storage.IsNotFound(err) {
+			notFoundResponse(w, sess, imageKey)
+			return
+		}
+		serverErrorResponse(w)
+		return
+	}
+
+	// detect file type
+	fileType, err := imageproc.GetFileType(file)
+	if err != nil {
+		logger.Errorf("File read error: %s", err)
+		close(file)
+		serverErrorResponse(w)
+		return
+	}
+
+	// reject bad file types
+	if !imageproc.IsValidFormat(fileType) {
+		errorMessage := fmt.Sprintf("Unsupported file type: %s", fileType)
+		logger.Error(errorMessage)
+		close(file)
+		recordEvent(sess, analytics.Event{
+			EventType: analytics.EventDerivativeRejected,
+			Bucket:    sourceBucket,
+			FileKey:   imageKey,
+			FileType:  fileType,
+			Reason:    "unsupported_file_type",
+		})
+		userErrorResponse(w, 400, problem.UnsupportedType, errorMessage)
+		return
+	}
+
+	// reject images too large to decode
+	if pixelsResponse(w, sess, sourceBucket, imageKey, localFile, fileType) {
+		close(file)
+		return
+	}
+
+	// reject animations too large to decode
+	if imageproc.IsGIF(fileType) && animationResponse(w, sess, sourceBucket, imageKey, localFile) {
+		close(file)
+		return
+	}
+
+	// open image, or the requested page
+	img, err := imageproc.OpenPage(localFile, fileType, page)
+	if err != nil {
+		close(file)
+		pageResponse(w, err)
+		return
+	}
+
+	// load watermark for hotlinked requests
+	if watermark {
+		chain, err = s.watermarked(sourceBucket, chain)
+		if err != nil {
+			logger.Errorf("Failed to load watermark: %v", err)
+			close(file)
+			serverErrorResponse(w)
+			return
+		}
+	}
+
+	// transform image, converting it to the derivative's format
+	var width, height int
+	derive := func(img image.Image) image.Image {
+		derived := chain(d.transform(img))
+		width, height = imageproc.Dimensions(derived)
+		return derived
+	}
+	outputFile, outputType := localFile, fileType
+	if d.format != "" && d.format != fileType {
+		outputFile, outputType = localFile+imageproc.Extension(d.format), d.format
+	}
+	var quality int
+	var ssim float64
+	if imageproc.IsGIF(fileType) && imageproc.IsGIF(outputType) && page == 0 {
+		err = imageproc.TransformGIFFile(localFile, derive)
+	} else {
+		quality, ssim, err = s.saveTuned(derive(img), outputFile, outputType, d.quality)
+	}
+	if err != nil {
+		logger.Errorf("Failed to transform image: %v", err)
+		close(file)
+		serverErrorResponse(w)
+		return
+	}
+	if outputFile != localFile {
+		close(file)
+		file, err = os.Open(outputFile)
+		if err != nil {
+			logger.Errorf("os.Open() error: %s", err)
+			serverErrorResponse(w)
+			return
+		}
+	}
+
+	// upload to public bucket
+	err = s.uploadDerivative(file, destinationBucket, resizedFileKey, outputType)
+	if err != nil {
+		logger.Errorf("Failed to upload file: %s, %v", resizedFileKey, err)
+		close(file)
+		serverErrorResponse(w)
+		return
+	}
+
+	var sizeBytes int64
+	if info, err := file.Stat(); err == nil {
+		sizeBytes = info.Size()
+	}
+
+	logger.Infow("Image transform complete.",
+		"bucket", destinationBucket,
+		"file_key", resizedFileKey,
+		"width", width,
+		"height", height,
+		"quality", quality,
+		"ssim", ssim,
+	)
+
+	close(file)
+
+	recordEvent(sess, analytics.Event{
+		EventType: analytics.EventDerivativeGenerated,
+		Bucket:    destinationBucket,
+		FileKey:   resizedFileKey,
+		FileType:  outputType,
+		SizeBytes: sizeBytes,
+		Width:     width,
+		Height:    height,
+		Quality:   quality,
+		SSIM:      ssim,
+	})
+
+	// response
+	imageResponse(w, r, redirectURL, outputFile, outputType)
+}
+
+// queryTransforms parses the optional rotate and flip query parameters, applied in that order after an
+// operation, returning the derivative key suffix naming them, e.g. "-r90-fh" for "?rotate=90&flip=horizontal",
+// and the transformation applying them
+func queryTransforms(r *http.Request) (string, func(image.Image) image.Image, error) {
+	rotate := r.URL.Query().Get("rotate")
+	flip := r.URL.Query().Get("flip")
+
+	var degrees int
+	var suffix []string
+	if rotate != "" {
+		var err error
+		degrees, err = strconv.Atoi(rotate)
+		if err != nil || !imageproc.IsValidRotation(degrees) {
+			return "", nil, fmt.Errorf("rotate: %s, must be 90, 180, or 270", rotate)
+		}
+		suffix = append(suffix, fmt.Sprintf("r%d", degrees))
+	}
+	if flip != "" {
+		if !imageproc.IsValidFlip(flip) {
+			return "", nil, fmt.Errorf("flip: %s, must be horizontal or vertical", flip)
+		}
+		suffix = append(suffix, "f"+flip[:1])
+	}
+
+	transform := func(img image.Image) image.Image {
+		if rotate != "" {
+			img = imageproc.Rotate(img, degrees)
+		}
+		if flip != "" {
+			img = imageproc.Flip(img, flip)
+		}
+		return img
+	}
+	if len(suffix) == 0 {
+		return "", transform, nil
+	}
+	return "-" + strings.Join(suffix, "-"), transform, nil
+}
+
+// queryPage parses the optional page query parameter (1-based), selecting the page or frame of a multi-page
+// source image to derive from, returning the derivative key suffix naming it, e.g. "-p2" for "?page=2", or 0
+// and an empty string if it is not set
+func queryPage(r *http.Request) (int, string, error) {
+	value := r.URL.Query().Get("page")
+	if value == "" {
+		return 0, "", nil
+	}
+	page, err := strconv.Atoi(value)
+	if err != nil || page < 1 {
+		return 0, "", fmt.Errorf("page: %s, must be 1 or more", value)
+	}
+	return page, fmt.Sprintf("-p%d", page), nil
+}
+
+// pageResponse responds to an error opening a page of an image, with a 400 if the page does not exist
+func pageResponse(w http.ResponseWriter, err error) {
+	if perr, ok := err.(*imageproc.PageRangeError); ok {
+		errorMessage := fmt.Sprintf("Bad parameter value, cannot complete request; %v", perr)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterValue, errorMessage)
+		return
+	}
+	logger.Errorf("Failed to open image: %v", err)
+	serverErrorResponse(w)
+}
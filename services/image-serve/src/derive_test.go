@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/go-chi/chi"
+	"github.com/okebinda/internal/awstest"
+	"github.com/okebinda/internal/storage"
+)
+
+func TestDeriveImageEmbargoedCache(t *testing.T) {
+	awstest.Setenv(t, "AWS_S3_BUCKET_SOURCE", "source")
+	awstest.Setenv(t, "AWS_S3_BUCKET_DESTINATION", "cache")
+	awstest.Setenv(t, "REGION", "us-east-1")
+	awstest.Setenv(t, "MAX_WIDTH", "2000")
+
+	tests := []struct {
+		name          string
+		availableFrom string
+		status        int
+	}{
+		{"embargoed", "2020-11-03T00:00:00Z", 403},
+		{"embargo ended", "2020-11-01T00:00:00Z", 301},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			// a derivative cached before the image was embargoed
+			s := newTestService(&awstest.FakeS3{Headers: map[string]*s3.HeadObjectOutput{
+				"source/2020/photo.png": {
+					ContentType: aws.String("image/png"),
+					Metadata:    aws.StringMap(map[string]string{storage.MetadataAvailableFrom: test.availableFrom}),
+				},
+				"cache/width/100/2020/photo.png": {ContentType: aws.String("image/png")},
+			}})
+			r := httptest.NewRequest("GET", "/width/100/2020/photo.png", nil)
+			routeContext := chi.NewRouteContext()
+			routeContext.URLParams.Add("size", "100")
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, routeContext))
+			w := httptest.NewRecorder()
+			s.GetResizeWidth(w, r)
+
+			if w.Code != test.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestDeriveImageDownloadErrors(t *testing.T) {
+	awstest.Setenv(t, "AWS_S3_BUCKET_SOURCE", "source")
+	awstest.Setenv(t, "AWS_S3_BUCKET_DESTINATION", "cache")
+	awstest.Setenv(t, "REGION", "us-east-1")
+	awstest.Setenv(t, "MAX_WIDTH", "2000")
+
+	tests := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"missing object", nil, 404},
+		{"download error", errors.New("unavailable"), 500},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			// a source image whose header is found, but whose content cannot be downloaded
+			s := newTestService(&awstest.FakeS3{
+				Headers: map[string]*s3.HeadObjectOutput{
+					"source/2020/photo.png": {ContentType: aws.String("image/png")},
+				},
+				GetErr: test.err,
+			})
+			r := httptest.NewRequest("GET", "/width/100/2020/photo.png", nil)
+			routeContext := chi.NewRouteContext()
+			routeContext.URLParams.Add("size", "100")
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, routeContext))
+			w := httptest.NewRecorder()
+			s.GetResizeWidth(w, r)
+
+			if w.Code != test.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/okebinda/internal/apiversion"
+	"github.com/okebinda/internal/httpresp"
+	"github.com/okebinda/internal/keys"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/storage"
+)
+
+// defaultDownloadExpiryMinutes is how long download URLs are valid if DOWNLOAD_URL_EXPIRY_MINUTES is not set
+const defaultDownloadExpiryMinutes = 5
+
+// EncryptionPayload defines the JSON schema for the cipher and key ID a client-side encrypted file was uploaded with
+type EncryptionPayload struct {
+	Cipher string `json:"cipher"`
+	KeyID  string `json:"key_id,omitempty"`
+}
+
+// DownloadPayload defines the JSON schema for the payload returned to v2 download requests instead of a redirect:
+// the presigned URL of the encrypted file, when it expires, and the file's type, size, and encryption
+type DownloadPayload struct {
+	URL         string            `json:"url"`
+	ExpiresAt   string            `json:"expires_at"`
+	ContentType string            `json:"content_type"`
+	SizeBytes   int64             `json:"size_bytes"`
+	Encryption  EncryptionPayload `json:"encryption"`
+}
+
+// GetDownload issues a short-lived presigned URL to download a client-side encrypted file, which is stored
+// privately and never transformed; the file is returned as it was uploaded, for the client to decrypt
+func (s *Service) GetDownload(w http.ResponseWriter, r *http.Request) {
+
+	// get environment parameters
+	keyValidation, err := keys.ValidationFromEnv()
+	if err != nil {
+		logger.Errorf("Could not read KEY_VALIDATION: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+	sourceBucket := os.Getenv("AWS_S3_BUCKET_SOURCE")
+	expiryMinutes := defaultDownloadExpiryMinutes
+	if value := os.Getenv("DOWNLOAD_URL_EXPIRY_MINUTES"); value != "" {
+		expiryMinutes, err = strconv.Atoi(value)
+		if err != nil || expiryMinutes < 1 {
+			logger.Errorf("Could not convert DOWNLOAD_URL_EXPIRY_MINUTES to a positive int: %s", value)
+			serverErrorResponse(w)
+			return
+		}
+	}
+
+	// get path parameters (chi doesn't support greedy path parameters)
+	imageKey := strings.TrimPrefix(r.URL.Path, "/download/")
+
+	logger.Infow("Request parameters",
+		"imageKey", imageKey,
+	)
+
+	// simple sanity check
+	if imageKey == "" {
+		errorMessage := fmt.Sprintf("Missing parameters, cannot complete request; image_key: %s", imageKey)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.MissingParameters, errorMessage)
+		return
+	}
+
+	// check key format
+	if err = keys.Validate(imageKey, keyValidation); err != nil {
+		errorMessage := fmt.Sprintf("Bad parameter format, cannot complete request; image_key: %v", err)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterFormat, errorMessage)
+		return
+	}
+
+	// reject geo-restricted requests
+	if geoResponse(w, r, imageKey) {
+		return
+	}
+
+	// serve sandbox files from the sandbox buckets
+	sourceBucket, _, ok := sandboxBuckets(w, imageKey, sourceBucket, "")
+	if !ok {
+		return
+	}
+
+	// initialize AWS session
+	sess := s.Session
+
+	// read object headers
+	header, err := storage.HeadObject(s.S3, sourceBucket, imageKey)
+	if err != nil {
+		logger.Errorf("S3 head object error: %s, %s", imageKey, err)
+		if storage.IsNotFound(err) {
+			notFoundResponse(w, sess, imageKey)
+			return
+		}
+		serverErrorResponse(w)
+		return
+	}
+
+	// reject embargoed files
+	if s.embargoResponse(w, sourceBucket, imageKey) {
+		return
+	}
+
+	// only encrypted files are downloaded; images are served by their public URLs
+	cipher, keyID, ok := storage.Encryption(header)
+	if !ok {
+		errorMessage := fmt.Sprintf("Bad parameter value, cannot complete request; image_key: not an encrypted file, %s", imageKey)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterValue, errorMessage)
+		return
+	}
+
+	expires := time.Duration(expiryMinutes) * time.Minute
+	downloadURL, err := storage.PresignGet(s.S3, sourceBucket, imageKey, expires)
+	if err != nil {
+		logger.Errorf("Failed to presign download: %s, %v", imageKey, err)
+		serverErrorResponse(w)
+		return
+	}
+
+	logger.Infow("Download issued.",
+		"bucket", sourceBucket,
+		"file_key", imageKey,
+		"expiry_minutes", expiryMinutes,
+	)
+
+	// download URLs are short-lived, so responses must never be cached
+	w.Header().Set("Cache-Control", "no-store")
+	if apiVersion >= apiversion.V2 {
+		err = httpresp.Success(w, 200, DownloadPayload{
+			URL:         downloadURL,
+			ExpiresAt:   s.Clock.Now().Add(expires).UTC().Format(time.RFC3339),
+			ContentType: aws.StringValue(header.ContentType),
+			SizeBytes:   aws.Int64Value(header.ContentLength),
+			Encryption:  EncryptionPayload{Cipher: cipher, KeyID: keyID},
+		})
+		if err != nil {
+			logger.Errorf("Error generating response: %s", err)
+		}
+		return
+	}
+	http.Redirect(w, r, downloadURL, http.StatusFound)
+}
+
+// encryptedResponse rejects requests to transform a client-side encrypted file, which the service cannot read;
+// returns false if the source is not encrypted
+func (s *Service) encryptedResponse(w http.ResponseWriter, bucketName, fileKey string) bool {
+	header, err := storage.HeadObject(s.S3, bucketName, fileKey)
+	if err != nil {
+		return false
+	}
+	if _, _, ok := storage.Encryption(header); !ok {
+		return false
+	}
+
+	logger.Infow("Encrypted file transform refused.",
+		"file_key", fileKey,
+	)
+
+	err = httpresp.Reject(w, language, requestID, 400, problem.EncryptedFile, "Encrypted files can only be downloaded", map[string]interface{}{
+		"download_path": "/download/" + fileKey,
+	})
+	if err != nil {
+		logger.Errorf("Error generating response: %s", err)
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/okebinda/internal/apiversion"
+	"github.com/okebinda/internal/awstest"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/storage"
+)
+
+func TestGetDownload(t *testing.T) {
+	awstest.Setenv(t, "AWS_S3_BUCKET_SOURCE", "source")
+	awstest.Setenv(t, "DOWNLOAD_URL_EXPIRY_MINUTES", "10")
+	t.Cleanup(func() {
+		apiVersion = apiversion.Default
+	})
+
+	headers := map[string]*s3.HeadObjectOutput{
+		"source/2020/secret.enc": {
+			ContentType:   aws.String("application/octet-stream"),
+			ContentLength: aws.Int64(4096),
+			Metadata:      aws.StringMap(map[string]string{storage.MetadataEncryption: "AES-256-GCM", storage.MetadataEncryptionKeyID: "key-1"}),
+		},
+		"source/2020/embargoed.enc": {
+			Metadata: aws.StringMap(map[string]string{storage.MetadataEncryption: "AES-256-GCM", storage.MetadataAvailableFrom: "2020-11-03T00:00:00Z"}),
+		},
+		"source/2020/photo.png": {
+			ContentType: aws.String("image/png"),
+		},
+	}
+
+	tests := []struct {
+		name, path string
+		version    int
+		status     int
+		code       string
+	}{
+		{"not found", "/download/2020/missing.enc", apiversion.V1, 404, problem.NotFound},
+		{"head error", "/download/2020/secret.enc", apiversion.V1, 500, problem.InternalError},
+		{"not encrypted", "/download/2020/photo.png", apiversion.V1, 400, problem.BadParameterValue},
+		{"embargoed", "/download/2020/embargoed.enc", apiversion.V1, 403, problem.Embargoed},
+		{"redirect", "/download/2020/secret.enc", apiversion.V1, 302, ""},
+		{"payload", "/download/2020/secret.enc", apiversion.V2, 200, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			apiVersion = test.version
+			s3Client := &awstest.FakeS3{Headers: headers}
+			if test.status == 500 {
+				s3Client.HeadErr = errors.New("unavailable")
+			}
+			s := newTestService(s3Client)
+			w := httptest.NewRecorder()
+			s.GetDownload(w, httptest.NewRequest("GET", test.path, nil))
+
+			if w.Code != test.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+			if test.code != "" {
+				var got problem.Problem
+				if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+					t.Fatal(err)
+				}
+				if got.Code != test.code {
+					t.Errorf("code = %s, want %s", got.Code, test.code)
+				}
+				return
+			}
+
+			if got := w.Header().Get("Cache-Control"); got != "no-store" {
+				t.Errorf("Cache-Control = %q, want no-store", got)
+			}
+			downloadURL := w.Header().Get("Location")
+			if test.version >= apiversion.V2 {
+				var got DownloadPayload
+				if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+					t.Fatal(err)
+				}
+				want := EncryptionPayload{Cipher: "AES-256-GCM", KeyID: "key-1"}
+				if got.Encryption != want || got.ExpiresAt != "2020-11-02T15:14:05Z" || got.SizeBytes != 4096 {
+					t.Errorf("payload = %+v, want encryption %+v, expires_at 2020-11-02T15:14:05Z, size_bytes 4096", got, want)
+				}
+				downloadURL = got.URL
+			}
+			if !strings.HasPrefix(downloadURL, "https://source.s3.amazonaws.com/2020/secret.enc?") || !strings.Contains(downloadURL, "X-Amz-Expires=600") {
+				t.Errorf("download URL = %s, want a URL of source/2020/secret.enc valid for 10 minutes", downloadURL)
+			}
+		})
+	}
+}
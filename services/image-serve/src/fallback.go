@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/okebinda/internal/storage"
+)
+
+// fallbackSource copies a source image missing from the source bucket forward from the bucket named by the
+// AWS_S3_BUCKET_FALLBACK env parameter, e.g. a legacy bucket images are being migrated from, so it is served
+// like any other; if the image is in neither bucket, the download that follows responds not found. It returns
+// false if the request failed and a response has been written
+func (s *Service) fallbackSource(w http.ResponseWriter, sourceBucket, imageKey string) bool {
+	fallbackBucket := os.Getenv("AWS_S3_BUCKET_FALLBACK")
+	if fallbackBucket == "" || sandboxKey(imageKey) {
+		return true
+	}
+
+	// nothing to do if the image is in the source bucket, or cannot be checked
+	_, err := storage.HeadObject(s.S3, sourceBucket, imageKey)
+	if err == nil {
+		return true
+	}
+	if !storage.IsNotFound(err) {
+		logger.Errorf("S3 head object error: %s, %s", imageKey, err)
+		return true
+	}
+
+	header, err := storage.HeadObject(s.S3, fallbackBucket, imageKey)
+	if err != nil {
+		if storage.IsNotFound(err) {
+			return true
+		}
+		logger.Errorf("S3 head object error: %s, %s, %s", fallbackBucket, imageKey, err)
+		serverErrorResponse(w)
+		return false
+	}
+
+	// copy, keeping the metadata; copies do not retain the ACL, so the image is public unless embargoed
+	metadata := storage.ObjectMetadata{
+		ACL:                storage.PublicACL(),
+		CacheControl:       aws.StringValue(header.CacheControl),
+		ContentDisposition: aws.StringValue(header.ContentDisposition),
+		ContentType:        aws.StringValue(header.ContentType),
+		Metadata:           header.Metadata,
+	}
+	if availableFrom, ok := storage.AvailableFrom(header); ok && s.Clock.Now().Before(availableFrom) {
+		metadata.ACL = s3.ObjectCannedACLPrivate
+	}
+	err = storage.CopyObjectMetadata(s.S3, fallbackBucket, imageKey, sourceBucket, imageKey, metadata)
+	if err != nil {
+		logger.Errorf("Failed to copy object from fallback bucket: %s, %s", imageKey, err)
+		serverErrorResponse(w)
+		return false
+	}
+
+	logger.Infow("Source image copied from fallback bucket.",
+		"fallback_bucket", fallbackBucket,
+		"image_key", imageKey,
+	)
+	return true
+}
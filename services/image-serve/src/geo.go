@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/okebinda/internal/httpresp"
+	"github.com/okebinda/internal/problem"
+)
+
+// GeoRestriction defines the countries (ISO 3166-1 alpha-2 codes) allowed or denied access to a directory
+type GeoRestriction struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// geoResponse rejects the request if the viewer's country, from the CloudFront-Viewer-Country header, is not
+// allowed to access the image's directory by the GEO_RESTRICTIONS env parameter (a JSON object mapping
+// directories to GeoRestriction rules); blocked countries receive a 451 and unknown countries a 403; returns
+// false if the request is allowed
+func geoResponse(w http.ResponseWriter, r *http.Request, imageKey string) bool {
+	restrictions := map[string]GeoRestriction{}
+	if config := os.Getenv("GEO_RESTRICTIONS"); config != "" {
+		if err := json.Unmarshal([]byte(config), &restrictions); err != nil {
+			logger.Errorf("Could not parse GEO_RESTRICTIONS: %v", err)
+			serverErrorResponse(w)
+			return true
+		}
+	}
+
+	// find the rule for the closest directory
+	directory, restriction, ok := geoRestriction(restrictions, imageKey)
+	if !ok {
+		return false
+	}
+
+	country := strings.ToUpper(r.Header.Get("CloudFront-Viewer-Country"))
+	status, code := 0, ""
+	switch {
+	case country == "":
+		status, code = 403, problem.CountryUnknown
+	case len(restriction.Allow) > 0 && !containsFold(restriction.Allow, country):
+		status, code = 451, problem.GeoRestricted
+	case containsFold(restriction.Deny, country):
+		status, code = 451, problem.GeoRestricted
+	default:
+		return false
+	}
+
+	logger.Infow("Image geo-restricted.",
+		"directory", directory,
+		"country", country,
+	)
+
+	err := httpresp.Reject(w, language, requestID, status, code, fmt.Sprintf("Image is not available in your region: %s", country), map[string]interface{}{
+		"country": country,
+	})
+	if err != nil {
+		logger.Errorf("Error generating response: %s", err)
+	}
+	return true
+}
+
+// geoRestriction finds the rule for the deepest directory containing an image key
+func geoRestriction(restrictions map[string]GeoRestriction, imageKey string) (string, GeoRestriction, bool) {
+	directory := imageKey
+	for {
+		i := strings.LastIndex(directory, "/")
+		if i < 0 {
+			break
+		}
+		directory = directory[:i]
+		if restriction, ok := restrictions[directory]; ok {
+			return directory, restriction, true
+		}
+	}
+	restriction, ok := restrictions[""]
+	return "", restriction, ok
+}
+
+// containsFold tests if a slice contains a string, ignoring case
+func containsFold(a []string, x string) bool {
+	for _, n := range a {
+		if strings.EqualFold(n, x) {
+			return true
+		}
+	}
+	return false
+}
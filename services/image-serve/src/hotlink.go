@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/okebinda/internal/httpresp"
+	"github.com/okebinda/internal/imageproc"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/signing"
+	"github.com/okebinda/internal/storage"
+)
+
+// watermarkSuffix is appended to the size in the derivative keys of watermarked images, caching them separately
+const watermarkSuffix = "-wm"
+
+// watermarkOpacity is the opacity (0-1) of the watermark overlaid on hotlinked images
+const watermarkOpacity = 0.5
+
+// hotlinkResponse checks the site requesting an image, from the Origin or else Referer header, against the
+// HOTLINK_ALLOWED_ORIGINS env parameter (a comma separated list of hosts, "*.domain.com" matching subdomains);
+// requests from other sites are rejected with a 403, or watermarked if HOTLINK_WATERMARK_KEY names a watermark
+// image in the source bucket; requests without either header, or with a valid signature for the
+// URL_SIGNING_SECRET, are allowed; returns true to watermark the image, and true if the request was rejected
+func (s *Service) hotlinkResponse(w http.ResponseWriter, r *http.Request) (bool, bool) {
+	allowed := splitList(os.Getenv("HOTLINK_ALLOWED_ORIGINS"))
+	if len(allowed) == 0 {
+		return false, false
+	}
+
+	// allow direct requests and approved sites
+	site := r.Header.Get("Origin")
+	if site == "" {
+		site = r.Header.Get("Referer")
+	}
+	if site == "" {
+		return false, false
+	}
+	host := site
+	if u, err := url.Parse(site); err == nil && u.Host != "" {
+		host = u.Hostname()
+	}
+	if isAllowedHost(allowed, host) {
+		return false, false
+	}
+
+	// allow signed URLs
+	if secret := os.Getenv("URL_SIGNING_SECRET"); secret != "" {
+		if signing.VerifyURL(secret, r.URL.Path, r.URL.Query(), s.Clock.Now()) {
+			return false, false
+		}
+	}
+
+	logger.Infow("Image hotlinked.",
+		"host", host,
+	)
+
+	if os.Getenv("HOTLINK_WATERMARK_KEY") != "" {
+		return true, false
+	}
+
+	err := httpresp.Reject(w, language, requestID, 403, problem.Hotlinked, fmt.Sprintf("Image may not be embedded on this site: %s", host), map[string]interface{}{
+		"host": host,
+	})
+	if err != nil {
+		logger.Errorf("Error generating response: %s", err)
+	}
+	return false, true
+}
+
+// isAllowedHost tests if a host is in a list of allowed hosts, where "*.domain.com" matches any subdomain
+func isAllowedHost(allowed []string, host string) bool {
+	host = strings.ToLower(host)
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(pattern)
+		if host == pattern {
+			return true
+		}
+		if strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// watermarked downloads the HOTLINK_WATERMARK_KEY image from a bucket, returning a transformation that applies
+// transform and then overlays the watermark
+func (s *Service) watermarked(bucket string, transform func(image.Image) image.Image) (func(image.Image) image.Image, error) {
+	watermarkKey := os.Getenv("HOTLINK_WATERMARK_KEY")
+	localFile := fmt.Sprintf("/tmp/watermark-%s", filepath.Base(watermarkKey))
+	file, err := os.Create(localFile)
+	if err != nil {
+		return nil, err
+	}
+	_, err = storage.DownloadFile(s.S3, file, bucket, watermarkKey)
+	close(file)
+	if err != nil {
+		return nil, err
+	}
+	watermark, err := imageproc.Open(localFile)
+	if err != nil {
+		return nil, err
+	}
+	return func(img image.Image) image.Image {
+		return imageproc.Watermark(transform(img), watermark, watermarkOpacity)
+	}, nil
+}
+
+// splitList splits a comma separated list, dropping empty values
+func splitList(list string) []string {
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
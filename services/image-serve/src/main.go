@@ -3,39 +3,75 @@ package main
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	chiproxy "github.com/awslabs/aws-lambda-go-api-proxy/chi"
 	"github.com/go-chi/chi"
+	"github.com/okebinda/internal/analytics"
+	"github.com/okebinda/internal/apiversion"
+	"github.com/okebinda/internal/httpresp"
+	"github.com/okebinda/internal/i18n"
+	"github.com/okebinda/internal/keys"
+	"github.com/okebinda/internal/logging"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/server"
+	"github.com/okebinda/internal/storage"
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
 )
 
 var logger *zap.SugaredLogger
-var adapter *chiproxy.ChiLambda
+var requestID string
 
-// validImageFormats defines valid image mime types for processing
-var validImageFormats []string = []string{
-	"image/png",
-	"image/jpeg",
-}
+// invocation is the context of the request being handled, which AWS requests are sent with
+var invocation = context.Background()
+
+var language = i18n.DefaultLanguage
+
+// apiVersion is the version of the response schemas negotiated for the request being handled
+var apiVersion = apiversion.Default
+
+// principal identifies who made the request being handled, if its auth provider names them, for audit logs
+var principal string
+
+var adapter *chiproxy.ChiLambda
+var router http.Handler
 
 func init() {
 	r := chi.NewRouter()
+	r.Use(negotiateLanguage)
+	r.Use(negotiateVersion)
+
+	// originals are authenticated separately, see authenticateOriginal
+	r.With(authenticateOriginal).Get("/image/original/*", service.GetOriginal)
 
-	r.Get("/ratio/{size}/*", GetResizeRatio)
-	r.Get("/crop/{size}/*", GetResizeCrop)
+	r.Group(func(r chi.Router) {
+		r.Use(authenticate)
 
+		r.Get("/ratio/{size}/*", service.GetResizeRatio)
+		r.Get("/crop/{size}/*", service.GetResizeCrop)
+		r.Get("/width/{size}/*", service.GetResizeWidth)
+		r.Get("/height/{size}/*", service.GetResizeHeight)
+		r.Get("/rotate/{size}/*", service.GetRotate)
+		r.Get("/flip/{size}/*", service.GetFlip)
+		r.Get("/preset/{size}/*", service.GetPreset)
+		r.Get("/placeholder/*", service.GetPlaceholder)
+		r.Get("/download/*", service.GetDownload)
+	})
+
+	router = r
 	adapter = chiproxy.New(r)
 }
 
@@ -44,7 +80,9 @@ func Handler(ctx context.Context, request events.APIGatewayProxyRequest) (events
 
 	// initialize logger
 	lc, _ := lambdacontext.FromContext(ctx)
-	logger = sugaredLogger(lc.AwsRequestID)
+	requestID = lc.AwsRequestID
+	invocation = ctx
+	logger = logging.New(requestID)
 	defer logger.Sync()
 
 	// serve request
@@ -52,16 +90,31 @@ func Handler(ctx context.Context, request events.APIGatewayProxyRequest) (events
 	return c, err
 }
 
-// sugaredLogger initializes the zap sugar logger
-func sugaredLogger(requestID string) *zap.SugaredLogger {
-	// zapLogger, err := zap.NewDevelopment()
-	zapLogger, err := zap.NewProduction()
-	if err != nil {
-		log.Fatalf("can't initialize zap logger: %v", err)
-	}
-	return zapLogger.
-		With(zap.Field{Key: "request_id", Type: zapcore.StringType, String: requestID}).
-		Sugar()
+// negotiateLanguage selects the language of error messages from the request's Accept-Language header
+func negotiateLanguage(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		language = i18n.Negotiate(r.Header.Get("Accept-Language"))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// negotiateVersion selects the version of the response schemas from the request's /v1 or /v2 path prefix, else its
+// Accept-Version header, removing the prefix so versioned paths are routed, and signed, like unversioned ones
+func negotiateVersion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version, path, err := apiversion.Negotiate(r.URL.Path, r.Header.Get(apiversion.Header))
+		if err != nil {
+			errorMessage := fmt.Sprintf("Unsupported API version; version: %v", err)
+			logger.Error(errorMessage)
+			userErrorResponse(w, 400, problem.UnsupportedVersion, errorMessage)
+			return
+		}
+		apiVersion = version
+		r.URL.Path = path
+		r.URL.RawPath = apiversion.StripPrefix(r.URL.RawPath)
+		w.Header().Set(apiversion.ResponseHeader, apiversion.String(version))
+		next.ServeHTTP(w, r)
+	})
 }
 
 // close closes a file and logs any errors
@@ -71,104 +124,223 @@ func close(file *os.File) {
 	}
 }
 
-// downloadFile downloads a file from an S3 bucket
-func downloadFile(sess *session.Session, file *os.File, bucketName, fileKey string) (int64, error) {
-	downloader := s3manager.NewDownloader(sess)
-	numBytes, err := downloader.Download(file,
-		&s3.GetObjectInput{
-			Bucket: aws.String(bucketName),
-			Key:    aws.String(fileKey),
-		})
-	return numBytes, err
+// recordEvent sends an analytics event and logs any errors
+func recordEvent(sess *session.Session, event analytics.Event) {
+	event.Service = "image-serve"
+	event.RequestID = requestID
+	if err := analytics.Record(sess, event); err != nil {
+		logger.Errorf("Error recording analytics event: %s", err)
+	}
+}
+
+// redirectResponse generates a redirect (301) response, or a temporary (302) one to presigned URLs, which expire
+func redirectResponse(w http.ResponseWriter, r *http.Request, redirectURL string) {
+	if storage.URLsExpire() {
+		httpresp.RedirectTemporary(w, r, redirectURL)
+		return
+	}
+	httpresp.Redirect(w, r, redirectURL)
+}
+
+// derivativeURL returns the URL a derivative in the cache bucket is served from: its URL on the bucket's S3
+// website endpoint, unless OBJECT_URL_MODE is set (see storage.ObjectURL)
+func (s *Service) derivativeURL(bucketName, fileKey, region string) (string, error) {
+	websiteURL := fmt.Sprintf("http://%s.s3-website.%s.amazonaws.com/%s", bucketName, region, keys.EscapePath(storage.ObjectKey(bucketName, fileKey)))
+	return storage.ObjectURL(s.S3, bucketName, fileKey, websiteURL)
 }
 
-// getFileType detects the mime type of the given file
-func getFileType(file *os.File) (string, error) {
-	buff := make([]byte, 512)
-	if _, err := file.Read(buff); err != nil {
-		return "", err
+// ImagePayload defines the JSON schema for the payload returned to v2 requests instead of a redirect: the URL of
+// the resized image in the cache bucket, and its type, dimensions, and size
+type ImagePayload struct {
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+	SizeBytes   int64  `json:"size_bytes"`
+}
+
+// headerProbeBytes is the number of bytes read from the start of a cached image to decode its dimensions
+const headerProbeBytes = 65536
+
+// imagePayloadResponse generates a success (200) response describing a resized image, decoding its dimensions
+// from the start of its content; images whose dimensions cannot be decoded are described without them
+func imagePayloadResponse(w http.ResponseWriter, redirectURL, fileType string, sizeBytes int64, content io.Reader) {
+	payload := ImagePayload{
+		URL:         redirectURL,
+		ContentType: fileType,
+		SizeBytes:   sizeBytes,
+	}
+	if config, _, err := image.DecodeConfig(content); err == nil {
+		payload.Width, payload.Height = config.Width, config.Height
+	} else {
+		logger.Errorf("Could not decode image dimensions: %s", err)
 	}
-	fileType := http.DetectContentType(buff)
-	if _, err := file.Seek(0, 0); err != nil {
-		return "", err
+	if err := httpresp.Success(w, 200, payload); err != nil {
+		logger.Errorf("Error generating response: %s", err)
 	}
-	return fileType, nil
 }
 
-// contains tests if a slice contains a string
-func contains(a []string, x string) bool {
-	for _, n := range a {
-		if x == n {
-			return true
+// imageResponse responds with a resized image, redirecting to its URL in the cache bucket, or describing it in an
+// ImagePayload for v2 requests, or, if the RESPONSE_MODE env parameter is "binary", returning the image file itself
+func imageResponse(w http.ResponseWriter, r *http.Request, redirectURL, localFile, fileType string) {
+	if os.Getenv("RESPONSE_MODE") != "binary" {
+		if apiVersion >= apiversion.V2 {
+			file, err := os.Open(localFile)
+			if err != nil {
+				logger.Errorf("File read error: %s", err)
+				serverErrorResponse(w)
+				return
+			}
+			defer close(file)
+			info, err := file.Stat()
+			if err != nil {
+				logger.Errorf("File read error: %s", err)
+				serverErrorResponse(w)
+				return
+			}
+			imagePayloadResponse(w, redirectURL, fileType, info.Size(), file)
+			return
 		}
+		redirectResponse(w, r, redirectURL)
+		return
+	}
+	body, err := ioutil.ReadFile(localFile)
+	if err != nil {
+		logger.Errorf("File read error: %s", err)
+		serverErrorResponse(w)
+		return
+	}
+	if err = httpresp.Binary(w, 200, fileType, os.Getenv("CACHE_CONTROL"), body); err != nil {
+		logger.Errorf("Error generating response: %s", err)
 	}
-	return false
 }
 
-// min returns the lesser of two ints
-func min(a, b int) int {
-	if a < b {
-		return a
+// uploadDerivative uploads a derivative to the cache bucket, publicly readable, with the Cache-Control in the
+// CACHE_CONTROL env parameter, if set, and the Content-Disposition in CONTENT_DISPOSITION, "attachment" if not set
+func (s *Service) uploadDerivative(file *os.File, bucketName, fileKey, fileType string) error {
+	metadata := storage.ObjectMetadata{
+		ACL:                storage.PublicACL(),
+		CacheControl:       os.Getenv("CACHE_CONTROL"),
+		ContentDisposition: os.Getenv("CONTENT_DISPOSITION"),
+		ContentType:        fileType,
+	}
+	if metadata.ContentDisposition == "" {
+		metadata.ContentDisposition = "attachment"
 	}
-	return b
+	if err := storage.ValidateMetadata(metadata); err != nil {
+		return fmt.Errorf("invalid CACHE_CONTROL or CONTENT_DISPOSITION: %v", err)
+	}
+	return storage.UploadFileMetadata(s.S3, file, bucketName, fileKey, metadata)
 }
 
-// uploadFile uploads a file to an S3 bucket
-func uploadFile(sess *session.Session, file *os.File, bucketName, fileKey, fileType string) error {
+// cachedResponse responds with a previously resized image if it exists in the cache bucket, returning false if
+// it does not and the image must be generated
+func (s *Service) cachedResponse(w http.ResponseWriter, r *http.Request, bucketName, fileKey, redirectURL string) bool {
+	header, err := storage.HeadObject(s.S3, bucketName, fileKey)
+	if err != nil {
+		if !storage.IsNotFound(err) {
+			logger.Errorf("S3 head object error: %s, %s", fileKey, err)
+		}
+		return false
+	}
+
+	logger.Infow("Image cache hit.",
+		"bucket", bucketName,
+		"file_key", fileKey,
+	)
 
-	// Get file size and read the file content into a buffer
-	fileInfo, _ := file.Stat()
-	var size int64 = fileInfo.Size()
-	buffer := make([]byte, size)
-	if _, err := file.Read(buffer); err != nil {
-		return err
+	if os.Getenv("RESPONSE_MODE") != "binary" {
+		if apiVersion >= apiversion.V2 {
+			content, err := storage.ReadRange(s.S3, bucketName, fileKey, headerProbeBytes)
+			if err != nil {
+				logger.Errorf("S3 read error: %s, %s", fileKey, err)
+				return false
+			}
+			imagePayloadResponse(w, redirectURL, aws.StringValue(header.ContentType), aws.Int64Value(header.ContentLength), bytes.NewReader(content))
+			return true
+		}
+		redirectResponse(w, r, redirectURL)
+		return true
 	}
 
-	// upload to public bucket
-	_, err := s3.New(sess).PutObject(&s3.PutObjectInput{
-		Bucket:             aws.String(bucketName),
-		Key:                aws.String(fileKey),
-		ACL:                aws.String("public-read"),
-		Body:               bytes.NewReader(buffer),
-		ContentLength:      aws.Int64(size),
-		ContentType:        aws.String(fileType),
-		ContentDisposition: aws.String("attachment"),
-	})
-	return err
+	// download cached image to return it
+	localFile := fmt.Sprintf("/tmp/cached-%s", filepath.Base(fileKey))
+	file, err := os.Create(localFile)
+	if err != nil {
+		logger.Errorf("os.Create() error: %s", err)
+		return false
+	}
+	_, err = storage.DownloadFile(s.S3, file, bucketName, fileKey)
+	close(file)
+	if err != nil {
+		logger.Errorf("S3 downloader error: %s, %s", fileKey, err)
+		return false
+	}
+	imageResponse(w, r, redirectURL, localFile, aws.StringValue(header.ContentType))
+	return true
 }
 
-// successResponse generates a redirect (301) response
-func redirectResponse(w http.ResponseWriter, r *http.Request, redirectURL string) {
-	http.Redirect(w, r, redirectURL, http.StatusMovedPermanently)
-}
+// embargoResponse rejects the request (403) if the source image is embargoed until a later time, returning false
+// if it is not
+func (s *Service) embargoResponse(w http.ResponseWriter, bucketName, fileKey string) bool {
+	header, err := storage.HeadObject(s.S3, bucketName, fileKey)
+	if err != nil {
+		return false
+	}
+	availableFrom, ok := storage.AvailableFrom(header)
+	if !ok || !s.Clock.Now().Before(availableFrom) {
+		return false
+	}
+
+	logger.Infow("Image embargoed.",
+		"file_key", fileKey,
+		"available_from", availableFrom,
+	)
 
-// userErrorResponse generates a user error (400) response
-func userErrorResponse(w http.ResponseWriter, code int, errorMessage string) {
-	body, err := json.Marshal(map[string]interface{}{
-		"error": errorMessage,
+	err = httpresp.Reject(w, language, requestID, 403, problem.Embargoed, "Image is not available yet.", map[string]interface{}{
+		"available_from": availableFrom.UTC().Format(time.RFC3339),
 	})
 	if err != nil {
-		logger.Errorf("Marshalling error: %s", err)
-		serverErrorResponse(w)
+		logger.Errorf("Error generating response: %s", err)
+	}
+	return true
+}
+
+// userErrorResponse generates a user error (4xx) response with a problem code, e.g. problem.NotFound
+func userErrorResponse(w http.ResponseWriter, code int, problemCode, errorMessage string) {
+	if err := httpresp.UserError(w, language, requestID, code, problemCode, errorMessage); err != nil {
+		logger.Errorf("Error generating response: %s", err)
 	}
-	generateResponse(w, code, body)
 }
 
 // serverErrorResponse generates a server error (500) response
 func serverErrorResponse(w http.ResponseWriter) {
-	generateResponse(w, 500, []byte("{\"error\":\"Server error\"}"))
+	if err := httpresp.ServerError(w, requestID); err != nil {
+		logger.Errorf("Error generating response: %s", err)
+	}
 }
 
-// generateResponse generates an HTTP JSON Lambda response to return to the user
-func generateResponse(w http.ResponseWriter, statusCode int, body []byte) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(statusCode)
-	_, err := w.Write(body)
-	if err != nil {
-		logger.Errorf("Error writing response: %s", err)
+func main() {
+
+	// serve HTTP directly in a standalone container, or else as a Lambda function
+	switch os.Getenv("EVENT_SOURCE") {
+	case "server":
+		serve()
+	default:
+		lambda.Start(Handler)
 	}
 }
 
-func main() {
-	lambda.Start(Handler)
+// serve serves the router as a standalone HTTP server, for containers outside Lambda
+func serve() {
+	handler := server.Serial(router, func(ctx context.Context, id string) func() {
+		requestID = id
+		invocation = ctx
+		logger = logging.New(requestID)
+		return func() { logger.Sync() }
+	})
+	log.Printf("Serving on %s", server.Addr())
+	if err := server.Serve(server.Addr(), handler); err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
 }
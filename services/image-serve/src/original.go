@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/awslabs/aws-lambda-go-api-proxy/core"
+	"github.com/okebinda/internal/analytics"
+	"github.com/okebinda/internal/httpresp"
+	"github.com/okebinda/internal/keys"
+	"github.com/okebinda/internal/lock"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/storage"
+)
+
+// defaultOriginalRateLimit is the number of originals a principal may request per minute if ORIGINAL_RATE_LIMIT
+// is not set
+const defaultOriginalRateLimit = 60
+
+// originalRateWindow is the window original requests are counted over
+const originalRateWindow = time.Minute
+
+// GetOriginal streams the unmodified original of an image through the service, for internal tools that need
+// originals without being given access to the source bucket; requests are authenticated by the ORIGINAL_AUTH
+// provider, rate limited per principal, and recorded for audit
+func (s *Service) GetOriginal(w http.ResponseWriter, r *http.Request) {
+
+	// get environment parameters
+	keyValidation, err := keys.ValidationFromEnv()
+	if err != nil {
+		logger.Errorf("Could not read KEY_VALIDATION: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+	sourceBucket := os.Getenv("AWS_S3_BUCKET_SOURCE")
+	maxBytes, err := envInt("ORIGINAL_MAX_BYTES", 0)
+	if err != nil {
+		logger.Errorf("Could not convert ORIGINAL_MAX_BYTES to int: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+
+	// get path parameters (chi doesn't support greedy path parameters)
+	imageKey := strings.TrimPrefix(r.URL.Path, "/image/original/")
+
+	logger.Infow("Request parameters",
+		"imageKey", imageKey,
+	)
+
+	// simple sanity check
+	if imageKey == "" {
+		errorMessage := fmt.Sprintf("Missing parameters, cannot complete request; image_key: %s", imageKey)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.MissingParameters, errorMessage)
+		return
+	}
+
+	// check key format
+	if err = keys.Validate(imageKey, keyValidation); err != nil {
+		errorMessage := fmt.Sprintf("Bad parameter format, cannot complete request; image_key: %v", err)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterFormat, errorMessage)
+		return
+	}
+
+	// initialize AWS session
+	sess := s.Session
+
+	// limit the rate each client may download originals at
+	client := principal
+	if client == "" {
+		client = "ip:" + clientAddress(r)
+	}
+	if s.rateLimitResponse(w, client) {
+		return
+	}
+
+	// reject geo-restricted requests
+	if geoResponse(w, r, imageKey) {
+		return
+	}
+
+	// serve sandbox files from the sandbox buckets
+	sourceBucket, _, ok := sandboxBuckets(w, imageKey, sourceBucket, "")
+	if !ok {
+		return
+	}
+
+	// read object headers
+	header, err := storage.HeadObject(s.S3, sourceBucket, imageKey)
+	if err != nil {
+		logger.Errorf("S3 head object error: %s, %s", imageKey, err)
+		if storage.IsNotFound(err) {
+			notFoundResponse(w, sess, imageKey)
+			return
+		}
+		serverErrorResponse(w)
+		return
+	}
+
+	// reject embargoed files, and encrypted files, which are downloaded instead
+	if s.embargoResponse(w, sourceBucket, imageKey) || s.encryptedResponse(w, sourceBucket, imageKey) {
+		return
+	}
+
+	// originals larger than a response can carry are refused rather than truncated
+	sizeBytes := aws.Int64Value(header.ContentLength)
+	if maxBytes > 0 && sizeBytes > int64(maxBytes) {
+		logger.Errorf("Original is too large to serve: %s, %d", imageKey, sizeBytes)
+		err = httpresp.Reject(w, language, requestID, 413, problem.OriginalTooLarge, "Original is too large to serve", map[string]interface{}{
+			"size_bytes": sizeBytes,
+			"max_bytes":  maxBytes,
+		})
+		if err != nil {
+			logger.Errorf("Error generating response: %s", err)
+		}
+		return
+	}
+
+	body, err := storage.OpenObject(s.S3, sourceBucket, imageKey)
+	if err != nil {
+		logger.Errorf("S3 get object error: %s, %s", imageKey, err)
+		serverErrorResponse(w)
+		return
+	}
+	defer body.Close()
+
+	logger.Infow("Original served.",
+		"bucket", sourceBucket,
+		"file_key", imageKey,
+		"principal", principal,
+		"client_address", clientAddress(r),
+		"user_agent", r.UserAgent(),
+		"size_bytes", sizeBytes,
+	)
+	recordEvent(sess, analytics.Event{
+		EventType: analytics.EventOriginalServed,
+		Bucket:    sourceBucket,
+		FileKey:   imageKey,
+		FileType:  aws.StringValue(header.ContentType),
+		SizeBytes: sizeBytes,
+		Principal: client,
+	})
+
+	// originals are only for the authenticated client, so responses must never be cached
+	w.Header().Set("Content-Type", aws.StringValue(header.ContentType))
+	w.Header().Set("Content-Length", strconv.FormatInt(sizeBytes, 10))
+	w.Header().Set("Cache-Control", "private, no-store")
+	if etag := aws.StringValue(header.ETag); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	w.WriteHeader(200)
+	if _, err = io.Copy(w, body); err != nil {
+		logger.Errorf("Failed to stream original: %s, %v", imageKey, err)
+	}
+}
+
+// rateLimitResponse rejects the request (429) if a client has already requested ORIGINAL_RATE_LIMIT (default 60)
+// originals this minute, counted across all function instances in the LOCK_TABLE DynamoDB table; returns false
+// if it has not; requests are not limited if LOCK_TABLE is not set or the limit is 0
+func (s *Service) rateLimitResponse(w http.ResponseWriter, client string) bool {
+	table := os.Getenv("LOCK_TABLE")
+	limit, err := envInt("ORIGINAL_RATE_LIMIT", defaultOriginalRateLimit)
+	if err != nil {
+		logger.Errorf("Could not convert ORIGINAL_RATE_LIMIT to int: %v", err)
+		serverErrorResponse(w)
+		return true
+	}
+	if table == "" || limit <= 0 {
+		return false
+	}
+
+	count, resetAt, err := lock.Increment(s.Session, table, "original/"+client, originalRateWindow)
+	if err != nil {
+		// don't fail requests because the lock table is unavailable
+		logger.Errorf("Could not count original requests: %s, %v", client, err)
+		return false
+	}
+	if count <= int64(limit) {
+		return false
+	}
+
+	logger.Infow("Original requests rate limited.",
+		"client", client,
+		"count", count,
+		"limit", limit,
+	)
+	w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(resetAt).Seconds())+1))
+	err = httpresp.Reject(w, language, requestID, 429, problem.RateLimited, "Too many requests, try again later.", map[string]interface{}{
+		"limit":          limit,
+		"window_seconds": int(originalRateWindow.Seconds()),
+	})
+	if err != nil {
+		logger.Errorf("Error generating response: %s", err)
+	}
+	return true
+}
+
+// clientAddress returns the IP address a request was sent from, as API Gateway saw it, or else as the server did
+func clientAddress(r *http.Request) string {
+	if ctx, ok := core.GetAPIGatewayContextFromContext(r.Context()); ok && ctx.Identity.SourceIP != "" {
+		return ctx.Identity.SourceIP
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/okebinda/internal/analytics"
+	"github.com/okebinda/internal/httpresp"
+	"github.com/okebinda/internal/imageproc"
+	"github.com/okebinda/internal/problem"
+)
+
+// pixelsResponse rejects source images whose width times height exceeds the limit in the MAX_PIXELS env
+// parameter with a 422, reading their dimensions from the header before any pixels are decoded, so decompression
+// bombs cannot exhaust the function's memory; returns true if the request was rejected
+func pixelsResponse(w http.ResponseWriter, sess *session.Session, bucket, imageKey, localFile, fileType string) bool {
+	maxPixels, err := imageproc.MaxPixelsFromEnv()
+	if err != nil {
+		logger.Errorf("Could not read pixel limit: %v", err)
+		serverErrorResponse(w)
+		return true
+	}
+	width, height, err := imageproc.ScanDimensions(localFile)
+	if err != nil {
+		logger.Infof("Could not read image dimensions: %v", err)
+		return false
+	}
+	if err = imageproc.CheckPixels(width, height, maxPixels); err == nil {
+		return false
+	}
+
+	logger.Errorf("Image is too large: %v", err)
+	recordEvent(sess, analytics.Event{
+		EventType: analytics.EventDerivativeRejected,
+		Bucket:    bucket,
+		FileKey:   imageKey,
+		FileType:  fileType,
+		Width:     width,
+		Height:    height,
+		Reason:    "image_too_large",
+	})
+	err = httpresp.Reject(w, language, requestID, 422, problem.ImageTooLarge, fmt.Sprintf("Image is too large: %v", err), map[string]interface{}{
+		"width":      width,
+		"height":     height,
+		"max_pixels": maxPixels,
+	})
+	if err != nil {
+		logger.Errorf("Error generating response: %s", err)
+	}
+	return true
+}
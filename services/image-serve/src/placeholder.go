@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/okebinda/internal/httpresp"
+	"github.com/okebinda/internal/imageproc"
+	"github.com/okebinda/internal/keys"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/storage"
+)
+
+// GetPlaceholder serves a tiny SVG placeholder for an image, with the image's aspect ratio, dominant color, and a
+// blurred 4x4 color gradient, rendered from the placeholder image-upload stores in the image's metadata when it is
+// published, so the image is never downloaded or decoded
+func (s *Service) GetPlaceholder(w http.ResponseWriter, r *http.Request) {
+
+	// get environment parameters
+	keyValidation, err := keys.ValidationFromEnv()
+	if err != nil {
+		logger.Errorf("Could not read KEY_VALIDATION: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+	sourceBucket := os.Getenv("AWS_S3_BUCKET_SOURCE")
+
+	// get path parameters (chi doesn't support greedy path parameters)
+	imageKey := strings.TrimPrefix(r.URL.Path, "/placeholder/")
+
+	logger.Infow("Request parameters",
+		"imageKey", imageKey,
+	)
+
+	// simple sanity check
+	if imageKey == "" {
+		errorMessage := fmt.Sprintf("Missing parameters, cannot complete request; image_key: %s", imageKey)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.MissingParameters, errorMessage)
+		return
+	}
+
+	// check key format
+	if err = keys.Validate(imageKey, keyValidation); err != nil {
+		errorMessage := fmt.Sprintf("Bad parameter format, cannot complete request; image_key: %v", err)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterFormat, errorMessage)
+		return
+	}
+
+	// reject geo-restricted requests
+	if geoResponse(w, r, imageKey) {
+		return
+	}
+
+	// serve sandbox images from the sandbox buckets
+	sourceBucket, _, ok := sandboxBuckets(w, imageKey, sourceBucket, "")
+	if !ok {
+		return
+	}
+
+	// reject hotlinked requests
+	if _, rejected := s.hotlinkResponse(w, r); rejected {
+		return
+	}
+
+	// copy the source image forward if it is only in the fallback bucket
+	if !s.fallbackSource(w, sourceBucket, imageKey) {
+		return
+	}
+
+	// reject embargoed images
+	if s.embargoResponse(w, sourceBucket, imageKey) {
+		return
+	}
+
+	// reject encrypted files, which can only be downloaded
+	if s.encryptedResponse(w, sourceBucket, imageKey) {
+		return
+	}
+
+	// read the placeholder computed when the image was published
+	header, err := storage.HeadObject(s.S3, sourceBucket, imageKey)
+	if err != nil {
+		logger.Errorf("S3 head object error: %s, %s", imageKey, err)
+		if storage.IsNotFound(err) {
+			notFoundResponse(w, s.Session, imageKey)
+			return
+		}
+		serverErrorResponse(w)
+		return
+	}
+	encoded, ok := storage.Placeholder(header)
+	if !ok {
+		errorMessage := fmt.Sprintf("No placeholder, the image was published without one: %s", imageKey)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 404, problem.NotFound, errorMessage)
+		return
+	}
+	placeholder, err := imageproc.ParsePlaceholder(encoded)
+	if err != nil {
+		logger.Errorf("Could not parse placeholder: %s, %v", imageKey, err)
+		serverErrorResponse(w)
+		return
+	}
+
+	// response
+	if err = httpresp.Binary(w, 200, "image/svg+xml", os.Getenv("CACHE_CONTROL"), placeholder.SVG()); err != nil {
+		logger.Errorf("Error generating response: %s", err)
+	}
+}
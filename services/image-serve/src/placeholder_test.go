@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/okebinda/internal/awstest"
+	"github.com/okebinda/internal/imageproc"
+	"github.com/okebinda/internal/storage"
+)
+
+func TestGetPlaceholder(t *testing.T) {
+	awstest.Setenv(t, "AWS_S3_BUCKET_SOURCE", "source")
+
+	placeholder := imageproc.Placeholder{Width: 1200, Height: 800}
+	headers := map[string]*s3.HeadObjectOutput{
+		"source/2020/photo.png": {
+			Metadata: aws.StringMap(map[string]string{storage.MetadataPlaceholder: placeholder.String()}),
+		},
+		"source/2020/legacy.png": {},
+		"source/2020/embargoed.png": {
+			Metadata: aws.StringMap(map[string]string{
+				storage.MetadataPlaceholder:   placeholder.String(),
+				storage.MetadataAvailableFrom: "2020-11-03T00:00:00Z",
+			}),
+		},
+	}
+
+	tests := []struct {
+		name, path string
+		status     int
+	}{
+		{"served from metadata", "/placeholder/2020/photo.png", 200},
+		{"published without one", "/placeholder/2020/legacy.png", 404},
+		{"not found", "/placeholder/2020/missing.png", 404},
+		{"embargoed", "/placeholder/2020/embargoed.png", 403},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestService(&awstest.FakeS3{Headers: headers})
+			w := httptest.NewRecorder()
+			s.GetPlaceholder(w, httptest.NewRequest("GET", test.path, nil))
+
+			if w.Code != test.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+			if test.status != 200 {
+				return
+			}
+			if got := w.Header().Get("Content-Type"); got != "image/svg+xml" {
+				t.Errorf("Content-Type = %s, want image/svg+xml", got)
+			}
+			if !strings.Contains(w.Body.String(), `width="1200" height="800"`) {
+				t.Errorf("body = %s, want an SVG of 1200x800", w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/okebinda/internal/config"
+	"github.com/okebinda/internal/imageproc"
+)
+
+// presetsTTL is how long presets loaded from PRESETS_CONFIG are reused before they are read again
+const presetsTTL = 5 * time.Minute
+
+// presetFormats maps the output formats presets may convert images to onto their mime types
+var presetFormats = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+}
+
+// Preset defines a named, fixed transformation; the operation is ratio, crop, width, or height, with the
+// dimensions it needs, and the image may be converted to a format (jpeg, png, or gif) with a JPEG quality (1-100)
+type Preset struct {
+	Operation string `json:"operation"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Gravity   string `json:"gravity"`
+	Format    string `json:"format"`
+	Quality   int    `json:"quality"`
+}
+
+// presetCache holds the presets loaded from PRESETS_CONFIG between invocations
+var presetCache struct {
+	sync.Mutex
+	source   string
+	presets  map[string]Preset
+	loadedAt time.Time
+}
+
+// GetPreset transforms an image by a named preset and saves to an S3 bucket
+func (s *Service) GetPreset(w http.ResponseWriter, r *http.Request) {
+
+	// get environment parameters
+	maxWidth, err := strconv.Atoi(os.Getenv("MAX_WIDTH"))
+	if err != nil {
+		logger.Errorf("Could not convert MAX_WIDTH to int: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+	maxHeight, err := strconv.Atoi(os.Getenv("MAX_HEIGHT"))
+	if err != nil {
+		logger.Errorf("Could not convert MAX_HEIGHT to int: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+	presets, err := s.loadPresets()
+	if err != nil {
+		logger.Errorf("Could not load presets: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+
+	s.deriveImage(w, r, "preset", func(name string) (derivative, error) {
+		preset, ok := presets[name]
+		if !ok {
+			return derivative{}, fmt.Errorf("unknown preset")
+		}
+		return preset.derivative(maxWidth, maxHeight), nil
+	})
+}
+
+// loadPresets reads the presets defined in the PRESETS env parameter (a JSON object mapping names to presets)
+// or, if it is not set, the config document in PRESETS_CONFIG ("s3://bucket/key" or "ssm:/parameter/name")
+func (s *Service) loadPresets() (map[string]Preset, error) {
+	if document := os.Getenv("PRESETS"); document != "" {
+		return parsePresets([]byte(document))
+	}
+	source := os.Getenv("PRESETS_CONFIG")
+	if source == "" {
+		return map[string]Preset{}, nil
+	}
+
+	// reuse recently loaded presets
+	presetCache.Lock()
+	defer presetCache.Unlock()
+	if presetCache.source == source && time.Since(presetCache.loadedAt) < presetsTTL {
+		return presetCache.presets, nil
+	}
+
+	document, err := config.Load(s.Session, source)
+	if err != nil {
+		return nil, err
+	}
+	presets, err := parsePresets(document)
+	if err != nil {
+		return nil, err
+	}
+	presetCache.source = source
+	presetCache.presets = presets
+	presetCache.loadedAt = s.Clock.Now()
+	return presets, nil
+}
+
+// parsePresets parses a JSON object mapping names to presets, checking that each preset is valid
+func parsePresets(document []byte) (map[string]Preset, error) {
+	presets := map[string]Preset{}
+	if err := json.Unmarshal(document, &presets); err != nil {
+		return nil, err
+	}
+	for name, preset := range presets {
+		if err := preset.validate(); err != nil {
+			return nil, fmt.Errorf("preset %s: %v", name, err)
+		}
+	}
+	return presets, nil
+}
+
+// validate checks a preset's operation has the dimensions it needs and its format and quality are supported
+func (p Preset) validate() error {
+	switch p.Operation {
+	case "ratio", "crop":
+		if p.Width <= 0 || p.Height <= 0 {
+			return fmt.Errorf("%s requires a width and height", p.Operation)
+		}
+	case "width":
+		if p.Width <= 0 {
+			return fmt.Errorf("width requires a width")
+		}
+	case "height":
+		if p.Height <= 0 {
+			return fmt.Errorf("height requires a height")
+		}
+	default:
+		return fmt.Errorf("unknown operation: %s", p.Operation)
+	}
+	if p.Gravity != "" && (p.Operation != "crop" || !imageproc.IsValidGravity(p.Gravity)) {
+		return fmt.Errorf("invalid gravity: %s", p.Gravity)
+	}
+	if _, ok := presetFormats[p.Format]; p.Format != "" && !ok {
+		return fmt.Errorf("unsupported format: %s", p.Format)
+	}
+	if p.Quality < 0 || p.Quality > 100 {
+		return fmt.Errorf("quality must be 1-100: %d", p.Quality)
+	}
+	return nil
+}
+
+// derivative returns the derivative generated by a preset, limiting its dimensions to maxWidth and maxHeight
+func (p Preset) derivative(maxWidth, maxHeight int) derivative {
+	width := imageproc.Min(maxWidth, p.Width)
+	height := imageproc.Min(maxHeight, p.Height)
+	var transform func(image.Image) image.Image
+	switch p.Operation {
+	case "ratio":
+		transform = func(img image.Image) image.Image {
+			return imageproc.ResizeRatio(img, width, height)
+		}
+	case "crop":
+		gravity := p.Gravity
+		if gravity == "" {
+			gravity = imageproc.GravityCenter
+		}
+		transform = imageproc.CropGravity(width, height, gravity)
+	case "width":
+		transform = func(img image.Image) image.Image {
+			return imageproc.ResizeWidth(img, width)
+		}
+	case "height":
+		transform = func(img image.Image) image.Image {
+			return imageproc.ResizeHeight(img, height)
+		}
+	}
+	return derivative{
+		transform: transform,
+		format:    presetFormats[p.Format],
+		quality:   p.Quality,
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/okebinda/internal/config"
+	"github.com/okebinda/internal/imageproc"
+)
+
+// qualityTTL is how long the encoder qualities loaded from QUALITY_CONFIG are reused before they are read again
+const qualityTTL = 5 * time.Minute
+
+// qualityCache holds the encoder qualities loaded from QUALITY_CONFIG between invocations
+var qualityCache struct {
+	sync.Mutex
+	source    string
+	qualities map[string]int
+	loadedAt  time.Time
+}
+
+// tunedQuality returns the encoder quality for a file type chosen by the quality-tuner function, read from the
+// config document in the QUALITY_CONFIG env parameter ("ssm:/parameter/name" or "s3://bucket/key", a JSON object
+// mapping file types to qualities), or 0 for the default quality if it is not set, cannot be read, or has no
+// quality for the file type
+func (s *Service) tunedQuality(fileType string) int {
+	source := os.Getenv("QUALITY_CONFIG")
+	if source == "" {
+		return 0
+	}
+
+	// reuse recently loaded qualities
+	qualityCache.Lock()
+	defer qualityCache.Unlock()
+	if qualityCache.source != source || s.Clock.Now().Sub(qualityCache.loadedAt) >= qualityTTL {
+		qualities := map[string]int{}
+		document, err := config.Load(s.Session, source)
+		if err == nil {
+			err = json.Unmarshal(document, &qualities)
+		}
+		if err != nil && !config.IsNotFound(err) {
+			logger.Errorf("Could not load encoder qualities: %v", err)
+			return 0
+		}
+		qualityCache.source = source
+		qualityCache.qualities = qualities
+		qualityCache.loadedAt = s.Clock.Now()
+	}
+	quality := qualityCache.qualities[fileType]
+	if quality < 1 || quality > 100 {
+		return 0
+	}
+	return quality
+}
+
+// saveTuned saves a derived image to a local file like imageproc.SaveQuality; lossy images without a set quality
+// are encoded with the tuned quality and, if QUALITY_CONFIG is set, measured to tune it, since decoding them again
+// is only worth it for the quality-tuner function. Returns the quality lossy images were encoded with, and their
+// SSIM if they were measured, or else 0
+func (s *Service) saveTuned(derived image.Image, outputFile, outputType string, quality int) (int, float64, error) {
+	if !imageproc.IsLossy(outputType) {
+		return 0, 0, imageproc.SaveQuality(derived, outputFile, quality)
+	}
+	if quality != 0 {
+		return quality, 0, imageproc.SaveQuality(derived, outputFile, quality)
+	}
+	quality = s.tunedQuality(outputType)
+	if err := imageproc.SaveQuality(derived, outputFile, quality); err != nil {
+		return 0, 0, err
+	}
+	if quality == 0 {
+		quality = imageproc.DefaultQuality
+	}
+	if os.Getenv("QUALITY_CONFIG") == "" {
+		return quality, 0, nil
+	}
+	return quality, measureQuality(derived, outputFile), nil
+}
+
+// measureQuality estimates how closely an encoded image file matches the image it was encoded from (see
+// imageproc.SSIM), or returns 0 if the file cannot be read
+func measureQuality(original image.Image, encodedFile string) float64 {
+	encoded, err := imageproc.Open(encodedFile)
+	if err != nil {
+		logger.Errorf("Could not open encoded image to measure its quality: %v", err)
+		return 0
+	}
+	return imageproc.SSIM(original, encoded)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/okebinda/internal/awstest"
+	"github.com/okebinda/internal/imageproc"
+	"go.uber.org/zap"
+)
+
+// photoLike returns a photo-like derivative: gradients with noise
+func photoLike(width, height int) image.Image {
+	derived := image.NewNRGBA(image.Rect(0, 0, width, height))
+	random := rand.New(rand.NewSource(1))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			noise := uint8(random.Intn(32))
+			derived.SetNRGBA(x, y, color.NRGBA{uint8(x*255/width) + noise, uint8(y*255/height) + noise, 128 + noise, 255})
+		}
+	}
+	return derived
+}
+
+// tuneQualities sets QUALITY_CONFIG for the duration of a test, with the qualities it would load already cached
+func tuneQualities(t testing.TB, qualities map[string]int) {
+	awstest.Setenv(t, "QUALITY_CONFIG", "ssm:/image-serve/quality")
+	qualityCache.source = "ssm:/image-serve/quality"
+	qualityCache.qualities = qualities
+	qualityCache.loadedAt = time.Now()
+	t.Cleanup(func() {
+		qualityCache.source = ""
+		qualityCache.qualities = nil
+	})
+}
+
+func TestSaveTunedMeasures(t *testing.T) {
+	logger = zap.NewNop().Sugar()
+	awstest.Setenv(t, "QUALITY_CONFIG", "")
+	s := NewService(nil, nil, systemClock{})
+	outputFile := filepath.Join(t.TempDir(), "output.jpg")
+	derived := photoLike(40, 30)
+
+	// without quality tuning, derivatives are not decoded again to be measured
+	quality, ssim, err := s.saveTuned(derived, outputFile, "image/jpeg", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quality != imageproc.DefaultQuality || ssim != 0 {
+		t.Errorf("saveTuned() = %d, %f, want %d, 0", quality, ssim, imageproc.DefaultQuality)
+	}
+
+	tuneQualities(t, map[string]int{"image/jpeg": 70})
+	quality, ssim, err = s.saveTuned(derived, outputFile, "image/jpeg", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quality != 70 || ssim <= 0 || ssim > 1 {
+		t.Errorf("saveTuned() = %d, %f, want 70 and a measured SSIM", quality, ssim)
+	}
+}
+
+// BenchmarkSaveTuned measures encoding a derivative as image-serve does, where lossy images without a set
+// quality are also decoded again to measure their SSIM if qualities are tuned
+func BenchmarkSaveTuned(b *testing.B) {
+	logger = zap.NewNop().Sugar()
+	s := NewService(nil, nil, systemClock{})
+	dir, err := ioutil.TempDir("", "image-serve")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	derived := photoLike(400, 300)
+
+	tests := []struct {
+		name, outputFile, outputType string
+		quality                      int
+		tuned                        bool
+	}{
+		{"jpeg-measured", "output.jpg", "image/jpeg", 0, true},
+		{"jpeg-default", "output.jpg", "image/jpeg", 0, false},
+		{"jpeg-quality", "output.jpg", "image/jpeg", 85, false},
+		{"png", "output.png", "image/png", 0, false},
+	}
+	for _, test := range tests {
+		b.Run(test.name, func(b *testing.B) {
+			awstest.Setenv(b, "QUALITY_CONFIG", "")
+			if test.tuned {
+				tuneQualities(b, map[string]int{})
+			}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := s.saveTuned(derived, filepath.Join(dir, test.outputFile), test.outputType, test.quality); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
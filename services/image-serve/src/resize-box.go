@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/okebinda/internal/imageproc"
+	"github.com/okebinda/internal/problem"
+)
+
+// GetResizeRatio resizes an image and saves to an S3 bucket, preserving the original aspect ratio
+func (s *Service) GetResizeRatio(w http.ResponseWriter, r *http.Request) {
+	s.resizeBox(w, r, "ratio", func(width, height int, size string) (derivative, error) {
+		return derivative{
+			transform: func(img image.Image) image.Image {
+				return imageproc.ResizeRatio(img, width, height)
+			},
+			size: size,
+		}, nil
+	})
+}
+
+// GetResizeCrop resizes an image and saves to an S3 bucket, cropping to fit the given dimensions
+func (s *Service) GetResizeCrop(w http.ResponseWriter, r *http.Request) {
+
+	// get crop gravity or focal point from query parameters
+	gravity := r.URL.Query().Get("gravity")
+	focalX := r.URL.Query().Get("fp-x")
+	focalY := r.URL.Query().Get("fp-y")
+
+	s.resizeBox(w, r, "crop", func(width, height int, size string) (derivative, error) {
+
+		logger.Infow("Crop parameters",
+			"gravity", gravity,
+			"fp-x", focalX,
+			"fp-y", focalY,
+		)
+
+		// check crop parameters, naming the derivative after them so each crop is cached separately
+		if focalX != "" || focalY != "" {
+			fpX, err := strconv.ParseFloat(focalX, 64)
+			var fpY float64
+			if err == nil {
+				fpY, err = strconv.ParseFloat(focalY, 64)
+			}
+			if err != nil || gravity != "" || fpX < 0 || fpX > 1 || fpY < 0 || fpY > 1 {
+				return derivative{}, &parameterError{problem.BadParameterValue, fmt.Sprintf("Bad parameter value, cannot complete request; fp-x: %s, fp-y: %s, must both be 0-1 without gravity", focalX, focalY)}
+			}
+			return derivative{
+				transform: imageproc.CropFocalPoint(width, height, fpX, fpY),
+				size:      fmt.Sprintf("%s-fp%sx%s", size, strconv.FormatFloat(fpX, 'f', -1, 64), strconv.FormatFloat(fpY, 'f', -1, 64)),
+			}, nil
+		}
+		if gravity == "" || gravity == imageproc.GravityCenter {
+			return derivative{
+				transform: imageproc.CropGravity(width, height, imageproc.GravityCenter),
+				size:      size,
+			}, nil
+		}
+		if !imageproc.IsValidGravity(gravity) {
+			return derivative{}, &parameterError{problem.BadParameterValue, fmt.Sprintf("Bad parameter value, cannot complete request; gravity: %s", gravity)}
+		}
+		return derivative{
+			transform: imageproc.CropGravity(width, height, gravity),
+			size:      fmt.Sprintf("%s-%s", size, gravity),
+		}, nil
+	})
+}
+
+// resizeBox resizes an image to fit a WxH size, the operation; build returns the derivative for the size to
+// resize to, limited by the MAX_WIDTH and MAX_HEIGHT env parameters, and the size to name it after
+func (s *Service) resizeBox(w http.ResponseWriter, r *http.Request, operation string, build func(width, height int, size string) (derivative, error)) {
+
+	// get environment parameters
+	maxWidth, err := strconv.Atoi(os.Getenv("MAX_WIDTH"))
+	if err != nil {
+		logger.Errorf("Could not convert MAX_WIDTH to int: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+	maxHeight, err := strconv.Atoi(os.Getenv("MAX_HEIGHT"))
+	if err != nil {
+		logger.Errorf("Could not convert MAX_HEIGHT to int: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+	limit, err := sizeLimitFromEnv()
+	if err != nil {
+		logger.Errorf("Could not parse ALLOWED_SIZES: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+
+	s.deriveImage(w, r, operation, func(size string) (derivative, error) {
+
+		// check size parameter is correct format
+		isMatch, err := regexp.MatchString(`^\d+x\d+$`, size)
+		if err != nil {
+			return derivative{}, &parameterError{problem.UnreadableParameter, fmt.Sprintf("Could not read parameter format, cannot complete request; size: %s: %v", size, err)}
+		}
+		if !isMatch {
+			return derivative{}, &parameterError{problem.BadParameterFormat, fmt.Sprintf("Bad parameter format, cannot complete request; size: %s", size)}
+		}
+
+		// parse image dimensions from path
+		sizes := strings.Split(size, "x")
+		width, err := strconv.Atoi(sizes[0])
+		if err != nil {
+			return derivative{}, &parameterError{problem.BadWidth, "Could not convert width to int."}
+		}
+		height, err := strconv.Atoi(sizes[1])
+		if err != nil {
+			return derivative{}, &parameterError{problem.BadHeight, "Could not convert height to int."}
+		}
+
+		// check size is allowed, naming the derivative after the size generated
+		width, height, ok := limit.check(width, height)
+		if !ok {
+			return derivative{}, &parameterError{problem.SizeNotAllowed, fmt.Sprintf("Size not allowed, cannot complete request; size: %s", size)}
+		}
+		return build(imageproc.Min(maxWidth, width), imageproc.Min(maxHeight, height), fmt.Sprintf("%dx%d", width, height))
+	})
+}
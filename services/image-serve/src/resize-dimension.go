@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/okebinda/internal/imageproc"
+)
+
+// GetResizeWidth resizes an image to a width and saves to an S3 bucket, scaling its height to preserve the
+// original aspect ratio
+func (s *Service) GetResizeWidth(w http.ResponseWriter, r *http.Request) {
+	s.resizeDimension(w, r, "width", "MAX_WIDTH", imageproc.ResizeWidth)
+}
+
+// GetResizeHeight resizes an image to a height and saves to an S3 bucket, scaling its width to preserve the
+// original aspect ratio
+func (s *Service) GetResizeHeight(w http.ResponseWriter, r *http.Request) {
+	s.resizeDimension(w, r, "height", "MAX_HEIGHT", imageproc.ResizeHeight)
+}
+
+// resizeDimension resizes an image by a single dimension, the operation, limited by the maxEnv env parameter
+func (s *Service) resizeDimension(w http.ResponseWriter, r *http.Request, operation, maxEnv string, resize func(image.Image, int) image.Image) {
+
+	// get environment parameters
+	maxSize, err := strconv.Atoi(os.Getenv(maxEnv))
+	if err != nil {
+		logger.Errorf("Could not convert %s to int: %v", maxEnv, err)
+		serverErrorResponse(w)
+		return
+	}
+	limit, err := sizeLimitFromEnv()
+	if err != nil {
+		logger.Errorf("Could not parse ALLOWED_SIZES: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+
+	s.deriveImage(w, r, operation, func(size string) (derivative, error) {
+		dimension, err := strconv.Atoi(size)
+		if err != nil || dimension <= 0 {
+			return derivative{}, fmt.Errorf("must be a positive integer")
+		}
+
+		// check size is allowed, naming the derivative after the size generated
+		var ok bool
+		if operation == "width" {
+			dimension, _, ok = limit.check(dimension, 0)
+		} else {
+			_, dimension, ok = limit.check(0, dimension)
+		}
+		if !ok {
+			return derivative{}, fmt.Errorf("size not allowed")
+		}
+
+		resized := imageproc.Min(maxSize, dimension)
+		return derivative{
+			transform: func(img image.Image) image.Image {
+				return resize(img, resized)
+			},
+			size: strconv.Itoa(dimension),
+		}, nil
+	})
+}
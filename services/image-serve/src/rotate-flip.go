@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"net/http"
+	"strconv"
+
+	"github.com/okebinda/internal/imageproc"
+)
+
+// GetRotate rotates an image counter-clockwise by 90, 180, or 270 degrees and saves to an S3 bucket
+func (s *Service) GetRotate(w http.ResponseWriter, r *http.Request) {
+	s.deriveImage(w, r, "rotate", func(size string) (derivative, error) {
+		degrees, err := strconv.Atoi(size)
+		if err != nil || !imageproc.IsValidRotation(degrees) {
+			return derivative{}, fmt.Errorf("must be 90, 180, or 270")
+		}
+		return derivative{transform: func(img image.Image) image.Image {
+			return imageproc.Rotate(img, degrees)
+		}}, nil
+	})
+}
+
+// GetFlip mirrors an image horizontally or vertically and saves to an S3 bucket
+func (s *Service) GetFlip(w http.ResponseWriter, r *http.Request) {
+	s.deriveImage(w, r, "flip", func(size string) (derivative, error) {
+		if !imageproc.IsValidFlip(size) {
+			return derivative{}, fmt.Errorf("must be horizontal or vertical")
+		}
+		return derivative{transform: func(img image.Image) image.Image {
+			return imageproc.Flip(img, size)
+		}}, nil
+	})
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/okebinda/internal/problem"
+)
+
+// sandboxKey tests if an image key is under the SANDBOX_PREFIX env parameter (default "_sandbox"), where the
+// image-upload service publishes sandbox uploads
+func sandboxKey(imageKey string) bool {
+	prefix := strings.Trim(os.Getenv("SANDBOX_PREFIX"), "/")
+	if prefix == "" {
+		prefix = "_sandbox"
+	}
+	return strings.HasPrefix(imageKey, prefix+"/")
+}
+
+// sandboxBuckets returns the source and destination buckets for an image: for sandbox images, those in the
+// SANDBOX_AWS_S3_BUCKET_SOURCE and SANDBOX_AWS_S3_BUCKET_DESTINATION env parameters, so sandbox requests never
+// read or write production buckets, responding not found if they are not set; it returns false if a response has
+// been written
+func sandboxBuckets(w http.ResponseWriter, imageKey, sourceBucket, destinationBucket string) (string, string, bool) {
+	if !sandboxKey(imageKey) {
+		return sourceBucket, destinationBucket, true
+	}
+	sourceBucket = os.Getenv("SANDBOX_AWS_S3_BUCKET_SOURCE")
+	destinationBucket = os.Getenv("SANDBOX_AWS_S3_BUCKET_DESTINATION")
+	if sourceBucket == "" || destinationBucket == "" {
+		logger.Infof("Sandbox is not enabled: %s", imageKey)
+		userErrorResponse(w, 404, problem.NotFound, "Not found.")
+		return "", "", false
+	}
+	return sourceBucket, destinationBucket, true
+}
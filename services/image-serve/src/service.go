@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/okebinda/internal/awsconfig"
+)
+
+// Clock tells the current time
+type Clock interface {
+	Now() time.Time
+}
+
+// Service holds what the handlers depend on outside the request: the S3 client, the AWS session every other
+// client, e.g. DynamoDB and Lambda, is created from, and the clock; create it with other clients to run the
+// handlers against fakes, stub endpoints (see awsconfig), or at a fixed time, e.g. before an embargo lifts
+type Service struct {
+	S3      s3iface.S3API
+	Session *session.Session
+	Clock   Clock
+}
+
+// NewService creates a service with its S3 client, session, and clock
+func NewService(s3Client s3iface.S3API, sess *session.Session, clock Clock) *Service {
+	return &Service{
+		S3:      s3Client,
+		Session: sess,
+		Clock:   clock,
+	}
+}
+
+// systemClock tells the time by the system clock
+type systemClock struct{}
+
+// Now returns the current time
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// newDeployedService creates the service of a deployed function: clients of a session configured from the
+// environment, whose requests are sent with the invocation's context, so they are abandoned at the function's
+// deadline, each limited to AWS_OPERATION_TIMEOUT_MS if it is set, and the system clock
+func newDeployedService() *Service {
+	sess := awsconfig.NewContextFuncSession(func() context.Context {
+		return invocation
+	})
+	return NewService(s3.New(sess), sess, systemClock{})
+}
+
+// service is the service the handlers are registered on
+var service = newDeployedService()
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/okebinda/internal/awstest"
+	"go.uber.org/zap"
+)
+
+// newTestService creates a service with a fake S3 client, at awstest.Now, and discards its logs
+func newTestService(s3Client *awstest.FakeS3) *Service {
+	logger = zap.NewNop().Sugar()
+	sess := awstest.NewSession()
+	s3Client.S3API = s3.New(sess)
+	return NewService(s3Client, sess, awstest.FixedClock{Time: awstest.Now})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// reSize matches a WxH size
+var reSize = regexp.MustCompile(`^(\d+)x(\d+)$`)
+
+// sizeLimit is the list of sizes images may be resized to, and whether other sizes are snapped to the nearest
+// of them or rejected
+type sizeLimit struct {
+	sizes []image.Point
+	snap  bool
+}
+
+// sizeLimitFromEnv reads the sizes allowed by the ALLOWED_SIZES env parameter, a comma separated list of WxH
+// sizes, and if ALLOWED_SIZES_MODE is "snap" (rather than the default "reject"); all sizes are allowed if
+// ALLOWED_SIZES is not set
+func sizeLimitFromEnv() (sizeLimit, error) {
+	var limit sizeLimit
+	for _, size := range splitList(os.Getenv("ALLOWED_SIZES")) {
+		match := reSize.FindStringSubmatch(size)
+		if match == nil {
+			return limit, fmt.Errorf("invalid size: %s", size)
+		}
+		width, _ := strconv.Atoi(match[1])
+		height, _ := strconv.Atoi(match[2])
+		limit.sizes = append(limit.sizes, image.Pt(width, height))
+	}
+	switch mode := os.Getenv("ALLOWED_SIZES_MODE"); mode {
+	case "", "reject":
+	case "snap":
+		limit.snap = true
+	default:
+		return limit, fmt.Errorf("unknown mode: %s", mode)
+	}
+	return limit, nil
+}
+
+// check tests if a size is allowed, where width or height is 0 if only the other dimension was requested;
+// returns the size to generate, snapped to the nearest allowed size in snap mode, and false if it is not allowed
+func (l sizeLimit) check(width, height int) (int, int, bool) {
+	if len(l.sizes) == 0 {
+		return width, height, true
+	}
+	var nearest image.Point
+	distance := -1
+	for _, size := range l.sizes {
+		if width == 0 {
+			size.X = 0
+		}
+		if height == 0 {
+			size.Y = 0
+		}
+		d := abs(size.X-width) + abs(size.Y-height)
+		if d == 0 {
+			return width, height, true
+		}
+		if distance < 0 || d < distance {
+			nearest, distance = size, d
+		}
+	}
+	if !l.snap {
+		return width, height, false
+	}
+	return nearest.X, nearest.Y, true
+}
+
+// abs returns the absolute value of an int
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
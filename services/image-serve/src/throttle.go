@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/okebinda/internal/httpresp"
+	"github.com/okebinda/internal/lock"
+	"github.com/okebinda/internal/problem"
+)
+
+// sourceLockTTL is the longest a source image slot is held, in case a function instance fails to release it
+const sourceLockTTL = 30 * time.Second
+
+// sourceSlot waits for one of the SOURCE_CONCURRENCY (default 2) slots for processing a source image, shared by
+// all function instances in the LOCK_TABLE DynamoDB table, for up to SOURCE_LOCK_WAIT_MS (default 3000)
+// milliseconds; if no slot is freed it responds with a 503, to be retried; returns a function releasing the slot,
+// and false if the request was rejected; requests are not limited if LOCK_TABLE is not set
+func sourceSlot(w http.ResponseWriter, sess *session.Session, bucketName, imageKey string) (func(), bool) {
+	table := os.Getenv("LOCK_TABLE")
+	if table == "" {
+		return func() {}, true
+	}
+	concurrency, err := envInt("SOURCE_CONCURRENCY", 2)
+	if err != nil {
+		logger.Errorf("Could not convert SOURCE_CONCURRENCY to int: %v", err)
+		serverErrorResponse(w)
+		return nil, false
+	}
+	waitMS, err := envInt("SOURCE_LOCK_WAIT_MS", 3000)
+	if err != nil {
+		logger.Errorf("Could not convert SOURCE_LOCK_WAIT_MS to int: %v", err)
+		serverErrorResponse(w)
+		return nil, false
+	}
+
+	name := "source/" + bucketName + "/" + imageKey
+	l, err := lock.Wait(sess, table, name, requestID, concurrency, sourceLockTTL, time.Duration(waitMS)*time.Millisecond)
+	if err != nil {
+		// don't fail requests because the lock table is unavailable
+		logger.Errorf("Could not acquire source lock: %s, %v", imageKey, err)
+		return func() {}, true
+	}
+	if l == nil {
+		logger.Infow("Source image busy.",
+			"image_key", imageKey,
+		)
+		w.Header().Set("Retry-After", "1")
+		if err = httpresp.Reject(w, language, requestID, 503, problem.SourceBusy, "Image is busy, try again.", nil); err != nil {
+			logger.Errorf("Error generating response: %s", err)
+		}
+		return nil, false
+	}
+
+	return func() {
+		if err := l.Release(); err != nil {
+			logger.Errorf("Could not release source lock: %s, %v", imageKey, err)
+		}
+	}, true
+}
+
+// envInt reads an int env parameter, or returns a default value if it is not set
+func envInt(name string, defaultValue int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// derivativeLockTTL is the longest a derivative is locked, in case a function instance fails to release it
+const derivativeLockTTL = 30 * time.Second
+
+// derivativePollInterval is the time between checks for a derivative generated by another request
+const derivativePollInterval = 250 * time.Millisecond
+
+// derivativeLock locks a derivative missing from the cache bucket so only one request generates it; other
+// requests wait up to DERIVATIVE_LOCK_WAIT_MS (default 3000) milliseconds for it to land, responding with the
+// cached image, or else with a 503, to be retried; returns a function releasing the lock, and false if the
+// request was answered; derivatives are not locked if LOCK_TABLE is not set
+func (s *Service) derivativeLock(w http.ResponseWriter, r *http.Request, bucketName, fileKey, redirectURL string) (func(), bool) {
+	table := os.Getenv("LOCK_TABLE")
+	if table == "" {
+		return func() {}, true
+	}
+	waitMS, err := envInt("DERIVATIVE_LOCK_WAIT_MS", 3000)
+	if err != nil {
+		logger.Errorf("Could not convert DERIVATIVE_LOCK_WAIT_MS to int: %v", err)
+		serverErrorResponse(w)
+		return nil, false
+	}
+
+	name := "derivative/" + bucketName + "/" + fileKey
+	l, err := lock.Acquire(s.Session, table, name, requestID, 1, derivativeLockTTL)
+	if err != nil {
+		// don't fail requests because the lock table is unavailable
+		logger.Errorf("Could not acquire derivative lock: %s, %v", fileKey, err)
+		return func() {}, true
+	}
+	if l != nil {
+		return func() {
+			if err := l.Release(); err != nil {
+				logger.Errorf("Could not release derivative lock: %s, %v", fileKey, err)
+			}
+		}, true
+	}
+
+	// wait for the derivative to be generated by the request holding the lock
+	logger.Infow("Derivative pending.",
+		"file_key", fileKey,
+	)
+	deadline := s.Clock.Now().Add(time.Duration(waitMS) * time.Millisecond)
+	for s.Clock.Now().Before(deadline) {
+		time.Sleep(derivativePollInterval)
+		if s.cachedResponse(w, r, bucketName, fileKey, redirectURL) {
+			return nil, false
+		}
+	}
+	w.Header().Set("Retry-After", "1")
+	if err = httpresp.Reject(w, language, requestID, 503, problem.DerivativePending, "Image is being generated, try again.", nil); err != nil {
+		logger.Errorf("Error generating response: %s", err)
+	}
+	return nil, false
+}
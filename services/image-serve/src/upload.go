@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/okebinda/internal/httpresp"
+	"github.com/okebinda/internal/invoke"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/rejections"
+)
+
+// notFoundResponse generates a not found (404) response for a missing source image; if the UPLOAD_FUNCTION_NAME
+// env parameter names the image-upload internal function, the rejection of the image's upload is looked up by
+// its file ID (the key's base name, without extension) and returned with the response
+func notFoundResponse(w http.ResponseWriter, sess *session.Session, imageKey string) {
+	functionName := os.Getenv("UPLOAD_FUNCTION_NAME")
+	if functionName == "" {
+		userErrorResponse(w, 404, problem.NotFound, "Not found.")
+		return
+	}
+
+	// look up the rejection, falling back to a plain response if the call fails
+	fileID := strings.TrimSuffix(path.Base(imageKey), path.Ext(imageKey))
+	var rejection *rejections.Rejection
+	err := invoke.Call(sess, functionName, invoke.GetRejection, invoke.RejectionInput{FileID: fileID}, &rejection)
+	if err != nil {
+		logger.Errorf("Failed to look up rejection: %s, %v", fileID, err)
+	}
+	if rejection == nil {
+		userErrorResponse(w, 404, problem.NotFound, "Not found.")
+		return
+	}
+
+	logger.Infow("Image upload rejected.",
+		"file_id", fileID,
+		"rule", rejection.Rule,
+	)
+
+	err = httpresp.Reject(w, language, requestID, 404, problem.UploadRejected, "Not found.", map[string]interface{}{
+		"rule":        rejection.Rule,
+		"message":     rejection.Message,
+		"rejected_at": rejection.RejectedAt,
+	})
+	if err != nil {
+		logger.Errorf("Error generating response: %s", err)
+	}
+}
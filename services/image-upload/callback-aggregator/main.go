@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/google/uuid"
+	"github.com/okebinda/internal/awsconfig"
+	"github.com/okebinda/internal/callbacks"
+	"github.com/okebinda/internal/logging"
+	"github.com/okebinda/internal/notify"
+	"github.com/okebinda/internal/webhooks"
+	"go.uber.org/zap"
+)
+
+var logger *zap.SugaredLogger
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call, with batches of callbacks collected
+// from the callback aggregation queue; the callbacks of a batch to the same URL, with the same headers, are posted
+// as one callbacks_aggregated callback with a manifest of them, signed like the callbacks they aggregate and
+// marked with an X-Callback-ID header of its own; groups that could not be delivered are retried, unless the
+// callback URL rejected them
+func Handler(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+
+	// initialize logger
+	lc, _ := lambdacontext.FromContext(ctx)
+	logger = logging.New(lc.AwsRequestID)
+	defer logger.Sync()
+
+	// get environment parameters
+	retry, err := notify.RetryFromEnv()
+	if err != nil {
+		logger.Errorf("Could not read callback retry limits: %v", err)
+		return events.SQSEventResponse{}, err
+	}
+
+	// parse queued callbacks, remembering their messages to retry them
+	var queued []callbacks.Callback
+	messageIDs := map[string]string{}
+	for _, message := range event.Records {
+		var callback callbacks.Callback
+		if err := json.Unmarshal([]byte(message.Body), &callback); err != nil || callback.URL == "" {
+			logger.Errorf("Could not parse callback: %s, %v", message.MessageId, err)
+			continue
+		}
+		queued = append(queued, callback)
+		messageIDs[callback.CallbackID] = message.MessageId
+	}
+
+	// initialize AWS session
+	sess := awsconfig.NewContextSession(ctx)
+
+	var response events.SQSEventResponse
+	for _, group := range callbacks.Aggregate(queued) {
+		if err := postGroup(ctx, sess, retry, group); err != nil {
+			for _, callback := range group.Callbacks {
+				response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{
+					ItemIdentifier: messageIDs[callback.CallbackID],
+				})
+			}
+		}
+	}
+	return response, nil
+}
+
+// postGroup posts the aggregated callback of a group of callbacks, signed with the secrets of its URL's webhook
+// subscription, if any, or the CALLBACK_SECRET env parameter, returning an error if it should be retried
+func postGroup(ctx context.Context, sess *session.Session, retry notify.Retry, group callbacks.Group) error {
+	aggregateID := uuid.New().String()
+	secrets, err := webhooks.SecretsForURL(sess, os.Getenv("WEBHOOKS_TABLE"), group.URL, os.Getenv("CALLBACK_SECRET"), time.Now())
+	if err != nil {
+		logger.Errorf("Failed to read webhook subscriptions, will be retried: %s, %v", group.URL, err)
+		return err
+	}
+	headers := map[string]string{}
+	for name, value := range group.Headers {
+		headers[name] = value
+	}
+	headers["X-Callback-ID"] = aggregateID
+
+	if err = notify.Callback(ctx, group.URL, secrets, headers, group.Payload(), retry); err != nil {
+		if !notify.Retryable(err) {
+			logger.Errorf("Aggregated callback rejected, will not be retried: %s, %v", group.URL, err)
+			return nil
+		}
+		logger.Errorf("Failed to post aggregated callback, will be retried: %s, %v", group.URL, err)
+		return err
+	}
+
+	logger.Infow("Aggregated callback posted.",
+		"callback_id", aggregateID,
+		"url", group.URL,
+		"callbacks", len(group.Callbacks),
+	)
+	return nil
+}
+
+func main() {
+	lambda.Start(Handler)
+}
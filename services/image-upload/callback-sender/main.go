@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/okebinda/internal/awsconfig"
+	"github.com/okebinda/internal/callbacks"
+	"github.com/okebinda/internal/logging"
+	"github.com/okebinda/internal/notify"
+	"github.com/okebinda/internal/tracing"
+	"github.com/okebinda/internal/webhooks"
+	"go.uber.org/zap"
+)
+
+var logger *zap.SugaredLogger
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call, with recorded callbacks re-enqueued
+// to the callback queue by a replay request, or enqueued by the request that posted them when the post failed;
+// each is published again to its SNS topic, with its message attributes and a "replay" attribute, or posted again
+// to its URL, signed with the CALLBACK_SECRET env parameter and marked with the X-Callback-ID header and, unless it
+// is a retry, the X-Callback-Replay header; callbacks that could not be delivered are retried, unless the callback
+// URL rejected them
+func Handler(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+
+	// initialize logger
+	lc, _ := lambdacontext.FromContext(ctx)
+	logger = logging.New(lc.AwsRequestID)
+	defer logger.Sync()
+
+	// get environment parameters
+	retry, err := notify.RetryFromEnv()
+	if err != nil {
+		logger.Errorf("Could not read callback retry limits: %v", err)
+		return events.SQSEventResponse{}, err
+	}
+
+	// initialize AWS session
+	sess := awsconfig.NewContextSession(ctx)
+
+	var response events.SQSEventResponse
+	for _, message := range event.Records {
+		var callback callbacks.Callback
+		if err := json.Unmarshal([]byte(message.Body), &callback); err != nil {
+			logger.Errorf("Could not parse callback: %s, %v", message.MessageId, err)
+			continue
+		}
+		for name, value := range message.MessageAttributes {
+			if value.StringValue == nil {
+				continue
+			}
+			if callback.Attributes == nil {
+				callback.Attributes = map[string]string{}
+			}
+			callback.Attributes[name] = *value.StringValue
+		}
+		replayMessage(ctx, sess, retry, message, callback, &response)
+	}
+	return response, nil
+}
+
+// replayMessage replays the callback of one message, adding the message to the response's failures if it should
+// be retried; the replay is traced in the trace of the replay request, if it was traced
+func replayMessage(ctx context.Context, sess *session.Session, retry notify.Retry, message events.SQSMessage, callback callbacks.Callback, response *events.SQSEventResponse) {
+
+	// continue the replay request's trace
+	ctx, segment := tracing.Continue(ctx, message.Attributes[tracing.SQSAttribute], "callback-sender")
+	if segment != nil {
+		segment.Annotate("callback_id", callback.CallbackID)
+		sess = awsconfig.WithContext(sess, ctx, 0)
+		defer segment.End(nil)
+	}
+
+	// sign callbacks to a webhook subscription's URL with its secrets
+	secrets := []string{os.Getenv("CALLBACK_SECRET")}
+	if callback.URL != "" {
+		var err error
+		secrets, err = webhooks.SecretsForURL(sess, os.Getenv("WEBHOOKS_TABLE"), callback.URL, os.Getenv("CALLBACK_SECRET"), time.Now())
+		if err != nil {
+			logger.Errorf("Failed to read webhook subscriptions, will be retried: %s, %v", callback.CallbackID, err)
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{
+				ItemIdentifier: message.MessageId,
+			})
+			return
+		}
+	}
+
+	// publish to the callback topic, or post to the callback URL
+	if err := callbacks.Replay(ctx, sess, callback, secrets, retry); err != nil {
+		if !notify.Retryable(err) {
+			logger.Errorf("Callback rejected, will not be retried: %s, %v", callback.CallbackID, err)
+			return
+		}
+		logger.Errorf("Failed to replay callback, will be retried: %s, %v", callback.CallbackID, err)
+		response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{
+			ItemIdentifier: message.MessageId,
+		})
+		return
+	}
+
+	logger.Infow("Callback replayed.",
+		"callback_id", callback.CallbackID,
+		"event", callback.Event,
+		"file_id", callback.FileID,
+	)
+}
+
+func main() {
+	lambda.Start(Handler)
+}
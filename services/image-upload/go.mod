@@ -3,11 +3,13 @@ module github.com/okebinda/image-upload
 go 1.15
 
 require (
-	github.com/aws/aws-lambda-go v1.19.1
-	github.com/aws/aws-sdk-go v1.35.14
+	github.com/aws/aws-lambda-go v1.28.0
+	github.com/aws/aws-sdk-go v1.35.19
 	github.com/awslabs/aws-lambda-go-api-proxy v0.9.0
-	github.com/disintegration/imaging v1.6.2
 	github.com/go-chi/chi v4.1.2+incompatible
 	github.com/google/uuid v1.1.2
+	github.com/okebinda/internal v0.0.0-00010101000000-000000000000
 	go.uber.org/zap v1.16.0
 )
+
+replace github.com/okebinda/internal => ../internal
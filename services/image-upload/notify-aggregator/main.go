@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/okebinda/internal/awsconfig"
+	"github.com/okebinda/internal/logging"
+	"github.com/okebinda/internal/notify"
+	"go.uber.org/zap"
+)
+
+var logger *zap.SugaredLogger
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call, with batches of upload
+// notifications collected from the notification queue; each batch is sent as one notification
+func Handler(ctx context.Context, event events.SQSEvent) error {
+
+	// initialize logger
+	lc, _ := lambdacontext.FromContext(ctx)
+	logger = logging.New(lc.AwsRequestID)
+	defer logger.Sync()
+
+	// parse queued notifications
+	var uploads []notify.Upload
+	for _, record := range event.Records {
+		var upload notify.Upload
+		if err := json.Unmarshal([]byte(record.Body), &upload); err != nil {
+			logger.Errorf("Could not parse notification: %s, %v", record.MessageId, err)
+			continue
+		}
+		uploads = append(uploads, upload)
+	}
+	if len(uploads) == 0 {
+		return nil
+	}
+
+	logger.Infow("Sending upload digest.",
+		"uploads", len(uploads),
+	)
+
+	// send digest; failures are logged rather than retried, so other recipients are not notified twice
+	subject, message := notify.Digest(uploads)
+	if webhookURL := os.Getenv("NOTIFY_WEBHOOK_URL"); webhookURL != "" {
+		if err := notify.Webhook(ctx, webhookURL, message); err != nil {
+			logger.Errorf("Failed to post upload digest: %v", err)
+		}
+	}
+	if to := splitList(os.Getenv("NOTIFY_EMAIL_TO")); len(to) > 0 {
+		sess := awsconfig.NewContextSession(ctx)
+		if err := notify.Email(sess, os.Getenv("NOTIFY_EMAIL_FROM"), to, subject, message); err != nil {
+			logger.Errorf("Failed to email upload digest: %v", err)
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma separated list, dropping empty values
+func splitList(list string) []string {
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func main() {
+	lambda.Start(Handler)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/okebinda/internal/awsconfig"
+	"github.com/okebinda/internal/logging"
+	"github.com/okebinda/internal/notify"
+	"go.uber.org/zap"
+)
+
+var logger *zap.SugaredLogger
+
+// Spike defines a rejection reason whose current rate exceeds its baseline
+type Spike struct {
+	Service      string
+	Reason       string
+	Rejections   float64
+	CurrentRate  float64
+	BaselineRate float64
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call, on a schedule
+func Handler(ctx context.Context, event events.CloudWatchEvent) error {
+
+	// initialize logger
+	lc, _ := lambdacontext.FromContext(ctx)
+	logger = logging.New(lc.AwsRequestID)
+	defer logger.Sync()
+
+	// get environment parameters
+	namespace := os.Getenv("METRICS_NAMESPACE")
+	windowMinutes, err := strconv.Atoi(os.Getenv("WINDOW_MINUTES"))
+	if err != nil {
+		logger.Errorf("Could not convert WINDOW_MINUTES to int: %v", err)
+		return err
+	}
+	baselineHours, err := strconv.Atoi(os.Getenv("BASELINE_HOURS"))
+	if err != nil {
+		logger.Errorf("Could not convert BASELINE_HOURS to int: %v", err)
+		return err
+	}
+	multiplier, err := strconv.ParseFloat(os.Getenv("SPIKE_MULTIPLIER"), 64)
+	if err != nil {
+		logger.Errorf("Could not convert SPIKE_MULTIPLIER to float64: %v", err)
+		return err
+	}
+	minRejections, err := strconv.ParseFloat(os.Getenv("MIN_REJECTIONS"), 64)
+	if err != nil {
+		logger.Errorf("Could not convert MIN_REJECTIONS to float64: %v", err)
+		return err
+	}
+
+	// initialize AWS session
+	sess := awsconfig.NewContextSession(ctx)
+	svc := cloudwatch.New(sess)
+
+	// define current and baseline windows
+	end := time.Now().Truncate(time.Minute)
+	windowStart := end.Add(-time.Duration(windowMinutes) * time.Minute)
+	baselineStart := windowStart.Add(-time.Duration(baselineHours) * time.Hour)
+
+	// find rejection reasons with recorded metrics
+	rejectionMetrics, err := listMetrics(svc, namespace, "Rejections")
+	if err != nil {
+		logger.Errorf("Failed to list metrics: %v", err)
+		return err
+	}
+
+	// compare current rejection rate per reason against the baseline
+	var spikes []Spike
+	currentTotals := map[string]float64{}
+	baselineTotals := map[string]float64{}
+	for _, metric := range rejectionMetrics {
+		service := dimensionValue(metric, "Service")
+		reason := dimensionValue(metric, "Reason")
+
+		if _, ok := currentTotals[service]; !ok {
+			currentTotals[service], baselineTotals[service], err = serviceTotals(svc, namespace, service, baselineStart, windowStart, end)
+			if err != nil {
+				logger.Errorf("Failed to read event totals: %s, %v", service, err)
+				return err
+			}
+		}
+
+		current, err := sumMetric(svc, metric, windowStart, end)
+		if err != nil {
+			logger.Errorf("Failed to read rejections: %s, %s, %v", service, reason, err)
+			return err
+		}
+		baseline, err := sumMetric(svc, metric, baselineStart, windowStart)
+		if err != nil {
+			logger.Errorf("Failed to read rejections: %s, %s, %v", service, reason, err)
+			return err
+		}
+
+		currentRate := rate(current, currentTotals[service])
+		baselineRate := rate(baseline, baselineTotals[service])
+
+		logger.Infow("Rejection rate",
+			"service", service,
+			"reason", reason,
+			"rejections", current,
+			"current_rate", currentRate,
+			"baseline_rate", baselineRate,
+		)
+
+		if current >= minRejections && currentRate > baselineRate*multiplier {
+			spikes = append(spikes, Spike{
+				Service:      service,
+				Reason:       reason,
+				Rejections:   current,
+				CurrentRate:  currentRate,
+				BaselineRate: baselineRate,
+			})
+		}
+	}
+
+	if len(spikes) == 0 {
+		return nil
+	}
+
+	// send alerts
+	message := alertMessage(spikes, windowMinutes, baselineHours)
+	logger.Warnw("Rejection rate spike detected.",
+		"spikes", len(spikes),
+	)
+	if topicArn := os.Getenv("ALERT_TOPIC_ARN"); topicArn != "" {
+		if err := publishAlert(sess, topicArn, message); err != nil {
+			logger.Errorf("Failed to publish alert: %v", err)
+			return err
+		}
+	}
+	if webhookURL := os.Getenv("ALERT_WEBHOOK_URL"); webhookURL != "" {
+		if err := notify.Webhook(ctx, webhookURL, message); err != nil {
+			logger.Errorf("Failed to post alert: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// listMetrics lists all dimension combinations recorded for a metric
+func listMetrics(svc *cloudwatch.CloudWatch, namespace, metricName string) ([]*cloudwatch.Metric, error) {
+	var metrics []*cloudwatch.Metric
+	err := svc.ListMetricsPages(&cloudwatch.ListMetricsInput{
+		Namespace:  aws.String(namespace),
+		MetricName: aws.String(metricName),
+	}, func(page *cloudwatch.ListMetricsOutput, lastPage bool) bool {
+		metrics = append(metrics, page.Metrics...)
+		return true
+	})
+	return metrics, err
+}
+
+// sumMetric sums a metric over a time range
+func sumMetric(svc *cloudwatch.CloudWatch, metric *cloudwatch.Metric, start, end time.Time) (float64, error) {
+	output, err := svc.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
+		Namespace:  metric.Namespace,
+		MetricName: metric.MetricName,
+		Dimensions: metric.Dimensions,
+		StartTime:  aws.Time(start),
+		EndTime:    aws.Time(end),
+		Period:     aws.Int64(int64(end.Sub(start).Seconds())),
+		Statistics: []*string{aws.String(cloudwatch.StatisticSum)},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var sum float64
+	for _, datapoint := range output.Datapoints {
+		sum += aws.Float64Value(datapoint.Sum)
+	}
+	return sum, nil
+}
+
+// serviceTotals sums all events recorded by a service over the baseline and current windows
+func serviceTotals(svc *cloudwatch.CloudWatch, namespace, service string, baselineStart, windowStart, end time.Time) (float64, float64, error) {
+	eventMetrics, err := listMetrics(svc, namespace, "Events")
+	if err != nil {
+		return 0, 0, err
+	}
+	var current, baseline float64
+	for _, metric := range eventMetrics {
+		if dimensionValue(metric, "Service") != service {
+			continue
+		}
+		sum, err := sumMetric(svc, metric, windowStart, end)
+		if err != nil {
+			return 0, 0, err
+		}
+		current += sum
+		sum, err = sumMetric(svc, metric, baselineStart, windowStart)
+		if err != nil {
+			return 0, 0, err
+		}
+		baseline += sum
+	}
+	return current, baseline, nil
+}
+
+// dimensionValue returns the value of a metric's dimension
+func dimensionValue(metric *cloudwatch.Metric, name string) string {
+	for _, dimension := range metric.Dimensions {
+		if aws.StringValue(dimension.Name) == name {
+			return aws.StringValue(dimension.Value)
+		}
+	}
+	return ""
+}
+
+// rate returns count as a fraction of total
+func rate(count, total float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return count / total
+}
+
+// alertMessage formats the alert text for a list of spikes
+func alertMessage(spikes []Spike, windowMinutes, baselineHours int) string {
+	lines := []string{fmt.Sprintf("Rejection rate spike in the last %d minutes (baseline: previous %d hours):", windowMinutes, baselineHours)}
+	for _, spike := range spikes {
+		lines = append(lines, fmt.Sprintf("- %s / %s: %.0f rejections, rate %.1f%% (baseline %.1f%%)",
+			spike.Service, spike.Reason, spike.Rejections, spike.CurrentRate*100, spike.BaselineRate*100))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// publishAlert publishes an alert message to an SNS topic
+func publishAlert(sess *session.Session, topicArn, message string) error {
+	_, err := sns.New(sess).Publish(&sns.PublishInput{
+		TopicArn: aws.String(topicArn),
+		Subject:  aws.String("Image rejection rate spike"),
+		Message:  aws.String(message),
+	})
+	return err
+}
+
+func main() {
+	lambda.Start(Handler)
+}
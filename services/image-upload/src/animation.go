@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/okebinda/internal/analytics"
+	"github.com/okebinda/internal/imageproc"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/rejections"
+)
+
+// checkAnimation rejects GIFs whose frame count, decoded pixels, or duration exceed the limits before any frames
+// are decoded, recording the rejection; GIFs whose structure cannot be read are left for the decoders to reject
+func checkAnimation(sess *session.Session, requestData RequestPayload, file *os.File, limits imageproc.AnimationLimits, bucket, localFile, fileKey, fileType string, numBytes int64) *processError {
+	animation, err := imageproc.ScanGIF(localFile)
+	if err != nil {
+		logger.Infof("Could not scan GIF: %v", err)
+		return nil
+	}
+	if err = limits.Check(animation); err == nil {
+		return nil
+	}
+
+	errorMessage := fmt.Sprintf("Animation is too large: %v, %s", err, fileKey)
+	logger.Errorf(errorMessage)
+	recordRejection(sess, rejections.Rejection{
+		FileID:       requestData.FileID,
+		FileKey:      fileKey,
+		Rule:         "animation_too_large",
+		Message:      errorMessage,
+		DetectedType: fileType,
+		MagicBytes:   magicBytes(file),
+		SizeBytes:    numBytes,
+		Limit:        fmt.Sprintf("frames=%d,pixels=%d,duration_ms=%d", limits.Frames, limits.Pixels, limits.Duration.Milliseconds()),
+	})
+	recordEvent(sess, analytics.Event{
+		EventType: analytics.EventUploadRejected,
+		Bucket:    bucket,
+		FileKey:   fileKey,
+		FileType:  fileType,
+		SizeBytes: numBytes,
+		Reason:    "animation_too_large",
+	})
+	return &processError{400, problem.AnimationTooLarge, errorMessage}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/okebinda/internal/callbacks"
+	"github.com/okebinda/internal/notify"
+	"github.com/okebinda/internal/queue"
+	"github.com/okebinda/internal/webhooks"
+)
+
+// CallbackPayload defines the JSON schema for the payload posted to a request's callback_url once its image is
+// published, echoing the request's context
+type CallbackPayload struct {
+	Event         string            `json:"event"`
+	Bucket        string            `json:"bucket"`
+	Checksums     *ChecksumPayload  `json:"checksums,omitempty"`
+	FileID        string            `json:"file_id"`
+	Directory     string            `json:"directory"`
+	FileExtension string            `json:"file_extension"`
+	FileKey       string            `json:"file_key"`
+	ContentType   string            `json:"content_type"`
+	Width         int               `json:"width"`
+	Height        int               `json:"height"`
+	SizeBytes     int64             `json:"size_bytes"`
+	Encrypted     bool              `json:"encrypted,omitempty"`
+	Variants      []VariantPayload  `json:"variants,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+	ProcessedAt   string            `json:"processed_at"`
+	Sandbox       bool              `json:"sandbox,omitempty"`
+	Context       json.RawMessage   `json:"context,omitempty"`
+}
+
+// processedPayload returns the CallbackPayload for a published image
+func (s *Service) processedPayload(requestData RequestPayload, responseData *ResponsePayload, published publishedImage) CallbackPayload {
+	return CallbackPayload{
+		Event:         "upload_processed",
+		Bucket:        responseData.Bucket,
+		Checksums:     responseData.Checksums,
+		FileID:        requestData.FileID,
+		Directory:     requestData.Directory,
+		FileExtension: requestData.FileExtension,
+		FileKey:       published.fileKey,
+		ContentType:   published.fileType,
+		Width:         published.width,
+		Height:        published.height,
+		SizeBytes:     responseData.SizeBytes,
+		Encrypted:     responseData.Encrypted,
+		Variants:      responseData.Variants,
+		Annotations:   responseData.Annotations,
+		ProcessedAt:   s.Clock.Now().UTC().Format(time.RFC3339),
+		Sandbox:       requestData.Sandbox,
+		Context:       requestData.Context,
+	}
+}
+
+// subscriptionsMaxAge is how long the webhook subscriptions read to fan out callbacks are reused for
+const subscriptionsMaxAge = time.Minute
+
+// sendProcessedCallback posts the CallbackPayload for a published image to the request's callback targets
+func (s *Service) sendProcessedCallback(requestData RequestPayload, payload CallbackPayload) {
+	s.postCallback(requestData, payload.Event, payload)
+}
+
+// postCallback posts a payload to the request's callback_url, if set, with its callback_headers and an
+// X-Callback-ID header, and to the URL of each webhook subscribed to the event in the WEBHOOKS_TABLE env
+// parameter, if set, except for sandbox requests, and publishes it to the request's callback_sns_topic_arn, if
+// set; posts to a subscription's URL are signed with its secrets, others with the CALLBACK_SECRET env parameter.
+// Callbacks to the callback_url of a request with callback_aggregate are queued to be posted in batches instead.
+// Each callback carries the request's propagated message attributes and is recorded to be replayed, and failures
+// are logged and do not affect processing
+func (s *Service) postCallback(requestData RequestPayload, event string, payload interface{}) {
+	table := os.Getenv("WEBHOOKS_TABLE")
+	if requestData.CallbackURL == "" && requestData.CallbackSNSTopicARN == "" && (table == "" || requestData.Sandbox) {
+		return
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logger.Errorf("Error marshalling callback: %v", err)
+		return
+	}
+
+	// queue the callback to be posted in an aggregated callback, or post to the callback URL, signed with the
+	// secrets of its subscription, if any
+	if requestData.CallbackURL != "" && requestData.CallbackAggregate {
+		s.aggregateCallback(requestData, event, body)
+	} else if requestData.CallbackURL != "" {
+		secrets, err := webhooks.SecretsForURL(s.Session, table, requestData.CallbackURL, os.Getenv("CALLBACK_SECRET"), s.Clock.Now())
+		if err != nil {
+			logger.Errorf("Failed to read webhook subscription: %v", err)
+			secrets = []string{os.Getenv("CALLBACK_SECRET")}
+		}
+		s.postCallbackURL(requestData, event, requestData.CallbackURL, requestData.CallbackHeaders, secrets, body)
+	}
+
+	// post to the subscribed webhooks, once per URL
+	if table != "" && !requestData.Sandbox {
+		subscriptions, err := webhooks.ListCached(s.Session, table, subscriptionsMaxAge, s.Clock.Now())
+		if err != nil {
+			logger.Errorf("Failed to read webhook subscriptions: %v", err)
+		}
+		for _, subscription := range subscriptions {
+			if subscription.URL != requestData.CallbackURL && subscription.Subscribed(event) {
+				s.postCallbackURL(requestData, event, subscription.URL, nil, subscription.Secrets(s.Clock.Now()), body)
+			}
+		}
+	}
+
+	// publish to the callback topic
+	if requestData.CallbackSNSTopicARN != "" {
+		callback := callbacks.Callback{
+			CallbackID: s.IDs.New().String(),
+			Event:      event,
+			FileID:     requestData.FileID,
+			Directory:  requestData.Directory,
+			TopicARN:   requestData.CallbackSNSTopicARN,
+			Attributes: requestData.attributes,
+			Payload:    string(body),
+			Status:     "delivered",
+		}
+		if err = callbacks.Publish(invocation, s.Session, callback, false); err != nil {
+			logger.Errorf("Failed to publish callback: %v", err)
+			callback.Status = fmt.Sprintf("failed: %v", err)
+		}
+		recordCallback(s.Session, callback)
+	}
+}
+
+// postCallbackURL posts a callback body to a URL with custom headers and an X-Callback-ID header, signed with
+// secrets, and records it; the post is not retried within the request, but if it fails with an error that may not
+// recur (see notify.Retryable), the callback is enqueued to the queue in the CALLBACK_QUEUE_URL env parameter, for
+// the callback-sender function to retry
+func (s *Service) postCallbackURL(requestData RequestPayload, event, url string, customHeaders map[string]string, secrets []string, body []byte) {
+	callback := callbacks.Callback{
+		CallbackID: s.IDs.New().String(),
+		Event:      event,
+		FileID:     requestData.FileID,
+		Directory:  requestData.Directory,
+		URL:        url,
+		Headers:    customHeaders,
+		Attributes: requestData.attributes,
+		Payload:    string(body),
+		Status:     "delivered",
+	}
+	headers := map[string]string{}
+	for name, value := range customHeaders {
+		headers[name] = value
+	}
+	headers["X-Callback-ID"] = callback.CallbackID
+	retry, err := notify.RetryFromEnv()
+	if err != nil {
+		logger.Errorf("Could not read callback retry limits: %v", err)
+		return
+	}
+	err = notify.Callback(invocation, url, secrets, headers, json.RawMessage(body), notify.Retry{Attempts: 1, Timeout: retry.Timeout})
+	if err != nil {
+		logger.Errorf("Failed to post callback: %s, %v", url, err)
+		callback.Status = fmt.Sprintf("failed: %v", err)
+		if notify.Retryable(err) {
+			retrying := callback
+			retrying.Retry = true
+			if queueErr := s.enqueueCallback(os.Getenv("CALLBACK_QUEUE_URL"), retrying); queueErr != nil {
+				logger.Errorf("Failed to queue callback to be retried: %s, %v", callback.CallbackID, queueErr)
+			} else {
+				callback.Status = fmt.Sprintf("retrying: %v", err)
+			}
+		}
+	}
+	recordCallback(s.Session, callback)
+}
+
+// aggregateCallback queues a callback body to the queue in the CALLBACK_AGGREGATE_QUEUE_URL env parameter (an SQS
+// queue URL, ARN, or "sqs://{name}"), to be posted to the request's callback_url with other callbacks to it in one
+// aggregated callback by the callback-aggregator function, or posts it at once if the queue is unavailable in the
+// degraded mode, and records it
+func (s *Service) aggregateCallback(requestData RequestPayload, event string, body []byte) {
+	callback := callbacks.Callback{
+		CallbackID: s.IDs.New().String(),
+		Event:      event,
+		FileID:     requestData.FileID,
+		Directory:  requestData.Directory,
+		URL:        requestData.CallbackURL,
+		Headers:    requestData.CallbackHeaders,
+		Attributes: requestData.attributes,
+		Payload:    string(body),
+		Status:     "aggregated",
+	}
+	err := s.enqueueCallback(os.Getenv("CALLBACK_AGGREGATE_QUEUE_URL"), callback)
+	if err == nil {
+		recordCallback(s.Session, callback)
+		return
+	}
+	logger.Errorf("Failed to queue callback for aggregation: %s, %v", callback.CallbackID, err)
+	if !degraded("aggregate", err) {
+		callback.Status = fmt.Sprintf("failed: %v", err)
+		recordCallback(s.Session, callback)
+		return
+	}
+
+	secrets, err := webhooks.SecretsForURL(s.Session, os.Getenv("WEBHOOKS_TABLE"), requestData.CallbackURL, os.Getenv("CALLBACK_SECRET"), s.Clock.Now())
+	if err != nil {
+		logger.Errorf("Failed to read webhook subscription: %v", err)
+		secrets = []string{os.Getenv("CALLBACK_SECRET")}
+	}
+	s.postCallbackURL(requestData, event, requestData.CallbackURL, requestData.CallbackHeaders, secrets, body)
+}
+
+// enqueueCallback publishes a callback to a queue (an SQS queue URL, ARN, or "sqs://{name}"), with its attributes
+// as message attributes
+func (s *Service) enqueueCallback(queueURL string, callback callbacks.Callback) error {
+	q, err := queue.OpenWithClient(s.Session, s.SQS, queueURL)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(callback)
+	if err != nil {
+		return err
+	}
+	_, err = publishMessage(q, queue.Message{Body: body, Attributes: callback.Attributes})
+	return err
+}
+
+// recordCallback records a callback in the CALLBACKS_TABLE env parameter's table, if set, for the
+// CALLBACK_RETENTION_DAYS env parameter's days; failures are logged and do not affect processing
+func recordCallback(sess *session.Session, callback callbacks.Callback) {
+	table := os.Getenv("CALLBACKS_TABLE")
+	if table == "" {
+		return
+	}
+	retention, err := callbacks.RetentionFromEnv()
+	if err != nil {
+		logger.Errorf("Could not convert CALLBACK_RETENTION_DAYS to int: %v", err)
+		return
+	}
+	if err = callbacks.Put(sess, table, callback, retention); err != nil {
+		logger.Errorf("Error recording callback: %v", err)
+	}
+}
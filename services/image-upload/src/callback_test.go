@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/okebinda/internal/awstest"
+	"github.com/okebinda/internal/callbacks"
+)
+
+func TestAggregateCallback(t *testing.T) {
+	awstest.Setenv(t, "CALLBACK_AGGREGATE_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/123456789012/aggregate")
+	awstest.Setenv(t, "QUEUE_PUBLISH_ATTEMPTS", "1")
+	awstest.Setenv(t, "QUEUE_FALLBACK", "")
+	awstest.Setenv(t, "WEBHOOKS_TABLE", "")
+	awstest.Setenv(t, "CALLBACKS_TABLE", "")
+
+	requestData := RequestPayload{
+		CallbackAggregate: true,
+		CallbackHeaders:   map[string]string{"Authorization": "Bearer x"},
+		CallbackURL:       "https://example.com/hook",
+		Directory:         "news",
+		FileID:            "id",
+		attributes:        map[string]string{"tenant": "acme"},
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		sent int
+	}{
+		{"queued", nil, 1},
+		{"queue unavailable", errors.New("unavailable"), 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sqsClient := &awstest.FakeSQS{Err: test.err}
+			s := newTestService(&awstest.FakeS3{}, sqsClient)
+			s.postCallback(requestData, "upload_processed", map[string]string{"event": "upload_processed"})
+
+			if len(sqsClient.Sent) != test.sent {
+				t.Fatalf("sent %d messages, want %d", len(sqsClient.Sent), test.sent)
+			}
+			if test.sent == 0 {
+				return
+			}
+			message := sqsClient.Sent[0]
+			if got := aws.StringValue(message.QueueUrl); got != "https://sqs.us-east-1.amazonaws.com/123456789012/aggregate" {
+				t.Errorf("queue URL = %s", got)
+			}
+			if got := message.MessageAttributes["tenant"]; got == nil || aws.StringValue(got.StringValue) != "acme" {
+				t.Errorf("tenant attribute = %v, want acme", got)
+			}
+			var callback callbacks.Callback
+			if err := json.Unmarshal([]byte(aws.StringValue(message.MessageBody)), &callback); err != nil {
+				t.Fatal(err)
+			}
+			if callback.URL != requestData.CallbackURL || callback.Headers["Authorization"] != "Bearer x" || callback.FileID != "id" ||
+				callback.Event != "upload_processed" || callback.Payload != `{"event":"upload_processed"}` || callback.CallbackID == "" {
+				t.Errorf("callback = %+v, want the request's callback to its URL", callback)
+			}
+		})
+	}
+}
+
+func TestPostCallbackURLRetry(t *testing.T) {
+	awstest.Setenv(t, "CALLBACK_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/123456789012/callbacks")
+	awstest.Setenv(t, "CALLBACK_MAX_ATTEMPTS", "4")
+	awstest.Setenv(t, "QUEUE_PUBLISH_ATTEMPTS", "1")
+	awstest.Setenv(t, "CALLBACKS_TABLE", "")
+
+	tests := []struct {
+		name   string
+		status int
+		err    error
+		sent   int
+	}{
+		{"delivered", 200, nil, 0},
+		{"server error", 503, nil, 1},
+		{"rejected", 400, nil, 0},
+		{"queue unavailable", 503, errors.New("unavailable"), 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			posts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				posts++
+				w.WriteHeader(test.status)
+			}))
+			defer server.Close()
+
+			sqsClient := &awstest.FakeSQS{Err: test.err}
+			s := newTestService(&awstest.FakeS3{}, sqsClient)
+			s.postCallbackURL(RequestPayload{FileID: "id"}, "upload_processed", server.URL, nil, nil, []byte(`{"event":"upload_processed"}`))
+
+			// the request posts once, leaving retries to the callback-sender function
+			if posts != 1 {
+				t.Errorf("posted %d times, want 1", posts)
+			}
+			if len(sqsClient.Sent) != test.sent {
+				t.Fatalf("sent %d messages, want %d", len(sqsClient.Sent), test.sent)
+			}
+			if test.sent == 0 {
+				return
+			}
+			message := sqsClient.Sent[0]
+			if got := aws.StringValue(message.QueueUrl); got != "https://sqs.us-east-1.amazonaws.com/123456789012/callbacks" {
+				t.Errorf("queue URL = %s", got)
+			}
+			var callback callbacks.Callback
+			if err := json.Unmarshal([]byte(aws.StringValue(message.MessageBody)), &callback); err != nil {
+				t.Fatal(err)
+			}
+			if !callback.Retry || callback.URL != server.URL || callback.Payload != `{"event":"upload_processed"}` {
+				t.Errorf("callback = %+v, want a retry of the callback to %s", callback, server.URL)
+			}
+		})
+	}
+}
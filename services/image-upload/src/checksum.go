@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/okebinda/internal/analytics"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/rejections"
+	"github.com/okebinda/internal/storage"
+)
+
+// checksum formats, as hex digests
+var (
+	sha256Format = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+	md5Format    = regexp.MustCompile(`^[0-9a-fA-F]{32}$`)
+)
+
+// ChecksumPayload defines the JSON schema for the digests of an uploaded object, as hex strings
+type ChecksumPayload struct {
+	SHA256 string `json:"sha256"`
+	MD5    string `json:"md5"`
+}
+
+// validateChecksums checks the format of a request's expected checksums
+func validateChecksums(requestData RequestPayload) *processError {
+	if requestData.ExpectedSHA256 != "" && !sha256Format.MatchString(requestData.ExpectedSHA256) {
+		errorMessage := fmt.Sprintf("Bad parameter format, cannot complete request; expected_sha256: %s, must be 64 hex digits", requestData.ExpectedSHA256)
+		logger.Error(errorMessage)
+		return &processError{400, problem.BadParameterFormat, errorMessage}
+	}
+	if requestData.ExpectedMD5 != "" && !md5Format.MatchString(requestData.ExpectedMD5) {
+		errorMessage := fmt.Sprintf("Bad parameter format, cannot complete request; expected_md5: %s, must be 32 hex digits", requestData.ExpectedMD5)
+		logger.Error(errorMessage)
+		return &processError{400, problem.BadParameterFormat, errorMessage}
+	}
+	return nil
+}
+
+// checkChecksums computes the digests of an uploaded object, as it was uploaded, and rejects it if they do not
+// match the request's expected checksums, recording the rejection
+func (s *Service) checkChecksums(requestData RequestPayload, uploadBucket, fileKey string, numBytes int64) (*ChecksumPayload, *processError) {
+	checksums, err := s.computeChecksums(uploadBucket, fileKey)
+	if err != nil {
+		logger.Errorf("Failed to compute checksums: %s, %v", fileKey, err)
+		if storage.IsNotFound(err) {
+			return nil, &processError{404, problem.NotFound, "Not found."}
+		}
+		return nil, errServer
+	}
+
+	logger.Infow("Upload checksums.",
+		"sha256", checksums.SHA256,
+		"md5", checksums.MD5,
+	)
+
+	var mismatch []string
+	if requestData.ExpectedSHA256 != "" && !strings.EqualFold(requestData.ExpectedSHA256, checksums.SHA256) {
+		mismatch = append(mismatch, fmt.Sprintf("sha256: %s, expected: %s", checksums.SHA256, strings.ToLower(requestData.ExpectedSHA256)))
+	}
+	if requestData.ExpectedMD5 != "" && !strings.EqualFold(requestData.ExpectedMD5, checksums.MD5) {
+		mismatch = append(mismatch, fmt.Sprintf("md5: %s, expected: %s", checksums.MD5, strings.ToLower(requestData.ExpectedMD5)))
+	}
+	if len(mismatch) > 0 {
+		errorMessage := fmt.Sprintf("Checksum mismatch: %s, %s", strings.Join(mismatch, ", "), fileKey)
+		logger.Errorf(errorMessage)
+		recordEvent(s.Session, analytics.Event{
+			EventType: analytics.EventUploadRejected,
+			Bucket:    uploadBucket,
+			FileKey:   fileKey,
+			SizeBytes: numBytes,
+			Reason:    "checksum_mismatch",
+		})
+		recordRejection(s.Session, rejections.Rejection{
+			FileID:    requestData.FileID,
+			FileKey:   fileKey,
+			Rule:      "checksum_mismatch",
+			Message:   errorMessage,
+			SizeBytes: numBytes,
+		})
+		return nil, &processError{400, problem.ChecksumMismatch, errorMessage}
+	}
+	return &checksums, nil
+}
+
+// computeChecksums streams an object in an S3 bucket to compute its SHA-256 and MD5 digests
+func (s *Service) computeChecksums(bucketName, fileKey string) (ChecksumPayload, error) {
+	body, err := storage.OpenObject(s.S3, bucketName, fileKey)
+	if err != nil {
+		return ChecksumPayload{}, err
+	}
+	defer body.Close()
+
+	sha256Hash := sha256.New()
+	md5Hash := md5.New()
+	if _, err = io.Copy(io.MultiWriter(sha256Hash, md5Hash), body); err != nil {
+		return ChecksumPayload{}, err
+	}
+	return ChecksumPayload{
+		SHA256: hex.EncodeToString(sha256Hash.Sum(nil)),
+		MD5:    hex.EncodeToString(md5Hash.Sum(nil)),
+	}, nil
+}
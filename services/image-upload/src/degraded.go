@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/okebinda/internal/analytics"
+	"github.com/okebinda/internal/queue"
+)
+
+// publishBackoff is the wait before the first retry of a failed publish, doubled after each
+const publishBackoff = 200 * time.Millisecond
+
+// publishMessage publishes a message to a queue, retrying failed publishes up to the QUEUE_PUBLISH_ATTEMPTS env
+// parameter's times in all (default 3), so a queue is only considered unavailable once they have all failed
+func publishMessage(q queue.Queue, message queue.Message) (string, error) {
+	attempts := 3
+	if value := os.Getenv("QUEUE_PUBLISH_ATTEMPTS"); value != "" {
+		var err error
+		attempts, err = strconv.Atoi(value)
+		if err != nil || attempts < 1 {
+			return "", fmt.Errorf("could not convert QUEUE_PUBLISH_ATTEMPTS to a positive int: %s", value)
+		}
+	}
+	return queue.PublishRetry(invocation, q, message, attempts, publishBackoff)
+}
+
+// degraded tests if work that could not be queued should be completed synchronously instead, if the
+// QUEUE_FALLBACK env parameter is "sync"; if so the degradation is logged and counted in the "Degradations"
+// metric by operation, so it can be alarmed on
+func degraded(operation string, err error) bool {
+	if os.Getenv("QUEUE_FALLBACK") != "sync" {
+		return false
+	}
+	logger.Errorf("Queue unavailable, completing synchronously: %s, %v", operation, err)
+	if err := analytics.RecordDegradation("image-upload", operation); err != nil {
+		logger.Errorf("Error recording degradation: %v", err)
+	}
+	return true
+}
@@ -6,26 +6,33 @@ import (
 	"os"
 	"strings"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/okebinda/internal/keys"
+	"github.com/okebinda/internal/lifecycle"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/storage"
 )
 
 // DeleteImage removes an image from the static S3 bucket
-func DeleteImage(w http.ResponseWriter, r *http.Request) {
+func (s *Service) DeleteImage(w http.ResponseWriter, r *http.Request) {
 
 	// check API key
 	ok := authentication(r)
 	if !ok {
-		userErrorResponse(w, 403, "Permission denied.")
+		userErrorResponse(w, 403, problem.PermissionDenied, "Permission denied.")
 		return
 	}
 
 	// get environment parameters
 	bucket := os.Getenv("AWS_S3_BUCKET_PUBLIC")
+	keyValidation, err := keys.ValidationFromEnv()
+	if err != nil {
+		logger.Errorf("Could not read KEY_VALIDATION: %v", err)
+		serverErrorResponse(w)
+		return
+	}
 
 	// get path parameters (chi doesn't support greedy path parameters)
-	imageKey := strings.Replace(r.RequestURI, "/image/delete/", "", 1)
+	imageKey := strings.TrimPrefix(r.URL.Path, "/image/delete/")
 
 	logger.Infow("Request parameters",
 		"imageKey", imageKey,
@@ -34,12 +41,21 @@ func DeleteImage(w http.ResponseWriter, r *http.Request) {
 	// simple sanity check
 	if imageKey == "" {
 		logger.Errorf("Missing parameters, cannot complete request; image_key: %s", imageKey)
-		userErrorResponse(w, 400, fmt.Sprintf("Missing parameters, cannot complete request; image_key: %s", imageKey))
+		userErrorResponse(w, 400, problem.MissingParameters, fmt.Sprintf("Missing parameters, cannot complete request; image_key: %s", imageKey))
+		return
+	}
+
+	// check key format
+	if err = keys.Validate(imageKey, keyValidation); err != nil {
+		errorMessage := fmt.Sprintf("Bad parameter format, cannot complete request; image_key: %v", err)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterFormat, errorMessage)
 		return
 	}
 
 	// delete object
-	err := deleteObject(bucket, imageKey)
+	sess := s.Session
+	err = storage.DeleteObject(s.S3, bucket, imageKey)
 	if err != nil {
 		logger.Errorf("Failed delete object: %s", err)
 		serverErrorResponse(w)
@@ -48,22 +64,78 @@ func DeleteImage(w http.ResponseWriter, r *http.Request) {
 
 	logger.Infow("Object deleted.")
 
+	// delete its cached derivatives
+	if err = s.deleteDerivatives(imageKey); err != nil {
+		logger.Errorf("Failed to delete derivatives: %s, %v", imageKey, err)
+		serverErrorResponse(w)
+		return
+	}
+
+	deleteHash(sess, imageKey)
+
+	emitEvent(sess, lifecycle.ImageDeleted, lifecycle.Detail{
+		Bucket:  bucket,
+		FileKey: imageKey,
+	})
+
 	// response
 	successResponse(w, 204, nil)
 }
 
-// deleteObject deletes a file from an S3 bucket
-func deleteObject(bucketName, fileKey string) error {
+// deleteDerivatives deletes the derivatives of an image that image-serve cached in the AWS_S3_BUCKET_CACHE env
+// parameter's bucket, if set, for every operation and size, with keys from the DERIVATIVE_KEY_TEMPLATE env
+// parameter; when the size is a whole key segment, as with the default template, only the sizes under each
+// operation's prefix are listed, otherwise every derivative under the prefix is listed and matched
+func (s *Service) deleteDerivatives(imageKey string) error {
+	cacheBucket := os.Getenv("AWS_S3_BUCKET_CACHE")
+	if cacheBucket == "" {
+		return nil
+	}
+	derivativeTemplate, err := keys.FromEnv("DERIVATIVE_KEY_TEMPLATE", keys.DefaultDerivativeTemplate, keys.DerivativeVariables)
+	if err != nil {
+		return err
+	}
+
+	var derivativeKeys []string
+	for _, operation := range keys.DerivativeOperations {
+		prefix, suffix, err := derivativeTemplate.Pattern(map[string]string{
+			"operation": operation,
+			"key":       imageKey,
+		}, "size")
+		if err != nil {
+			return err
+		}
 
-	// connect to AWS and create an S3 client
-	sess := session.Must(session.NewSession())
-	svc := s3.New(sess)
+		// delete the key under each size's folder; keys without a derivative are ignored
+		if strings.HasPrefix(suffix, "/") {
+			folders, err := storage.ListFolders(s.S3, cacheBucket, prefix)
+			if err != nil {
+				return err
+			}
+			for _, folder := range folders {
+				derivativeKeys = append(derivativeKeys, folder+suffix[1:])
+			}
+			continue
+		}
 
-	// delete object from bucket
-	input := &s3.DeleteObjectInput{
-		Bucket: aws.String(bucketName),
-		Key:    aws.String(fileKey),
+		objectKeys, err := storage.ListKeys(s.S3, cacheBucket, prefix)
+		if err != nil {
+			return err
+		}
+		for _, objectKey := range objectKeys {
+			size := strings.TrimSuffix(strings.TrimPrefix(objectKey, prefix), suffix)
+			if strings.HasSuffix(objectKey, suffix) && size != "" && !strings.Contains(size, "/") {
+				derivativeKeys = append(derivativeKeys, objectKey)
+			}
+		}
+	}
+	if err = storage.DeleteObjects(s.S3, cacheBucket, derivativeKeys); err != nil {
+		return err
 	}
-	_, err := svc.DeleteObject(input)
-	return err
+
+	logger.Infow("Derivatives deleted.",
+		"bucket", cacheBucket,
+		"keys", len(derivativeKeys),
+	)
+	return nil
 }
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/okebinda/internal/keys"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/storage"
+)
+
+// maxPresignExpiryMinutes is the longest SigV4 presigned URLs can be valid for, one week
+const maxPresignExpiryMinutes = 10080
+
+// GetDownloadURL retrieves a pre-signed GET URL for an image in the static S3 bucket, so clients can download
+// images that are not publicly readable; the URL expires after a configurable time, and may override the
+// Content-Disposition the image is downloaded with
+func (s *Service) GetDownloadURL(w http.ResponseWriter, r *http.Request) {
+
+	// the response carries credentials, so it must never be cached, not even an error
+	w.Header().Set("Cache-Control", "no-store")
+
+	// check API key
+	ok := authentication(r)
+	if !ok {
+		userErrorResponse(w, 403, problem.PermissionDenied, "Permission denied.")
+		return
+	}
+
+	// get environment parameters
+	keyValidation, err := keys.ValidationFromEnv()
+	if err != nil {
+		logger.Errorf("Could not read KEY_VALIDATION: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+	expiryMinutes, err := strconv.Atoi(os.Getenv("DOWNLOAD_URL_EXPIRY_MINUTES"))
+	if err != nil {
+		logger.Errorf("Could not convert DOWNLOAD_URL_EXPIRY_MINUTES to int: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+	maxExpiryMinutes, err := strconv.Atoi(os.Getenv("DOWNLOAD_URL_MAX_EXPIRY_MINUTES"))
+	if err != nil {
+		logger.Errorf("Could not convert DOWNLOAD_URL_MAX_EXPIRY_MINUTES to int: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+	if expiryMinutes < 1 || expiryMinutes > maxExpiryMinutes || maxExpiryMinutes > maxPresignExpiryMinutes {
+		logger.Errorf("Download URL expiry must be 1-%d minutes: %d, maximum: %d", maxPresignExpiryMinutes, expiryMinutes, maxExpiryMinutes)
+		serverErrorResponse(w)
+		return
+	}
+
+	// get path parameters (chi doesn't support greedy path parameters)
+	imageKey := strings.TrimPrefix(r.URL.Path, "/image/download-url/")
+
+	// get request parameters
+	expires := r.URL.Query().Get("expires")
+	disposition := r.URL.Query().Get("content_disposition")
+	sandbox := r.URL.Query().Get("sandbox")
+
+	logger.Infow("Request parameters",
+		"imageKey", imageKey,
+		"expires", expires,
+		"content_disposition", disposition,
+		"sandbox", sandbox,
+	)
+
+	// simple sanity check
+	if imageKey == "" {
+		logger.Errorf("Missing parameters, cannot complete request; image_key: %s", imageKey)
+		userErrorResponse(w, 400, problem.MissingParameters, fmt.Sprintf("Missing parameters, cannot complete request; image_key: %s", imageKey))
+		return
+	}
+
+	// check key format
+	if err = keys.Validate(imageKey, keyValidation); err != nil {
+		errorMessage := fmt.Sprintf("Bad parameter format, cannot complete request; image_key: %v", err)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterFormat, errorMessage)
+		return
+	}
+
+	// requested expiry, in minutes, bounded by the maximum
+	if expires != "" {
+		expiryMinutes, err = strconv.Atoi(expires)
+		if err != nil || expiryMinutes < 1 || expiryMinutes > maxExpiryMinutes {
+			errorMessage := fmt.Sprintf("Bad parameter value, cannot complete request; expires: %s, must be 1-%d minutes", expires, maxExpiryMinutes)
+			logger.Error(errorMessage)
+			userErrorResponse(w, 400, problem.BadParameterValue, errorMessage)
+			return
+		}
+	}
+
+	// the Content-Disposition override is checked like the one images are published with
+	if err = storage.ValidateMetadata(storage.ObjectMetadata{ContentDisposition: disposition}); err != nil {
+		errorMessage := fmt.Sprintf("Bad parameter value, cannot complete request; content_disposition: %v", err)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterValue, errorMessage)
+		return
+	}
+
+	// sandbox images are kept under the sandbox prefix, in the sandbox public bucket
+	if sandbox != "" && sandbox != "true" && sandbox != "false" {
+		errorMessage := fmt.Sprintf("Bad parameter value, cannot complete request; sandbox: %s, must be true or false", sandbox)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterValue, errorMessage)
+		return
+	}
+	isSandbox := sandboxed(sandbox == "true", imageKey)
+	bucket, perr := bucketFor("AWS_S3_BUCKET_PUBLIC", isSandbox)
+	if perr != nil {
+		logger.Error(perr.message)
+		userErrorResponse(w, perr.code, perr.problem, perr.message)
+		return
+	}
+
+	// presigned URLs are issued for any key, so the image is looked up first
+	header, err := storage.HeadObject(s.S3, bucket, imageKey)
+	if err != nil {
+		logger.Errorf("S3 head object error: %s", err)
+		if storage.IsNotFound(err) {
+			userErrorResponse(w, 404, problem.NotFound, "Not found.")
+			return
+		}
+		serverErrorResponse(w)
+		return
+	}
+
+	// generate a presigned download URL
+	expiresAt := s.Clock.Now().UTC().Add(time.Duration(expiryMinutes) * time.Minute)
+	signedURL, err := storage.PresignDownload(s.S3, bucket, imageKey, disposition, time.Duration(expiryMinutes)*time.Minute)
+	if err != nil {
+		logger.Errorf("Failed to sign request: %s", err)
+		serverErrorResponse(w)
+		return
+	}
+
+	logger.Infow("Response parameters",
+		"bucket", bucket,
+		"file_key", imageKey,
+		"expires_at", expiresAt,
+	)
+
+	responseData := map[string]interface{}{
+		"download_url": signedURL,
+		"file_key":     imageKey,
+		"expires_at":   expiresAt.Format(time.RFC3339),
+		"content_type": aws.StringValue(header.ContentType),
+		"size_bytes":   aws.Int64Value(header.ContentLength),
+	}
+	if isSandbox {
+		responseData["sandbox"] = true
+	}
+
+	// response
+	successResponse(w, 200, responseData)
+}
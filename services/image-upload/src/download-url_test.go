@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/okebinda/internal/awstest"
+	"github.com/okebinda/internal/problem"
+)
+
+func TestGetDownloadURL(t *testing.T) {
+	awstest.Setenv(t, "API_KEY", "key")
+	awstest.Setenv(t, "AWS_S3_BUCKET_PUBLIC", "public")
+	awstest.Setenv(t, "DOWNLOAD_URL_EXPIRY_MINUTES", "15")
+	awstest.Setenv(t, "DOWNLOAD_URL_MAX_EXPIRY_MINUTES", "60")
+
+	tests := []struct {
+		name, apiKey, path string
+		status             int
+		code               string
+	}{
+		{"permission denied", "", "/image/download-url/2020/photo.png", 403, problem.PermissionDenied},
+		{"bad key", "key", "/image/download-url/2020/photo%20(1).png", 400, problem.BadParameterFormat},
+		{"bad expiry", "key", "/image/download-url/2020/photo.png?expires=61", 400, problem.BadParameterValue},
+		{"not found", "key", "/image/download-url/2020/missing.png", 404, problem.NotFound},
+		{"found", "key", "/image/download-url/2020/photo.png?expires=30", 200, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestService(&awstest.FakeS3{Headers: map[string]*s3.HeadObjectOutput{
+				"public/2020/photo.png": {ContentType: aws.String("image/png"), ContentLength: aws.Int64(1024)},
+			}}, &awstest.FakeSQS{})
+			r := httptest.NewRequest("GET", test.path, nil)
+			r.Header.Set("X-API-KEY", test.apiKey)
+			w := httptest.NewRecorder()
+			s.GetDownloadURL(w, r)
+
+			if w.Code != test.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+			if got := w.Header().Get("Cache-Control"); got != "no-store" {
+				t.Errorf("Cache-Control = %q, want no-store", got)
+			}
+			if test.code != "" {
+				var got problem.Problem
+				if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+					t.Fatal(err)
+				}
+				if got.Code != test.code {
+					t.Errorf("code = %s, want %s", got.Code, test.code)
+				}
+				return
+			}
+
+			var got struct {
+				DownloadURL string `json:"download_url"`
+				FileKey     string `json:"file_key"`
+				ExpiresAt   string `json:"expires_at"`
+				ContentType string `json:"content_type"`
+				SizeBytes   int64  `json:"size_bytes"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(got.DownloadURL, "https://public.s3.amazonaws.com/2020/photo.png?") || !strings.Contains(got.DownloadURL, "X-Amz-Expires=1800") {
+				t.Errorf("download_url = %s, want a URL of public/2020/photo.png valid for 30 minutes", got.DownloadURL)
+			}
+			if got.FileKey != "2020/photo.png" || got.ContentType != "image/png" || got.SizeBytes != 1024 {
+				t.Errorf("file_key, content_type, size_bytes = %s, %s, %d, want 2020/photo.png, image/png, 1024", got.FileKey, got.ContentType, got.SizeBytes)
+			}
+			if want := "2020-11-02T15:34:05Z"; got.ExpiresAt != want {
+				t.Errorf("expires_at = %s, want %s", got.ExpiresAt, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/okebinda/internal/analytics"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/storage"
+)
+
+// isEncrypted tests if a request is for a client-side encrypted upload, by its file extension
+func isEncrypted(requestData RequestPayload) bool {
+	return requestData.FileExtension == storage.EncryptedExtension
+}
+
+// checkEncryptedRequest rejects requests for variants or pages of a client-side encrypted upload, which cannot be
+// generated from ciphertext
+func checkEncryptedRequest(requestData RequestPayload) *processError {
+	if isEncrypted(requestData) && (len(requestData.Sizes) > 0 || requestData.Page > 0) {
+		errorMessage := "Bad parameter value, cannot complete request; sizes, page: not allowed for encrypted uploads"
+		logger.Error(errorMessage)
+		return &processError{400, problem.BadParameterValue, errorMessage}
+	}
+	return nil
+}
+
+// publishEncrypted stores a client-side encrypted upload in the static S3 bucket as is: the service cannot read
+// it, so it is neither scanned, moderated, nor processed, and it is stored privately, with the cipher and key ID
+// it was uploaded with, to be downloaded only through presigned URLs issued by the Image Serve service
+func (s *Service) publishEncrypted(requestData RequestPayload, pub *publication, header *s3.HeadObjectOutput, uploadBucket, publicBucket, fileKey, publishedKey string, checksums *ChecksumPayload) (*ResponsePayload, publishedImage, *processError) {
+	if perr := checkEncryptedRequest(requestData); perr != nil {
+		return nil, publishedImage{}, perr
+	}
+	cipher, keyID, ok := storage.Encryption(header)
+	if !ok {
+		errorMessage := fmt.Sprintf("Upload is not encrypted: %s", fileKey)
+		logger.Error(errorMessage)
+		return nil, publishedImage{}, &processError{400, problem.BadRequest, errorMessage}
+	}
+
+	// store privately, keeping the encryption metadata
+	metadata := pub.metadata(storage.EncryptedContentType)
+	metadata.ACL = "private"
+	metadata.Metadata = map[string]*string{storage.MetadataEncryption: aws.String(cipher)}
+	if keyID != "" {
+		metadata.Metadata[storage.MetadataEncryptionKeyID] = aws.String(keyID)
+	}
+	for key, value := range pub.userMetadata {
+		metadata.Metadata[key] = value
+	}
+	numBytes := aws.Int64Value(header.ContentLength)
+	if err := storage.CopyObjectMetadata(s.S3, uploadBucket, fileKey, publicBucket, publishedKey, metadata); err != nil {
+		logger.Errorf("Failed to copy object: %v", err)
+		return nil, publishedImage{}, errServer
+	}
+
+	logger.Infow("Encrypted upload stored.",
+		"bucket", publicBucket,
+		"file_key", publishedKey,
+		"cipher", cipher,
+		"key_id", keyID,
+	)
+
+	if err := s.verifyPublished(publicBucket, map[string]int64{publishedKey: numBytes}); err != nil {
+		logger.Errorf("Failed to verify published object: %v", err)
+		return nil, publishedImage{}, errServer
+	}
+
+	recordEvent(s.Session, analytics.Event{
+		EventType: analytics.EventUploadProcessed,
+		Bucket:    publicBucket,
+		FileKey:   publishedKey,
+		FileType:  storage.EncryptedContentType,
+		SizeBytes: numBytes,
+	})
+
+	responseData := &ResponsePayload{
+		Bucket:        publicBucket,
+		Checksums:     checksums,
+		Directory:     requestData.Directory,
+		Encrypted:     true,
+		FileExtension: requestData.FileExtension,
+		FileID:        requestData.FileID,
+		Sandbox:       requestData.Sandbox,
+		SizeBytes:     numBytes,
+	}
+	return responseData, publishedImage{publishedKey, storage.EncryptedContentType, 0, 0}, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/okebinda/internal/imageproc"
+	"github.com/okebinda/internal/keys"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/storage"
+)
+
+// CameraPayload defines the JSON schema for the camera an image was captured with
+type CameraPayload struct {
+	Make  string `json:"make,omitempty"`
+	Model string `json:"model,omitempty"`
+	Lens  string `json:"lens,omitempty"`
+}
+
+// ExposurePayload defines the JSON schema for the exposure settings an image was captured with
+type ExposurePayload struct {
+	Time        string  `json:"time,omitempty"`
+	FNumber     float64 `json:"f_number,omitempty"`
+	ISO         int     `json:"iso,omitempty"`
+	FocalLength float64 `json:"focal_length,omitempty"`
+}
+
+// GPSPayload defines the JSON schema for the location an image was captured at
+type GPSPayload struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty"`
+}
+
+// ExifResponse defines the JSON schema for the payload to return to an EXIF request; fields the image has no
+// metadata for are null
+type ExifResponse struct {
+	FileKey     string           `json:"file_key"`
+	Camera      *CameraPayload   `json:"camera"`
+	Exposure    *ExposurePayload `json:"exposure"`
+	TakenAt     string           `json:"taken_at,omitempty"`
+	Orientation int              `json:"orientation,omitempty"`
+	GPS         *GPSPayload      `json:"gps,omitempty"`
+}
+
+// GetImageExif reads the capture metadata of a published image from its EXIF tags, so it can be displayed without
+// downloading the file. The location is only returned to requests whose API key ID (or source IP) is listed in the
+// EXIF_GPS_ISSUERS env parameter
+func (s *Service) GetImageExif(w http.ResponseWriter, r *http.Request) {
+
+	// check API key
+	if ok := authentication(r); !ok {
+		userErrorResponse(w, 403, problem.PermissionDenied, "Permission denied.")
+		return
+	}
+
+	// get environment parameters
+	publicBucket := os.Getenv("AWS_S3_BUCKET_PUBLIC")
+	keyValidation, err := keys.ValidationFromEnv()
+	if err != nil {
+		logger.Errorf("Could not read KEY_VALIDATION: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+
+	// get path parameters (chi doesn't support greedy path parameters)
+	fileKey := strings.TrimPrefix(r.URL.Path, "/image/exif/")
+	gps := false
+	if issuers := os.Getenv("EXIF_GPS_ISSUERS"); issuers != "" {
+		gps = contains(strings.Split(issuers, ","), requestIssuer(r))
+	}
+
+	logger.Infow("Request parameters",
+		"fileKey", fileKey,
+		"gps", gps,
+	)
+
+	// simple sanity check
+	if fileKey == "" {
+		logger.Errorf("Missing parameters, cannot complete request; file_key: %s", fileKey)
+		userErrorResponse(w, 400, problem.MissingParameters, fmt.Sprintf("Missing parameters, cannot complete request; file_key: %s", fileKey))
+		return
+	}
+
+	// check key format
+	if err = keys.Validate(fileKey, keyValidation); err != nil {
+		errorMessage := fmt.Sprintf("Bad parameter format, cannot complete request; file_key: %v", err)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterFormat, errorMessage)
+		return
+	}
+
+	// read the metadata, which precedes the image data
+	body, err := storage.OpenObject(s.S3, publicBucket, fileKey)
+	if err != nil {
+		logger.Errorf("S3 get object error: %s", err)
+		if storage.IsNotFound(err) {
+			userErrorResponse(w, 404, problem.NotFound, "Not found.")
+		} else {
+			serverErrorResponse(w)
+		}
+		return
+	}
+	defer body.Close()
+	exif, err := imageproc.ReadEXIF(body)
+	if err != nil && err != imageproc.ErrNoEXIF {
+		logger.Errorf("Could not read EXIF: %s, %v", fileKey, err)
+	}
+
+	responseData := ExifResponse{
+		FileKey:     fileKey,
+		TakenAt:     exif.TakenAt,
+		Orientation: exif.Orientation,
+	}
+	if exif.Make != "" || exif.Model != "" || exif.LensModel != "" {
+		responseData.Camera = &CameraPayload{
+			Make:  exif.Make,
+			Model: exif.Model,
+			Lens:  exif.LensModel,
+		}
+	}
+	if exif.ExposureTime != "" || exif.FNumber != 0 || exif.ISO != 0 || exif.FocalLength != 0 {
+		responseData.Exposure = &ExposurePayload{
+			Time:        exif.ExposureTime,
+			FNumber:     exif.FNumber,
+			ISO:         exif.ISO,
+			FocalLength: exif.FocalLength,
+		}
+	}
+	if gps && exif.GPS != nil {
+		responseData.GPS = &GPSPayload{
+			Latitude:  exif.GPS.Latitude,
+			Longitude: exif.GPS.Longitude,
+			Altitude:  exif.GPS.Altitude,
+		}
+	}
+
+	logger.Infow("Response parameters",
+		"exif", err == nil,
+		"gps", responseData.GPS != nil,
+	)
+
+	// response
+	successResponse(w, 200, responseData)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/okebinda/internal/keys"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/storage"
+)
+
+// ObjectPayload defines the JSON schema for an object found in a bucket by an exists request
+type ObjectPayload struct {
+	Bucket      string `json:"bucket"`
+	SizeBytes   int64  `json:"size_bytes"`
+	ContentType string `json:"content_type"`
+	ETag        string `json:"etag"`
+}
+
+// ExistsResponse defines the JSON schema for the payload to return to an exists request; a bucket's object is
+// null if the key is not in it, and the quarantine bucket's is always null if uploads are not screened
+type ExistsResponse struct {
+	FileKey    string         `json:"file_key"`
+	Exists     bool           `json:"exists"`
+	Quarantine *ObjectPayload `json:"quarantine"`
+	Upload     *ObjectPayload `json:"upload"`
+	Public     *ObjectPayload `json:"public"`
+}
+
+// GetImageExists checks whether a key is in the upload or the static S3 bucket, so a client can confirm its upload
+// landed before processing it; a key in neither bucket is not found. It is authorized by the API key, or by the
+// upload's process token
+func (s *Service) GetImageExists(w http.ResponseWriter, r *http.Request) {
+
+	// get environment parameters
+	uploadBucket := os.Getenv("AWS_S3_BUCKET_UPLOAD")
+	publicBucket := os.Getenv("AWS_S3_BUCKET_PUBLIC")
+	keyValidation, err := keys.ValidationFromEnv()
+	if err != nil {
+		logger.Errorf("Could not read KEY_VALIDATION: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+
+	// get path parameters (chi doesn't support greedy path parameters)
+	fileKey := strings.TrimPrefix(r.URL.Path, "/image/exists/")
+
+	logger.Infow("Request parameters",
+		"fileKey", fileKey,
+	)
+
+	// check API key, or process token
+	ok := authentication(r)
+	if !ok && !verifyProcessToken(os.Getenv("PROCESS_TOKEN_SECRET"), r.Header.Get(ProcessTokenHeader), fileKey, s.Clock.Now()) {
+		userErrorResponse(w, 403, problem.PermissionDenied, "Permission denied.")
+		return
+	}
+
+	// simple sanity check
+	if fileKey == "" {
+		logger.Errorf("Missing parameters, cannot complete request; file_key: %s", fileKey)
+		userErrorResponse(w, 400, problem.MissingParameters, fmt.Sprintf("Missing parameters, cannot complete request; file_key: %s", fileKey))
+		return
+	}
+
+	// check key format
+	if err = keys.Validate(fileKey, keyValidation); err != nil {
+		errorMessage := fmt.Sprintf("Bad parameter format, cannot complete request; file_key: %v", err)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterFormat, errorMessage)
+		return
+	}
+
+	// look up the key in each bucket
+	responseData := ExistsResponse{FileKey: fileKey}
+	if screened(false) {
+		if responseData.Quarantine, err = s.headPayload(quarantineBucket(false), fileKey); err != nil {
+			logger.Errorf("S3 head object error: %s", err)
+			serverErrorResponse(w)
+			return
+		}
+	}
+	if responseData.Upload, err = s.headPayload(uploadBucket, fileKey); err != nil {
+		logger.Errorf("S3 head object error: %s", err)
+		serverErrorResponse(w)
+		return
+	}
+	if responseData.Public, err = s.headPayload(publicBucket, fileKey); err != nil {
+		logger.Errorf("S3 head object error: %s", err)
+		serverErrorResponse(w)
+		return
+	}
+	responseData.Exists = responseData.Quarantine != nil || responseData.Upload != nil || responseData.Public != nil
+
+	logger.Infow("Response parameters",
+		"quarantine", responseData.Quarantine != nil,
+		"upload", responseData.Upload != nil,
+		"public", responseData.Public != nil,
+	)
+
+	if !responseData.Exists {
+		userErrorResponse(w, 404, problem.NotFound, "Not found.")
+		return
+	}
+
+	// response
+	successResponse(w, 200, responseData)
+}
+
+// headPayload reads an object's size, content type, and ETag (without quotes), or nil if it is not in the bucket
+func (s *Service) headPayload(bucket, fileKey string) (*ObjectPayload, error) {
+	header, err := storage.HeadObject(s.S3, bucket, fileKey)
+	if err != nil {
+		if storage.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &ObjectPayload{
+		Bucket:      bucket,
+		SizeBytes:   aws.Int64Value(header.ContentLength),
+		ContentType: aws.StringValue(header.ContentType),
+		ETag:        strings.Trim(aws.StringValue(header.ETag), `"`),
+	}, nil
+}
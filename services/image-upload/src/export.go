@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/okebinda/internal/config"
+	"github.com/okebinda/internal/notify"
+)
+
+// exporter registers published uploads with an external system
+type exporter interface {
+	export(payload CallbackPayload) error
+}
+
+// exporters maps the values of the EXPORT_TARGET env parameter to the constructors of their exporters
+var exporters = map[string]func(s *Service) (exporter, error){
+	"dam": newDAMExporter,
+}
+
+// exportAuth caches the auth header values read from EXPORT_AUTH_SOURCE between invocations
+var exportAuth = map[string]string{}
+var exportAuthMu sync.Mutex
+
+// exportUpload registers a published upload with the exporter selected by the EXPORT_TARGET env parameter, if
+// set; failures are logged and do not affect processing
+func (s *Service) exportUpload(payload CallbackPayload) {
+	target := os.Getenv("EXPORT_TARGET")
+	if target == "" {
+		return
+	}
+	newExporter, ok := exporters[target]
+	if !ok {
+		logger.Errorf("Unknown EXPORT_TARGET: %s", target)
+		return
+	}
+	exp, err := newExporter(s)
+	if err != nil {
+		logger.Errorf("Could not configure %s exporter: %v", target, err)
+		return
+	}
+	if err = exp.export(payload); err != nil {
+		logger.Errorf("Failed to export upload to %s: %v", target, err)
+		return
+	}
+
+	logger.Infow("Upload exported.",
+		"target", target,
+		"file_key", payload.FileKey,
+	)
+}
+
+// damExporter posts published uploads to a DAM or CMS API, mapped to its schema
+type damExporter struct {
+	service *Service
+	url     string
+	headers map[string]string
+	mapping map[string]string
+	retry   notify.Retry
+}
+
+// newDAMExporter configures a damExporter from the EXPORT_URL, EXPORT_MAPPING, EXPORT_AUTH_SOURCE, and
+// EXPORT_AUTH_HEADER (default "Authorization") env parameters; the auth header value is read from the config
+// source in EXPORT_AUTH_SOURCE, e.g. "secretsmanager:name"
+func newDAMExporter(s *Service) (exporter, error) {
+	exp := &damExporter{
+		service: s,
+		url:     os.Getenv("EXPORT_URL"),
+		headers: map[string]string{},
+	}
+	if exp.url == "" {
+		return nil, fmt.Errorf("EXPORT_URL is not set")
+	}
+	if mapping := os.Getenv("EXPORT_MAPPING"); mapping != "" {
+		if err := json.Unmarshal([]byte(mapping), &exp.mapping); err != nil {
+			return nil, fmt.Errorf("could not parse EXPORT_MAPPING: %v", err)
+		}
+	}
+	retry, err := notify.RetryFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	exp.retry = retry
+
+	// read the auth header value, once per container
+	if source := os.Getenv("EXPORT_AUTH_SOURCE"); source != "" {
+		exportAuthMu.Lock()
+		defer exportAuthMu.Unlock()
+		if _, ok := exportAuth[source]; !ok {
+			value, err := config.Load(s.Session, source)
+			if err != nil {
+				return nil, fmt.Errorf("could not read EXPORT_AUTH_SOURCE: %v", err)
+			}
+			exportAuth[source] = strings.TrimSpace(string(value))
+		}
+		header := os.Getenv("EXPORT_AUTH_HEADER")
+		if header == "" {
+			header = "Authorization"
+		}
+		exp.headers[header] = exportAuth[source]
+	}
+	return exp, nil
+}
+
+// export posts a published upload, mapped to the API's schema, retrying within the callback retry limits
+func (e *damExporter) export(payload CallbackPayload) error {
+	body, err := e.service.mapPayload(payload, e.mapping)
+	if err != nil {
+		return err
+	}
+	return notify.Callback(invocation, e.url, nil, e.headers, body, e.retry)
+}
+
+// mapPayload maps the properties of a CallbackPayload, plus the published image's "url", to another schema:
+// each key of the mapping is a field of the schema, dot separated for nested objects, and its value the property
+// to set it to; properties missing from the payload are skipped, and an empty mapping returns every property
+func (s *Service) mapPayload(payload CallbackPayload, mapping map[string]string) (map[string]interface{}, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	var properties map[string]interface{}
+	if err = json.Unmarshal(data, &properties); err != nil {
+		return nil, err
+	}
+	properties["url"] = s.objectURL(payload.Bucket, payload.FileKey)
+	if len(mapping) == 0 {
+		return properties, nil
+	}
+
+	mapped := map[string]interface{}{}
+	for field, property := range mapping {
+		value, ok := properties[property]
+		if !ok {
+			continue
+		}
+		path := strings.Split(field, ".")
+		object := mapped
+		for _, name := range path[:len(path)-1] {
+			child, ok := object[name].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				object[name] = child
+			}
+			object = child
+		}
+		object[path[len(path)-1]] = value
+	}
+	return mapped, nil
+}
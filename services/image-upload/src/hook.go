@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/okebinda/internal/analytics"
+	"github.com/okebinda/internal/failures"
+	"github.com/okebinda/internal/invoke"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/rejections"
+)
+
+// hookPostProcess is the name of the hook invoked once an image is processed, before it is published
+const hookPostProcess = "post_process"
+
+// HookRequest defines the JSON schema for the event a post-processing hook is invoked with: the processed image,
+// readable at its bucket and file key until it is published, the key and variants it will be published as, and
+// the upload it was processed from
+type HookRequest struct {
+	Hook          string           `json:"hook"`
+	Bucket        string           `json:"bucket"`
+	FileKey       string           `json:"file_key"`
+	PublishedKey  string           `json:"published_key"`
+	FileID        string           `json:"file_id"`
+	Directory     string           `json:"directory"`
+	FileExtension string           `json:"file_extension"`
+	ContentType   string           `json:"content_type"`
+	Width         int              `json:"width"`
+	Height        int              `json:"height"`
+	SizeBytes     int64            `json:"size_bytes"`
+	Variants      []VariantPayload `json:"variants,omitempty"`
+	Uploader      string           `json:"uploader,omitempty"`
+	Sandbox       bool             `json:"sandbox,omitempty"`
+	Context       json.RawMessage  `json:"context,omitempty"`
+}
+
+// HookResponse defines the JSON schema for a post-processing hook's result: if it vetoes publication, with a
+// machine-readable reason and a message, or else the annotations the image is published with
+type HookResponse struct {
+	Veto        bool              `json:"veto"`
+	Reason      string            `json:"reason,omitempty"`
+	Message     string            `json:"message,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// hookFunction returns the function hooked to an upload's directory in the POST_PROCESS_HOOKS env parameter (a
+// JSON object mapping directories to Lambda function names or ARNs; subdirectories are included, and the deepest
+// directory listed wins), or "" if none is
+func hookFunction(directory string) (string, error) {
+	document := os.Getenv("POST_PROCESS_HOOKS")
+	if document == "" {
+		return "", nil
+	}
+	var hooks map[string]string
+	if err := json.Unmarshal([]byte(document), &hooks); err != nil {
+		return "", fmt.Errorf("POST_PROCESS_HOOKS: %v", err)
+	}
+	var function, matched string
+	for hookDirectory, name := range hooks {
+		hookDirectory = strings.Trim(hookDirectory, "/")
+		if notifyDirectory(directory, []string{hookDirectory}) && (function == "" || len(hookDirectory) > len(matched)) {
+			function, matched = name, hookDirectory
+		}
+	}
+	return function, nil
+}
+
+// runHook invokes the post-processing hook of an upload's directory, if any, with the processed image, returning
+// the annotations to publish it with; if the hook vetoes publication the upload is rejected (see rejectVetoed).
+// Uploads whose hook cannot be invoked, or fails, fail with a server error, to be retried, rather than being
+// published unchecked
+func (s *Service) runHook(requestData RequestPayload, hookRequest HookRequest) (map[string]string, *processError) {
+	function, err := hookFunction(requestData.Directory)
+	if err != nil {
+		logger.Errorf("Could not read post-processing hooks: %v", err)
+		return nil, errServer
+	}
+	if function == "" {
+		return nil, nil
+	}
+
+	hookRequest.Hook = hookPostProcess
+	hookRequest.FileID = requestData.FileID
+	hookRequest.Directory = requestData.Directory
+	hookRequest.FileExtension = requestData.FileExtension
+	hookRequest.Uploader = requestData.Uploader
+	hookRequest.Sandbox = requestData.Sandbox
+	hookRequest.Context = requestData.Context
+	var response HookResponse
+	if err = invoke.Event(s.Session, function, hookRequest, &response); err != nil {
+		logger.Errorf("Post-processing hook failed: %s, %v", function, err)
+		return nil, errServer
+	}
+
+	logger.Infow("Post-processing hook complete.",
+		"function", function,
+		"veto", response.Veto,
+		"reason", response.Reason,
+		"annotations", len(response.Annotations),
+	)
+
+	if response.Veto {
+		return nil, s.rejectVetoed(requestData, hookRequest, function, response)
+	}
+	return response.Annotations, nil
+}
+
+// rejectVetoed handles an upload a post-processing hook vetoed: the rejection is recorded, and a failure callback
+// with the hook's reason as its class is posted to its callback_url; returns the error to report
+func (s *Service) rejectVetoed(requestData RequestPayload, hookRequest HookRequest, function string, response HookResponse) *processError {
+	message := response.Message
+	if message == "" {
+		message = "Image was vetoed by a post-processing hook"
+	}
+	reason := response.Reason
+	if reason == "" {
+		reason = "unspecified"
+	}
+	errorMessage := fmt.Sprintf("Image was vetoed by a post-processing hook: %s, %s", reason, hookRequest.PublishedKey)
+	logger.Errorf(errorMessage)
+
+	recordRejection(s.Session, rejections.Rejection{
+		FileID:    requestData.FileID,
+		FileKey:   hookRequest.PublishedKey,
+		Rule:      "hook_vetoed",
+		Message:   errorMessage,
+		SizeBytes: hookRequest.SizeBytes,
+		Limit:     fmt.Sprintf("function=%s", function),
+	})
+	recordEvent(s.Session, analytics.Event{
+		EventType: analytics.EventUploadRejected,
+		Bucket:    hookRequest.Bucket,
+		FileKey:   hookRequest.FileKey,
+		FileType:  hookRequest.ContentType,
+		SizeBytes: hookRequest.SizeBytes,
+		Reason:    "hook_vetoed",
+	})
+
+	// report the failure to the requester
+	s.postCallback(requestData, "upload_failed", failures.Callback{
+		Event:         "upload_failed",
+		FileID:        requestData.FileID,
+		Directory:     requestData.Directory,
+		FileExtension: requestData.FileExtension,
+		Error: failures.CallbackError{
+			Code:     "hook_vetoed",
+			Class:    response.Reason,
+			Message:  message,
+			Attempts: 1,
+		},
+		FailedAt: s.Clock.Now().UTC().Format(time.RFC3339),
+		Sandbox:  requestData.Sandbox,
+		Context:  requestData.Context,
+	})
+
+	return &processError{400, problem.BadRequest, errorMessage}
+}
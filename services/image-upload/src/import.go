@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/storage"
+)
+
+// ImportRequest defines the JSON schema for the input of the import internal action: a batch of uploads, each a
+// process-upload message, and where to post the import_completed callback once they are all processed
+type ImportRequest struct {
+	JobID               string            `json:"job_id"`
+	Uploads             []RequestPayload  `json:"uploads"`
+	ReportFormat        string            `json:"report_format"`
+	CallbackHeaders     map[string]string `json:"callback_headers"`
+	CallbackSNSTopicARN string            `json:"callback_sns_topic_arn"`
+	CallbackURL         string            `json:"callback_url"`
+}
+
+// ImportEntry defines the JSON schema for the outcome of one upload in an import report: processed, rejected, or
+// failed, with its published keys or its error
+type ImportEntry struct {
+	InputKey   string   `json:"input_key"`
+	FileID     string   `json:"file_id"`
+	Outcome    string   `json:"outcome"`
+	OutputKeys []string `json:"output_keys"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// ImportReport defines the JSON schema for an import report
+type ImportReport struct {
+	JobID       string        `json:"job_id"`
+	StartedAt   string        `json:"started_at"`
+	CompletedAt string        `json:"completed_at"`
+	Processed   int           `json:"processed"`
+	Rejected    int           `json:"rejected"`
+	Failed      int           `json:"failed"`
+	Uploads     []ImportEntry `json:"uploads"`
+}
+
+// ImportPayload defines the JSON schema for the import_completed callback payload, also returned by the import
+// action: the outcome counts and where to download the report
+type ImportPayload struct {
+	Event           string `json:"event"`
+	JobID           string `json:"job_id"`
+	Total           int    `json:"total"`
+	Processed       int    `json:"processed"`
+	Rejected        int    `json:"rejected"`
+	Failed          int    `json:"failed"`
+	ReportKey       string `json:"report_key"`
+	ReportURL       string `json:"report_url"`
+	ReportExpiresAt string `json:"report_expires_at"`
+	CompletedAt     string `json:"completed_at"`
+}
+
+// import limits
+const (
+	maxImportUploads                 = 1000
+	maxImportReportExpiryMinutes     = 7 * 24 * 60
+	defaultImportReportExpiryMinutes = 24 * 60
+)
+
+// jobIDFormat is the format of import job IDs, which are used in report keys
+var jobIDFormat = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// importUploads processes a batch of uploads in order, then writes a report of each upload's outcome to the
+// upload bucket under the IMPORT_REPORT_PREFIX env parameter (default "_reports"), as {job_id}.json or
+// {job_id}.csv, and posts the import_completed callback with a download URL valid for
+// IMPORT_REPORT_URL_EXPIRY_MINUTES (default 1440); failed uploads are reported, not retried
+func (s *Service) importUploads(input ImportRequest) (*ImportPayload, *processError) {
+
+	// get environment parameters
+	uploadBucket := os.Getenv("AWS_S3_BUCKET_UPLOAD")
+	expiryMinutes := defaultImportReportExpiryMinutes
+	if value := os.Getenv("IMPORT_REPORT_URL_EXPIRY_MINUTES"); value != "" {
+		var err error
+		expiryMinutes, err = strconv.Atoi(value)
+		if err != nil || expiryMinutes < 1 || expiryMinutes > maxImportReportExpiryMinutes {
+			logger.Errorf("Could not convert IMPORT_REPORT_URL_EXPIRY_MINUTES to 1-%d: %s", maxImportReportExpiryMinutes, value)
+			return nil, errServer
+		}
+	}
+
+	// check parameters
+	if input.JobID == "" {
+		input.JobID = s.IDs.New().String()
+	}
+	if !jobIDFormat.MatchString(input.JobID) {
+		return nil, &processError{400, problem.BadParameterFormat, fmt.Sprintf("Bad parameter format, cannot complete request; job_id: %s", input.JobID)}
+	}
+	if len(input.Uploads) == 0 {
+		return nil, &processError{400, problem.MissingParameters, "Missing parameters, cannot complete request; uploads"}
+	}
+	if len(input.Uploads) > maxImportUploads {
+		return nil, &processError{400, problem.BadParameterValue, fmt.Sprintf("Bad parameter value, cannot complete request; uploads: %d, must be at most %d", len(input.Uploads), maxImportUploads)}
+	}
+	if input.ReportFormat == "" {
+		input.ReportFormat = "json"
+	}
+	if input.ReportFormat != "json" && input.ReportFormat != "csv" {
+		return nil, &processError{400, problem.BadParameterValue, fmt.Sprintf("Bad parameter value, cannot complete request; report_format: %s, must be json or csv", input.ReportFormat)}
+	}
+
+	logger.Infow("Import started.",
+		"job_id", input.JobID,
+		"uploads", len(input.Uploads),
+	)
+
+	// process each upload
+	report := ImportReport{
+		JobID:     input.JobID,
+		StartedAt: s.Clock.Now().UTC().Format(time.RFC3339),
+		Uploads:   []ImportEntry{},
+	}
+	for _, requestData := range input.Uploads {
+		entry := ImportEntry{
+			InputKey:   uploadKey(requestData),
+			FileID:     requestData.FileID,
+			OutputKeys: []string{},
+		}
+		responseData, published, perr := s.processUploadImage(requestData)
+		switch {
+		case perr == nil:
+			entry.Outcome = "processed"
+			entry.OutputKeys = append(entry.OutputKeys, published.fileKey)
+			for _, variant := range responseData.Variants {
+				entry.OutputKeys = append(entry.OutputKeys, variant.FileKey)
+			}
+			report.Processed++
+		case perr.code < 500:
+			entry.Outcome = "rejected"
+			entry.Error = perr.message
+			report.Rejected++
+		default:
+			entry.Outcome = "failed"
+			entry.Error = perr.message
+			report.Failed++
+		}
+		report.Uploads = append(report.Uploads, entry)
+	}
+	report.CompletedAt = s.Clock.Now().UTC().Format(time.RFC3339)
+
+	// write the report
+	body, contentType, err := encodeImportReport(report, input.ReportFormat)
+	if err != nil {
+		logger.Errorf("Error encoding import report: %s, %v", input.JobID, err)
+		return nil, errServer
+	}
+	reportKey := fmt.Sprintf("%s/%s.%s", importReportPrefix(), input.JobID, input.ReportFormat)
+	if err = storage.PutObject(s.S3, uploadBucket, reportKey, body, contentType); err != nil {
+		logger.Errorf("Error writing import report: %s, %v", reportKey, err)
+		return nil, errServer
+	}
+	expires := time.Duration(expiryMinutes) * time.Minute
+	reportURL, err := storage.PresignGet(s.S3, uploadBucket, reportKey, expires)
+	if err != nil {
+		logger.Errorf("Error presigning import report: %s, %v", reportKey, err)
+		return nil, errServer
+	}
+
+	payload := ImportPayload{
+		Event:           "import_completed",
+		JobID:           input.JobID,
+		Total:           len(input.Uploads),
+		Processed:       report.Processed,
+		Rejected:        report.Rejected,
+		Failed:          report.Failed,
+		ReportKey:       reportKey,
+		ReportURL:       reportURL,
+		ReportExpiresAt: s.Clock.Now().UTC().Add(expires).Format(time.RFC3339),
+		CompletedAt:     report.CompletedAt,
+	}
+	logger.Infow("Import completed.",
+		"job_id", payload.JobID,
+		"processed", payload.Processed,
+		"rejected", payload.Rejected,
+		"failed", payload.Failed,
+		"report_key", payload.ReportKey,
+	)
+
+	// the import's callback targets, not those of its uploads
+	s.postCallback(RequestPayload{
+		CallbackHeaders:     input.CallbackHeaders,
+		CallbackSNSTopicARN: input.CallbackSNSTopicARN,
+		CallbackURL:         input.CallbackURL,
+	}, payload.Event, payload)
+	return &payload, nil
+}
+
+// uploadKey returns the key of an upload in the upload bucket
+func uploadKey(requestData RequestPayload) string {
+	if requestData.Directory != "" {
+		return fmt.Sprintf("%s/%s.%s", requestData.Directory, requestData.FileID, requestData.FileExtension)
+	}
+	return fmt.Sprintf("%s.%s", requestData.FileID, requestData.FileExtension)
+}
+
+// encodeImportReport encodes an import report as JSON, or as CSV with one row per upload and its output keys
+// separated by spaces, returning the body and its content type
+func encodeImportReport(report ImportReport, format string) ([]byte, string, error) {
+	if format == "json" {
+		body, err := json.Marshal(report)
+		return body, "application/json", err
+	}
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	writer.Write([]string{"input_key", "file_id", "outcome", "output_keys", "error"})
+	for _, entry := range report.Uploads {
+		writer.Write([]string{entry.InputKey, entry.FileID, entry.Outcome, strings.Join(entry.OutputKeys, " "), entry.Error})
+	}
+	writer.Flush()
+	return buffer.Bytes(), "text/csv", writer.Error()
+}
+
+// importReportPrefix returns the prefix of import reports in the upload bucket, from the IMPORT_REPORT_PREFIX env
+// parameter, or "_reports" by default
+func importReportPrefix() string {
+	if prefix := strings.Trim(os.Getenv("IMPORT_REPORT_PREFIX"), "/"); prefix != "" {
+		return prefix
+	}
+	return "_reports"
+}
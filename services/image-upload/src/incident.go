@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/okebinda/internal/config"
+	"github.com/okebinda/internal/httpresp"
+	"github.com/okebinda/internal/problem"
+)
+
+// incidentTTL is how long the incident mode loaded from INCIDENT_MODE_CONFIG is reused before it is read again, so
+// operators' changes take effect within it
+const incidentTTL = 30 * time.Second
+
+// defaultRetryAfterSeconds is the Retry-After of shed requests if the incident mode does not set one
+const defaultRetryAfterSeconds = 60
+
+// IncidentMode defines the incident mode config document: while enabled, presign requests are shed with a 503
+// unless they are critical, i.e. for a directory in, or under, critical_directories, or made with an API key ID in
+// critical_api_keys
+type IncidentMode struct {
+	Enabled             bool     `json:"enabled"`
+	CriticalDirectories []string `json:"critical_directories"`
+	CriticalAPIKeys     []string `json:"critical_api_keys"`
+	RetryAfterSeconds   int      `json:"retry_after_seconds"`
+}
+
+// incidentCache holds the incident mode loaded from INCIDENT_MODE_CONFIG between invocations
+var incidentCache struct {
+	sync.Mutex
+	source   string
+	mode     IncidentMode
+	loadedAt time.Time
+}
+
+// loadIncidentMode reads the incident mode from the config document in the INCIDENT_MODE_CONFIG env parameter
+// ("ssm:/parameter/name", "appconfig:application/environment/profile", or "s3://bucket/key"); incident mode is
+// disabled if it is not set
+func (s *Service) loadIncidentMode() (IncidentMode, error) {
+	source := os.Getenv("INCIDENT_MODE_CONFIG")
+	if source == "" {
+		return IncidentMode{}, nil
+	}
+
+	// reuse a recently loaded incident mode
+	incidentCache.Lock()
+	defer incidentCache.Unlock()
+	if incidentCache.source == source && s.Clock.Now().Sub(incidentCache.loadedAt) < incidentTTL {
+		return incidentCache.mode, nil
+	}
+
+	document, err := config.Load(s.Session, source)
+	if err != nil {
+		return IncidentMode{}, err
+	}
+	var mode IncidentMode
+	if err = json.Unmarshal(document, &mode); err != nil {
+		return IncidentMode{}, err
+	}
+	incidentCache.source = source
+	incidentCache.mode = mode
+	incidentCache.loadedAt = s.Clock.Now()
+	return mode, nil
+}
+
+// critical tests if a request for a directory, made by an issuer (see requestIssuer), is critical traffic
+func (m IncidentMode) critical(directory, issuer string) bool {
+	for _, apiKey := range m.CriticalAPIKeys {
+		if apiKey == issuer {
+			return true
+		}
+	}
+	directory = strings.Trim(directory, "/")
+	for _, critical := range m.CriticalDirectories {
+		critical = strings.Trim(critical, "/")
+		if directory == critical || strings.HasPrefix(directory, critical+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// shedPresign rejects a low-priority presign request for a directory with a 503 while incident mode is enabled, so
+// operators can shed load during S3 or Lambda capacity incidents; returns true if the request was rejected.
+// Requests are allowed if the incident mode cannot be read, so the lever never causes an outage itself
+func (s *Service) shedPresign(w http.ResponseWriter, r *http.Request, directory string) bool {
+	mode, err := s.loadIncidentMode()
+	if err != nil {
+		logger.Errorf("Could not read incident mode: %v", err)
+		return false
+	}
+	issuer := requestIssuer(r)
+	if !mode.Enabled || mode.critical(directory, issuer) {
+		return false
+	}
+
+	logger.Infow("Presign shed.",
+		"directory", directory,
+		"issuer", issuer,
+	)
+	retryAfter := mode.RetryAfterSeconds
+	if retryAfter < 1 {
+		retryAfter = defaultRetryAfterSeconds
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	if err = httpresp.Reject(w, language, requestID, 503, problem.LoadShed, "Service is shedding load, try again later.", nil); err != nil {
+		logger.Errorf("Error generating response: %s", err)
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/okebinda/internal/invoke"
+	"github.com/okebinda/internal/logging"
+	"github.com/okebinda/internal/rejections"
+)
+
+// InternalHandler is our lambda handler for the internal actions other services invoke directly, authorized by
+// their IAM role rather than the API key: get_rejection reads the rejection of a file, or null if there is none,
+// reprocess processes an upload again, and import processes a batch of uploads and reports their outcomes;
+// failures are returned with the same error types as the steps of the process-upload state machine
+func (s *Service) InternalHandler(ctx context.Context, event invoke.Request) (interface{}, error) {
+
+	// initialize logger
+	lc, _ := lambdacontext.FromContext(ctx)
+	requestID = lc.AwsRequestID
+	invocation = ctx
+	logger = logging.New(requestID)
+	defer logger.Sync()
+
+	logger.Infow("Internal action",
+		"action", event.Action,
+	)
+
+	// initialize AWS session
+	sess := s.Session
+
+	switch event.Action {
+	case invoke.GetRejection:
+		var input invoke.RejectionInput
+		if err := json.Unmarshal(event.Input, &input); err != nil {
+			return nil, &UploadRejected{fmt.Sprintf("Error unmarshalling input: %v", err)}
+		}
+		return internalRejection(sess, input)
+	case invoke.Reprocess:
+		var requestData RequestPayload
+		if err := json.Unmarshal(event.Input, &requestData); err != nil {
+			return nil, &UploadRejected{fmt.Sprintf("Error unmarshalling input: %v", err)}
+		}
+		responseData, perr := s.processUpload(requestData)
+		if perr != nil {
+			return nil, stepError(perr)
+		}
+		return responseData, nil
+	case invoke.Import:
+		var input ImportRequest
+		if err := json.Unmarshal(event.Input, &input); err != nil {
+			return nil, &UploadRejected{fmt.Sprintf("Error unmarshalling input: %v", err)}
+		}
+		payload, perr := s.importUploads(input)
+		if perr != nil {
+			return nil, stepError(perr)
+		}
+		return payload, nil
+	}
+	return nil, &UploadRejected{fmt.Sprintf("Unknown action: %s", event.Action)}
+}
+
+// internalRejection reads the latest rejection of a file, or nil if there is none
+func internalRejection(sess *session.Session, input invoke.RejectionInput) (*rejections.Rejection, error) {
+	table := os.Getenv("REJECTIONS_TABLE")
+	if table == "" {
+		logger.Error("REJECTIONS_TABLE is not set")
+		return nil, stepError(errServer)
+	}
+	if input.FileID == "" {
+		return nil, &UploadRejected{"Missing parameters, cannot complete request; file_id"}
+	}
+
+	rejection, found, err := rejections.Get(sess, table, input.FileID)
+	if err != nil {
+		logger.Errorf("Failed to read rejection: %v", err)
+		return nil, stepError(errServer)
+	}
+	if !found {
+		return nil, nil
+	}
+	return &rejection, nil
+}
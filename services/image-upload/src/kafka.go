@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/okebinda/internal/keys"
+	"github.com/okebinda/internal/logging"
+	"github.com/okebinda/internal/storage"
+)
+
+// KafkaHandler is our lambda handler for MSK/Kafka event sources, processing each record's RequestPayload;
+// records rejected as bad requests are logged and skipped, any other failure returns an error so the batch is
+// retried. A retried batch is delivered whole, so records whose image was already published since they were
+// produced are skipped, and their callbacks are not posted again
+func (s *Service) KafkaHandler(ctx context.Context, event events.KafkaEvent) error {
+
+	// initialize logger
+	lc, _ := lambdacontext.FromContext(ctx)
+	requestID = lc.AwsRequestID
+	invocation = ctx
+	logger = logging.New(requestID)
+	defer logger.Sync()
+
+	for topicPartition, records := range event.Records {
+		for _, record := range records {
+
+			// get payload from record value
+			value, err := base64.StdEncoding.DecodeString(record.Value)
+			if err != nil {
+				logger.Errorf("Error decoding record value: %s, %d, %v", topicPartition, record.Offset, err)
+				continue
+			}
+			var requestData RequestPayload
+			if err = json.Unmarshal(value, &requestData); err != nil {
+				logger.Errorf("Error unmarshalling record value: %s, %d, %v", topicPartition, record.Offset, err)
+				continue
+			}
+
+			// skip records published by an earlier delivery of the batch
+			done, err := s.alreadyPublished(requestData, record.Timestamp.Time)
+			if err != nil {
+				logger.Errorf("Could not check for a published image: %s, %d, %v", topicPartition, record.Offset, err)
+				return err
+			}
+			if done {
+				logger.Infof("Record already published, skipped: %s, %d", topicPartition, record.Offset)
+				continue
+			}
+
+			// process upload
+			responseData, published, perr := s.processUploadImage(requestData)
+			if perr != nil {
+				if perr.code >= 500 {
+					return perr
+				}
+				logger.Errorf("Record rejected: %s, %d, %s", topicPartition, record.Offset, perr.message)
+				continue
+			}
+			logPublished(responseData, published)
+		}
+	}
+	return nil
+}
+
+// alreadyPublished tests if a record's image was published since the record was produced, by reading the
+// published key's last modified time; S3 keeps it to the second, so the record's time is truncated to match.
+// Records that cannot be published are left for processing to reject
+func (s *Service) alreadyPublished(requestData RequestPayload, produced time.Time) (bool, error) {
+	requestData = sandboxRequest(requestData)
+	publishedTemplate, err := keys.FromEnv("PUBLISHED_KEY_TEMPLATE", keys.DefaultPublishedTemplate, keys.PublishedVariables)
+	if err != nil {
+		return false, err
+	}
+	publicBucket, perr := bucketFor("AWS_S3_BUCKET_PUBLIC", requestData.Sandbox)
+	if perr != nil {
+		return false, nil
+	}
+	publishedKey, err := publishedTemplate.Render(map[string]string{
+		"directory": requestData.Directory,
+		"file_id":   requestData.FileID,
+		"ext":       requestData.FileExtension,
+	})
+	if err != nil {
+		return false, nil
+	}
+	header, err := storage.HeadObject(s.S3, publicBucket, publishedKey)
+	if storage.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !aws.TimeValue(header.LastModified).Before(produced.Truncate(time.Second)), nil
+}
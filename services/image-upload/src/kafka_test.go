@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/okebinda/internal/awstest"
+)
+
+func TestAlreadyPublished(t *testing.T) {
+	awstest.Setenv(t, "AWS_S3_BUCKET_PUBLIC", "public")
+	produced := time.Date(2020, 11, 2, 15, 4, 5, 500000000, time.UTC)
+
+	tests := []struct {
+		name         string
+		lastModified time.Time
+		published    bool
+		want         bool
+	}{
+		{"not published", time.Time{}, false, false},
+		{"published before the record", produced.Add(-time.Hour), true, false},
+		{"published in the record's second", produced.Truncate(time.Second), true, true},
+		{"published since the record", produced.Add(time.Minute), true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			headers := map[string]*s3.HeadObjectOutput{}
+			if test.published {
+				headers["public/news/id.png"] = &s3.HeadObjectOutput{LastModified: aws.Time(test.lastModified)}
+			}
+			s := newTestService(&awstest.FakeS3{Headers: headers}, &awstest.FakeSQS{})
+			got, err := s.alreadyPublished(RequestPayload{Directory: "news", FileID: "id", FileExtension: "png"}, produced)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("already published = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestKafkaHandlerSkipsPublishedRecords(t *testing.T) {
+	awstest.Setenv(t, "AWS_S3_BUCKET_PUBLIC", "public")
+	produced := time.Date(2020, 11, 2, 15, 4, 5, 0, time.UTC)
+
+	// a record redelivered after its image was published is skipped, so no upload is read again
+	s := newTestService(&awstest.FakeS3{Headers: map[string]*s3.HeadObjectOutput{
+		"public/news/id.png": {LastModified: aws.Time(produced.Add(time.Second))},
+	}}, &awstest.FakeSQS{})
+	value := base64.StdEncoding.EncodeToString([]byte(`{"directory":"news","file_id":"id","file_extension":"png"}`))
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "request"})
+	err := s.KafkaHandler(ctx, events.KafkaEvent{Records: map[string][]events.KafkaRecord{
+		"uploads-0": {{Offset: 1, Timestamp: events.MilliSecondsEpochTime{Time: produced}, Value: value}},
+	}})
+	if err != nil {
+		t.Errorf("error = %v, want nil", err)
+	}
+}
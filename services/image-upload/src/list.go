@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/okebinda/internal/keys"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/storage"
+)
+
+// ImagePayload defines the JSON schema for an image listed in a ListResponse
+type ImagePayload struct {
+	Key          string `json:"key"`
+	SizeBytes    int64  `json:"size_bytes"`
+	LastModified string `json:"last_modified"`
+	URL          string `json:"url"`
+}
+
+// ListResponse defines the JSON schema for the payload to return to a list request
+type ListResponse struct {
+	Images    []ImagePayload `json:"images"`
+	NextToken string         `json:"next_token,omitempty"`
+}
+
+// list page sizes
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// GetImages lists the images in the static S3 bucket, optionally only in a directory, a page at a time; the
+// next_token of a response continues the listing with the next page
+func (s *Service) GetImages(w http.ResponseWriter, r *http.Request) {
+
+	// check API key
+	ok := authentication(r)
+	if !ok {
+		userErrorResponse(w, 403, problem.PermissionDenied, "Permission denied.")
+		return
+	}
+
+	// get environment parameters
+	bucket := os.Getenv("AWS_S3_BUCKET_PUBLIC")
+	keyValidation, err := keys.ValidationFromEnv()
+	if err != nil {
+		logger.Errorf("Could not read KEY_VALIDATION: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+
+	// get request parameters
+	directory := strings.Trim(r.URL.Query().Get("directory"), "/")
+	limit := r.URL.Query().Get("limit")
+	token := r.URL.Query().Get("token")
+
+	logger.Infow("Request parameters",
+		"directory", directory,
+		"limit", limit,
+		"token", token,
+	)
+
+	// check directory format
+	var prefix string
+	if directory != "" {
+		if err = keys.Validate(directory, keyValidation); err != nil {
+			errorMessage := fmt.Sprintf("Bad parameter format, cannot complete request; directory: %v", err)
+			logger.Error(errorMessage)
+			userErrorResponse(w, 400, problem.BadParameterFormat, errorMessage)
+			return
+		}
+		prefix = directory + "/"
+	}
+
+	// page size, bounded by the maximum
+	maxKeys := defaultListLimit
+	if limit != "" {
+		maxKeys, err = strconv.Atoi(limit)
+		if err != nil || maxKeys < 1 || maxKeys > maxListLimit {
+			errorMessage := fmt.Sprintf("Bad parameter value, cannot complete request; limit: %s, must be 1-%d", limit, maxListLimit)
+			logger.Error(errorMessage)
+			userErrorResponse(w, 400, problem.BadParameterValue, errorMessage)
+			return
+		}
+	}
+
+	// list objects
+	objects, nextToken, err := storage.ListObjects(s.S3, bucket, prefix, token, int64(maxKeys))
+	if err != nil {
+		logger.Errorf("Failed to list objects: %s", err)
+		serverErrorResponse(w)
+		return
+	}
+
+	responseData := ListResponse{
+		Images:    make([]ImagePayload, 0, len(objects)),
+		NextToken: nextToken,
+	}
+	for _, object := range objects {
+		responseData.Images = append(responseData.Images, ImagePayload{
+			Key:          object.Key,
+			SizeBytes:    object.Size,
+			LastModified: object.LastModified.UTC().Format(time.RFC3339),
+			URL:          s.objectURL(bucket, object.Key),
+		})
+	}
+
+	logger.Infow("Response parameters",
+		"images", len(responseData.Images),
+		"next_token", nextToken,
+	)
+
+	// response
+	successResponse(w, 200, responseData)
+}
@@ -2,7 +2,7 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -10,22 +10,61 @@ import (
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws/session"
 	chiproxy "github.com/awslabs/aws-lambda-go-api-proxy/chi"
 	"github.com/go-chi/chi"
+	"github.com/okebinda/internal/analytics"
+	"github.com/okebinda/internal/apiversion"
+	"github.com/okebinda/internal/httpresp"
+	"github.com/okebinda/internal/i18n"
+	"github.com/okebinda/internal/lifecycle"
+	"github.com/okebinda/internal/logging"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/server"
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
 )
 
 var logger *zap.SugaredLogger
+var requestID string
+
+// invocation is the context of the event or request being handled, which AWS requests and callbacks are sent with
+var invocation = context.Background()
+
+var language = i18n.DefaultLanguage
+
+// apiVersion is the version of the response schemas negotiated for the request being handled
+var apiVersion = apiversion.Default
+
 var adapter *chiproxy.ChiLambda
+var router http.Handler
 
 func init() {
 	r := chi.NewRouter()
-
-	r.Get("/image/upload-url", GetUploadURL)
-	r.Post("/image/process-upload", PostProcessUpload)
-	r.Delete("/image/delete/*", DeleteImage)
-
+	r.Use(negotiateLanguage)
+	r.Use(negotiateVersion)
+
+	r.Get("/image/upload-url", service.GetUploadURL)
+	r.Get("/image/download-url/*", service.GetDownloadURL)
+	r.Get("/image/list", service.GetImages)
+	r.Post("/image/process-upload", service.PostProcessUpload)
+	r.Get("/image/exists/*", service.GetImageExists)
+	r.Head("/image/exists/*", service.GetImageExists)
+	r.Get("/image/exif/*", service.GetImageExif)
+	r.Get("/image/similar/*", service.GetImageSimilar)
+	r.Delete("/image/delete/*", service.DeleteImage)
+	r.Post("/image/copy", service.PostCopyImage)
+	r.Post("/image/move", service.PostMoveImage)
+	r.Patch("/image/*", service.PatchMetadata)
+	r.Get("/image/rejections/{file_id}", service.GetRejection)
+	r.Post("/image/callbacks/replay", service.PostCallbackReplay)
+	r.Get("/image/webhooks", service.GetWebhooks)
+	r.Post("/image/webhooks", service.PostWebhook)
+	r.Get("/image/webhooks/{subscription_id}", service.GetWebhook)
+	r.Patch("/image/webhooks/{subscription_id}", service.PatchWebhook)
+	r.Delete("/image/webhooks/{subscription_id}", service.DeleteWebhook)
+	r.Post("/image/webhooks/{subscription_id}/rotate", service.PostWebhookRotate)
+
+	router = r
 	adapter = chiproxy.New(r)
 }
 
@@ -34,7 +73,9 @@ func Handler(ctx context.Context, request events.APIGatewayProxyRequest) (events
 
 	// initialize logger
 	lc, _ := lambdacontext.FromContext(ctx)
-	logger = sugaredLogger(lc.AwsRequestID)
+	requestID = lc.AwsRequestID
+	invocation = ctx
+	logger = logging.New(requestID)
 	defer logger.Sync()
 
 	// serve request
@@ -42,16 +83,31 @@ func Handler(ctx context.Context, request events.APIGatewayProxyRequest) (events
 	return c, err
 }
 
-// sugaredLogger initializes the zap sugar logger
-func sugaredLogger(requestID string) *zap.SugaredLogger {
-	// zapLogger, err := zap.NewDevelopment()
-	zapLogger, err := zap.NewProduction()
-	if err != nil {
-		log.Fatalf("can't initialize zap logger: %v", err)
-	}
-	return zapLogger.
-		With(zap.Field{Key: "request_id", Type: zapcore.StringType, String: requestID}).
-		Sugar()
+// negotiateLanguage selects the language of error messages from the request's Accept-Language header
+func negotiateLanguage(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		language = i18n.Negotiate(r.Header.Get("Accept-Language"))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// negotiateVersion selects the version of the response schemas from the request's /v1 or /v2 path prefix, else its
+// Accept-Version header, removing the prefix so versioned paths are routed like unversioned ones
+func negotiateVersion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version, path, err := apiversion.Negotiate(r.URL.Path, r.Header.Get(apiversion.Header))
+		if err != nil {
+			errorMessage := fmt.Sprintf("Unsupported API version; version: %v", err)
+			logger.Error(errorMessage)
+			userErrorResponse(w, 400, problem.UnsupportedVersion, errorMessage)
+			return
+		}
+		apiVersion = version
+		r.URL.Path = path
+		r.URL.RawPath = apiversion.StripPrefix(r.URL.RawPath)
+		w.Header().Set(apiversion.ResponseHeader, apiversion.String(version))
+		next.ServeHTTP(w, r)
+	})
 }
 
 // authentication checks the request headers for an X_API_KEY value and compares it to env parameter
@@ -66,43 +122,100 @@ func authentication(r *http.Request) bool {
 	return true
 }
 
+// contains tests if a slice contains a string
+func contains(a []string, x string) bool {
+	for _, n := range a {
+		if x == n {
+			return true
+		}
+	}
+	return false
+}
+
+// recordEvent sends an analytics event and logs any errors
+func recordEvent(sess *session.Session, event analytics.Event) {
+	event.Service = "image-upload"
+	event.RequestID = requestID
+	if err := analytics.Record(sess, event); err != nil {
+		logger.Errorf("Error recording analytics event: %s", err)
+	}
+}
+
+// emitEvent publishes a lifecycle event and logs any errors
+func emitEvent(sess *session.Session, detailType string, detail lifecycle.Detail) {
+	detail.Service = "image-upload"
+	detail.RequestID = requestID
+	if err := lifecycle.Emit(sess, detailType, detail); err != nil {
+		logger.Errorf("Error emitting lifecycle event: %s", err)
+	}
+}
+
 // successResponse generates a success (200) response
 func successResponse(w http.ResponseWriter, code int, fields interface{}) {
-	body, err := json.Marshal(fields)
-	if err != nil {
-		logger.Errorf("Marshalling error: %s", err)
-		serverErrorResponse(w)
+	if err := httpresp.Success(w, code, fields); err != nil {
+		logger.Errorf("Error generating response: %s", err)
 	}
-	generateResponse(w, code, body)
 }
 
-// userErrorResponse generates a user error (400) response
-func userErrorResponse(w http.ResponseWriter, code int, errorMessage string) {
-	body, err := json.Marshal(map[string]interface{}{
-		"error": errorMessage,
-	})
-	if err != nil {
-		logger.Errorf("Marshalling error: %s", err)
-		serverErrorResponse(w)
+// userErrorResponse generates a user error (4xx) response with a problem code, e.g. problem.NotFound
+func userErrorResponse(w http.ResponseWriter, code int, problemCode, errorMessage string) {
+	if err := httpresp.UserError(w, language, requestID, code, problemCode, errorMessage); err != nil {
+		logger.Errorf("Error generating response: %s", err)
 	}
-	generateResponse(w, code, body)
 }
 
 // serverErrorResponse generates a server error (500) response
 func serverErrorResponse(w http.ResponseWriter) {
-	generateResponse(w, 500, []byte("{\"error\":\"Server error\"}"))
+	if err := httpresp.ServerError(w, requestID); err != nil {
+		logger.Errorf("Error generating response: %s", err)
+	}
 }
 
-// generateResponse generates an HTTP JSON Lambda response to return to the user
-func generateResponse(w http.ResponseWriter, statusCode int, body []byte) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(statusCode)
-	_, err := w.Write(body)
-	if err != nil {
-		logger.Errorf("Error writing response: %s", err)
+// close closes a file and logs any errors
+func close(file *os.File) {
+	if err := file.Close(); err != nil {
+		logger.Errorf("Error closing the file: %s", err)
 	}
 }
 
 func main() {
-	lambda.Start(Handler)
+
+	// check dependencies before handling any events
+	if err := service.checkNotifyQueue(); err != nil {
+		log.Fatalf("Notification queue unavailable: %v", err)
+	}
+
+	// select the handler for the function's event source
+	switch os.Getenv("EVENT_SOURCE") {
+	case "kafka":
+		lambda.Start(service.KafkaHandler)
+	case "sqs":
+		lambda.Start(service.SQSHandler)
+	case "s3":
+		lambda.Start(service.S3Handler)
+	case "quarantine":
+		lambda.Start(service.QuarantineHandler)
+	case "step":
+		lambda.Start(service.StepHandler)
+	case "internal":
+		lambda.Start(service.InternalHandler)
+	case "server":
+		serve()
+	default:
+		lambda.Start(Handler)
+	}
+}
+
+// serve serves the router as a standalone HTTP server, for containers outside Lambda
+func serve() {
+	handler := server.Serial(router, func(ctx context.Context, id string) func() {
+		requestID = id
+		invocation = ctx
+		logger = logging.New(requestID)
+		return func() { logger.Sync() }
+	})
+	log.Printf("Serving on %s", server.Addr())
+	if err := server.Serve(server.Addr(), handler); err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
 }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/okebinda/internal/analytics"
+	"github.com/okebinda/internal/failures"
+	"github.com/okebinda/internal/moderation"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/rejections"
+	"github.com/okebinda/internal/storage"
+)
+
+// moderators maps the values of the MODERATION_PROVIDER env parameter to the constructors of their moderators
+var moderators = map[string]func(sess *session.Session) (moderation.Moderator, error){
+	"rekognition": newRekognitionModerator,
+}
+
+// newRekognitionModerator configures an AWS Rekognition moderator
+func newRekognitionModerator(sess *session.Session) (moderation.Moderator, error) {
+	return moderation.Rekognition{Session: sess}, nil
+}
+
+// moderateUpload checks an uploaded image for unsafe content before it is published, if the MODERATION_ENABLED
+// env parameter is "true", with the provider in MODERATION_PROVIDER (default "rekognition"); images flagged with
+// labels of at least MODERATION_MIN_CONFIDENCE percent are quarantined and reported (see rejectFlagged). Images
+// of types the provider does not support are published unmoderated, and uploads that cannot be moderated fail
+// with a server error, to be retried
+func (s *Service) moderateUpload(requestData RequestPayload, uploadBucket, fileKey, contentType string, numBytes int64) *processError {
+	if os.Getenv("MODERATION_ENABLED") != "true" {
+		return nil
+	}
+	name := os.Getenv("MODERATION_PROVIDER")
+	if name == "" {
+		name = "rekognition"
+	}
+	newModerator, ok := moderators[name]
+	if !ok {
+		logger.Errorf("Unknown MODERATION_PROVIDER: %s", name)
+		return errServer
+	}
+	minConfidence, err := strconv.ParseFloat(os.Getenv("MODERATION_MIN_CONFIDENCE"), 64)
+	if err != nil || minConfidence < 0 || minConfidence > 100 {
+		logger.Errorf("Could not convert MODERATION_MIN_CONFIDENCE to a percentage: %s", os.Getenv("MODERATION_MIN_CONFIDENCE"))
+		return errServer
+	}
+	moderator, err := newModerator(s.Session)
+	if err != nil {
+		logger.Errorf("Could not configure %s moderator: %v", name, err)
+		return errServer
+	}
+
+	if !moderator.Supports(contentType) {
+		logger.Infow("Upload not moderated, unsupported type.",
+			"moderator", name,
+			"content_type", contentType,
+		)
+		return nil
+	}
+	labels, err := moderator.Moderate(moderation.Image{
+		Bucket:      uploadBucket,
+		Key:         fileKey,
+		ContentType: contentType,
+	}, minConfidence)
+	if err != nil {
+		logger.Errorf("Failed to moderate upload with %s: %s, %v", name, fileKey, err)
+		return errServer
+	}
+
+	logger.Infow("Upload moderated.",
+		"moderator", name,
+		"labels", labels,
+	)
+
+	if len(labels) > 0 {
+		return s.rejectFlagged(requestData, uploadBucket, fileKey, contentType, numBytes, labels, minConfidence)
+	}
+	return nil
+}
+
+// rejectFlagged handles an upload moderation flagged: the object is moved under the MODERATION_PREFIX env
+// parameter (default "_moderation") in the upload bucket for review, the rejection is recorded, and a failure
+// callback with the labels is posted to its callback_url; returns the error to report
+func (s *Service) rejectFlagged(requestData RequestPayload, bucket, fileKey, contentType string, numBytes int64, labels []moderation.Label, minConfidence float64) *processError {
+	var names []string
+	for _, label := range labels {
+		names = append(names, label.Name)
+	}
+	errorMessage := fmt.Sprintf("Image was flagged by moderation: %s, %s", strings.Join(names, ", "), fileKey)
+	logger.Errorf(errorMessage)
+
+	recordRejection(s.Session, rejections.Rejection{
+		FileID:    requestData.FileID,
+		FileKey:   fileKey,
+		Rule:      "moderation_flagged",
+		Message:   errorMessage,
+		SizeBytes: numBytes,
+		Limit:     fmt.Sprintf("min_confidence=%g", minConfidence),
+	})
+	recordEvent(s.Session, analytics.Event{
+		EventType: analytics.EventUploadRejected,
+		Bucket:    bucket,
+		FileKey:   fileKey,
+		FileType:  contentType,
+		SizeBytes: numBytes,
+		Reason:    "moderation_flagged",
+	})
+
+	// move the object aside for review, so it is not processed again
+	quarantineKey := fmt.Sprintf("%s/%s", moderationPrefix(), fileKey)
+	if err := storage.CopyObject(s.S3, bucket, fileKey, bucket, quarantineKey, contentType); err != nil {
+		logger.Errorf("Failed to quarantine object: %v", err)
+	} else if err = storage.DeleteObject(s.S3, bucket, fileKey); err != nil {
+		logger.Errorf("Failed to delete quarantined object: %v", err)
+	} else {
+		logger.Infow("Object quarantined.",
+			"bucket", bucket,
+			"file_key", quarantineKey,
+		)
+	}
+
+	// report the failure to the requester
+	s.postCallback(requestData, "upload_failed", failures.Callback{
+		Event:         "upload_failed",
+		FileID:        requestData.FileID,
+		Directory:     requestData.Directory,
+		FileExtension: requestData.FileExtension,
+		Error: failures.CallbackError{
+			Code:     "moderation_flagged",
+			Message:  "Image was flagged by moderation",
+			Labels:   labels,
+			Attempts: 1,
+		},
+		FailedAt: s.Clock.Now().UTC().Format(time.RFC3339),
+		Sandbox:  requestData.Sandbox,
+		Context:  requestData.Context,
+	})
+
+	return &processError{400, problem.ModerationFlagged, errorMessage}
+}
+
+// moderationPrefix returns the MODERATION_PREFIX env parameter, or "_moderation" if it is not set
+func moderationPrefix() string {
+	if prefix := strings.Trim(os.Getenv("MODERATION_PREFIX"), "/"); prefix != "" {
+		return prefix
+	}
+	return "_moderation"
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/okebinda/internal/notify"
+	"github.com/okebinda/internal/queue"
+)
+
+// notifyUpload posts a summary of a published upload to the Slack webhook in the NOTIFY_WEBHOOK_URL env parameter
+// and emails it to NOTIFY_EMAIL_TO (comma separated) through SES, or queues it to be sent in a batch if
+// NOTIFY_AGGREGATE is "true", sending it at once if the queue is unavailable in the degraded mode, if its
+// directory, or a parent directory, is listed in NOTIFY_DIRECTORIES (comma separated); failures are logged and do
+// not affect processing
+func (s *Service) notifyUpload(requestData RequestPayload, responseData *ResponsePayload, publishedKey string) {
+	if !notifyDirectory(requestData.Directory, splitList(os.Getenv("NOTIFY_DIRECTORIES"))) {
+		return
+	}
+
+	// link to the smallest published image
+	thumbnailKey := publishedKey
+	thumbnailArea := responseData.Width * responseData.Height
+	for _, variant := range responseData.Variants {
+		if variant.Width*variant.Height < thumbnailArea {
+			thumbnailKey = variant.FileKey
+			thumbnailArea = variant.Width * variant.Height
+		}
+	}
+	thumbnailURL := s.objectURL(responseData.Bucket, thumbnailKey)
+
+	uploader := requestData.Uploader
+	if uploader == "" {
+		uploader = "unknown"
+	}
+	upload := notify.Upload{
+		Uploader:     uploader,
+		Directory:    requestData.Directory,
+		FileKey:      publishedKey,
+		Width:        responseData.Width,
+		Height:       responseData.Height,
+		SizeBytes:    responseData.SizeBytes,
+		ThumbnailURL: thumbnailURL,
+	}
+
+	// queue the notification to be sent in a batch by the notify-aggregator function
+	if os.Getenv("NOTIFY_AGGREGATE") == "true" {
+		err := s.queueNotification(upload)
+		if err == nil {
+			return
+		}
+		logger.Errorf("Failed to queue upload notification: %v", err)
+		if !degraded("notify", err) {
+			return
+		}
+	}
+
+	subject, message := upload.Message()
+	if webhookURL := os.Getenv("NOTIFY_WEBHOOK_URL"); webhookURL != "" {
+		if err := notify.Webhook(invocation, webhookURL, message); err != nil {
+			logger.Errorf("Failed to post upload notification: %v", err)
+		}
+	}
+	if to := splitList(os.Getenv("NOTIFY_EMAIL_TO")); len(to) > 0 {
+		if err := notify.Email(s.Session, os.Getenv("NOTIFY_EMAIL_FROM"), to, subject, message); err != nil {
+			logger.Errorf("Failed to email upload notification: %v", err)
+		}
+	}
+}
+
+// queueNotification publishes an upload notification to the queue in the NOTIFY_QUEUE_URL env parameter (an SQS
+// queue URL, ARN, or "sqs://{name}")
+func (s *Service) queueNotification(upload notify.Upload) error {
+	q, err := queue.OpenWithClient(s.Session, s.SQS, os.Getenv("NOTIFY_QUEUE_URL"))
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	_, err = publishMessage(q, queue.Message{Body: body})
+	return err
+}
+
+// notifyDirectory tests if a directory, or one of its parents, is in a list of directories
+func notifyDirectory(directory string, directories []string) bool {
+	if directory == "" {
+		return false
+	}
+	for _, n := range directories {
+		if directory == n || strings.HasPrefix(directory, n+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma separated list, dropping empty values
+func splitList(list string) []string {
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// checkNotifyQueue verifies the notification queue exists if notifications are aggregated, so a missing queue
+// fails the function at startup rather than losing each notification
+func (s *Service) checkNotifyQueue() error {
+	if os.Getenv("NOTIFY_AGGREGATE") != "true" {
+		return nil
+	}
+	q, err := queue.OpenWithClient(s.Session, s.SQS, os.Getenv("NOTIFY_QUEUE_URL"))
+	if err != nil {
+		return err
+	}
+	return q.Check(invocation)
+}
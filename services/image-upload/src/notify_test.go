@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/okebinda/internal/awstest"
+	"github.com/okebinda/internal/notify"
+)
+
+func TestNotifyUpload(t *testing.T) {
+	awstest.Setenv(t, "NOTIFY_DIRECTORIES", "news")
+	awstest.Setenv(t, "NOTIFY_AGGREGATE", "true")
+	awstest.Setenv(t, "NOTIFY_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/123456789012/notify")
+	awstest.Setenv(t, "QUEUE_PUBLISH_ATTEMPTS", "1")
+
+	responseData := &ResponsePayload{
+		Bucket:    "public",
+		Width:     1200,
+		Height:    800,
+		SizeBytes: 2048,
+		Variants: []VariantPayload{
+			{Name: "thumbnail", FileKey: "news/id-thumbnail.png", Width: 120, Height: 80},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		directory string
+		err       error
+		sent      int
+	}{
+		{"queued", "news/2020", nil, 1},
+		{"directory not notified", "sports", nil, 0},
+		{"queue unavailable", "news", errors.New("unavailable"), 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sqsClient := &awstest.FakeSQS{Err: test.err}
+			s := newTestService(&awstest.FakeS3{}, sqsClient)
+			s.notifyUpload(RequestPayload{Directory: test.directory, Uploader: "editor"}, responseData, "news/id.png")
+
+			if len(sqsClient.Sent) != test.sent {
+				t.Fatalf("sent %d messages, want %d", len(sqsClient.Sent), test.sent)
+			}
+			if test.sent == 0 {
+				return
+			}
+			message := sqsClient.Sent[0]
+			if got := aws.StringValue(message.QueueUrl); got != "https://sqs.us-east-1.amazonaws.com/123456789012/notify" {
+				t.Errorf("queue URL = %s", got)
+			}
+			var upload notify.Upload
+			if err := json.Unmarshal([]byte(aws.StringValue(message.MessageBody)), &upload); err != nil {
+				t.Fatal(err)
+			}
+			want := notify.Upload{
+				Uploader:     "editor",
+				Directory:    test.directory,
+				FileKey:      "news/id.png",
+				Width:        1200,
+				Height:       800,
+				SizeBytes:    2048,
+				ThumbnailURL: "https://public.s3.amazonaws.com/news/id-thumbnail.png",
+			}
+			if upload != want {
+				t.Errorf("notification = %+v, want %+v", upload, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/okebinda/internal/analytics"
+	"github.com/okebinda/internal/imageproc"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/rejections"
+)
+
+// checkPixels rejects images whose width times height exceeds the pixel limit, read from their header before any
+// pixels are decoded, so decompression bombs cannot exhaust the function's memory, recording the rejection; images
+// whose header cannot be read are left for the decoders to reject
+func checkPixels(sess *session.Session, requestData RequestPayload, file *os.File, maxPixels int64, bucket, localFile, fileKey, fileType string, numBytes int64) *processError {
+	width, height, err := imageproc.ScanDimensions(localFile)
+	if err != nil {
+		logger.Infof("Could not read image dimensions: %v", err)
+		return nil
+	}
+	if err = imageproc.CheckPixels(width, height, maxPixels); err == nil {
+		return nil
+	}
+
+	errorMessage := fmt.Sprintf("Image is too large: %v, %s", err, fileKey)
+	logger.Errorf(errorMessage)
+	recordRejection(sess, rejections.Rejection{
+		FileID:       requestData.FileID,
+		FileKey:      fileKey,
+		Rule:         "image_too_large",
+		Message:      errorMessage,
+		DetectedType: fileType,
+		MagicBytes:   magicBytes(file),
+		SizeBytes:    numBytes,
+		Limit:        fmt.Sprintf("pixels=%d", maxPixels),
+	})
+	recordEvent(sess, analytics.Event{
+		EventType: analytics.EventUploadRejected,
+		Bucket:    bucket,
+		FileKey:   fileKey,
+		FileType:  fileType,
+		SizeBytes: numBytes,
+		Width:     width,
+		Height:    height,
+		Reason:    "image_too_large",
+	})
+	return &processError{400, problem.ImageTooLarge, errorMessage}
+}
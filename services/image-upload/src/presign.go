@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/awslabs/aws-lambda-go-api-proxy/core"
+	"github.com/okebinda/internal/analytics"
+	"github.com/okebinda/internal/presigns"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/rejections"
+)
+
+// processWindow reads how long after its upload URL expires an upload may still be processed from the
+// UPLOAD_PROCESS_WINDOW_MINUTES env parameter
+func processWindow() (time.Duration, error) {
+	minutes, err := strconv.Atoi(os.Getenv("UPLOAD_PROCESS_WINDOW_MINUTES"))
+	if err != nil || minutes < 0 {
+		return 0, fmt.Errorf("UPLOAD_PROCESS_WINDOW_MINUTES: %s, must be 0 or more", os.Getenv("UPLOAD_PROCESS_WINDOW_MINUTES"))
+	}
+	return time.Duration(minutes) * time.Minute, nil
+}
+
+// recordPresign records an upload URL issued for a file key, expiring at a time, in the table named by the
+// PRESIGNS_TABLE env parameter, if set, so the upload may be processed
+func recordPresign(sess *session.Session, r *http.Request, fileKey string, expiresAt time.Time) error {
+	table := os.Getenv("PRESIGNS_TABLE")
+	if table == "" {
+		return nil
+	}
+	window, err := processWindow()
+	if err != nil {
+		return err
+	}
+	return presigns.Put(sess, table, presigns.Presign{
+		FileKey:   fileKey,
+		Issuer:    requestIssuer(r),
+		ExpiresAt: expiresAt.UTC().Format(time.RFC3339),
+	}, expiresAt.Add(window))
+}
+
+// requestIssuer identifies who a request was made by: the API Gateway API key ID, or else the source IP
+func requestIssuer(r *http.Request) string {
+	if ctx, ok := core.GetAPIGatewayContextFromContext(r.Context()); ok {
+		if ctx.Identity.APIKeyID != "" {
+			return ctx.Identity.APIKeyID
+		}
+		return ctx.Identity.SourceIP
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// checkPresign rejects uploads that were not made through an issued upload URL, or whose processing window has
+// closed, if the PRESIGNS_TABLE env parameter is set, recording the rejection
+func (s *Service) checkPresign(requestData RequestPayload, uploadBucket, fileKey string) *processError {
+	table := os.Getenv("PRESIGNS_TABLE")
+	if table == "" {
+		return nil
+	}
+	presign, found, err := presigns.Get(s.Session, table, fileKey)
+	if err != nil {
+		logger.Errorf("Failed to read presign: %s, %v", fileKey, err)
+		return errServer
+	}
+
+	var rule, errorMessage, limit string
+	switch {
+	case !found:
+		rule = "upload_not_issued"
+		errorMessage = fmt.Sprintf("Upload was not issued: %s", fileKey)
+	case !presign.Open(s.Clock.Now()):
+		rule = "upload_window_closed"
+		errorMessage = fmt.Sprintf("Upload window has closed: %s, process before: %s", fileKey, presign.ProcessBefore)
+		limit = fmt.Sprintf("process_before=%s", presign.ProcessBefore)
+	default:
+		logger.Infow("Upload issued.",
+			"issuer", presign.Issuer,
+			"issued_at", presign.IssuedAt,
+			"process_before", presign.ProcessBefore,
+		)
+		return nil
+	}
+
+	logger.Errorf(errorMessage)
+	recordEvent(s.Session, analytics.Event{
+		EventType: analytics.EventUploadRejected,
+		Bucket:    uploadBucket,
+		FileKey:   fileKey,
+		Reason:    rule,
+	})
+	recordRejection(s.Session, rejections.Rejection{
+		FileID:  requestData.FileID,
+		FileKey: fileKey,
+		Rule:    rule,
+		Message: errorMessage,
+		Limit:   limit,
+	})
+	return &processError{403, problem.UploadWindowClosed, errorMessage}
+}
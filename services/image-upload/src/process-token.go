@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/okebinda/internal/signing"
+)
+
+// ProcessTokenHeader is the request header carrying a process token, authorizing a process-upload request for one
+// upload without the API key
+const ProcessTokenHeader = "X-Process-Token"
+
+// processToken generates a token authorizing the processing of an upload until a time, in the format
+// {expires}.{signature}, with the expiry as a unix timestamp
+func processToken(secret, fileKey string, expiresAt time.Time) string {
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	return expires + "." + signing.Sign(secret, processTokenMessage(fileKey, expires))
+}
+
+// verifyProcessToken tests a process token for an upload, which must not have expired
+func verifyProcessToken(secret, token, fileKey string, now time.Time) bool {
+	parts := strings.SplitN(token, ".", 2)
+	if secret == "" || len(parts) != 2 {
+		return false
+	}
+	expiresAt, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || now.Unix() > expiresAt {
+		return false
+	}
+	return signing.Verify(secret, processTokenMessage(fileKey, parts[0]), parts[1])
+}
+
+// processTokenMessage returns the message signed for a process token, e.g.
+// "process-upload:test/90546589-e63c-4de1-bd49-042ecd20daf1.png:1608857630"
+func processTokenMessage(fileKey, expires string) string {
+	return fmt.Sprintf("process-upload:%s:%s", fileKey, expires)
+}
@@ -5,101 +5,406 @@ import (
 	"encoding/json"
 	"fmt"
 	"image"
-	"math"
+	"image/gif"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
-	"github.com/disintegration/imaging"
+	"github.com/okebinda/internal/analytics"
+	"github.com/okebinda/internal/apiversion"
+	"github.com/okebinda/internal/imageproc"
+	"github.com/okebinda/internal/keys"
+	"github.com/okebinda/internal/lifecycle"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/rejections"
+	"github.com/okebinda/internal/storage"
 )
 
 // RequestPayload defines the JSON schema for payload received from the request
 type RequestPayload struct {
-	Directory     string `json:"directory"`
-	FileExtension string `json:"file_extension"`
-	FileID        string `json:"file_id"`
-	Height        int    `json:"height"`
-	Width         int    `json:"width"`
+	CacheControl        string            `json:"cache_control"`
+	CallbackAggregate   bool              `json:"callback_aggregate"`
+	CallbackHeaders     map[string]string `json:"callback_headers"`
+	CallbackSNSTopicARN string            `json:"callback_sns_topic_arn"`
+	CallbackURL         string            `json:"callback_url"`
+	ContentDisposition  string            `json:"content_disposition"`
+	Context             json.RawMessage   `json:"context"`
+	DeleteSource        bool              `json:"delete_source"`
+	Directory           string            `json:"directory"`
+	ExpectedMD5         string            `json:"expected_md5"`
+	ExpectedSHA256      string            `json:"expected_sha256"`
+	FileExtension       string            `json:"file_extension"`
+	FileID              string            `json:"file_id"`
+	Height              int               `json:"height"`
+	Metadata            map[string]string `json:"metadata"`
+	Page                int               `json:"page"`
+	AvailableFrom       string            `json:"available_from"`
+	Sandbox             bool              `json:"sandbox"`
+	Sizes               []SizePayload     `json:"sizes"`
+	StripMetadata       bool              `json:"strip_metadata"`
+	Uploader            string            `json:"uploader"`
+	Width               int               `json:"width"`
+
+	// attributes are the propagated message attributes of an upload message received from SQS
+	attributes map[string]string
 }
 
 // ResponsePayload defines the JSON schema for the payload to return to the request
 type ResponsePayload struct {
-	Bucket        string `json:"bucket"`
-	Directory     string `json:"directory"`
-	FileExtension string `json:"file_extension"`
-	FileID        string `json:"file_id"`
-	Height        int    `json:"height"`
-	SizeBytes     int64  `json:"size_bytes"`
-	Width         int    `json:"width"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+	Bucket        string            `json:"bucket"`
+	Checksums     *ChecksumPayload  `json:"checksums,omitempty"`
+	Directory     string            `json:"directory"`
+	Encrypted     bool              `json:"encrypted,omitempty"`
+	FileExtension string            `json:"file_extension"`
+	FileID        string            `json:"file_id"`
+	Height        int               `json:"height"`
+	Sandbox       bool              `json:"sandbox,omitempty"`
+	SizeBytes     int64             `json:"size_bytes"`
+	SourceColor   *ColorPayload     `json:"source_color,omitempty"`
+	Variants      []VariantPayload  `json:"variants,omitempty"`
+	Width         int               `json:"width"`
 }
 
-// validImageFormats defines valid image mime types for processing
-var validImageFormats []string = []string{
-	"image/png",
-	"image/jpeg",
+// ColorPayload defines the JSON schema for the color space and bit depth of an upload as it was received, and if
+// it was converted to 8-bit sRGB to publish it
+type ColorPayload struct {
+	ColorSpace string `json:"color_space"`
+	BitDepth   int    `json:"bit_depth"`
+	Converted  bool   `json:"converted"`
 }
 
-// PostProcessUpload moves an image from the upload S3 bucket to the static S3 bucket
-func PostProcessUpload(w http.ResponseWriter, r *http.Request) {
+// ResponsePayloadV2 defines the JSON schema for the payload to return to v2 requests: the published image and its
+// variants, with their URLs and dimensions
+type ResponsePayloadV2 struct {
+	Annotations   map[string]string  `json:"annotations,omitempty"`
+	Bucket        string             `json:"bucket"`
+	Checksums     *ChecksumPayload   `json:"checksums,omitempty"`
+	ContentType   string             `json:"content_type"`
+	Dimensions    DimensionsPayload  `json:"dimensions"`
+	Directory     string             `json:"directory"`
+	Encrypted     bool               `json:"encrypted,omitempty"`
+	FileExtension string             `json:"file_extension"`
+	FileID        string             `json:"file_id"`
+	FileKey       string             `json:"file_key"`
+	Sandbox       bool               `json:"sandbox,omitempty"`
+	SizeBytes     int64              `json:"size_bytes"`
+	SourceColor   *ColorPayload      `json:"source_color,omitempty"`
+	URL           string             `json:"url"`
+	Variants      []VariantPayloadV2 `json:"variants"`
+}
+
+// DimensionsPayload defines the JSON schema for the dimensions of an image in a v2 payload
+type DimensionsPayload struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// VariantPayloadV2 defines the JSON schema for a generated image variant returned in a v2 payload
+type VariantPayloadV2 struct {
+	Name       string            `json:"name"`
+	FileKey    string            `json:"file_key"`
+	URL        string            `json:"url"`
+	Dimensions DimensionsPayload `json:"dimensions"`
+	SizeBytes  int64             `json:"size_bytes"`
+}
 
-	// check API key
+// responseV2 returns the ResponsePayloadV2 for a published image
+func (s *Service) responseV2(responseData *ResponsePayload, published publishedImage) ResponsePayloadV2 {
+	variants := []VariantPayloadV2{}
+	for _, variant := range responseData.Variants {
+		variants = append(variants, VariantPayloadV2{
+			Name:       variant.Name,
+			FileKey:    variant.FileKey,
+			URL:        s.objectURL(responseData.Bucket, variant.FileKey),
+			Dimensions: DimensionsPayload{Width: variant.Width, Height: variant.Height},
+			SizeBytes:  variant.SizeBytes,
+		})
+	}
+	return ResponsePayloadV2{
+		Annotations:   responseData.Annotations,
+		Bucket:        responseData.Bucket,
+		Checksums:     responseData.Checksums,
+		ContentType:   published.fileType,
+		Dimensions:    DimensionsPayload{Width: published.width, Height: published.height},
+		Directory:     responseData.Directory,
+		Encrypted:     responseData.Encrypted,
+		FileExtension: responseData.FileExtension,
+		FileID:        responseData.FileID,
+		FileKey:       published.fileKey,
+		Sandbox:       responseData.Sandbox,
+		SizeBytes:     responseData.SizeBytes,
+		SourceColor:   responseData.SourceColor,
+		URL:           s.objectURL(responseData.Bucket, published.fileKey),
+		Variants:      variants,
+	}
+}
+
+// objectURL returns the URL an image in an S3 bucket is served from, its public URL unless OBJECT_URL_MODE is set
+// (see storage.ObjectURL); if it cannot be generated, the error is logged and the public URL returned
+func (s *Service) objectURL(bucketName, fileKey string) string {
+	publicURL := fmt.Sprintf("https://%s.s3.amazonaws.com/%s", bucketName, keys.EscapePath(storage.ObjectKey(bucketName, fileKey)))
+	servedURL, err := storage.ObjectURL(s.S3, bucketName, fileKey, publicURL)
+	if err != nil {
+		logger.Errorf("Could not generate object URL: %s, %v", fileKey, err)
+		return publicURL
+	}
+	return servedURL
+}
+
+// processError defines a processing failure, the HTTP status code it maps to, and its problem code
+type processError struct {
+	code    int
+	problem string
+	message string
+}
+
+// Error returns the processing failure message
+func (e *processError) Error() string {
+	return e.message
+}
+
+// errServer is returned for processing failures that are not caused by the request
+var errServer = &processError{500, problem.InternalError, "Server error"}
+
+// headerProbeBytes is the number of bytes read from the start of an object to decode its image header
+const headerProbeBytes = 65536
+
+// PostProcessUpload moves an image from the upload S3 bucket to the static S3 bucket; instead of the API key, the
+// request may be authorized by the process token issued with the upload URL, if it sets no callbacks. v2 requests
+// are returned a ResponsePayloadV2
+func (s *Service) PostProcessUpload(w http.ResponseWriter, r *http.Request) {
+
+	// check API key, or else that there is a process token to check once the upload is known
 	ok := authentication(r)
+	token := r.Header.Get(ProcessTokenHeader)
+	if !ok && token == "" {
+		userErrorResponse(w, 403, problem.PermissionDenied, "Permission denied.")
+		return
+	}
+
+	// get payload from request body
+	var requestData RequestPayload
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&requestData); err != nil {
+		logger.Errorf("Error unmarshalling request body: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+	defer r.Body.Close()
+	requestData = sandboxRequest(requestData)
+
+	// check process token, which is only valid for the upload it was issued with
 	if !ok {
-		userErrorResponse(w, 403, "Permission denied.")
+		var fileKey string
+		if requestData.Directory != "" {
+			fileKey = fmt.Sprintf("%s/%s.%s", requestData.Directory, requestData.FileID, requestData.FileExtension)
+		} else {
+			fileKey = fmt.Sprintf("%s.%s", requestData.FileID, requestData.FileExtension)
+		}
+		if !verifyProcessToken(os.Getenv("PROCESS_TOKEN_SECRET"), token, fileKey, s.Clock.Now()) {
+			logger.Errorf("Invalid process token: %s", fileKey)
+			userErrorResponse(w, 403, problem.PermissionDenied, "Permission denied.")
+			return
+		}
+
+		// callbacks are requests made on the client's behalf, so need the API key
+		if requestData.CallbackURL != "" || requestData.CallbackSNSTopicARN != "" || len(requestData.CallbackHeaders) > 0 {
+			errorMessage := "Bad parameter value, cannot complete request; callback_url: callbacks require the API key"
+			logger.Error(errorMessage)
+			userErrorResponse(w, 400, problem.BadParameterValue, errorMessage)
+			return
+		}
+	}
+
+	// process upload
+	responseData, published, perr := s.processUploadImage(requestData)
+	if perr != nil {
+		if perr.code >= 500 {
+			serverErrorResponse(w)
+			return
+		}
+		userErrorResponse(w, perr.code, perr.problem, perr.message)
+		return
+	}
+
+	// response, in the negotiated version's schema
+	if apiVersion >= apiversion.V2 {
+		successResponse(w, 201, s.responseV2(responseData, published))
+		return
+	}
+	successResponse(w, 201, responseData)
+}
+
+// publishedImage describes the published image of an upload, for its notifications
+type publishedImage struct {
+	fileKey  string
+	fileType string
+	width    int
+	height   int
+}
+
+// processUpload moves an image from the upload S3 bucket to the static S3 bucket, records its hash, and announces
+// it, returning the response payload or the error to report to the requester
+func (s *Service) processUpload(requestData RequestPayload) (*ResponsePayload, *processError) {
+	responseData, _, perr := s.processUploadImage(requestData)
+	return responseData, perr
+}
+
+// processUploadImage processes an upload like processUpload, also returning the published image
+func (s *Service) processUploadImage(requestData RequestPayload) (*ResponsePayload, publishedImage, *processError) {
+	requestData = sandboxRequest(requestData)
+	responseData, published, perr := s.publishUpload(requestData)
+	if perr != nil {
+
+		// server errors may be retried, so only rejections are final
+		if perr.code < 500 {
+			emitFailure(s.Session, requestData, failureCode(perr), perr.message)
+		}
+		return nil, published, perr
+	}
+	if !requestData.Sandbox && !responseData.Encrypted {
+		s.recordHash(responseData.Bucket, published.fileKey)
+	}
+	s.announceUpload(requestData, responseData, published)
+	s.deleteSource(requestData)
+	return responseData, published, nil
+}
+
+// logPublished logs the final key, dimensions, and size of an image published from a queue, stream, or event,
+// whose response payload is not returned to anyone
+func logPublished(responseData *ResponsePayload, published publishedImage) {
+	logger.Infow("Upload published.",
+		"file_key", published.fileKey,
+		"width", published.width,
+		"height", published.height,
+		"size_bytes", responseData.SizeBytes,
+	)
+}
+
+// announceUpload emits an ImageProcessed event, notifies subscribers of the upload's directory, posts the
+// processed callback, and exports the upload; sandbox uploads only post the callback
+func (s *Service) announceUpload(requestData RequestPayload, responseData *ResponsePayload, published publishedImage) {
+	if requestData.Sandbox {
+		s.sendProcessedCallback(requestData, s.processedPayload(requestData, responseData, published))
 		return
 	}
+	var variants []lifecycle.Variant
+	for _, variant := range responseData.Variants {
+		variants = append(variants, lifecycle.Variant{
+			Name:    variant.Name,
+			FileKey: variant.FileKey,
+			Width:   variant.Width,
+			Height:  variant.Height,
+		})
+	}
+	emitEvent(s.Session, lifecycle.ImageProcessed, lifecycle.Detail{
+		Bucket:        responseData.Bucket,
+		FileKey:       published.fileKey,
+		FileID:        requestData.FileID,
+		Directory:     requestData.Directory,
+		FileExtension: requestData.FileExtension,
+		ContentType:   published.fileType,
+		Width:         published.width,
+		Height:        published.height,
+		SizeBytes:     responseData.SizeBytes,
+		Variants:      variants,
+		Context:       requestData.Context,
+	})
+	s.notifyUpload(requestData, responseData, published.fileKey)
+	payload := s.processedPayload(requestData, responseData, published)
+	s.sendProcessedCallback(requestData, payload)
+	s.exportUpload(payload)
+}
+
+// publishUpload moves an image from the upload S3 bucket to the static S3 bucket, returning the response payload
+// and the published image, or the error to report to the requester
+func (s *Service) publishUpload(requestData RequestPayload) (*ResponsePayload, publishedImage, *processError) {
 
 	// get environment parameters
-	uploadBucket := os.Getenv("AWS_S3_BUCKET_UPLOAD")
-	publicBucket := os.Getenv("AWS_S3_BUCKET_PUBLIC")
+	publishedTemplate, err := keys.FromEnv("PUBLISHED_KEY_TEMPLATE", keys.DefaultPublishedTemplate, keys.PublishedVariables)
+	if err != nil {
+		logger.Errorf("Could not parse PUBLISHED_KEY_TEMPLATE: %v", err)
+		return nil, publishedImage{}, errServer
+	}
+	variantTemplate, err := keys.FromEnv("VARIANT_KEY_TEMPLATE", keys.DefaultVariantTemplate, keys.VariantVariables)
+	if err != nil {
+		logger.Errorf("Could not parse VARIANT_KEY_TEMPLATE: %v", err)
+		return nil, publishedImage{}, errServer
+	}
+	uploadBucket, perr := bucketFor("AWS_S3_BUCKET_UPLOAD", requestData.Sandbox)
+	if perr != nil {
+		return nil, publishedImage{}, perr
+	}
+	publicBucket, perr := bucketFor("AWS_S3_BUCKET_PUBLIC", requestData.Sandbox)
+	if perr != nil {
+		return nil, publishedImage{}, perr
+	}
 	maxBytes, err := strconv.ParseInt(os.Getenv("MAX_BYTES"), 10, 64)
 	if err != nil {
 		logger.Errorf("Could not convert MAX_BYTES to int64: %v", err)
-		serverErrorResponse(w)
-		return
+		return nil, publishedImage{}, errServer
 	}
 	maxWidth, err := strconv.Atoi(os.Getenv("MAX_WIDTH"))
 	if err != nil {
 		logger.Errorf("Could not convert MAX_WIDTH to int: %v", err)
-		serverErrorResponse(w)
-		return
+		return nil, publishedImage{}, errServer
 	}
 	maxHeight, err := strconv.Atoi(os.Getenv("MAX_HEIGHT"))
 	if err != nil {
 		logger.Errorf("Could not convert MAX_HEIGHT to int: %v", err)
-		serverErrorResponse(w)
-		return
+		return nil, publishedImage{}, errServer
 	}
-
-	// get payload from request body
-	var requestData RequestPayload
-	decoder := json.NewDecoder(r.Body)
-	if err = decoder.Decode(&requestData); err != nil {
-		logger.Errorf("Error unmarshalling request body: %v", err)
-		serverErrorResponse(w)
-		return
+	animationLimits, err := imageproc.AnimationLimitsFromEnv()
+	if err != nil {
+		logger.Errorf("Could not read animation limits: %v", err)
+		return nil, publishedImage{}, errServer
+	}
+	maxPixels, err := imageproc.MaxPixelsFromEnv()
+	if err != nil {
+		logger.Errorf("Could not read pixel limit: %v", err)
+		return nil, publishedImage{}, errServer
+	}
+	stripMetadata := false
+	if os.Getenv("STRIP_METADATA") != "" {
+		stripMetadata, err = strconv.ParseBool(os.Getenv("STRIP_METADATA"))
+		if err != nil {
+			logger.Errorf("Could not convert STRIP_METADATA to bool: %v", err)
+			return nil, publishedImage{}, errServer
+		}
 	}
-	defer r.Body.Close()
 
 	logger.Infow("Request data",
 		"directory", requestData.Directory,
 		"file_extension", requestData.FileExtension,
 		"file_id", requestData.FileID,
 		"height", requestData.Height,
+		"available_from", requestData.AvailableFrom,
+		"sizes", len(requestData.Sizes),
+		"strip_metadata", requestData.StripMetadata,
+		"uploader", requestData.Uploader,
 		"width", requestData.Width,
 	)
+	stripMetadata = stripMetadata || requestData.StripMetadata
 
 	// simple sanity check
-	if requestData.FileID == "" || requestData.FileExtension == "" {
-		errorMessage := fmt.Sprintf("Missing parameters, cannot complete request; file_id: %s, file_extension: %s", requestData.FileID, requestData.FileExtension)
-		logger.Error(errorMessage)
-		userErrorResponse(w, 400, errorMessage)
-		return
+	if perr := validateRequest(requestData, maxWidth, maxHeight); perr != nil {
+		return nil, publishedImage{}, perr
+	}
+
+	// embargo publication until the available from time, validated above
+	pub := s.newPublication(uploadBucket, publicBucket)
+	if requestData.AvailableFrom != "" {
+		availableFrom, _ := time.Parse(time.RFC3339, requestData.AvailableFrom)
+		pub.embargo(availableFrom)
+	}
+	if err = pub.describe(requestData); err != nil {
+		logger.Error(err)
+		return nil, publishedImage{}, errServer
 	}
 
 	// assign file names
@@ -109,97 +414,297 @@ func PostProcessUpload(w http.ResponseWriter, r *http.Request) {
 	} else {
 		fileKey = fmt.Sprintf("%s.%s", requestData.FileID, requestData.FileExtension)
 	}
+	publishedKey, err := publishedTemplate.Render(map[string]string{
+		"directory": requestData.Directory,
+		"file_id":   requestData.FileID,
+		"ext":       requestData.FileExtension,
+	})
+	if err != nil {
+		errorMessage := fmt.Sprintf("Could not generate published key: %v", err)
+		logger.Error(errorMessage)
+		return nil, publishedImage{}, &processError{400, problem.PublishedKeyFailed, errorMessage}
+	}
 	localFile := fmt.Sprintf("/tmp/%s.%s", requestData.FileID, requestData.FileExtension)
 
+	// read object headers before downloading
+	header, perr := s.headUpload(requestData, uploadBucket, fileKey)
+	if perr != nil {
+		return nil, publishedImage{}, perr
+	}
+
+	// reject uploads not made through an issued upload URL, or too late
+	if perr := s.checkPresign(requestData, uploadBucket, fileKey); perr != nil {
+		return nil, publishedImage{}, perr
+	}
+
+	numBytes := aws.Int64Value(header.ContentLength)
+	emitEvent(s.Session, lifecycle.ImageUploaded, lifecycle.Detail{
+		Bucket:        uploadBucket,
+		FileKey:       fileKey,
+		FileID:        requestData.FileID,
+		Directory:     requestData.Directory,
+		FileExtension: requestData.FileExtension,
+		ContentType:   aws.StringValue(header.ContentType),
+		SizeBytes:     numBytes,
+		Context:       requestData.Context,
+	})
+
+	// reject large files
+	if perr := checkSize(s.Session, requestData, uploadBucket, fileKey, numBytes, maxBytes); perr != nil {
+		return nil, publishedImage{}, perr
+	}
+
+	// reject files that do not match the expected checksums
+	checksums, perr := s.checkChecksums(requestData, uploadBucket, fileKey, numBytes)
+	if perr != nil {
+		return nil, publishedImage{}, perr
+	}
+
+	// client-side encrypted uploads cannot be read, so they are stored as they are
+	if isEncrypted(requestData) {
+		return s.publishEncrypted(requestData, pub, header, uploadBucket, publicBucket, fileKey, publishedKey, checksums)
+	}
+
+	// reject infected files and unsafe content before anything is published, unless they were screened in the
+	// quarantine bucket already
+	if !screened(requestData.Sandbox) {
+		if perr = s.scanUpload(requestData, uploadBucket, fileKey, aws.StringValue(header.ContentType), numBytes); perr != nil {
+			return nil, publishedImage{}, perr
+		}
+		if perr = s.moderateUpload(requestData, uploadBucket, fileKey, aws.StringValue(header.ContentType), numBytes); perr != nil {
+			return nil, publishedImage{}, perr
+		}
+	}
+
+	// determine maximum dimensions; a width or height of 0 (or null) leaves that axis constrained only by the
+	// service maximum
+	newMaxWidth := maxWidth
+	if requestData.Width > 0 {
+		newMaxWidth = imageproc.Min(newMaxWidth, requestData.Width)
+	}
+	newMaxHeight := maxHeight
+	if requestData.Height > 0 {
+		newMaxHeight = imageproc.Min(newMaxHeight, requestData.Height)
+	}
+
+	// copy object server-side if no resize, metadata stripping, color conversion, or variants are needed; GIFs are
+	// always downloaded, so their animation limits are checked however small they are
+	headerType, config, orientation, err := s.readImageHeader(uploadBucket, fileKey)
+	headerColor := imageproc.DescribeColor(config.ColorModel)
+	if err != nil {
+		logger.Infof("Could not read image header, falling back to download: %s", err)
+	} else if !stripMetadata && len(requestData.Sizes) == 0 && requestData.Page == 0 && !imageproc.IsGIF(headerType) &&
+		imageproc.IsValidFormat(headerType) && imageproc.IsOriented(orientation) && headerColor.Normalized() &&
+		config.Width <= newMaxWidth && config.Height <= newMaxHeight {
+
+		// let the directory's post-processing hook veto or annotate publication
+		annotations, perr := s.runHook(requestData, HookRequest{
+			Bucket:       uploadBucket,
+			FileKey:      fileKey,
+			PublishedKey: publishedKey,
+			ContentType:  headerType,
+			Width:        config.Width,
+			Height:       config.Height,
+			SizeBytes:    numBytes,
+		})
+		if perr != nil {
+			return nil, publishedImage{}, perr
+		}
+		pub.annotate(annotations)
+		s.copyPlaceholder(pub, uploadBucket, fileKey, publishedKey, config.Width, config.Height)
+
+		err = storage.CopyObjectMetadata(s.S3, uploadBucket, fileKey, publicBucket, publishedKey, pub.objectMetadata(publishedKey, headerType))
+		if err != nil {
+			logger.Errorf("Failed to copy object: %v", err)
+			return nil, publishedImage{}, errServer
+		}
+
+		logger.Infow("Image copy complete.",
+			"bucket", publicBucket,
+			"file_key", publishedKey,
+		)
+
+		// verify published image is readable
+		err = s.verifyPublished(publicBucket, map[string]int64{publishedKey: numBytes})
+		if err != nil {
+			logger.Errorf("Failed to verify published object: %v", err)
+			return nil, publishedImage{}, errServer
+		}
+
+		recordEvent(s.Session, analytics.Event{
+			EventType:  analytics.EventUploadProcessed,
+			Bucket:     publicBucket,
+			FileKey:    publishedKey,
+			FileType:   headerType,
+			SizeBytes:  numBytes,
+			Width:      config.Width,
+			Height:     config.Height,
+			ColorSpace: headerColor.ColorSpace,
+			BitDepth:   headerColor.BitDepth,
+		})
+
+		responseData := &ResponsePayload{
+			Annotations:   annotations,
+			Bucket:        publicBucket,
+			Checksums:     checksums,
+			Directory:     requestData.Directory,
+			FileExtension: requestData.FileExtension,
+			FileID:        requestData.FileID,
+			Height:        config.Height,
+			Sandbox:       requestData.Sandbox,
+			SizeBytes:     numBytes,
+			SourceColor:   &ColorPayload{ColorSpace: headerColor.ColorSpace, BitDepth: headerColor.BitDepth},
+			Width:         config.Width,
+		}
+		return responseData, publishedImage{publishedKey, headerType, config.Width, config.Height}, nil
+	}
+
 	// create local temp file
 	file, err := os.Create(localFile)
 	if err != nil {
 		logger.Errorf("os.Create() error: %s", err)
-		serverErrorResponse(w)
-		return
+		return nil, publishedImage{}, errServer
 	}
 
-	// initialize AWS session
-	sess := session.Must(session.NewSession())
-
 	// download file from S3
-	numBytes, err := downloadFile(sess, file, uploadBucket, fileKey)
+	_, err = storage.DownloadFile(s.S3, file, uploadBucket, fileKey)
 	if err != nil {
 		logger.Errorf("S3 downloader error: %s", err)
 		close(file)
-		if strings.HasPrefix(err.Error(), "NoSuchKey") {
-			userErrorResponse(w, 404, "Not found.")
-			return
+		if storage.IsNotFound(err) {
+			return nil, publishedImage{}, &processError{404, problem.NotFound, "Not found."}
 		}
-		serverErrorResponse(w)
-		return
-	}
-
-	// reject large files
-	if numBytes > maxBytes {
-		errorMessage := fmt.Sprintf("File is too large: %d, %s", numBytes, fileKey)
-		logger.Errorf(errorMessage)
-		close(file)
-		userErrorResponse(w, 400, errorMessage)
-		return
+		return nil, publishedImage{}, errServer
 	}
 
 	// detect file type
-	fileType, err := getFileType(file)
+	fileType, err := imageproc.GetFileType(file)
 	if err != nil {
 		logger.Errorf("File read error: %s", err)
 		close(file)
-		serverErrorResponse(w)
-		return
+		return nil, publishedImage{}, errServer
 	}
 
 	// reject bad file types
-	if !contains(validImageFormats, fileType) {
+	if !imageproc.IsValidFormat(fileType) {
 		errorMessage := fmt.Sprintf("Unsupported file type: %s, %s", fileType, fileKey)
 		logger.Errorf(errorMessage)
+		recordRejection(s.Session, rejections.Rejection{
+			FileID:       requestData.FileID,
+			FileKey:      fileKey,
+			Rule:         "unsupported_file_type",
+			Message:      errorMessage,
+			DetectedType: fileType,
+			MagicBytes:   magicBytes(file),
+			SizeBytes:    numBytes,
+			Limit:        fmt.Sprintf("formats=%s", strings.Join(imageproc.ValidFormats(), ",")),
+		})
 		close(file)
-		userErrorResponse(w, 400, errorMessage)
-		return
+		recordEvent(s.Session, analytics.Event{
+			EventType: analytics.EventUploadRejected,
+			Bucket:    uploadBucket,
+			FileKey:   fileKey,
+			FileType:  fileType,
+			SizeBytes: numBytes,
+			Reason:    "unsupported_file_type",
+		})
+		return nil, publishedImage{}, &processError{400, problem.UnsupportedType, errorMessage}
+	}
+
+	// reject images too large to decode
+	if perr := checkPixels(s.Session, requestData, file, maxPixels, uploadBucket, localFile, fileKey, fileType, numBytes); perr != nil {
+		close(file)
+		return nil, publishedImage{}, perr
 	}
 
-	// open image
-	img, err := imaging.Open(localFile)
+	// reject animations too large to decode
+	if imageproc.IsGIF(fileType) {
+		if perr := checkAnimation(s.Session, requestData, file, animationLimits, uploadBucket, localFile, fileKey, fileType, numBytes); perr != nil {
+			close(file)
+			return nil, publishedImage{}, perr
+		}
+	}
+
+	// detect EXIF orientation
+	orientation, err = imageproc.FileOrientation(file)
 	if err != nil {
-		logger.Errorf("Failed to open image: %v", err)
+		logger.Errorf("File read error: %s", err)
 		close(file)
-		serverErrorResponse(w)
-		return
+		return nil, publishedImage{}, errServer
 	}
 
-	// resize image if too large
-	newMaxWidth := maxWidth
-	if requestData.Width > 0 {
-		newMaxWidth = min(newMaxWidth, requestData.Width)
+	// open image, rejecting files no decoder can read
+	img, err := imageproc.OpenTolerant(localFile, fileType)
+	if err != nil {
+		perr := s.rejectUnprocessable(requestData, file, uploadBucket, fileKey, fileType, numBytes, err)
+		close(file)
+		return nil, publishedImage{}, perr
 	}
-	newMaxHeight := maxHeight
-	if requestData.Height > 0 {
-		newMaxHeight = min(newMaxHeight, requestData.Height)
+
+	// select the requested page, published as a still image
+	if requestData.Page > 0 {
+		img, err = imageproc.OpenPage(localFile, fileType, requestData.Page)
+		if err != nil {
+			close(file)
+			if perr, ok := err.(*imageproc.PageRangeError); ok {
+				errorMessage := fmt.Sprintf("Bad parameter value, cannot complete request; %v", perr)
+				logger.Error(errorMessage)
+				return nil, publishedImage{}, &processError{400, problem.BadParameterValue, errorMessage}
+			}
+			logger.Errorf("Failed to open page: %v", err)
+			return nil, publishedImage{}, errServer
+		}
+	}
+
+	// detect the source's color space and bit depth, converting it to 8-bit sRGB if needed
+	sourceColor, err := imageproc.FileColor(file)
+	if err != nil {
+		sourceColor = imageproc.DescribeColor(img.ColorModel())
+	}
+	img, converted := imageproc.Normalize(img, sourceColor)
+	if converted {
+		logger.Infow("Image converted to 8-bit sRGB.",
+			"color_space", sourceColor.ColorSpace,
+			"bit_depth", sourceColor.BitDepth,
+		)
+	}
+
+	// open every frame of GIFs to preserve animation
+	var anim *gif.GIF
+	if imageproc.IsGIF(fileType) && requestData.Page == 0 {
+		anim, err = imageproc.OpenGIF(localFile)
+		if err != nil {
+			logger.Infof("Could not open every GIF frame, falling back to a still image: %v", err)
+			anim = nil
+		}
+	}
+
+	// resize image if too large
+	var finalWidth, finalHeight int
+	if anim != nil {
+		finalWidth, finalHeight, err = resizeGIFIfTooLarge(anim, localFile, newMaxWidth, newMaxHeight, stripMetadata)
+	} else {
+		finalWidth, finalHeight, err = resizeImageIfTooLarge(img, localFile, newMaxWidth, newMaxHeight, stripMetadata || requestData.Page > 0 || !imageproc.IsOriented(orientation) || converted)
 	}
-	finalWidth, finalHeight, err := resizeImageIfTooLarge(img, localFile, newMaxWidth, newMaxHeight)
 	if err != nil {
 		logger.Errorf("Failed to resize image: %v", err)
 		close(file)
-		serverErrorResponse(w)
-		return
+		return nil, publishedImage{}, errServer
 	}
 
-	// upload to public bucket
-	err = uploadFile(sess, file, publicBucket, fileKey, fileType)
+	// stage in upload bucket, with the placeholder of the published image
+	pub.placeholder(publishedKey, img, finalWidth, finalHeight)
+	err = pub.stage(file, publishedKey, fileType)
 	if err != nil {
 		logger.Errorf("Failed to upload file: %v", err)
 		close(file)
-		serverErrorResponse(w)
-		return
+		pub.discard()
+		return nil, publishedImage{}, errServer
 	}
 
-	logger.Infow("Image upload complete.",
+	logger.Infow("Image staging complete.",
 		"bucket", publicBucket,
-		"file_key", fileKey,
+		"file_key", publishedKey,
 	)
 
 	// get final file size
@@ -207,121 +712,239 @@ func PostProcessUpload(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		logger.Errorf("Failed to stat file: %v", err)
 		close(file)
-		serverErrorResponse(w)
-		return
+		pub.discard()
+		return nil, publishedImage{}, errServer
 	}
 	finalNumBytes := fileInfo.Size()
 
 	close(file)
 
-	// create response payload
-	responseData := &ResponsePayload{
-		Bucket:        publicBucket,
+	// generate and stage variants
+	variants, err := generateVariants(pub, img, anim, requestData, variantTemplate, fileType)
+	if err != nil {
+		logger.Errorf("Failed to generate variants: %v", err)
+		pub.discard()
+		return nil, publishedImage{}, errServer
+	}
+
+	// let the directory's post-processing hook veto or annotate publication
+	annotations, perr := s.runHook(requestData, HookRequest{
+		Bucket:       uploadBucket,
+		FileKey:      pub.stagingKey(publishedKey),
+		PublishedKey: publishedKey,
+		ContentType:  fileType,
+		Width:        finalWidth,
+		Height:       finalHeight,
+		SizeBytes:    finalNumBytes,
+		Variants:     variants,
+	})
+	if perr != nil {
+		pub.discard()
+		return nil, publishedImage{}, perr
+	}
+	pub.annotate(annotations)
+
+	// publish image and variants
+	if err = pub.promote(); err != nil {
+		logger.Errorf("Failed to promote staged objects: %v", err)
+		return nil, publishedImage{}, errServer
+	}
+
+	// verify published image and variants are readable
+	published := map[string]int64{publishedKey: finalNumBytes}
+	for _, variant := range variants {
+		published[variant.FileKey] = variant.SizeBytes
+	}
+	if err = s.verifyPublished(publicBucket, published); err != nil {
+		logger.Errorf("Failed to verify published objects: %v", err)
+		return nil, publishedImage{}, errServer
+	}
+
+	recordEvent(s.Session, analytics.Event{
+		EventType:      analytics.EventUploadProcessed,
+		Bucket:         publicBucket,
+		FileKey:        publishedKey,
+		FileType:       fileType,
+		SizeBytes:      finalNumBytes,
+		Width:          finalWidth,
+		Height:         finalHeight,
+		ColorSpace:     sourceColor.ColorSpace,
+		BitDepth:       sourceColor.BitDepth,
+		ColorConverted: converted,
+	})
+
+	final := publishedImage{publishedKey, fileType, finalWidth, finalHeight}
+	responseData := responsePayload(requestData, publicBucket, final, finalNumBytes)
+	responseData.Annotations = annotations
+	responseData.Checksums = checksums
+	responseData.SourceColor = &ColorPayload{ColorSpace: sourceColor.ColorSpace, BitDepth: sourceColor.BitDepth, Converted: converted}
+	responseData.Variants = variants
+	return responseData, final, nil
+}
+
+// responsePayload returns the response payload of an image published to a bucket, with its final dimensions and
+// size in bytes
+func responsePayload(requestData RequestPayload, bucket string, published publishedImage, sizeBytes int64) *ResponsePayload {
+	return &ResponsePayload{
+		Bucket:        bucket,
 		Directory:     requestData.Directory,
 		FileExtension: requestData.FileExtension,
 		FileID:        requestData.FileID,
-		Height:        finalWidth,
-		SizeBytes:     finalNumBytes,
-		Width:         finalHeight,
+		Height:        published.height,
+		Sandbox:       requestData.Sandbox,
+		SizeBytes:     sizeBytes,
+		Width:         published.width,
 	}
-
-	// response
-	successResponse(w, 201, responseData)
 }
 
-// close closes a file and logs any errors
-func close(file *os.File) {
-	if err := file.Close(); err != nil {
-		logger.Errorf("Error closing the file: %s", err)
+// emitFailure emits an ImageProcessFailed event for an upload, unless it is a sandbox upload
+func emitFailure(sess *session.Session, requestData RequestPayload, code, message string) {
+	if requestData.Sandbox {
+		return
 	}
+	emitEvent(sess, lifecycle.ImageProcessFailed, lifecycle.Detail{
+		FileID:        requestData.FileID,
+		Directory:     requestData.Directory,
+		FileExtension: requestData.FileExtension,
+		Error: &lifecycle.Error{
+			Code:    code,
+			Message: message,
+		},
+		Context: requestData.Context,
+	})
 }
 
-// downloadFile downloads a file from an S3 bucket
-func downloadFile(sess *session.Session, file *os.File, bucketName, fileKey string) (int64, error) {
-	downloader := s3manager.NewDownloader(sess)
-	numBytes, err := downloader.Download(file,
-		&s3.GetObjectInput{
-			Bucket: aws.String(bucketName),
-			Key:    aws.String(fileKey),
-		})
-	return numBytes, err
+// failureCode returns the error code reported for a processing failure
+func failureCode(perr *processError) string {
+	switch {
+	case perr.code >= 500:
+		return "processing_failed"
+	case perr.code == 422:
+		return "unprocessable_image"
+	default:
+		return "rejected"
+	}
 }
 
-// getFileType detects the mime type of the given file
-func getFileType(file *os.File) (string, error) {
-	buff := make([]byte, 512)
-	if _, err := file.Read(buff); err != nil {
-		return "", err
+// validateRequest checks the parameters of a process-upload request before any object is read
+func validateRequest(requestData RequestPayload, maxWidth, maxHeight int) *processError {
+	if requestData.FileID == "" || requestData.FileExtension == "" {
+		errorMessage := fmt.Sprintf("Missing parameters, cannot complete request; file_id: %s, file_extension: %s", requestData.FileID, requestData.FileExtension)
+		logger.Error(errorMessage)
+		return &processError{400, problem.MissingParameters, errorMessage}
+	}
+	if requestData.Page < 0 {
+		errorMessage := fmt.Sprintf("Bad parameter value, cannot complete request; page: %d, must be 1 or more", requestData.Page)
+		logger.Error(errorMessage)
+		return &processError{400, problem.BadParameterValue, errorMessage}
 	}
-	fileType := http.DetectContentType(buff)
-	if _, err := file.Seek(0, 0); err != nil {
-		return "", err
+	if requestData.Width < 0 || requestData.Height < 0 {
+		errorMessage := fmt.Sprintf("Bad parameter value, cannot complete request; width: %d, height: %d, must be 0 (unconstrained) or more", requestData.Width, requestData.Height)
+		logger.Error(errorMessage)
+		return &processError{400, problem.BadParameterValue, errorMessage}
 	}
-	return fileType, nil
-}
-
-// contains tests if a slice contains a string
-func contains(a []string, x string) bool {
-	for _, n := range a {
-		if x == n {
-			return true
+	if requestData.CallbackSNSTopicARN != "" && !strings.HasPrefix(requestData.CallbackSNSTopicARN, "arn:aws:sns:") {
+		errorMessage := fmt.Sprintf("Bad parameter format, cannot complete request; callback_sns_topic_arn: %s, must be an SNS topic ARN", requestData.CallbackSNSTopicARN)
+		logger.Error(errorMessage)
+		return &processError{400, problem.BadParameterFormat, errorMessage}
+	}
+	if perr := validateSizes(requestData.Sizes, maxWidth, maxHeight); perr != nil {
+		logger.Error(perr.message)
+		return perr
+	}
+	if perr := validateChecksums(requestData); perr != nil {
+		return perr
+	}
+	if perr := validateObjectMetadata(requestData); perr != nil {
+		return perr
+	}
+	if requestData.AvailableFrom != "" {
+		if _, err := time.Parse(time.RFC3339, requestData.AvailableFrom); err != nil {
+			errorMessage := fmt.Sprintf("Bad parameter format, cannot complete request; available_from: %s, must be RFC 3339", requestData.AvailableFrom)
+			logger.Error(errorMessage)
+			return &processError{400, problem.BadParameterFormat, errorMessage}
 		}
 	}
-	return false
+	return nil
 }
 
-// resizeImageIfTooLarge resizes an image if the width or height dimensions are too large
-func resizeImageIfTooLarge(img image.Image, localFile string, maxWidth, maxHeight int) (int, int, error) {
-	var err error
-
-	// get dimensions
-	width := img.Bounds().Max.X
-	height := img.Bounds().Max.Y
-
-	// resize if needed
-	if width > maxWidth || height > maxHeight {
-
-		ratioX := float64(maxWidth) / float64(width)
-		ratioY := float64(maxHeight) / float64(height)
-		ratio := math.Min(ratioX, ratioY)
+// checkSize rejects uploads larger than maxBytes, recording the rejection
+func checkSize(sess *session.Session, requestData RequestPayload, uploadBucket, fileKey string, numBytes, maxBytes int64) *processError {
+	if numBytes > maxBytes {
+		errorMessage := fmt.Sprintf("File is too large: %d, %s", numBytes, fileKey)
+		logger.Errorf(errorMessage)
+		recordEvent(sess, analytics.Event{
+			EventType: analytics.EventUploadRejected,
+			Bucket:    uploadBucket,
+			FileKey:   fileKey,
+			SizeBytes: numBytes,
+			Reason:    "file_too_large",
+		})
+		recordRejection(sess, rejections.Rejection{
+			FileID:    requestData.FileID,
+			FileKey:   fileKey,
+			Rule:      "file_too_large",
+			Message:   errorMessage,
+			SizeBytes: numBytes,
+			Limit:     fmt.Sprintf("max_bytes=%d", maxBytes),
+		})
+		return &processError{400, problem.FileTooLarge, errorMessage}
+	}
+	return nil
+}
 
-		width = int(float64(width) * ratio)
-		height = int(float64(height) * ratio)
+// readImageHeader reads the beginning of an object in an S3 bucket and decodes its mime type, dimensions, and
+// EXIF orientation
+func (s *Service) readImageHeader(bucketName, fileKey string) (string, image.Config, int, error) {
+	buffer, err := storage.ReadRange(s.S3, bucketName, fileKey, headerProbeBytes)
+	if err != nil {
+		return "", image.Config{}, imageproc.OrientationUnspecified, err
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(buffer))
+	orientation := imageproc.ReadOrientation(bytes.NewReader(buffer))
+	return http.DetectContentType(buffer), config, orientation, err
+}
 
-		img = imaging.Resize(img, width, height, imaging.Lanczos)
-		err = imaging.Save(img, localFile)
+// copyPlaceholder reads an image copied server-side, which is not otherwise decoded, to publish it with its
+// placeholder; errors are logged, as the image is published without one
+func (s *Service) copyPlaceholder(pub *publication, bucket, fileKey, publishedKey string, width, height int) {
+	body, err := storage.OpenObject(s.S3, bucket, fileKey)
+	if err != nil {
+		logger.Errorf("Error reading image for its placeholder: %s, %v", fileKey, err)
+		return
 	}
-	return width, height, err
+	defer body.Close()
+	img, err := imageproc.Decode(body)
+	if err != nil {
+		logger.Errorf("Error decoding image for its placeholder: %s, %v", fileKey, err)
+		return
+	}
+	pub.placeholder(publishedKey, img, width, height)
 }
 
-// min returns the lesser of two ints
-func min(a, b int) int {
-	if a < b {
-		return a
+// resizeImageIfTooLarge resizes an image if the width or height dimensions are too large, saving it if resized
+// or if reencode is set (e.g. the image was rotated to match its EXIF orientation, or its metadata must be
+// stripped; encoding writes pixel data only, discarding EXIF, GPS, and XMP metadata)
+func resizeImageIfTooLarge(img image.Image, localFile string, maxWidth, maxHeight int, reencode bool) (int, int, error) {
+	img, resized := imageproc.ResizeToFit(img, maxWidth, maxHeight)
+	width, height := imageproc.Dimensions(img)
+	if !resized && !reencode {
+		return width, height, nil
 	}
-	return b
+	return width, height, imageproc.Save(img, localFile)
 }
 
-// uploadFile uploads a file to an S3 bucket
-func uploadFile(sess *session.Session, file *os.File, bucketName, fileKey, fileType string) error {
-
-	// Get file size and read the file content into a buffer
-	fileInfo, _ := file.Stat()
-	var size int64 = fileInfo.Size()
-	buffer := make([]byte, size)
-	if _, err := file.Read(buffer); err != nil {
-		return err
-	}
-
-	// upload to public bucket
-	_, err := s3.New(sess).PutObject(&s3.PutObjectInput{
-		Bucket:             aws.String(bucketName),
-		Key:                aws.String(fileKey),
-		ACL:                aws.String("public-read"),
-		Body:               bytes.NewReader(buffer),
-		ContentLength:      aws.Int64(size),
-		ContentType:        aws.String(fileType),
-		ContentDisposition: aws.String("attachment"),
+// resizeGIFIfTooLarge resizes every frame of a GIF if the width or height dimensions are too large, saving it if
+// resized or if reencode is set (encoding discards comment and application extensions, e.g. XMP metadata)
+func resizeGIFIfTooLarge(anim *gif.GIF, localFile string, maxWidth, maxHeight int, reencode bool) (int, int, error) {
+	width, height := anim.Config.Width, anim.Config.Height
+	if imageproc.Fits(width, height, maxWidth, maxHeight) && !reencode {
+		return width, height, nil
+	}
+	resized := imageproc.TransformGIF(anim, func(img image.Image) image.Image {
+		img, _ = imageproc.ResizeToFit(img, maxWidth, maxHeight)
+		return img
 	})
-	return err
+	width, height = imageproc.Dimensions(resized.Image[0])
+	return width, height, imageproc.SaveGIF(resized, localFile)
 }
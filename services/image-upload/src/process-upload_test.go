@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/okebinda/internal/awstest"
+	"github.com/okebinda/internal/problem"
+)
+
+// TestPublishedDimensions checks the width and height of non-square images are reported as such, not swapped,
+// whether or not they are resized to fit
+func TestPublishedDimensions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name                  string
+		width, height         int
+		maxWidth, maxHeight   int
+		wantWidth, wantHeight int
+	}{
+		{"landscape fits", 300, 200, 1000, 1000, 300, 200},
+		{"portrait fits", 200, 300, 1000, 1000, 200, 300},
+		{"landscape too wide", 600, 200, 300, 300, 300, 100},
+		{"portrait too tall", 200, 600, 300, 300, 100, 300},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img := image.NewNRGBA(image.Rect(0, 0, test.width, test.height))
+			width, height, err := resizeImageIfTooLarge(img, filepath.Join(dir, "image.png"), test.maxWidth, test.maxHeight, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			responseData := responsePayload(RequestPayload{FileID: "id"}, "public", publishedImage{"id.png", "image/png", width, height}, 1024)
+			body, err := json.Marshal(responseData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got struct {
+				Width  int `json:"width"`
+				Height int `json:"height"`
+			}
+			if err = json.Unmarshal(body, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Width != test.wantWidth || got.Height != test.wantHeight {
+				t.Errorf("response dimensions = %dx%d, want %dx%d", got.Width, got.Height, test.wantWidth, test.wantHeight)
+			}
+		})
+	}
+}
+
+// TestProcessUploadAnimationLimits checks GIFs small enough to be copied server-side are still rejected if their
+// animation is too large to decode
+func TestProcessUploadAnimationLimits(t *testing.T) {
+	awstest.Setenv(t, "AWS_S3_BUCKET_UPLOAD", "upload")
+	awstest.Setenv(t, "AWS_S3_BUCKET_PUBLIC", "public")
+	awstest.Setenv(t, "MAX_BYTES", "1048576")
+	awstest.Setenv(t, "MAX_WIDTH", "1000")
+	awstest.Setenv(t, "MAX_HEIGHT", "1000")
+	awstest.Setenv(t, "ANIMATION_MAX_FRAMES", "2")
+
+	// a 3 frame, 4x4 GIF
+	palette := color.Palette{color.Black, color.White}
+	animation := &gif.GIF{}
+	for i := 0; i < 3; i++ {
+		animation.Image = append(animation.Image, image.NewPaletted(image.Rect(0, 0, 4, 4), palette))
+		animation.Delay = append(animation.Delay, 10)
+	}
+	var buffer bytes.Buffer
+	if err := gif.EncodeAll(&buffer, animation); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestService(&awstest.FakeS3{
+		Headers: map[string]*s3.HeadObjectOutput{
+			"upload/test/id.gif": {ContentType: aws.String("image/gif"), ContentLength: aws.Int64(int64(buffer.Len()))},
+		},
+		Objects: map[string][]byte{
+			"upload/test/id.gif": buffer.Bytes(),
+		},
+	}, &awstest.FakeSQS{})
+	_, perr := s.processUpload(RequestPayload{Directory: "test", FileID: "id", FileExtension: "gif"})
+	if perr == nil || perr.code != 400 || perr.problem != problem.AnimationTooLarge {
+		t.Errorf("error = %+v, want 400 %s", perr, problem.AnimationTooLarge)
+	}
+}
+
+// TestProcessUploadS3Errors checks uploads that cannot be read or published because of S3 errors fail with a
+// server error, so they are retried, and missing uploads are not found, while the same uploads are published
+// without errors
+func TestProcessUploadS3Errors(t *testing.T) {
+	awstest.Setenv(t, "AWS_S3_BUCKET_UPLOAD", "upload")
+	awstest.Setenv(t, "AWS_S3_BUCKET_PUBLIC", "public")
+	awstest.Setenv(t, "MAX_BYTES", "1048576")
+	awstest.Setenv(t, "MAX_WIDTH", "10")
+	awstest.Setenv(t, "MAX_HEIGHT", "10")
+
+	// a 4x4 image, copied server-side, and a 20x20 image, resized and uploaded
+	encode := func(size int) []byte {
+		var buffer bytes.Buffer
+		if err := png.Encode(&buffer, image.NewNRGBA(image.Rect(0, 0, size, size))); err != nil {
+			t.Fatal(err)
+		}
+		return buffer.Bytes()
+	}
+	small, large := encode(4), encode(20)
+	unavailable := awserr.New("ServiceUnavailable", "Service Unavailable", nil)
+
+	tests := []struct {
+		name     string
+		fileID   string
+		s3Client *awstest.FakeS3
+		code     int
+	}{
+		{"copied", "small", &awstest.FakeS3{}, 0},
+		{"resized", "large", &awstest.FakeS3{}, 0},
+		{"head error", "small", &awstest.FakeS3{HeadErr: unavailable}, 500},
+		{"not found", "missing", &awstest.FakeS3{}, 404},
+		{"download error", "large", &awstest.FakeS3{GetErr: unavailable}, 500},
+		{"copy error", "small", &awstest.FakeS3{CopyErr: unavailable}, 500},
+		{"put error", "large", &awstest.FakeS3{PutErr: unavailable}, 500},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.s3Client.Headers = map[string]*s3.HeadObjectOutput{
+				"upload/test/small.png": {ContentType: aws.String("image/png"), ContentLength: aws.Int64(int64(len(small)))},
+				"upload/test/large.png": {ContentType: aws.String("image/png"), ContentLength: aws.Int64(int64(len(large)))},
+			}
+			test.s3Client.Objects = map[string][]byte{
+				"upload/test/small.png": small,
+				"upload/test/large.png": large,
+			}
+			s := newTestService(test.s3Client, &awstest.FakeSQS{})
+			_, perr := s.processUpload(RequestPayload{Directory: "test", FileID: test.fileID, FileExtension: "png"})
+			if test.code == 0 {
+				if perr != nil {
+					t.Fatalf("error = %+v, want none", perr)
+				}
+				if _, ok := test.s3Client.Headers["public/test/"+test.fileID+".png"]; !ok {
+					t.Errorf("public/test/%s.png not published", test.fileID)
+				}
+				return
+			}
+			if perr == nil || perr.code != test.code {
+				t.Errorf("error = %+v, want %d", perr, test.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/okebinda/internal/imageproc"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/storage"
+)
+
+// defaultContentDisposition is the Content-Disposition objects are published with if neither the request nor the
+// CONTENT_DISPOSITION env parameter sets one
+const defaultContentDisposition = "attachment"
+
+// defaultStagingPrefix is the key prefix processed outputs are uploaded under until they are promoted
+const defaultStagingPrefix = "_staging"
+
+// verifyAttempts is the number of times a published object is checked before it is considered missing
+const verifyAttempts = 5
+
+// verifyDelay is the time to wait between checks of a published object
+const verifyDelay = 250 * time.Millisecond
+
+// stagedObject defines a privately staged object and the public key it is promoted to
+type stagedObject struct {
+	stagingKey   string
+	publishedKey string
+	fileType     string
+}
+
+// publication stages processed outputs in a private S3 bucket so that nothing is visible to users until every
+// output has been processed and published to the public bucket
+type publication struct {
+	s3            s3iface.S3API
+	stagingBucket string
+	bucket        string
+	prefix        string
+	acl           string
+	cacheControl  string
+	disposition   string
+	userMetadata  map[string]*string
+	placeholders  map[string]string
+	staged        []stagedObject
+	promoted      []string
+}
+
+// newPublication creates a publication staging outputs under the STAGING_PREFIX env parameter, scoped to the
+// current request
+func (s *Service) newPublication(stagingBucketName, bucketName string) *publication {
+	prefix := strings.Trim(os.Getenv("STAGING_PREFIX"), "/")
+	if prefix == "" {
+		prefix = defaultStagingPrefix
+	}
+	return &publication{
+		s3:            s.S3,
+		stagingBucket: stagingBucketName,
+		bucket:        bucketName,
+		prefix:        fmt.Sprintf("%s/%s", prefix, requestID),
+		acl:           storage.PublicACL(),
+		disposition:   defaultContentDisposition,
+	}
+}
+
+// describe sets the Content-Disposition, Cache-Control, and additional user metadata objects are published with:
+// the request's content_disposition, cache_control, and metadata, else the CONTENT_DISPOSITION, CACHE_CONTROL, and
+// OBJECT_METADATA (a JSON object) env parameters; request metadata is added to the configured metadata, and
+// neither can replace the embargo time
+func (p *publication) describe(requestData RequestPayload) error {
+	metadata := storage.ObjectMetadata{
+		CacheControl:       os.Getenv("CACHE_CONTROL"),
+		ContentDisposition: os.Getenv("CONTENT_DISPOSITION"),
+	}
+	if document := os.Getenv("OBJECT_METADATA"); document != "" {
+		var configured map[string]string
+		if err := json.Unmarshal([]byte(document), &configured); err != nil {
+			return fmt.Errorf("could not parse OBJECT_METADATA: %v", err)
+		}
+		metadata.Metadata = aws.StringMap(configured)
+	}
+	if err := storage.ValidateMetadata(metadata); err != nil {
+		return fmt.Errorf("invalid CACHE_CONTROL, CONTENT_DISPOSITION, or OBJECT_METADATA: %v", err)
+	}
+	if requestData.CacheControl != "" {
+		metadata.CacheControl = requestData.CacheControl
+	}
+	if requestData.ContentDisposition != "" {
+		metadata.ContentDisposition = requestData.ContentDisposition
+	}
+
+	p.cacheControl = metadata.CacheControl
+	if metadata.ContentDisposition != "" {
+		p.disposition = metadata.ContentDisposition
+	}
+	p.annotate(aws.StringValueMap(metadata.Metadata))
+	p.annotate(requestData.Metadata)
+	return nil
+}
+
+// validateObjectMetadata checks the content disposition, cache control, and metadata of a process request, see
+// storage.ValidateMetadata
+func validateObjectMetadata(requestData RequestPayload) *processError {
+	err := storage.ValidateMetadata(storage.ObjectMetadata{
+		CacheControl:       requestData.CacheControl,
+		ContentDisposition: requestData.ContentDisposition,
+		Metadata:           aws.StringMap(requestData.Metadata),
+	})
+	if err != nil {
+		errorMessage := fmt.Sprintf("Bad parameter value, cannot complete request; %v", err)
+		logger.Error(errorMessage)
+		return &processError{400, problem.BadParameterValue, errorMessage}
+	}
+	return nil
+}
+
+// stage uploads a private copy of a file to the staging prefix, to be published to publishedKey on promotion
+func (p *publication) stage(file *os.File, publishedKey, fileType string) error {
+	stagingKey := p.stagingKey(publishedKey)
+	if err := storage.UploadFileACL(p.s3, file, p.stagingBucket, stagingKey, fileType, "private"); err != nil {
+		return err
+	}
+	p.staged = append(p.staged, stagedObject{stagingKey, publishedKey, fileType})
+	return nil
+}
+
+// stagingKey returns the key an object to be published to publishedKey is staged under
+func (p *publication) stagingKey(publishedKey string) string {
+	return fmt.Sprintf("%s/%s", p.prefix, publishedKey)
+}
+
+// promote copies every staged object to its published key, in reverse staging order so the primary image
+// (staged first) only appears once its variants are in place; if a copy fails the objects already published
+// are removed again, and staged objects are always discarded
+func (p *publication) promote() error {
+	defer p.discard()
+	for i := len(p.staged) - 1; i >= 0; i-- {
+		object := p.staged[i]
+		err := storage.CopyObjectMetadata(p.s3, p.stagingBucket, object.stagingKey, p.bucket, object.publishedKey, p.objectMetadata(object.publishedKey, object.fileType))
+		if err != nil {
+			p.rollback()
+			return err
+		}
+		p.promoted = append(p.promoted, object.publishedKey)
+	}
+
+	logger.Infow("Publication promoted.",
+		"bucket", p.bucket,
+		"objects", len(p.promoted),
+	)
+	return nil
+}
+
+// metadata returns the metadata objects are published with
+func (p *publication) metadata(fileType string) storage.ObjectMetadata {
+	return storage.ObjectMetadata{
+		ACL:                p.acl,
+		CacheControl:       p.cacheControl,
+		ContentDisposition: p.disposition,
+		ContentType:        fileType,
+		Metadata:           p.userMetadata,
+	}
+}
+
+// objectMetadata returns the metadata the object at publishedKey is published with: the publication's metadata,
+// plus the object's placeholder, if it has one
+func (p *publication) objectMetadata(publishedKey, fileType string) storage.ObjectMetadata {
+	metadata := p.metadata(fileType)
+	placeholder, ok := p.placeholders[publishedKey]
+	if !ok {
+		return metadata
+	}
+	userMetadata := map[string]*string{}
+	for key, value := range metadata.Metadata {
+		userMetadata[key] = value
+	}
+	userMetadata[storage.MetadataPlaceholder] = aws.String(placeholder)
+	metadata.Metadata = userMetadata
+	return metadata
+}
+
+// placeholder publishes the object at publishedKey with the placeholder of its image, published at widthxheight,
+// so image-serve can serve the placeholder without downloading and decoding the image
+func (p *publication) placeholder(publishedKey string, img image.Image, width, height int) {
+	if p.placeholders == nil {
+		p.placeholders = map[string]string{}
+	}
+	p.placeholders[publishedKey] = imageproc.NewPlaceholder(img, width, height).String()
+}
+
+// embargo publishes objects privately, marked with the time from which image-serve may serve them
+func (p *publication) embargo(availableFrom time.Time) {
+	p.acl = "private"
+	if p.userMetadata == nil {
+		p.userMetadata = map[string]*string{}
+	}
+	p.userMetadata[storage.MetadataAvailableFrom] = aws.String(availableFrom.UTC().Format(time.RFC3339))
+}
+
+// annotate publishes objects with annotations as user metadata, e.g. from a post-processing hook; annotations
+// cannot replace the embargo time
+func (p *publication) annotate(annotations map[string]string) {
+	if len(annotations) == 0 {
+		return
+	}
+	if p.userMetadata == nil {
+		p.userMetadata = map[string]*string{}
+	}
+	for key, value := range annotations {
+		if !strings.EqualFold(key, storage.MetadataAvailableFrom) {
+			p.userMetadata[key] = aws.String(value)
+		}
+	}
+}
+
+// rollback removes objects published by a failed promotion, logging any errors
+func (p *publication) rollback() {
+	for _, fileKey := range p.promoted {
+		if err := storage.DeleteObject(p.s3, p.bucket, fileKey); err != nil {
+			logger.Errorf("Failed to roll back published object: %s, %v", fileKey, err)
+		}
+	}
+	p.promoted = nil
+}
+
+// discard deletes all staged objects, logging any errors; objects that cannot be deleted are left for the
+// staging prefix lifecycle rule to expire
+func (p *publication) discard() {
+	for _, object := range p.staged {
+		if err := storage.DeleteObject(p.s3, p.stagingBucket, object.stagingKey); err != nil {
+			logger.Errorf("Failed to delete staged object: %s, %v", object.stagingKey, err)
+		}
+	}
+	p.staged = nil
+}
+
+// verifyPublished checks that published objects, mapped from file key to expected size, are retrievable before
+// they are reported as complete, so consumers notified of them do not race ahead and find them missing
+func (s *Service) verifyPublished(bucketName string, objects map[string]int64) error {
+	for fileKey, size := range objects {
+		if err := storage.WaitForObject(s.S3, bucketName, fileKey, size, verifyAttempts, verifyDelay); err != nil {
+			return fmt.Errorf("%s: %v", fileKey, err)
+		}
+	}
+	return nil
+}
+
+// deleteSource deletes a published upload from the upload bucket if the request sets delete_source, or the
+// DELETE_SOURCE_AFTER_PROCESS env parameter is "true"; failures are logged, leaving the upload for the
+// upload-janitor function to purge
+func (s *Service) deleteSource(requestData RequestPayload) {
+	deleteAfterProcess := false
+	if os.Getenv("DELETE_SOURCE_AFTER_PROCESS") != "" {
+		var err error
+		deleteAfterProcess, err = strconv.ParseBool(os.Getenv("DELETE_SOURCE_AFTER_PROCESS"))
+		if err != nil {
+			logger.Errorf("Could not convert DELETE_SOURCE_AFTER_PROCESS to bool: %v", err)
+		}
+	}
+	if !deleteAfterProcess && !requestData.DeleteSource {
+		return
+	}
+
+	uploadBucket, perr := bucketFor("AWS_S3_BUCKET_UPLOAD", requestData.Sandbox)
+	if perr != nil {
+		return
+	}
+	var fileKey string
+	if requestData.Directory != "" {
+		fileKey = fmt.Sprintf("%s/%s.%s", requestData.Directory, requestData.FileID, requestData.FileExtension)
+	} else {
+		fileKey = fmt.Sprintf("%s.%s", requestData.FileID, requestData.FileExtension)
+	}
+	if err := storage.DeleteObject(s.S3, uploadBucket, fileKey); err != nil {
+		logger.Errorf("Failed to delete source object: %s, %v", fileKey, err)
+		return
+	}
+
+	logger.Infow("Source object deleted.",
+		"bucket", uploadBucket,
+		"file_key", fileKey,
+	)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/okebinda/internal/analytics"
+	"github.com/okebinda/internal/imageproc"
+	"github.com/okebinda/internal/logging"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/rejections"
+	"github.com/okebinda/internal/storage"
+)
+
+// fileTypeProbeBytes is the number of bytes read from the start of an object to detect its type
+const fileTypeProbeBytes = 512
+
+// quarantineBucket returns the bucket uploads land in until they are screened, named by the
+// AWS_S3_BUCKET_QUARANTINE env parameter, or for sandbox requests SANDBOX_AWS_S3_BUCKET_QUARANTINE; if it is not
+// set, uploads are sent to the upload bucket directly
+func quarantineBucket(sandbox bool) string {
+	if sandbox {
+		return os.Getenv("SANDBOX_AWS_S3_BUCKET_QUARANTINE")
+	}
+	return os.Getenv("AWS_S3_BUCKET_QUARANTINE")
+}
+
+// screened tests if uploads are screened in the quarantine bucket before they reach the upload bucket, so they
+// are not scanned or moderated again when they are processed
+func screened(sandbox bool) bool {
+	return quarantineBucket(sandbox) != ""
+}
+
+// QuarantineHandler is our lambda handler for s3:ObjectCreated:* events on the quarantine bucket, screening each
+// new upload and promoting it to the upload bucket if it passes; uploads rejected by screening are logged and
+// skipped, any other failure returns an error so the event is retried
+func (s *Service) QuarantineHandler(ctx context.Context, event events.S3Event) error {
+
+	// initialize logger
+	lc, _ := lambdacontext.FromContext(ctx)
+	requestID = lc.AwsRequestID
+	invocation = ctx
+	logger = logging.New(requestID)
+	defer logger.Sync()
+
+	// initialize AWS session
+	sess := s.Session
+
+	for _, record := range event.Records {
+		fileKey, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			logger.Errorf("Error decoding object key: %s, %v", record.S3.Object.Key, err)
+			continue
+		}
+
+		// skip objects set aside by screening
+		if isServiceKey(fileKey) {
+			continue
+		}
+
+		// derive payload from object, for the callbacks of rejected uploads
+		requestData, err := s.requestFromObject(record.S3.Bucket.Name, fileKey)
+		if err != nil {
+			if storage.IsNotFound(err) {
+				logger.Infof("Object no longer exists: %s", fileKey)
+				continue
+			}
+			logger.Errorf("Error reading object: %s, %v", fileKey, err)
+			return err
+		}
+		requestData = sandboxRequest(requestData)
+
+		// screen and promote upload
+		if perr := s.promoteUpload(requestData, fileKey); perr != nil {
+			if perr.code >= 500 {
+				return perr
+			}
+			logger.Errorf("Object rejected: %s, %s", fileKey, perr.message)
+			if perr.code != 404 {
+				emitFailure(sess, requestData, failureCode(perr), perr.message)
+			}
+		}
+	}
+	return nil
+}
+
+// headUpload reads the headers of an upload in the upload bucket; if it is not there yet, but uploads are
+// screened, it is first screened and promoted from the quarantine bucket, so requests made before the screening
+// function has run are not refused
+func (s *Service) headUpload(requestData RequestPayload, uploadBucket, fileKey string) (*s3.HeadObjectOutput, *processError) {
+	header, err := storage.HeadObject(s.S3, uploadBucket, fileKey)
+	if err != nil && storage.IsNotFound(err) && screened(requestData.Sandbox) {
+
+		// the upload may also have been promoted in the meantime, so a missing upload is looked up again
+		if perr := s.promoteUpload(requestData, fileKey); perr != nil && perr.code != 404 {
+			return nil, perr
+		}
+		header, err = storage.HeadObject(s.S3, uploadBucket, fileKey)
+	}
+	if err != nil {
+		logger.Errorf("S3 head object error: %s", err)
+		if storage.IsNotFound(err) {
+			return nil, &processError{404, problem.NotFound, "Not found."}
+		}
+		return nil, errServer
+	}
+	return header, nil
+}
+
+// promoteUpload screens an upload in the quarantine bucket, checking its size and type, scanning it for malware,
+// and moderating its content, then moves it to the upload bucket if it passes; encrypted uploads cannot be read,
+// so only their size is checked. Rejected uploads stay in the quarantine bucket, or are set aside by the scanner
+// or moderation, and are never promoted
+func (s *Service) promoteUpload(requestData RequestPayload, fileKey string) *processError {
+
+	// get environment parameters
+	quarantine := quarantineBucket(requestData.Sandbox)
+	uploadBucket, perr := bucketFor("AWS_S3_BUCKET_UPLOAD", requestData.Sandbox)
+	if perr != nil {
+		return perr
+	}
+	maxBytes, err := strconv.ParseInt(os.Getenv("MAX_BYTES"), 10, 64)
+	if err != nil {
+		logger.Errorf("Could not convert MAX_BYTES to int64: %v", err)
+		return errServer
+	}
+
+	header, err := storage.HeadObject(s.S3, quarantine, fileKey)
+	if err != nil {
+		logger.Errorf("S3 head object error: %s", err)
+		if storage.IsNotFound(err) {
+			return &processError{404, problem.NotFound, "Not found."}
+		}
+		return errServer
+	}
+	numBytes := aws.Int64Value(header.ContentLength)
+	contentType := aws.StringValue(header.ContentType)
+
+	// reject large files
+	if perr := checkSize(s.Session, requestData, quarantine, fileKey, numBytes, maxBytes); perr != nil {
+		return perr
+	}
+
+	if _, _, encrypted := storage.Encryption(header); !encrypted {
+
+		// reject files that are not images of an enabled format, whatever type they were uploaded as
+		if perr := s.checkFileType(requestData, quarantine, fileKey, numBytes); perr != nil {
+			return perr
+		}
+
+		// reject infected files
+		if perr := s.scanUpload(requestData, quarantine, fileKey, contentType, numBytes); perr != nil {
+			return perr
+		}
+
+		// reject unsafe content
+		if perr := s.moderateUpload(requestData, quarantine, fileKey, contentType, numBytes); perr != nil {
+			return perr
+		}
+	}
+
+	if err = storage.MoveObject(s.S3, quarantine, fileKey, uploadBucket, fileKey); err != nil {
+		logger.Errorf("Failed to promote upload: %s, %v", fileKey, err)
+		if storage.IsNotFound(err) {
+			return &processError{404, problem.NotFound, "Not found."}
+		}
+		return errServer
+	}
+
+	logger.Infow("Upload promoted.",
+		"quarantine_bucket", quarantine,
+		"bucket", uploadBucket,
+		"file_key", fileKey,
+		"size_bytes", numBytes,
+	)
+	return nil
+}
+
+// checkFileType rejects uploads whose content is not an image of an enabled format, detected from their first
+// bytes, recording the rejection
+func (s *Service) checkFileType(requestData RequestPayload, bucket, fileKey string, numBytes int64) *processError {
+	buffer, err := storage.ReadRange(s.S3, bucket, fileKey, fileTypeProbeBytes)
+	if err != nil {
+		logger.Errorf("S3 get object error: %s", err)
+		if storage.IsNotFound(err) {
+			return &processError{404, problem.NotFound, "Not found."}
+		}
+		return errServer
+	}
+	fileType := imageproc.DetectFileType(buffer)
+	if imageproc.IsValidFormat(fileType) {
+		return nil
+	}
+
+	errorMessage := fmt.Sprintf("Unsupported file type: %s, %s", fileType, fileKey)
+	logger.Errorf(errorMessage)
+	sample := buffer
+	if len(sample) > magicBytesSample {
+		sample = sample[:magicBytesSample]
+	}
+	recordRejection(s.Session, rejections.Rejection{
+		FileID:       requestData.FileID,
+		FileKey:      fileKey,
+		Rule:         "unsupported_file_type",
+		Message:      errorMessage,
+		DetectedType: fileType,
+		MagicBytes:   hex.EncodeToString(sample),
+		SizeBytes:    numBytes,
+		Limit:        fmt.Sprintf("formats=%s", strings.Join(imageproc.ValidFormats(), ",")),
+	})
+	recordEvent(s.Session, analytics.Event{
+		EventType: analytics.EventUploadRejected,
+		Bucket:    bucket,
+		FileKey:   fileKey,
+		FileType:  fileType,
+		SizeBytes: numBytes,
+		Reason:    "unsupported_file_type",
+	})
+	return &processError{400, problem.UnsupportedType, errorMessage}
+}
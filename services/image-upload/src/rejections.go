@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/go-chi/chi"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/rejections"
+)
+
+// magicBytesSample is the number of bytes from the start of a rejected file recorded with its rejection
+const magicBytesSample = 16
+
+// GetRejection returns the details recorded when an upload was rejected, so integrators can see why
+func (s *Service) GetRejection(w http.ResponseWriter, r *http.Request) {
+
+	// check API key
+	ok := authentication(r)
+	if !ok {
+		userErrorResponse(w, 403, problem.PermissionDenied, "Permission denied.")
+		return
+	}
+
+	// get environment parameters
+	table := os.Getenv("REJECTIONS_TABLE")
+	if table == "" {
+		logger.Error("REJECTIONS_TABLE is not set")
+		serverErrorResponse(w)
+		return
+	}
+
+	// get path parameters
+	fileID := chi.URLParam(r, "file_id")
+
+	logger.Infow("Request parameters",
+		"file_id", fileID,
+	)
+
+	// read rejection
+	sess := s.Session
+	rejection, found, err := rejections.Get(sess, table, fileID)
+	if err != nil {
+		logger.Errorf("Failed to read rejection: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+	if !found {
+		userErrorResponse(w, 404, problem.NotFound, "Not found.")
+		return
+	}
+
+	// response
+	successResponse(w, 200, rejection)
+}
+
+// recordRejection records why an upload was rejected in the REJECTIONS_TABLE env parameter, if set, and logs any
+// errors
+func recordRejection(sess *session.Session, rejection rejections.Rejection) {
+	table := os.Getenv("REJECTIONS_TABLE")
+	if table == "" {
+		return
+	}
+	if err := rejections.Put(sess, table, rejection); err != nil {
+		logger.Errorf("Error recording rejection: %s, %v", rejection.FileID, err)
+	}
+}
+
+// magicBytes returns the first bytes of a file, hex encoded, or an empty string if they cannot be read
+func magicBytes(file *os.File) string {
+	buff := make([]byte, magicBytesSample)
+	n, _ := file.ReadAt(buff, 0)
+	return hex.EncodeToString(buff[:n])
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/okebinda/internal/keys"
+	"github.com/okebinda/internal/lifecycle"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/storage"
+)
+
+// RelocateRequest defines the JSON schema for the payload received from a copy or move request; buckets are
+// "public" (the default) or "upload"
+type RelocateRequest struct {
+	SourceBucket      string `json:"source_bucket"`
+	SourceKey         string `json:"source_key"`
+	DestinationBucket string `json:"destination_bucket"`
+	DestinationKey    string `json:"destination_key"`
+	Overwrite         bool   `json:"overwrite"`
+}
+
+// RelocateResponse defines the JSON schema for the payload to return to a copy or move request
+type RelocateResponse struct {
+	Bucket    string `json:"bucket"`
+	FileKey   string `json:"file_key"`
+	SizeBytes int64  `json:"size_bytes"`
+}
+
+// PostCopyImage copies an image to another key, in the same or the other bucket, without downloading it
+func (s *Service) PostCopyImage(w http.ResponseWriter, r *http.Request) {
+	s.relocateImage(w, r, false)
+}
+
+// PostMoveImage moves an image to another key, in the same or the other bucket, without downloading it; the
+// source's cached derivatives are deleted with it
+func (s *Service) PostMoveImage(w http.ResponseWriter, r *http.Request) {
+	s.relocateImage(w, r, true)
+}
+
+// relocateImage copies an image with a server-side S3 copy, keeping its metadata, and deletes the source if move
+// is set; an existing destination is only replaced if the request sets overwrite
+func (s *Service) relocateImage(w http.ResponseWriter, r *http.Request, move bool) {
+
+	// check API key
+	ok := authentication(r)
+	if !ok {
+		userErrorResponse(w, 403, problem.PermissionDenied, "Permission denied.")
+		return
+	}
+
+	// get environment parameters
+	keyValidation, err := keys.ValidationFromEnv()
+	if err != nil {
+		logger.Errorf("Could not read KEY_VALIDATION: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+
+	// get payload from request body
+	var requestData RelocateRequest
+	decoder := json.NewDecoder(r.Body)
+	if err = decoder.Decode(&requestData); err != nil {
+		logger.Errorf("Error unmarshalling request body: %v", err)
+		userErrorResponse(w, 400, problem.BadRequestBody, "Bad request body, must be a JSON object.")
+		return
+	}
+	defer r.Body.Close()
+
+	logger.Infow("Request data",
+		"move", move,
+		"source_bucket", requestData.SourceBucket,
+		"source_key", requestData.SourceKey,
+		"destination_bucket", requestData.DestinationBucket,
+		"destination_key", requestData.DestinationKey,
+		"overwrite", requestData.Overwrite,
+	)
+
+	// simple sanity check
+	if requestData.SourceKey == "" || requestData.DestinationKey == "" {
+		errorMessage := fmt.Sprintf("Missing parameters, cannot complete request; source_key: %s, destination_key: %s", requestData.SourceKey, requestData.DestinationKey)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.MissingParameters, errorMessage)
+		return
+	}
+	for name, key := range map[string]string{"source_key": requestData.SourceKey, "destination_key": requestData.DestinationKey} {
+		if err = keys.Validate(key, keyValidation); err != nil {
+			errorMessage := fmt.Sprintf("Bad parameter format, cannot complete request; %s: %v", name, err)
+			logger.Error(errorMessage)
+			userErrorResponse(w, 400, problem.BadParameterFormat, errorMessage)
+			return
+		}
+	}
+	sourceBucket, ok := relocateBucket(requestData.SourceBucket)
+	if !ok {
+		errorMessage := fmt.Sprintf("Bad parameter value, cannot complete request; source_bucket: %s, must be public or upload", requestData.SourceBucket)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterValue, errorMessage)
+		return
+	}
+	destinationBucket, ok := relocateBucket(requestData.DestinationBucket)
+	if !ok {
+		errorMessage := fmt.Sprintf("Bad parameter value, cannot complete request; destination_bucket: %s, must be public or upload", requestData.DestinationBucket)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterValue, errorMessage)
+		return
+	}
+	if sourceBucket == destinationBucket && requestData.SourceKey == requestData.DestinationKey {
+		errorMessage := fmt.Sprintf("Bad parameter value, cannot complete request; destination_key: %s, must differ from source_key", requestData.DestinationKey)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterValue, errorMessage)
+		return
+	}
+
+	// initialize AWS session
+	sess := s.Session
+
+	// read source metadata, a copy replaces all of it
+	header, err := storage.HeadObject(s.S3, sourceBucket, requestData.SourceKey)
+	if err != nil {
+		logger.Errorf("S3 head object error: %s", err)
+		if storage.IsNotFound(err) {
+			userErrorResponse(w, 404, problem.NotFound, "Not found.")
+			return
+		}
+		serverErrorResponse(w)
+		return
+	}
+
+	// refuse to replace an existing destination
+	if !requestData.Overwrite {
+		_, err = storage.HeadObject(s.S3, destinationBucket, requestData.DestinationKey)
+		if err == nil {
+			errorMessage := fmt.Sprintf("Bad parameter value, cannot complete request; destination_key: %s, already exists, set overwrite to replace it", requestData.DestinationKey)
+			logger.Error(errorMessage)
+			userErrorResponse(w, 409, problem.BadParameterValue, errorMessage)
+			return
+		}
+		if !storage.IsNotFound(err) {
+			logger.Errorf("S3 head object error: %s", err)
+			serverErrorResponse(w)
+			return
+		}
+	}
+
+	// copy, keeping the metadata (copies do not retain the ACL, so published images stay public unless embargoed)
+	metadata := storage.ObjectMetadata{
+		ACL:                s3.ObjectCannedACLPrivate,
+		CacheControl:       aws.StringValue(header.CacheControl),
+		ContentDisposition: aws.StringValue(header.ContentDisposition),
+		ContentType:        aws.StringValue(header.ContentType),
+		Metadata:           header.Metadata,
+	}
+	availableFrom, embargoed := storage.AvailableFrom(header)
+	if destinationBucket == os.Getenv("AWS_S3_BUCKET_PUBLIC") && !(embargoed && s.Clock.Now().Before(availableFrom)) {
+		metadata.ACL = storage.PublicACL()
+	}
+	err = storage.CopyObjectMetadata(s.S3, sourceBucket, requestData.SourceKey, destinationBucket, requestData.DestinationKey, metadata)
+	if err != nil {
+		logger.Errorf("Failed to copy object: %s", err)
+		serverErrorResponse(w)
+		return
+	}
+
+	logger.Infow("Object copied.",
+		"source_bucket", sourceBucket,
+		"source_key", requestData.SourceKey,
+		"destination_bucket", destinationBucket,
+		"destination_key", requestData.DestinationKey,
+	)
+
+	// published copies are hashed like the source
+	if destinationBucket == os.Getenv("AWS_S3_BUCKET_PUBLIC") {
+		if sourceBucket == destinationBucket {
+			copyHash(sess, requestData.SourceKey, requestData.DestinationKey)
+		} else {
+			s.recordHash(destinationBucket, requestData.DestinationKey)
+		}
+	}
+
+	// copies to the upload bucket are issued like uploads, so they may be processed
+	if destinationBucket == os.Getenv("AWS_S3_BUCKET_UPLOAD") {
+		if err = recordPresign(sess, r, requestData.DestinationKey, s.Clock.Now()); err != nil {
+			logger.Errorf("Failed to record presign: %s", err)
+			serverErrorResponse(w)
+			return
+		}
+	}
+
+	// delete the source, and its cached derivatives if it was published
+	if move {
+		if err = storage.DeleteObject(s.S3, sourceBucket, requestData.SourceKey); err != nil {
+			logger.Errorf("Failed delete object: %s", err)
+			serverErrorResponse(w)
+			return
+		}
+		logger.Infow("Object deleted.")
+		if sourceBucket == os.Getenv("AWS_S3_BUCKET_PUBLIC") {
+			if err = s.deleteDerivatives(requestData.SourceKey); err != nil {
+				logger.Errorf("Failed to delete derivatives: %s, %v", requestData.SourceKey, err)
+				serverErrorResponse(w)
+				return
+			}
+			deleteHash(sess, requestData.SourceKey)
+			emitEvent(sess, lifecycle.ImageDeleted, lifecycle.Detail{
+				Bucket:  sourceBucket,
+				FileKey: requestData.SourceKey,
+			})
+		}
+	}
+
+	// response
+	successResponse(w, 201, RelocateResponse{
+		Bucket:    destinationBucket,
+		FileKey:   requestData.DestinationKey,
+		SizeBytes: aws.Int64Value(header.ContentLength),
+	})
+}
+
+// relocateBucket returns the name of a bucket copies and moves may use, by its alias: "public" (or empty) for the
+// static bucket, or "upload" for the upload bucket
+func relocateBucket(alias string) (string, bool) {
+	switch alias {
+	case "", "public":
+		return os.Getenv("AWS_S3_BUCKET_PUBLIC"), true
+	case "upload":
+		return os.Getenv("AWS_S3_BUCKET_UPLOAD"), true
+	}
+	return "", false
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/okebinda/internal/callbacks"
+	"github.com/okebinda/internal/notify"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/queue"
+	"github.com/okebinda/internal/webhooks"
+)
+
+// ReplayRequest defines the JSON schema for the payload received from a callback replay request
+type ReplayRequest struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Directory string `json:"directory"`
+	FileID    string `json:"file_id"`
+}
+
+// ReplayResponse defines the JSON schema for the payload to return to a callback replay request
+type ReplayResponse struct {
+	Replayed int `json:"replayed"`
+}
+
+// maxReplayCallbacks is the maximum number of callbacks that can be replayed by one request
+const maxReplayCallbacks = 1000
+
+// PostCallbackReplay re-enqueues the recorded callbacks sent within a time range, optionally only for a directory
+// or file, to the callback queue, with their attributes as message attributes, to be posted again by the
+// callback-sender function, or in the degraded mode replays them at once if the queue is unavailable
+func (s *Service) PostCallbackReplay(w http.ResponseWriter, r *http.Request) {
+
+	// check API key
+	ok := authentication(r)
+	if !ok {
+		userErrorResponse(w, 403, problem.PermissionDenied, "Permission denied.")
+		return
+	}
+
+	// get environment parameters
+	table := os.Getenv("CALLBACKS_TABLE")
+	if table == "" {
+		logger.Error("CALLBACKS_TABLE is not set")
+		serverErrorResponse(w)
+		return
+	}
+
+	// get payload from request body
+	var requestData ReplayRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&requestData); err != nil {
+		logger.Errorf("Error unmarshalling request body: %v", err)
+		userErrorResponse(w, 400, problem.BadRequestBody, "Bad request body, must be a JSON object.")
+		return
+	}
+	defer r.Body.Close()
+
+	logger.Infow("Request data",
+		"from", requestData.From,
+		"to", requestData.To,
+		"directory", requestData.Directory,
+		"file_id", requestData.FileID,
+	)
+
+	// parse time range; the end defaults to now
+	from, err := time.Parse(time.RFC3339, requestData.From)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Bad parameter format, cannot complete request; from: %s, must be RFC 3339", requestData.From)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterFormat, errorMessage)
+		return
+	}
+	to := s.Clock.Now()
+	if requestData.To != "" {
+		to, err = time.Parse(time.RFC3339, requestData.To)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Bad parameter format, cannot complete request; to: %s, must be RFC 3339", requestData.To)
+			logger.Error(errorMessage)
+			userErrorResponse(w, 400, problem.BadParameterFormat, errorMessage)
+			return
+		}
+	}
+	if to.Before(from) {
+		errorMessage := fmt.Sprintf("Bad parameter value, cannot complete request; to: %s, must not be before from: %s", requestData.To, requestData.From)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterValue, errorMessage)
+		return
+	}
+
+	// read recorded callbacks
+	sess := s.Session
+	recorded, err := callbacks.Query(sess, table, callbacks.Filter{
+		From:      from,
+		To:        to,
+		Directory: requestData.Directory,
+		FileID:    requestData.FileID,
+	})
+	if err != nil {
+		logger.Errorf("Failed to read callbacks: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+	if len(recorded) > maxReplayCallbacks {
+		errorMessage := fmt.Sprintf("Too many callbacks: %d, maximum: %d; narrow the time range, directory, or file_id", len(recorded), maxReplayCallbacks)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.TooManyCallbacks, errorMessage)
+		return
+	}
+
+	// enqueue callbacks, or replay them at once in the degraded mode if the queue is unavailable
+	q, err := queue.OpenWithClient(sess, s.SQS, os.Getenv("CALLBACK_QUEUE_URL"))
+	if err != nil && !degraded("replay", err) {
+		logger.Errorf("Failed to open callback queue: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+	for i, callback := range recorded {
+		if err == nil {
+			var body []byte
+			body, err = json.Marshal(callback)
+			if err != nil {
+				logger.Errorf("Error marshalling callback: %v", err)
+				serverErrorResponse(w)
+				return
+			}
+			if _, err = publishMessage(q, queue.Message{Body: body, Attributes: callback.Attributes}); err == nil {
+				continue
+			}
+			if !degraded("replay", err) {
+				logger.Errorf("Failed to enqueue callback: %s, %v", callback.CallbackID, err)
+				serverErrorResponse(w)
+				return
+			}
+		}
+		if err = s.replayCallbacks(recorded[i:]); err != nil {
+			logger.Errorf("Failed to replay callbacks: %v", err)
+			serverErrorResponse(w)
+			return
+		}
+		break
+	}
+
+	logger.Infow("Callbacks enqueued.",
+		"replayed", len(recorded),
+	)
+
+	// response
+	successResponse(w, 202, ReplayResponse{Replayed: len(recorded)})
+}
+
+// replayCallbacks posts or publishes recorded callbacks at once, as the callback-sender function would, signing
+// those to a webhook subscription's URL with its secrets; failures are logged, and the callbacks can be replayed
+// again
+func (s *Service) replayCallbacks(recorded []callbacks.Callback) error {
+	retry, err := notify.RetryFromEnv()
+	if err != nil {
+		return err
+	}
+	for _, callback := range recorded {
+		secrets, err := webhooks.SecretsForURL(s.Session, os.Getenv("WEBHOOKS_TABLE"), callback.URL, os.Getenv("CALLBACK_SECRET"), s.Clock.Now())
+		if err != nil {
+			return err
+		}
+		if err = callbacks.Replay(invocation, s.Session, callback, secrets, retry); err != nil {
+			logger.Errorf("Failed to replay callback: %s, %v", callback.CallbackID, err)
+		}
+	}
+	return nil
+}
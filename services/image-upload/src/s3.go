@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/okebinda/internal/logging"
+	"github.com/okebinda/internal/storage"
+)
+
+// optionsMetadataKey is the user metadata key holding the processing options of an uploaded object, a JSON
+// object with the same properties as the process-upload RequestPayload
+const optionsMetadataKey = "process-options"
+
+// maxOptionsBytes is the largest processing options accepted, leaving room in S3's 2 KB user metadata limit
+const maxOptionsBytes = 1536
+
+// S3Handler is our lambda handler for s3:ObjectCreated:* events on the upload bucket, processing each new object
+// with a RequestPayload derived from its key and processing options metadata; objects rejected as bad requests
+// are logged and skipped, any other failure returns an error so the event is retried
+func (s *Service) S3Handler(ctx context.Context, event events.S3Event) error {
+
+	// initialize logger
+	lc, _ := lambdacontext.FromContext(ctx)
+	requestID = lc.AwsRequestID
+	invocation = ctx
+	logger = logging.New(requestID)
+	defer logger.Sync()
+
+	for _, record := range event.Records {
+		fileKey, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			logger.Errorf("Error decoding object key: %s, %v", record.S3.Object.Key, err)
+			continue
+		}
+
+		// skip objects written by the service itself
+		if isServiceKey(fileKey) {
+			continue
+		}
+
+		// derive payload from object
+		requestData, err := s.requestFromObject(record.S3.Bucket.Name, fileKey)
+		if err != nil {
+			if storage.IsNotFound(err) {
+				logger.Infof("Object no longer exists: %s", fileKey)
+				continue
+			}
+			logger.Errorf("Error reading object: %s, %v", fileKey, err)
+			return err
+		}
+
+		// process upload
+		responseData, published, perr := s.processUploadImage(requestData)
+		if perr != nil {
+			if perr.code >= 500 {
+				return perr
+			}
+			logger.Errorf("Object rejected: %s, %s", fileKey, perr.message)
+			continue
+		}
+		logPublished(responseData, published)
+	}
+	return nil
+}
+
+// isServiceKey tests if an upload or quarantine bucket key is under the STAGING_PREFIX, QUARANTINE_PREFIX,
+// MODERATION_PREFIX, or IMPORT_REPORT_PREFIX, which hold objects written by the service rather than uploaded
+func isServiceKey(fileKey string) bool {
+	staging := strings.Trim(os.Getenv("STAGING_PREFIX"), "/")
+	return (staging != "" && strings.HasPrefix(fileKey, staging+"/")) || strings.HasPrefix(fileKey, quarantinePrefix()+"/") ||
+		strings.HasPrefix(fileKey, moderationPrefix()+"/") || strings.HasPrefix(fileKey, importReportPrefix()+"/")
+}
+
+// requestFromObject derives the RequestPayload for an uploaded object: the directory, file ID, and extension from
+// its key, and any other properties from the JSON in its processing options metadata
+func (s *Service) requestFromObject(bucket, fileKey string) (RequestPayload, error) {
+	var requestData RequestPayload
+	header, err := storage.HeadObject(s.S3, bucket, fileKey)
+	if err != nil {
+		return requestData, err
+	}
+	for name, value := range header.Metadata {
+		if strings.EqualFold(name, optionsMetadataKey) {
+			if err = json.Unmarshal([]byte(aws.StringValue(value)), &requestData); err != nil {
+				logger.Errorf("Error unmarshalling processing options, ignoring them: %s, %v", fileKey, err)
+				requestData = RequestPayload{}
+			}
+		}
+	}
+
+	directory, name := path.Split(fileKey)
+	extension := path.Ext(name)
+	requestData.Directory = strings.TrimSuffix(directory, "/")
+	requestData.FileID = strings.TrimSuffix(name, extension)
+	requestData.FileExtension = strings.TrimPrefix(extension, ".")
+	return requestData, nil
+}
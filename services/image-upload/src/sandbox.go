@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/okebinda/internal/problem"
+)
+
+// errSandboxDisabled is returned for sandbox requests when no sandbox buckets are configured
+var errSandboxDisabled = &processError{400, problem.BadParameterValue, "Bad parameter value, cannot complete request; sandbox: not enabled"}
+
+// sandboxPrefix returns the directory sandbox uploads are kept under, from the SANDBOX_PREFIX env parameter, or
+// "_sandbox" by default
+func sandboxPrefix() string {
+	if prefix := strings.Trim(os.Getenv("SANDBOX_PREFIX"), "/"); prefix != "" {
+		return prefix
+	}
+	return "_sandbox"
+}
+
+// sandboxed tests if a request runs in the sandbox: every request if the ENVIRONMENT env parameter is "TEST",
+// otherwise requests that set sandbox or whose directory is already under the sandbox prefix
+func sandboxed(sandbox bool, directory string) bool {
+	return os.Getenv("ENVIRONMENT") == "TEST" || sandbox || directory == sandboxPrefix() ||
+		strings.HasPrefix(directory, sandboxPrefix()+"/")
+}
+
+// sandboxDirectory returns a directory under the sandbox prefix
+func sandboxDirectory(directory string) string {
+	prefix := sandboxPrefix()
+	switch {
+	case directory == "":
+		return prefix
+	case directory == prefix || strings.HasPrefix(directory, prefix+"/"):
+		return directory
+	}
+	return prefix + "/" + directory
+}
+
+// sandboxRequest marks a sandboxed request, and moves its directory under the sandbox prefix, so every step that
+// follows reads and writes sandbox keys
+func sandboxRequest(requestData RequestPayload) RequestPayload {
+	if sandboxed(requestData.Sandbox, requestData.Directory) {
+		requestData.Sandbox = true
+		requestData.Directory = sandboxDirectory(requestData.Directory)
+	}
+	return requestData
+}
+
+// bucketFor returns the bucket named by an env parameter, e.g. AWS_S3_BUCKET_UPLOAD, or for sandbox requests the
+// bucket named by the same parameter prefixed with SANDBOX_, e.g. SANDBOX_AWS_S3_BUCKET_UPLOAD, so sandbox
+// requests never write to production buckets; returns errSandboxDisabled if the sandbox bucket is not set
+func bucketFor(name string, sandbox bool) (string, *processError) {
+	if !sandbox {
+		return os.Getenv(name), nil
+	}
+	bucket := os.Getenv("SANDBOX_" + name)
+	if bucket == "" {
+		return "", errSandboxDisabled
+	}
+	return bucket, nil
+}
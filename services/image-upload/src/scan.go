@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/okebinda/internal/analytics"
+	"github.com/okebinda/internal/config"
+	"github.com/okebinda/internal/failures"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/rejections"
+	"github.com/okebinda/internal/scan"
+	"github.com/okebinda/internal/storage"
+)
+
+// scanners maps the values of the SCANNER env parameter to the constructors of their scanners
+var scanners = map[string]func(sess *session.Session, timeout time.Duration) (scan.Scanner, error){
+	"clamav": newClamAVScanner,
+	"api":    newAPIScanner,
+}
+
+// scanAuth caches the auth header values read from SCAN_API_AUTH_SOURCE between invocations
+var scanAuth = map[string]string{}
+var scanAuthMu sync.Mutex
+
+// newClamAVScanner configures a ClamAV scanner from the CLAMSCAN_PATH (default "/opt/bin/clamscan", as installed
+// by a Lambda layer) and CLAMAV_DATABASE (default "/opt/var/lib/clamav") env parameters
+func newClamAVScanner(sess *session.Session, timeout time.Duration) (scan.Scanner, error) {
+	scanner := scan.ClamAV{
+		Path:     os.Getenv("CLAMSCAN_PATH"),
+		Database: os.Getenv("CLAMAV_DATABASE"),
+		Timeout:  timeout,
+	}
+	if scanner.Path == "" {
+		scanner.Path = "/opt/bin/clamscan"
+	}
+	if scanner.Database == "" {
+		scanner.Database = "/opt/var/lib/clamav"
+	}
+	return scanner, nil
+}
+
+// newAPIScanner configures a scanning API from the SCAN_API_URL, SCAN_API_AUTH_SOURCE, and SCAN_API_AUTH_HEADER
+// (default "Authorization") env parameters; the auth header value is read from the config source in
+// SCAN_API_AUTH_SOURCE, e.g. "secretsmanager:name"
+func newAPIScanner(sess *session.Session, timeout time.Duration) (scan.Scanner, error) {
+	scanner := scan.API{
+		URL:     os.Getenv("SCAN_API_URL"),
+		Headers: map[string]string{},
+		Timeout: timeout,
+	}
+	if scanner.URL == "" {
+		return nil, fmt.Errorf("SCAN_API_URL is not set")
+	}
+
+	// read the auth header value, once per container
+	if source := os.Getenv("SCAN_API_AUTH_SOURCE"); source != "" {
+		scanAuthMu.Lock()
+		defer scanAuthMu.Unlock()
+		if _, ok := scanAuth[source]; !ok {
+			value, err := config.Load(sess, source)
+			if err != nil {
+				return nil, fmt.Errorf("could not read SCAN_API_AUTH_SOURCE: %v", err)
+			}
+			scanAuth[source] = strings.TrimSpace(string(value))
+		}
+		header := os.Getenv("SCAN_API_AUTH_HEADER")
+		if header == "" {
+			header = "Authorization"
+		}
+		scanner.Headers[header] = scanAuth[source]
+	}
+	return scanner, nil
+}
+
+// scanUpload scans an uploaded object with the scanner selected by the SCANNER env parameter, if set, before
+// it is published; infected objects are quarantined and reported (see rejectInfected). Uploads that cannot be
+// scanned fail with a server error, to be retried, rather than being published unscanned
+func (s *Service) scanUpload(requestData RequestPayload, uploadBucket, fileKey, contentType string, numBytes int64) *processError {
+	name := os.Getenv("SCANNER")
+	if name == "" {
+		return nil
+	}
+	newScanner, ok := scanners[name]
+	if !ok {
+		logger.Errorf("Unknown SCANNER: %s", name)
+		return errServer
+	}
+	timeoutSeconds, err := strconv.Atoi(os.Getenv("SCAN_TIMEOUT_SECONDS"))
+	if err != nil || timeoutSeconds < 1 {
+		logger.Errorf("Could not convert SCAN_TIMEOUT_SECONDS to a positive int: %s", os.Getenv("SCAN_TIMEOUT_SECONDS"))
+		return errServer
+	}
+	scanner, err := newScanner(s.Session, time.Duration(timeoutSeconds)*time.Second)
+	if err != nil {
+		logger.Errorf("Could not configure %s scanner: %v", name, err)
+		return errServer
+	}
+
+	// stream the object to the scanner
+	body, err := storage.OpenObject(s.S3, uploadBucket, fileKey)
+	if err != nil {
+		logger.Errorf("S3 get object error: %s", err)
+		if storage.IsNotFound(err) {
+			return &processError{404, problem.NotFound, "Not found."}
+		}
+		return errServer
+	}
+	defer body.Close()
+	result, err := scanner.Scan(invocation, body)
+	if err != nil {
+		logger.Errorf("Failed to scan upload with %s: %s, %v", name, fileKey, err)
+		return errServer
+	}
+
+	logger.Infow("Upload scanned.",
+		"scanner", name,
+		"infected", result.Infected,
+		"signature", result.Signature,
+	)
+
+	if result.Infected {
+		return s.rejectInfected(requestData, uploadBucket, fileKey, contentType, numBytes, result.Signature)
+	}
+	return nil
+}
+
+// rejectInfected handles an upload the scanner found infected: the object is moved under the QUARANTINE_PREFIX
+// env parameter (default "_quarantine") in the bucket named by the SCAN_QUARANTINE_BUCKET env parameter (default
+// the upload bucket), the rejection is recorded, and a failure callback with the matched signature is posted to
+// its callback_url; returns the error to report
+func (s *Service) rejectInfected(requestData RequestPayload, bucket, fileKey, contentType string, numBytes int64, signature string) *processError {
+	errorMessage := fmt.Sprintf("Malware detected: %s, %s", signature, fileKey)
+	logger.Errorf(errorMessage)
+
+	recordRejection(s.Session, rejections.Rejection{
+		FileID:    requestData.FileID,
+		FileKey:   fileKey,
+		Rule:      "malware_detected",
+		Message:   errorMessage,
+		SizeBytes: numBytes,
+		Limit:     fmt.Sprintf("signature=%s", signature),
+	})
+	recordEvent(s.Session, analytics.Event{
+		EventType: analytics.EventUploadRejected,
+		Bucket:    bucket,
+		FileKey:   fileKey,
+		FileType:  contentType,
+		SizeBytes: numBytes,
+		Reason:    "malware_detected",
+	})
+
+	// quarantine the object so it is neither published nor processed again
+	quarantineBucket := os.Getenv("SCAN_QUARANTINE_BUCKET")
+	if quarantineBucket == "" {
+		quarantineBucket = bucket
+	}
+	quarantineKey := fmt.Sprintf("%s/%s", quarantinePrefix(), fileKey)
+	if err := storage.CopyObject(s.S3, bucket, fileKey, quarantineBucket, quarantineKey, contentType); err != nil {
+		logger.Errorf("Failed to quarantine object: %v", err)
+	} else if err = storage.DeleteObject(s.S3, bucket, fileKey); err != nil {
+		logger.Errorf("Failed to delete quarantined object: %v", err)
+	} else {
+		logger.Infow("Object quarantined.",
+			"bucket", quarantineBucket,
+			"file_key", quarantineKey,
+		)
+	}
+
+	// report the failure to the requester
+	s.postCallback(requestData, "upload_failed", failures.Callback{
+		Event:         "upload_failed",
+		FileID:        requestData.FileID,
+		Directory:     requestData.Directory,
+		FileExtension: requestData.FileExtension,
+		Error: failures.CallbackError{
+			Code:     "malware_detected",
+			Class:    signature,
+			Message:  "Malware detected",
+			Attempts: 1,
+		},
+		FailedAt: s.Clock.Now().UTC().Format(time.RFC3339),
+		Sandbox:  requestData.Sandbox,
+		Context:  requestData.Context,
+	})
+
+	return &processError{400, problem.MalwareDetected, errorMessage}
+}
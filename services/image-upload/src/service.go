@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/google/uuid"
+	"github.com/okebinda/internal/awsconfig"
+)
+
+// Clock tells the current time
+type Clock interface {
+	Now() time.Time
+}
+
+// IDSource generates unique IDs, e.g. file and callback IDs
+type IDSource interface {
+	New() uuid.UUID
+}
+
+// Service holds what the handlers depend on outside the request: the S3 and SQS clients, the AWS session every
+// other client, e.g. SNS and DynamoDB, is created from, the clock, and the source of IDs; create it with other
+// clients to run the handlers against fakes, stub endpoints (see awsconfig), at a fixed time, or with
+// predictable IDs
+type Service struct {
+	S3      s3iface.S3API
+	SQS     sqsiface.SQSAPI
+	Session *session.Session
+	Clock   Clock
+	IDs     IDSource
+}
+
+// NewService creates a service with its clients, session, clock, and source of IDs
+func NewService(s3Client s3iface.S3API, sqsClient sqsiface.SQSAPI, sess *session.Session, clock Clock, ids IDSource) *Service {
+	return &Service{
+		S3:      s3Client,
+		SQS:     sqsClient,
+		Session: sess,
+		Clock:   clock,
+		IDs:     ids,
+	}
+}
+
+// systemClock tells the time by the system clock
+type systemClock struct{}
+
+// Now returns the current time
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// randomIDs generates random (version 4) UUIDs
+type randomIDs struct{}
+
+// New returns a random UUID
+func (randomIDs) New() uuid.UUID {
+	return uuid.New()
+}
+
+// newDeployedService creates the service of a deployed function: clients of a session configured from the
+// environment, whose requests are sent with the invocation's context, so they are abandoned at the function's
+// deadline, each limited to AWS_OPERATION_TIMEOUT_MS if it is set, the system clock, and random UUIDs
+func newDeployedService() *Service {
+	sess := awsconfig.NewContextFuncSession(func() context.Context {
+		return invocation
+	})
+	return NewService(s3.New(sess), sqs.New(sess), sess, systemClock{}, randomIDs{})
+}
+
+// withSession returns a copy of the service whose clients are created from another session, e.g. one sending
+// requests with a message's own context
+func (s *Service) withSession(sess *session.Session) *Service {
+	return NewService(s3.New(sess), sqs.New(sess), sess, s.Clock, s.IDs)
+}
+
+// service is the service the handlers are registered on
+var service = newDeployedService()
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/okebinda/internal/awstest"
+	"go.uber.org/zap"
+)
+
+// newTestService creates a service with fake S3 and SQS clients, at awstest.Now, and discards its logs
+func newTestService(s3Client *awstest.FakeS3, sqsClient *awstest.FakeSQS) *Service {
+	logger = zap.NewNop().Sugar()
+	sess := awstest.NewSession()
+	s3Client.S3API = s3.New(sess)
+	return NewService(s3Client, sqsClient, sess, awstest.FixedClock{Time: awstest.Now}, randomIDs{})
+}
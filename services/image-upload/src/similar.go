@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/okebinda/internal/hashes"
+	"github.com/okebinda/internal/imageproc"
+	"github.com/okebinda/internal/keys"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/storage"
+)
+
+// SimilarPayload defines the JSON schema for an image found similar by a similar request
+type SimilarPayload struct {
+	FileKey  string `json:"file_key"`
+	Hash     string `json:"hash"`
+	Distance int    `json:"distance"`
+}
+
+// SimilarResponse defines the JSON schema for the payload to return to a similar request
+type SimilarResponse struct {
+	FileKey string           `json:"file_key"`
+	Hash    string           `json:"hash"`
+	Similar []SimilarPayload `json:"similar"`
+}
+
+// similarity thresholds, as Hamming distances between hashes
+const (
+	defaultSimilarThreshold = 5
+	maxSimilarThreshold     = 64
+)
+
+// GetImageSimilar finds the published images whose perceptual hashes are within a Hamming distance threshold of an
+// image's, closest first, for reviewing duplicates; the hashes are read from the table named by the HASHES_TABLE
+// env parameter
+func (s *Service) GetImageSimilar(w http.ResponseWriter, r *http.Request) {
+
+	// check API key
+	if ok := authentication(r); !ok {
+		userErrorResponse(w, 403, problem.PermissionDenied, "Permission denied.")
+		return
+	}
+
+	// get environment parameters
+	table := os.Getenv("HASHES_TABLE")
+	if table == "" {
+		logger.Errorf("HASHES_TABLE is not set")
+		serverErrorResponse(w)
+		return
+	}
+	keyValidation, err := keys.ValidationFromEnv()
+	if err != nil {
+		logger.Errorf("Could not read KEY_VALIDATION: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+
+	// get path parameters (chi doesn't support greedy path parameters)
+	fileKey := strings.TrimPrefix(r.URL.Path, "/image/similar/")
+	threshold := r.URL.Query().Get("threshold")
+
+	logger.Infow("Request parameters",
+		"fileKey", fileKey,
+		"threshold", threshold,
+	)
+
+	// simple sanity check
+	if fileKey == "" {
+		logger.Errorf("Missing parameters, cannot complete request; file_key: %s", fileKey)
+		userErrorResponse(w, 400, problem.MissingParameters, fmt.Sprintf("Missing parameters, cannot complete request; file_key: %s", fileKey))
+		return
+	}
+
+	// check key format
+	if err = keys.Validate(fileKey, keyValidation); err != nil {
+		errorMessage := fmt.Sprintf("Bad parameter format, cannot complete request; file_key: %v", err)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterFormat, errorMessage)
+		return
+	}
+
+	// maximum distance, bounded by the hash size
+	maxDistance := defaultSimilarThreshold
+	if threshold != "" {
+		maxDistance, err = strconv.Atoi(threshold)
+		if err != nil || maxDistance < 0 || maxDistance > maxSimilarThreshold {
+			errorMessage := fmt.Sprintf("Bad parameter value, cannot complete request; threshold: %s, must be 0-%d", threshold, maxSimilarThreshold)
+			logger.Error(errorMessage)
+			userErrorResponse(w, 400, problem.BadParameterValue, errorMessage)
+			return
+		}
+	}
+
+	// read the image's hash
+	sess := s.Session
+	hash, found, err := hashes.Get(sess, table, fileKey)
+	if err != nil {
+		logger.Errorf("Failed to read hash: %s, %v", fileKey, err)
+		serverErrorResponse(w)
+		return
+	}
+	if !found {
+		userErrorResponse(w, 404, problem.NotFound, "Not found.")
+		return
+	}
+	value, err := hash.Value()
+	if err != nil {
+		logger.Errorf("Could not parse hash: %s, %v", hash.Hash, err)
+		serverErrorResponse(w)
+		return
+	}
+
+	// compare every other image's hash
+	responseData := SimilarResponse{
+		FileKey: fileKey,
+		Hash:    hash.Hash,
+		Similar: []SimilarPayload{},
+	}
+	err = hashes.Scan(sess, table, func(other hashes.Hash) {
+		if other.FileKey == fileKey {
+			return
+		}
+		otherValue, err := other.Value()
+		if err != nil {
+			return
+		}
+		if distance := imageproc.HammingDistance(value, otherValue); distance <= maxDistance {
+			responseData.Similar = append(responseData.Similar, SimilarPayload{
+				FileKey:  other.FileKey,
+				Hash:     other.Hash,
+				Distance: distance,
+			})
+		}
+	})
+	if err != nil {
+		logger.Errorf("Failed to scan hashes: %s", err)
+		serverErrorResponse(w)
+		return
+	}
+	sort.Slice(responseData.Similar, func(i, j int) bool {
+		if responseData.Similar[i].Distance != responseData.Similar[j].Distance {
+			return responseData.Similar[i].Distance < responseData.Similar[j].Distance
+		}
+		return responseData.Similar[i].FileKey < responseData.Similar[j].FileKey
+	})
+
+	logger.Infow("Response parameters",
+		"similar", len(responseData.Similar),
+	)
+
+	// response
+	successResponse(w, 200, responseData)
+}
+
+// recordHash computes the perceptual hash of a published image and records it in the table named by the
+// HASHES_TABLE env parameter, if set; errors are logged, as the image is published regardless
+func (s *Service) recordHash(bucket, fileKey string) {
+	table := os.Getenv("HASHES_TABLE")
+	if table == "" {
+		return
+	}
+	body, err := storage.OpenObject(s.S3, bucket, fileKey)
+	if err != nil {
+		logger.Errorf("Error reading image to hash: %s, %v", fileKey, err)
+		return
+	}
+	defer body.Close()
+	img, err := imageproc.Decode(body)
+	if err != nil {
+		logger.Errorf("Error decoding image to hash: %s, %v", fileKey, err)
+		return
+	}
+	hash := hashes.Hash{
+		FileKey: fileKey,
+		Hash:    hashes.Format(imageproc.AverageHash(img)),
+	}
+	if err = hashes.Put(s.Session, table, hash); err != nil {
+		logger.Errorf("Error recording hash: %s, %v", fileKey, err)
+		return
+	}
+	logger.Infow("Hash recorded.",
+		"hash", hash.Hash,
+	)
+}
+
+// copyHash records the hash of a published image for its copy, if the HASHES_TABLE env parameter is set and the
+// image has one; errors are logged
+func copyHash(sess *session.Session, sourceKey, destinationKey string) {
+	table := os.Getenv("HASHES_TABLE")
+	if table == "" {
+		return
+	}
+	hash, found, err := hashes.Get(sess, table, sourceKey)
+	if err != nil {
+		logger.Errorf("Error reading hash: %s, %v", sourceKey, err)
+		return
+	}
+	if !found {
+		return
+	}
+	hash.FileKey = destinationKey
+	hash.HashedAt = ""
+	if err = hashes.Put(sess, table, hash); err != nil {
+		logger.Errorf("Error recording hash: %s, %v", destinationKey, err)
+	}
+}
+
+// deleteHash deletes the hash of an image that is no longer published, if the HASHES_TABLE env parameter is set;
+// errors are logged
+func deleteHash(sess *session.Session, fileKey string) {
+	table := os.Getenv("HASHES_TABLE")
+	if table == "" {
+		return
+	}
+	if err := hashes.Delete(sess, table, fileKey); err != nil {
+		logger.Errorf("Error deleting hash: %s, %v", fileKey, err)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/okebinda/internal/awsconfig"
+	"github.com/okebinda/internal/failures"
+	"github.com/okebinda/internal/logging"
+	"github.com/okebinda/internal/tracing"
+)
+
+// maxSQSConcurrency is the largest number of messages processed at once, SQS's largest standard batch
+const maxSQSConcurrency = 10
+
+// message outcomes
+const (
+	messageProcessed = "processed"
+	messageRejected  = "rejected"
+	messageDropped   = "dropped"
+	messageThrottled = "throttled"
+	messageFailed    = "failed"
+)
+
+// SQSHandler is our lambda handler for SQS event sources, processing each message's RequestPayload, up to
+// SQS_CONCURRENCY (default 1) messages at once; messages rejected as bad requests are logged and dropped, any
+// other failure is reported as a batch item failure so only that message is retried; messages throttled to
+// protect their callback API are sent back to the queue, delayed, or processed at once in the degraded mode if
+// the queue is unavailable
+func (s *Service) SQSHandler(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+
+	// initialize logger
+	lc, _ := lambdacontext.FromContext(ctx)
+	requestID = lc.AwsRequestID
+	invocation = ctx
+	logger = logging.New(requestID)
+	defer logger.Sync()
+
+	// get environment parameters
+	concurrency, err := sqsConcurrency()
+	if err != nil {
+		logger.Error(err)
+		return events.SQSEventResponse{}, err
+	}
+
+	// messages for the same upload are processed in order by one worker, as they share local files
+	var groups [][]int
+	groupIndex := map[string]int{}
+	for i, message := range event.Records {
+		var requestData RequestPayload
+		key := message.MessageId
+		if err := json.Unmarshal([]byte(message.Body), &requestData); err == nil {
+			key = uploadKey(requestData)
+		}
+		if g, ok := groupIndex[key]; ok {
+			groups[g] = append(groups[g], i)
+			continue
+		}
+		groupIndex[key] = len(groups)
+		groups = append(groups, []int{i})
+	}
+
+	// process the groups, up to concurrency at once
+	outcomes := make([]string, len(event.Records))
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, group := range groups {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(group []int) {
+			defer wg.Done()
+			for _, i := range group {
+				outcomes[i] = s.processMessage(ctx, event.Records[i])
+			}
+			<-slots
+		}(group)
+	}
+	wg.Wait()
+
+	// report the failures, in the batch's order
+	var response events.SQSEventResponse
+	counts := map[string]int{}
+	for i, outcome := range outcomes {
+		counts[outcome]++
+		if outcome == messageFailed {
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{
+				ItemIdentifier: event.Records[i].MessageId,
+			})
+		}
+	}
+	logger.Infow("Batch processed.",
+		"messages", len(event.Records),
+		"concurrency", concurrency,
+		messageProcessed, counts[messageProcessed],
+		messageRejected, counts[messageRejected],
+		messageDropped, counts[messageDropped],
+		messageThrottled, counts[messageThrottled],
+		messageFailed, counts[messageFailed],
+	)
+	return response, nil
+}
+
+// processMessage processes one message's RequestPayload, with its own context, returning its outcome; the
+// processing is traced in the trace of the request that sent the message, if it was traced
+func (s *Service) processMessage(ctx context.Context, message events.SQSMessage) (outcome string) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// continue the sender's trace
+	ctx, segment := tracing.Continue(ctx, message.Attributes[tracing.SQSAttribute], "image-upload")
+	if segment != nil {
+		segment.Annotate("message_id", message.MessageId)
+		s = s.withSession(awsconfig.WithContext(s.Session, ctx, 0))
+		defer func() {
+			segment.Annotate("outcome", outcome)
+			segment.End(nil)
+		}()
+	}
+
+	// get payload from message body
+	var requestData RequestPayload
+	if err := json.Unmarshal([]byte(message.Body), &requestData); err != nil {
+		logger.Errorf("Error unmarshalling message body: %s, %v", message.MessageId, err)
+		return messageDropped
+	}
+	requestData.attributes = propagatedAttributes(message)
+
+	// limit how many messages post callbacks to the same API at once, delaying the rest
+	release, err := callbackSlot(ctx, s.Session, requestData)
+	if err != nil {
+		logger.Errorf("Message failed, will be retried: %s, %v", message.MessageId, err)
+		return messageFailed
+	}
+	if release == nil {
+		err = s.delayMessage(ctx, message)
+		if err == nil {
+			return messageThrottled
+		}
+		if !degraded("throttle", err) {
+			logger.Errorf("Could not delay throttled message, will be retried: %s, %v", message.MessageId, err)
+			return messageFailed
+		}
+		release = func() {}
+	}
+
+	// process upload
+	responseData, published, perr := s.processUploadImage(requestData)
+	release()
+	if perr != nil {
+		if perr.code >= 500 {
+			logger.Errorf("Message failed, will be retried: %s, %s", message.MessageId, perr.message)
+			recordFailure(s.Session, message.MessageId, perr.message)
+			return messageFailed
+		}
+		logger.Errorf("Message rejected: %s, %s", message.MessageId, perr.message)
+		return messageRejected
+	}
+	logPublished(responseData, published)
+	return messageProcessed
+}
+
+// propagatedMessageAttributes are the message attributes of an upload message that are set on the callback
+// messages sent for it: its W3C trace context, tenant, and priority
+var propagatedMessageAttributes = []string{"traceparent", "tracestate", "tenant", "priority"}
+
+// propagatedAttributes reads the propagated string attributes of a message, or nil if it has none
+func propagatedAttributes(message events.SQSMessage) map[string]string {
+	var attributes map[string]string
+	for _, name := range propagatedMessageAttributes {
+		value, ok := message.MessageAttributes[name]
+		if !ok || value.StringValue == nil {
+			continue
+		}
+		if attributes == nil {
+			attributes = map[string]string{}
+		}
+		attributes[name] = *value.StringValue
+	}
+	return attributes
+}
+
+// sqsConcurrency reads the number of messages to process at once from the SQS_CONCURRENCY env parameter, 1 by
+// default, at most maxSQSConcurrency
+func sqsConcurrency() (int, error) {
+	value := os.Getenv("SQS_CONCURRENCY")
+	if value == "" {
+		return 1, nil
+	}
+	concurrency, err := strconv.Atoi(value)
+	if err != nil || concurrency < 1 || concurrency > maxSQSConcurrency {
+		return 0, fmt.Errorf("could not convert SQS_CONCURRENCY to 1-%d: %s", maxSQSConcurrency, value)
+	}
+	return concurrency, nil
+}
+
+// recordFailure records the latest error processing a message in the UPLOAD_FAILURES_TABLE env parameter, if set,
+// to be reported by the upload-dlq function if the message is never processed
+func recordFailure(sess *session.Session, messageID, message string) {
+	table := os.Getenv("UPLOAD_FAILURES_TABLE")
+	if table == "" {
+		return
+	}
+	if err := failures.RecordError(sess, table, messageID, message); err != nil {
+		logger.Errorf("Error recording failure: %s, %v", messageID, err)
+	}
+}
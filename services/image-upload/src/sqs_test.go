@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestPropagatedAttributes(t *testing.T) {
+	tests := []struct {
+		name       string
+		attributes map[string]events.SQSMessageAttribute
+		want       map[string]string
+	}{
+		{"none", nil, nil},
+		{"propagated", map[string]events.SQSMessageAttribute{
+			"traceparent": {StringValue: aws.String("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"), DataType: "String"},
+			"tenant":      {StringValue: aws.String("acme"), DataType: "String"},
+			"priority":    {StringValue: aws.String("low"), DataType: "String"},
+		}, map[string]string{
+			"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+			"tenant":      "acme",
+			"priority":    "low",
+		}},
+		{"other attributes ignored", map[string]events.SQSMessageAttribute{
+			"tenant":   {StringValue: aws.String("acme"), DataType: "String"},
+			"internal": {StringValue: aws.String("x"), DataType: "String"},
+			"priority": {BinaryValue: []byte{1}, DataType: "Binary"},
+		}, map[string]string{"tenant": "acme"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := propagatedAttributes(events.SQSMessage{MessageAttributes: test.attributes})
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("attributes = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
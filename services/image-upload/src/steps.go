@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image/gif"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/okebinda/internal/failures"
+	"github.com/okebinda/internal/imageproc"
+	"github.com/okebinda/internal/keys"
+	"github.com/okebinda/internal/logging"
+	"github.com/okebinda/internal/storage"
+)
+
+// StepEvent defines the JSON schema for a task of the process-upload state machine: the step to run and its input
+type StepEvent struct {
+	Step  string          `json:"step"`
+	Input json.RawMessage `json:"input"`
+}
+
+// UploadState defines the JSON schema for the state passed between the steps of the process-upload state machine
+type UploadState struct {
+	Request      RequestPayload   `json:"request"`
+	FileKey      string           `json:"file_key"`
+	SizeBytes    int64            `json:"size_bytes"`
+	PublishedKey string           `json:"published_key,omitempty"`
+	ContentType  string           `json:"content_type,omitempty"`
+	Width        int              `json:"width,omitempty"`
+	Height       int              `json:"height,omitempty"`
+	Response     *ResponsePayload `json:"response,omitempty"`
+	Variants     []VariantPayload `json:"variants,omitempty"`
+}
+
+// VariantInput defines the JSON schema for the input of the variant step, run once for each requested size
+type VariantInput struct {
+	Request      RequestPayload `json:"request"`
+	PublishedKey string         `json:"published_key"`
+	Size         SizePayload    `json:"size"`
+}
+
+// FailureInput defines the JSON schema for the input of the failure step: the request and the error caught by
+// the state machine
+type FailureInput struct {
+	Request RequestPayload `json:"request"`
+	Error   StepError      `json:"error"`
+}
+
+// StepError defines the JSON schema for an error caught by the state machine
+type StepError struct {
+	Error string `json:"Error"`
+	Cause string `json:"Cause"`
+}
+
+// UploadRejected is returned by a step for a request that cannot be completed; it is not retried
+type UploadRejected struct {
+	message string
+}
+
+// Error returns the rejection message
+func (e *UploadRejected) Error() string {
+	return e.message
+}
+
+// UploadUnprocessable is returned by a step for an image no decoder can read; it is not retried
+type UploadUnprocessable struct {
+	message string
+}
+
+// Error returns the rejection message
+func (e *UploadUnprocessable) Error() string {
+	return e.message
+}
+
+// UploadFailed is returned by a step for failures that are not caused by the request; it may be retried
+type UploadFailed struct {
+	message string
+}
+
+// Error returns the failure message
+func (e *UploadFailed) Error() string {
+	return e.message
+}
+
+// stepError converts a processing failure to the error type the state machine matches its retries and catches on
+func stepError(perr *processError) error {
+	switch {
+	case perr.code >= 500:
+		return &UploadFailed{perr.message}
+	case perr.code == 422:
+		return &UploadUnprocessable{perr.message}
+	default:
+		return &UploadRejected{perr.message}
+	}
+}
+
+// StepHandler is our lambda handler for the tasks of the process-upload state machine, which splits processing
+// into validate, resize, variant (once per size, in parallel), and callback steps, with a failure step to report
+// errors caught along the way
+func (s *Service) StepHandler(ctx context.Context, event StepEvent) (interface{}, error) {
+
+	// initialize logger
+	lc, _ := lambdacontext.FromContext(ctx)
+	requestID = lc.AwsRequestID
+	invocation = ctx
+	logger = logging.New(requestID)
+	defer logger.Sync()
+
+	switch event.Step {
+	case "validate":
+		var requestData RequestPayload
+		if err := json.Unmarshal(event.Input, &requestData); err != nil {
+			return nil, &UploadRejected{fmt.Sprintf("Error unmarshalling input: %v", err)}
+		}
+		return s.validateStep(requestData)
+	case "resize":
+		var state UploadState
+		if err := json.Unmarshal(event.Input, &state); err != nil {
+			return nil, &UploadRejected{fmt.Sprintf("Error unmarshalling input: %v", err)}
+		}
+		return s.resizeStep(state)
+	case "variant":
+		var input VariantInput
+		if err := json.Unmarshal(event.Input, &input); err != nil {
+			return nil, &UploadRejected{fmt.Sprintf("Error unmarshalling input: %v", err)}
+		}
+		return s.variantStep(input)
+	case "callback":
+		var state UploadState
+		if err := json.Unmarshal(event.Input, &state); err != nil {
+			return nil, &UploadRejected{fmt.Sprintf("Error unmarshalling input: %v", err)}
+		}
+		return s.callbackStep(state)
+	case "failure":
+		var input FailureInput
+		if err := json.Unmarshal(event.Input, &input); err != nil {
+			return nil, &UploadRejected{fmt.Sprintf("Error unmarshalling input: %v", err)}
+		}
+		s.failureStep(input)
+		return nil, nil
+	}
+	return nil, &UploadRejected{fmt.Sprintf("Unknown step: %s", event.Step)}
+}
+
+// validateStep checks the request parameters and that the uploaded object exists within the size limit
+func (s *Service) validateStep(requestData RequestPayload) (*UploadState, error) {
+	requestData = sandboxRequest(requestData)
+
+	// get environment parameters
+	uploadBucket, perr := bucketFor("AWS_S3_BUCKET_UPLOAD", requestData.Sandbox)
+	if perr != nil {
+		return nil, stepError(perr)
+	}
+	maxBytes, err := strconv.ParseInt(os.Getenv("MAX_BYTES"), 10, 64)
+	if err != nil {
+		logger.Errorf("Could not convert MAX_BYTES to int64: %v", err)
+		return nil, stepError(errServer)
+	}
+	maxWidth, err := strconv.Atoi(os.Getenv("MAX_WIDTH"))
+	if err != nil {
+		logger.Errorf("Could not convert MAX_WIDTH to int: %v", err)
+		return nil, stepError(errServer)
+	}
+	maxHeight, err := strconv.Atoi(os.Getenv("MAX_HEIGHT"))
+	if err != nil {
+		logger.Errorf("Could not convert MAX_HEIGHT to int: %v", err)
+		return nil, stepError(errServer)
+	}
+
+	if perr := validateRequest(requestData, maxWidth, maxHeight); perr != nil {
+		return nil, stepError(perr)
+	}
+	if perr := checkEncryptedRequest(requestData); perr != nil {
+		return nil, stepError(perr)
+	}
+
+	// read object headers
+	var fileKey string
+	if requestData.Directory != "" {
+		fileKey = fmt.Sprintf("%s/%s.%s", requestData.Directory, requestData.FileID, requestData.FileExtension)
+	} else {
+		fileKey = fmt.Sprintf("%s.%s", requestData.FileID, requestData.FileExtension)
+	}
+	header, perr := s.headUpload(requestData, uploadBucket, fileKey)
+	if perr != nil {
+		return nil, stepError(perr)
+	}
+
+	// reject large files
+	numBytes := aws.Int64Value(header.ContentLength)
+	if perr := checkSize(s.Session, requestData, uploadBucket, fileKey, numBytes, maxBytes); perr != nil {
+		return nil, stepError(perr)
+	}
+
+	return &UploadState{
+		Request:   requestData,
+		FileKey:   fileKey,
+		SizeBytes: numBytes,
+	}, nil
+}
+
+// resizeStep publishes the image, resized to the requested dimensions, leaving its variants to the variant steps
+func (s *Service) resizeStep(state UploadState) (*UploadState, error) {
+	requestData := state.Request
+	requestData.Sizes = nil
+	responseData, published, perr := s.publishUpload(requestData)
+	if perr != nil {
+		return nil, stepError(perr)
+	}
+	state.Response = responseData
+	state.PublishedKey = published.fileKey
+	state.ContentType = published.fileType
+	state.Width = published.width
+	state.Height = published.height
+	return &state, nil
+}
+
+// variantStep generates and publishes one variant from the published image
+func (s *Service) variantStep(input VariantInput) (*VariantPayload, error) {
+
+	// get environment parameters
+	variantTemplate, err := keys.FromEnv("VARIANT_KEY_TEMPLATE", keys.DefaultVariantTemplate, keys.VariantVariables)
+	if err != nil {
+		logger.Errorf("Could not parse VARIANT_KEY_TEMPLATE: %v", err)
+		return nil, stepError(errServer)
+	}
+	requestData := input.Request
+	uploadBucket, perr := bucketFor("AWS_S3_BUCKET_UPLOAD", requestData.Sandbox)
+	if perr != nil {
+		return nil, stepError(perr)
+	}
+	publicBucket, perr := bucketFor("AWS_S3_BUCKET_PUBLIC", requestData.Sandbox)
+	if perr != nil {
+		return nil, stepError(perr)
+	}
+	requestData.Sizes = []SizePayload{input.Size}
+
+	// embargo publication until the available from time
+	pub := s.newPublication(uploadBucket, publicBucket)
+	if requestData.AvailableFrom != "" {
+		availableFrom, _ := time.Parse(time.RFC3339, requestData.AvailableFrom)
+		pub.embargo(availableFrom)
+	}
+	if err = pub.describe(requestData); err != nil {
+		logger.Error(err)
+		return nil, stepError(errServer)
+	}
+
+	// download published image
+	localFile := fmt.Sprintf("/tmp/%s.%s", requestData.FileID, requestData.FileExtension)
+	file, err := os.Create(localFile)
+	if err != nil {
+		logger.Errorf("os.Create() error: %s", err)
+		return nil, stepError(errServer)
+	}
+	_, err = storage.DownloadFile(s.S3, file, publicBucket, input.PublishedKey)
+	if err != nil {
+		logger.Errorf("S3 downloader error: %s", err)
+		close(file)
+		return nil, stepError(errServer)
+	}
+	fileType, err := imageproc.GetFileType(file)
+	close(file)
+	if err != nil {
+		logger.Errorf("File read error: %s", err)
+		return nil, stepError(errServer)
+	}
+
+	// open image, and every frame of GIFs to preserve animation
+	img, err := imageproc.OpenTolerant(localFile, fileType)
+	if err != nil {
+		logger.Errorf("Failed to open published image: %v", err)
+		return nil, stepError(errServer)
+	}
+	var anim *gif.GIF
+	if imageproc.IsGIF(fileType) && requestData.Page == 0 {
+		anim, err = imageproc.OpenGIF(localFile)
+		if err != nil {
+			logger.Infof("Could not open every GIF frame, falling back to a still image: %v", err)
+			anim = nil
+		}
+	}
+
+	// generate and publish variant
+	variants, err := generateVariants(pub, img, anim, requestData, variantTemplate, fileType)
+	if err != nil {
+		logger.Errorf("Failed to generate variant: %v", err)
+		pub.discard()
+		return nil, stepError(errServer)
+	}
+	if err = pub.promote(); err != nil {
+		logger.Errorf("Failed to promote staged objects: %v", err)
+		return nil, stepError(errServer)
+	}
+	if err = s.verifyPublished(publicBucket, map[string]int64{variants[0].FileKey: variants[0].SizeBytes}); err != nil {
+		logger.Errorf("Failed to verify published objects: %v", err)
+		return nil, stepError(errServer)
+	}
+	return &variants[0], nil
+}
+
+// callbackStep announces the published image and its variants, returning the process-upload response payload
+func (s *Service) callbackStep(state UploadState) (*ResponsePayload, error) {
+	if state.Response == nil {
+		return nil, &UploadRejected{"Missing response, cannot complete request"}
+	}
+	responseData := state.Response
+	responseData.Variants = state.Variants
+	s.announceUpload(state.Request, responseData, publishedImage{state.PublishedKey, state.ContentType, state.Width, state.Height})
+	s.deleteSource(state.Request)
+	return responseData, nil
+}
+
+// failureStep emits an ImageProcessFailed event and posts a failure callback for an error caught by the state
+// machine, before the execution fails
+func (s *Service) failureStep(input FailureInput) {
+	input.Request = sandboxRequest(input.Request)
+
+	// the cause of errors returned by the handler is a JSON object holding the error message
+	var cause struct {
+		ErrorMessage string `json:"errorMessage"`
+	}
+	message := input.Error.Cause
+	if err := json.Unmarshal([]byte(input.Error.Cause), &cause); err == nil && cause.ErrorMessage != "" {
+		message = cause.ErrorMessage
+	}
+	code := "processing_failed"
+	switch input.Error.Error {
+	case "UploadRejected":
+		code = "rejected"
+	case "UploadUnprocessable":
+		code = "unprocessable_image"
+	}
+
+	logger.Infow("Upload failed.",
+		"file_id", input.Request.FileID,
+		"error", input.Error.Error,
+		"message", message,
+	)
+
+	emitFailure(s.Session, input.Request, code, message)
+
+	// the failure callback of unprocessable images was posted when they were rejected
+	if input.Error.Error != "UploadUnprocessable" {
+		s.postCallback(input.Request, "upload_failed", failures.Callback{
+			Event:         "upload_failed",
+			FileID:        input.Request.FileID,
+			Directory:     input.Request.Directory,
+			FileExtension: input.Request.FileExtension,
+			Error: failures.CallbackError{
+				Code:    code,
+				Message: message,
+			},
+			FailedAt: s.Clock.Now().UTC().Format(time.RFC3339),
+			Sandbox:  input.Request.Sandbox,
+			Context:  input.Request.Context,
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/okebinda/internal/lock"
+	"github.com/okebinda/internal/queue"
+)
+
+// callbackSlotTTL is the longest a callback slot is held, in case a function instance fails to release it
+const callbackSlotTTL = 15 * time.Minute
+
+// maxThrottleDelaySeconds is the longest SQS can delay a message
+const maxThrottleDelaySeconds = 900
+
+// callbackSlot takes one of the CALLBACK_CONCURRENCY slots for processing messages that post callbacks to the same
+// host, shared by all function instances in the LOCK_TABLE DynamoDB table, so a flood of messages cannot overwhelm
+// the callback API; returns a function releasing the slot, or nil if every slot is held. Messages are not limited
+// if LOCK_TABLE is not set, CALLBACK_CONCURRENCY is 0, or they have no callback_url
+func callbackSlot(ctx context.Context, sess *session.Session, requestData RequestPayload) (func(), error) {
+	table := os.Getenv("LOCK_TABLE")
+	if table == "" || requestData.CallbackURL == "" {
+		return func() {}, nil
+	}
+	concurrency, err := strconv.Atoi(os.Getenv("CALLBACK_CONCURRENCY"))
+	if err != nil || concurrency < 0 {
+		return nil, fmt.Errorf("could not convert CALLBACK_CONCURRENCY to a non-negative int: %s", os.Getenv("CALLBACK_CONCURRENCY"))
+	}
+	if concurrency == 0 {
+		return func() {}, nil
+	}
+	callbackURL, err := url.Parse(requestData.CallbackURL)
+	if err != nil {
+		// invalid URLs are rejected when the message is processed
+		return func() {}, nil
+	}
+
+	// hold the slot until the function times out at the latest
+	ttl := callbackSlotTTL
+	if deadline, ok := ctx.Deadline(); ok {
+		ttl = time.Until(deadline) + time.Minute
+	}
+	name := "callback/" + callbackURL.Host
+	l, err := lock.Acquire(sess, table, name, requestID, concurrency, ttl)
+	if err != nil {
+		// don't hold up messages because the lock table is unavailable
+		logger.Errorf("Could not acquire callback slot: %s, %v", callbackURL.Host, err)
+		return func() {}, nil
+	}
+	if l == nil {
+		return nil, nil
+	}
+	return func() {
+		if err := l.Release(); err != nil {
+			logger.Errorf("Could not release callback slot: %s, %v", callbackURL.Host, err)
+		}
+	}, nil
+}
+
+// delayMessage sends a copy of a throttled message back to its queue, delayed by CALLBACK_THROTTLE_DELAY_SECONDS
+// (default 30) plus up to half as much again at random, so throttled messages do not all return at once; the copy
+// starts over with no receives, so throttling never moves messages to the dead-letter queue
+func (s *Service) delayMessage(ctx context.Context, message events.SQSMessage) error {
+	delaySeconds := 30
+	if value := os.Getenv("CALLBACK_THROTTLE_DELAY_SECONDS"); value != "" {
+		var err error
+		delaySeconds, err = strconv.Atoi(value)
+		if err != nil || delaySeconds < 1 || delaySeconds > maxThrottleDelaySeconds {
+			return fmt.Errorf("could not convert CALLBACK_THROTTLE_DELAY_SECONDS to 1-%d: %s", maxThrottleDelaySeconds, value)
+		}
+	}
+	delaySeconds += rand.Intn(delaySeconds/2 + 1)
+	if delaySeconds > maxThrottleDelaySeconds {
+		delaySeconds = maxThrottleDelaySeconds
+	}
+
+	q, err := queue.OpenWithClient(s.Session, s.SQS, message.EventSourceARN)
+	if err != nil {
+		return err
+	}
+	sqsQueue, ok := q.(*queue.SQS)
+	if !ok {
+		return fmt.Errorf("not an SQS queue: %s", message.EventSourceARN)
+	}
+	attributes := map[string]string{}
+	for name, value := range message.MessageAttributes {
+		if value.StringValue != nil {
+			attributes[name] = *value.StringValue
+		}
+	}
+	messageID, err := sqsQueue.PublishDelayed(ctx, queue.Message{
+		Body:       []byte(message.Body),
+		Attributes: attributes,
+	}, time.Duration(delaySeconds)*time.Second)
+	if err != nil {
+		return err
+	}
+	logger.Infow("Message throttled.",
+		"message_id", message.MessageId,
+		"delayed_message_id", messageID,
+		"delay_seconds", delaySeconds,
+	)
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/okebinda/internal/awstest"
+)
+
+func TestDelayMessage(t *testing.T) {
+	awstest.Setenv(t, "CALLBACK_THROTTLE_DELAY_SECONDS", "30")
+
+	message := events.SQSMessage{
+		MessageId:      "message",
+		Body:           `{"file_id":"id","file_extension":"png","callback_url":"https://example.com/hook"}`,
+		EventSourceARN: "arn:aws:sqs:us-east-1:123456789012:uploads",
+		MessageAttributes: map[string]events.SQSMessageAttribute{
+			"tenant": {StringValue: aws.String("acme"), DataType: "String"},
+		},
+	}
+
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"delayed", nil},
+		{"queue unavailable", errors.New("unavailable")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sqsClient := &awstest.FakeSQS{Err: test.err}
+			s := newTestService(&awstest.FakeS3{}, sqsClient)
+			err := s.delayMessage(context.Background(), message)
+
+			// a message that cannot be delayed is failed, so it is retried rather than lost
+			if test.err != nil {
+				if err == nil {
+					t.Error("delayMessage() = nil, want the send error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sqsClient.Sent) != 1 {
+				t.Fatalf("sent %d messages, want 1", len(sqsClient.Sent))
+			}
+			sent := sqsClient.Sent[0]
+			if got := aws.StringValue(sent.QueueUrl); got != "https://sqs.us-east-1.amazonaws.com/123456789012/uploads" {
+				t.Errorf("queue URL = %s", got)
+			}
+			if got := aws.Int64Value(sent.DelaySeconds); got < 30 || got > 45 {
+				t.Errorf("delay = %d seconds, want 30-45", got)
+			}
+			if aws.StringValue(sent.MessageBody) != message.Body || aws.StringValue(sent.MessageAttributes["tenant"].StringValue) != "acme" {
+				t.Errorf("message = %s %v, want a copy of the throttled message", aws.StringValue(sent.MessageBody), sent.MessageAttributes)
+			}
+		})
+	}
+}
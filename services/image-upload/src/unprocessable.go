@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/okebinda/internal/analytics"
+	"github.com/okebinda/internal/failures"
+	"github.com/okebinda/internal/imageproc"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/rejections"
+	"github.com/okebinda/internal/storage"
+)
+
+// rejectUnprocessable handles an upload whose file could not be decoded by any decoder: the object is moved under
+// the QUARANTINE_PREFIX env parameter (default "_quarantine") in the upload bucket, the rejection is recorded,
+// and a failure callback with the decode error class is posted to its callback_url; returns the error to report
+func (s *Service) rejectUnprocessable(requestData RequestPayload, file *os.File, bucket, fileKey, fileType string, numBytes int64, decodeErr error) *processError {
+	class := imageproc.DecodeErrorClass(decodeErr)
+	errorMessage := fmt.Sprintf("Image could not be decoded (%s): %v, %s", class, decodeErr, fileKey)
+	logger.Errorf(errorMessage)
+
+	recordRejection(s.Session, rejections.Rejection{
+		FileID:       requestData.FileID,
+		FileKey:      fileKey,
+		Rule:         "unprocessable_image",
+		Message:      errorMessage,
+		DetectedType: fileType,
+		MagicBytes:   magicBytes(file),
+		SizeBytes:    numBytes,
+		Limit:        fmt.Sprintf("class=%s", class),
+	})
+	recordEvent(s.Session, analytics.Event{
+		EventType: analytics.EventUploadRejected,
+		Bucket:    bucket,
+		FileKey:   fileKey,
+		FileType:  fileType,
+		SizeBytes: numBytes,
+		Reason:    "unprocessable_image",
+	})
+
+	// quarantine the object so it is not processed again
+	quarantineKey := fmt.Sprintf("%s/%s", quarantinePrefix(), fileKey)
+	if err := storage.CopyObject(s.S3, bucket, fileKey, bucket, quarantineKey, fileType); err != nil {
+		logger.Errorf("Failed to quarantine object: %v", err)
+	} else if err = storage.DeleteObject(s.S3, bucket, fileKey); err != nil {
+		logger.Errorf("Failed to delete quarantined object: %v", err)
+	} else {
+		logger.Infow("Object quarantined.",
+			"bucket", bucket,
+			"file_key", quarantineKey,
+		)
+	}
+
+	// report the failure to the requester
+	s.postCallback(requestData, "upload_failed", failures.Callback{
+		Event:         "upload_failed",
+		FileID:        requestData.FileID,
+		Directory:     requestData.Directory,
+		FileExtension: requestData.FileExtension,
+		Error: failures.CallbackError{
+			Code:     "unprocessable_image",
+			Class:    class,
+			Message:  fmt.Sprintf("Image could not be decoded: %v", decodeErr),
+			Attempts: 1,
+		},
+		FailedAt: s.Clock.Now().UTC().Format(time.RFC3339),
+		Sandbox:  requestData.Sandbox,
+		Context:  requestData.Context,
+	})
+
+	return &processError{422, problem.UnprocessableImage, errorMessage}
+}
+
+// quarantinePrefix returns the QUARANTINE_PREFIX env parameter, or "_quarantine" if it is not set
+func quarantinePrefix() string {
+	if prefix := strings.Trim(os.Getenv("QUARANTINE_PREFIX"), "/"); prefix != "" {
+		return prefix
+	}
+	return "_quarantine"
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/okebinda/internal/keys"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/storage"
+)
+
+// MetadataPayload defines the JSON schema for object metadata received from and returned to the request
+type MetadataPayload struct {
+	ACL                string `json:"acl,omitempty"`
+	CacheControl       string `json:"cache_control,omitempty"`
+	ContentDisposition string `json:"content_disposition,omitempty"`
+	ContentType        string `json:"content_type,omitempty"`
+}
+
+// validACLs defines the canned ACLs that may be applied to an object
+var validACLs []string = []string{
+	s3.ObjectCannedACLPrivate,
+	s3.ObjectCannedACLPublicRead,
+}
+
+// PatchMetadata replaces the metadata of an image in the static S3 bucket without reprocessing it
+func (s *Service) PatchMetadata(w http.ResponseWriter, r *http.Request) {
+
+	// check API key
+	ok := authentication(r)
+	if !ok {
+		userErrorResponse(w, 403, problem.PermissionDenied, "Permission denied.")
+		return
+	}
+
+	// get environment parameters
+	bucket := os.Getenv("AWS_S3_BUCKET_PUBLIC")
+	keyValidation, err := keys.ValidationFromEnv()
+	if err != nil {
+		logger.Errorf("Could not read KEY_VALIDATION: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+
+	// get path parameters (chi doesn't support greedy path parameters)
+	if !strings.HasSuffix(r.URL.Path, "/metadata") {
+		userErrorResponse(w, 404, problem.NotFound, "Not found.")
+		return
+	}
+	imageKey := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/image/"), "/metadata")
+
+	// get payload from request body
+	var requestData MetadataPayload
+	decoder := json.NewDecoder(r.Body)
+	if err = decoder.Decode(&requestData); err != nil {
+		logger.Errorf("Error unmarshalling request body: %v", err)
+		userErrorResponse(w, 400, problem.UnreadableRequestBody, "Could not read request body.")
+		return
+	}
+	defer r.Body.Close()
+
+	logger.Infow("Request parameters",
+		"imageKey", imageKey,
+		"acl", requestData.ACL,
+		"cache_control", requestData.CacheControl,
+		"content_disposition", requestData.ContentDisposition,
+		"content_type", requestData.ContentType,
+	)
+
+	// simple sanity check
+	if imageKey == "" {
+		errorMessage := fmt.Sprintf("Missing parameters, cannot complete request; image_key: %s", imageKey)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.MissingParameters, errorMessage)
+		return
+	}
+	if err = keys.Validate(imageKey, keyValidation); err != nil {
+		errorMessage := fmt.Sprintf("Bad parameter format, cannot complete request; image_key: %v", err)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterFormat, errorMessage)
+		return
+	}
+	if requestData.ACL != "" && !contains(validACLs, requestData.ACL) {
+		errorMessage := fmt.Sprintf("Unsupported ACL: %s", requestData.ACL)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.UnsupportedACL, errorMessage)
+		return
+	}
+	if requestData.ACL != "" && !storage.ACLsEnabled() {
+		errorMessage := "Bad parameter value, cannot complete request; acl: ACLs are disabled"
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterValue, errorMessage)
+		return
+	}
+
+	// read current metadata, a self-copy replaces all of it
+	header, err := storage.HeadObject(s.S3, bucket, imageKey)
+	if err != nil {
+		logger.Errorf("S3 head object error: %s", err)
+		if storage.IsNotFound(err) {
+			userErrorResponse(w, 404, problem.NotFound, "Not found.")
+			return
+		}
+		serverErrorResponse(w)
+		return
+	}
+
+	// merge requested metadata over current metadata (copies do not retain the ACL, so default to public)
+	metadata := storage.ObjectMetadata{
+		ACL:                storage.PublicACL(),
+		CacheControl:       aws.StringValue(header.CacheControl),
+		ContentDisposition: aws.StringValue(header.ContentDisposition),
+		ContentType:        aws.StringValue(header.ContentType),
+		Metadata:           header.Metadata,
+	}
+	if requestData.ACL != "" {
+		metadata.ACL = requestData.ACL
+	}
+	if requestData.CacheControl != "" {
+		metadata.CacheControl = requestData.CacheControl
+	}
+	if requestData.ContentDisposition != "" {
+		metadata.ContentDisposition = requestData.ContentDisposition
+	}
+	if requestData.ContentType != "" {
+		metadata.ContentType = requestData.ContentType
+	}
+
+	// replace metadata
+	err = storage.ReplaceMetadata(s.S3, bucket, imageKey, metadata)
+	if err != nil {
+		logger.Errorf("Failed to replace metadata: %s", err)
+		serverErrorResponse(w)
+		return
+	}
+
+	logger.Infow("Metadata replaced.",
+		"bucket", bucket,
+		"file_key", imageKey,
+	)
+
+	// response
+	successResponse(w, 200, &MetadataPayload{
+		ACL:                metadata.ACL,
+		CacheControl:       metadata.CacheControl,
+		ContentDisposition: metadata.ContentDisposition,
+		ContentType:        metadata.ContentType,
+	})
+}
@@ -1,78 +1,259 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
+	"strconv"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/google/uuid"
+	"github.com/okebinda/internal/imageproc"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/storage"
 )
 
-// extensionMap maps extensions to mime types
-var extensionMap map[string]string = map[string]string{
-	"png":  "png",
-	"jpg":  "jpeg",
-	"jpeg": "jpeg",
-}
+// GetUploadURL retrieves a pre-signed S3 bucket upload URL, and optionally a process token authorizing the
+// client to process the upload itself
+func (s *Service) GetUploadURL(w http.ResponseWriter, r *http.Request) {
 
-// GetUploadURL retrieves a pre-signed S3 bucket upload URL
-func GetUploadURL(w http.ResponseWriter, r *http.Request) {
+	// the response carries credentials, so it must never be cached, not even an error
+	w.Header().Set("Cache-Control", "no-store")
 
 	// check API key
 	ok := authentication(r)
 	if !ok {
-		userErrorResponse(w, 403, "Permission denied.")
+		userErrorResponse(w, 403, problem.PermissionDenied, "Permission denied.")
+		return
+	}
+
+	// get environment parameters
+	expiryMinutes, err := strconv.Atoi(os.Getenv("UPLOAD_URL_EXPIRY_MINUTES"))
+	if err != nil {
+		logger.Errorf("Could not convert UPLOAD_URL_EXPIRY_MINUTES to int: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+	maxExpiryMinutes, err := strconv.Atoi(os.Getenv("UPLOAD_URL_MAX_EXPIRY_MINUTES"))
+	if err != nil {
+		logger.Errorf("Could not convert UPLOAD_URL_MAX_EXPIRY_MINUTES to int: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+
+	conditions, err := uploadConditions()
+	if err != nil {
+		logger.Errorf("Could not read upload conditions: %v", err)
+		serverErrorResponse(w)
 		return
 	}
 
 	// get request parameters
 	directory := r.URL.Query().Get("directory")
 	extension := r.URL.Query().Get("extension")
+	expires := r.URL.Query().Get("expires")
+	options := r.URL.Query().Get("options")
+	withToken := r.URL.Query().Get("process_token")
+	sandbox := r.URL.Query().Get("sandbox")
+	encrypted := r.URL.Query().Get("encrypted")
+	cipher := r.URL.Query().Get("cipher")
+	keyID := r.URL.Query().Get("key_id")
 
 	logger.Infow("Request parameters",
 		"directory", directory,
 		"extension", extension,
+		"expires", expires,
+		"options", options,
+		"process_token", withToken,
+		"sandbox", sandbox,
+		"encrypted", encrypted,
+		"cipher", cipher,
+		"key_id", keyID,
 	)
 
-	// basic sanity test for extension
-	extensionType, ok := extensionMap[extension]
-	if !ok {
-		logger.Errorf("Unsupported extension: %s", extension)
-		userErrorResponse(w, 400, fmt.Sprintf("Unsupported extension: %s", extension))
+	// shed low-priority requests during incidents
+	if s.shedPresign(w, r, directory) {
 		return
 	}
 
+	// process tokens are signed with PROCESS_TOKEN_SECRET, so can only be issued if it is set
+	tokenSecret := os.Getenv("PROCESS_TOKEN_SECRET")
+	switch withToken {
+	case "", "false":
+		withToken = ""
+	case "true":
+		if tokenSecret == "" {
+			errorMessage := "Bad parameter value, cannot complete request; process_token: not enabled"
+			logger.Error(errorMessage)
+			userErrorResponse(w, 400, problem.BadParameterValue, errorMessage)
+			return
+		}
+	default:
+		errorMessage := fmt.Sprintf("Bad parameter value, cannot complete request; process_token: %s, must be true or false", withToken)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterValue, errorMessage)
+		return
+	}
+
+	// sandbox uploads are kept under the sandbox prefix, in the sandbox upload bucket
+	if sandbox != "" && sandbox != "true" && sandbox != "false" {
+		errorMessage := fmt.Sprintf("Bad parameter value, cannot complete request; sandbox: %s, must be true or false", sandbox)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterValue, errorMessage)
+		return
+	}
+	isSandbox := sandboxed(sandbox == "true", directory)
+	if isSandbox {
+		directory = sandboxDirectory(directory)
+	}
+	uploadBucket, perr := bucketFor("AWS_S3_BUCKET_UPLOAD", isSandbox)
+	if perr != nil {
+		logger.Error(perr.message)
+		userErrorResponse(w, perr.code, perr.problem, perr.message)
+		return
+	}
+
+	// uploads are sent to the quarantine bucket, if set, and only reach the upload bucket once they are screened
+	if screened(isSandbox) {
+		uploadBucket = quarantineBucket(isSandbox)
+	}
+
+	// requested expiry, in minutes, bounded by the maximum
+	if expires != "" {
+		expiryMinutes, err = strconv.Atoi(expires)
+		if err != nil || expiryMinutes < 1 || expiryMinutes > maxExpiryMinutes {
+			errorMessage := fmt.Sprintf("Bad parameter value, cannot complete request; expires: %s, must be 1-%d minutes", expires, maxExpiryMinutes)
+			logger.Error(errorMessage)
+			userErrorResponse(w, 400, problem.BadParameterValue, errorMessage)
+			return
+		}
+	}
+
+	// client-side encrypted uploads are stored as ciphertext, with the cipher and key ID the client names, and are
+	// never processed as images
+	var fileType string
+	switch encrypted {
+	case "", "false":
+
+		// basic sanity test for extension
+		fileType, ok = imageproc.FileType(extension)
+		if !ok {
+			logger.Errorf("Unsupported extension: %s", extension)
+			userErrorResponse(w, 400, problem.UnsupportedExtension, fmt.Sprintf("Unsupported extension: %s", extension))
+			return
+		}
+	case "true":
+		if perr := checkEncryption(extension, cipher, keyID, options); perr != nil {
+			logger.Error(perr.message)
+			userErrorResponse(w, perr.code, perr.problem, perr.message)
+			return
+		}
+		extension = storage.EncryptedExtension
+		fileType = storage.EncryptedContentType
+	default:
+		errorMessage := fmt.Sprintf("Bad parameter value, cannot complete request; encrypted: %s, must be true or false", encrypted)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterValue, errorMessage)
+		return
+	}
+
+	// processing options for S3 event-driven processing, stored with the object
+	conditions.ContentType = fileType
+	conditions.Metadata = map[string]string{}
+	if options != "" {
+		var optionsData RequestPayload
+		if err = json.Unmarshal([]byte(options), &optionsData); err != nil || len(options) > maxOptionsBytes {
+			errorMessage := fmt.Sprintf("Bad parameter format, cannot complete request; options: must be a JSON object of at most %d bytes", maxOptionsBytes)
+			logger.Error(errorMessage)
+			userErrorResponse(w, 400, problem.BadParameterFormat, errorMessage)
+			return
+		}
+		conditions.Metadata[optionsMetadataKey] = options
+	}
+	if encrypted == "true" {
+		conditions.Metadata[storage.MetadataEncryption] = cipher
+		if keyID != "" {
+			conditions.Metadata[storage.MetadataEncryptionKeyID] = keyID
+		}
+	}
+
 	// generate S3 file key
-	fileKey := generateFileKey(extension, directory)
+	fileKey := s.generateFileKey(extension, directory)
 
 	// generate a presigned upload URL
-	signedURL, err := generatePresignedURL(os.Getenv("AWS_S3_BUCKET_UPLOAD"), fileKey, extensionType, 15)
+	expiresAt := s.Clock.Now().UTC().Add(time.Duration(expiryMinutes) * time.Minute)
+	signedURL, headers, err := s.generatePresignedURL(uploadBucket, fileKey, conditions, time.Duration(expiryMinutes))
 	if err != nil {
 		logger.Errorf("Failed to sign request: %s", err)
 		serverErrorResponse(w)
 		return
 	}
 
+	// record the upload URL, so the upload it makes may be processed
+	if err = recordPresign(s.Session, r, fileKey, expiresAt); err != nil {
+		logger.Errorf("Failed to record presign: %s", err)
+		serverErrorResponse(w)
+		return
+	}
+
 	logger.Infow("Response parameters",
 		"upload_url", signedURL,
 		"file_key", fileKey,
+		"expires_at", expiresAt,
 	)
 
-	// response
-	successResponse(w, 200, map[string]interface{}{
+	responseData := map[string]interface{}{
 		"upload_url": signedURL,
 		"file_key":   fileKey,
-	})
+		"expires_at": expiresAt.Format(time.RFC3339),
+		"headers":    headers,
+	}
+	if isSandbox {
+		responseData["sandbox"] = true
+	}
+	if encrypted == "true" {
+		responseData["encrypted"] = true
+	}
+
+	// a process token lets the client process the upload once it is sent, expiring with the upload URL
+	if withToken != "" {
+		responseData["process_token"] = processToken(tokenSecret, fileKey, expiresAt)
+	}
+
+	// response
+	successResponse(w, 200, responseData)
+}
+
+// reEncryptionParameter matches the cipher and key ID of encrypted uploads, stored as object metadata
+var reEncryptionParameter = regexp.MustCompile(`^[A-Za-z0-9._:/+=-]{1,256}$`)
+
+// checkEncryption checks the parameters of an encrypted upload URL request: the extension, if set, must be "enc",
+// the cipher is required, and processing options are not allowed, as the upload is never processed
+func checkEncryption(extension, cipher, keyID, options string) *processError {
+	if extension != "" && extension != storage.EncryptedExtension {
+		return &processError{400, problem.BadParameterValue, fmt.Sprintf("Bad parameter value, cannot complete request; extension: %s, must be %s for encrypted uploads", extension, storage.EncryptedExtension)}
+	}
+	if cipher == "" {
+		return &processError{400, problem.MissingParameters, "Missing parameters, cannot complete request; cipher: required for encrypted uploads"}
+	}
+	if !reEncryptionParameter.MatchString(cipher) {
+		return &processError{400, problem.BadParameterFormat, fmt.Sprintf("Bad parameter format, cannot complete request; cipher: %s", cipher)}
+	}
+	if keyID != "" && !reEncryptionParameter.MatchString(keyID) {
+		return &processError{400, problem.BadParameterFormat, fmt.Sprintf("Bad parameter format, cannot complete request; key_id: %s", keyID)}
+	}
+	if options != "" {
+		return &processError{400, problem.BadParameterValue, "Bad parameter value, cannot complete request; options: not allowed for encrypted uploads"}
+	}
+	return nil
 }
 
 // generateFileKey generates a file key for storage in an S3 bucket
-func generateFileKey(extension, directory string) string {
+func (s *Service) generateFileKey(extension, directory string) string {
 	var fileKey string
-	fileID := uuid.New()
+	fileID := s.IDs.New()
 	if directory == "" {
 		fileKey = fmt.Sprintf("%s.%s", fileID, extension)
 	} else {
@@ -81,18 +262,30 @@ func generateFileKey(extension, directory string) string {
 	return fileKey
 }
 
-// generatePresignedURL generates a presigned upload URL for S3 bucket
-func generatePresignedURL(bucket, fileKey, extensionType string, expires time.Duration) (string, error) {
-
-	// connect to AWS and create an S3 client
-	sess := session.Must(session.NewSession())
-	svc := s3.New(sess)
+// generatePresignedURL generates a presigned upload URL for S3 bucket, and the headers the upload must send
+func (s *Service) generatePresignedURL(bucket, fileKey string, conditions storage.PutConditions, expires time.Duration) (string, map[string]string, error) {
+	return storage.PresignPut(s.S3, bucket, fileKey, conditions, expires*time.Minute)
+}
 
-	// generate a presigned upload URL
-	req, _ := svc.PutObjectRequest(&s3.PutObjectInput{
-		Bucket:      aws.String(bucket),
-		Key:         aws.String(fileKey),
-		ContentType: aws.String(fmt.Sprintf("image/%s", extensionType)),
-	})
-	return req.Presign(expires * time.Minute)
+// uploadConditions reads the tags and server-side encryption every upload must be sent with from the UPLOAD_TAGS
+// (URL query encoded, e.g. "source=upload&team=web"), UPLOAD_SSE ("AES256" or "aws:kms"), and
+// UPLOAD_SSE_KMS_KEY_ID env parameters
+func uploadConditions() (storage.PutConditions, error) {
+	conditions := storage.PutConditions{
+		Tagging:              os.Getenv("UPLOAD_TAGS"),
+		ServerSideEncryption: os.Getenv("UPLOAD_SSE"),
+		SSEKMSKeyID:          os.Getenv("UPLOAD_SSE_KMS_KEY_ID"),
+	}
+	if _, err := url.ParseQuery(conditions.Tagging); err != nil {
+		return conditions, fmt.Errorf("UPLOAD_TAGS: %v", err)
+	}
+	switch conditions.ServerSideEncryption {
+	case "", "AES256", "aws:kms":
+	default:
+		return conditions, fmt.Errorf("UPLOAD_SSE: %s, must be AES256 or aws:kms", conditions.ServerSideEncryption)
+	}
+	if conditions.SSEKMSKeyID != "" && conditions.ServerSideEncryption != "aws:kms" {
+		return conditions, fmt.Errorf("UPLOAD_SSE_KMS_KEY_ID requires UPLOAD_SSE=aws:kms")
+	}
+	return conditions, nil
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/gif"
+	"os"
+	"regexp"
+
+	"github.com/okebinda/internal/imageproc"
+	"github.com/okebinda/internal/keys"
+	"github.com/okebinda/internal/problem"
+)
+
+// SizePayload defines the JSON schema for an image variant requested in the payload
+type SizePayload struct {
+	Name   string `json:"name"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Crop   bool   `json:"crop"`
+}
+
+// VariantPayload defines the JSON schema for a generated image variant returned in the payload
+type VariantPayload struct {
+	Name      string `json:"name"`
+	FileKey   string `json:"file_key"`
+	Height    int    `json:"height"`
+	SizeBytes int64  `json:"size_bytes"`
+	Width     int    `json:"width"`
+}
+
+// maxVariants is the maximum number of variants that can be requested for an image
+const maxVariants = 10
+
+// reSizeName matches valid variant names, which are used as a directory in the variant's file key
+var reSizeName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// validateSizes checks requested variants for valid names and dimensions; a width or height of 0 leaves that axis
+// unconstrained, but cropped variants need both
+func validateSizes(sizes []SizePayload, maxWidth, maxHeight int) *processError {
+	if len(sizes) > maxVariants {
+		return &processError{400, problem.TooManySizes, fmt.Sprintf("Too many sizes: %d, maximum: %d", len(sizes), maxVariants)}
+	}
+	names := map[string]bool{}
+	for _, size := range sizes {
+		if !reSizeName.MatchString(size.Name) {
+			return &processError{400, problem.BadSizeName, fmt.Sprintf("Bad size name format: %s", size.Name)}
+		}
+		if names[size.Name] {
+			return &processError{400, problem.DuplicateSizeName, fmt.Sprintf("Duplicate size name: %s", size.Name)}
+		}
+		names[size.Name] = true
+		if size.Width < 0 || size.Height < 0 || size.Width > maxWidth || size.Height > maxHeight ||
+			(size.Width == 0 && size.Height == 0) || (size.Crop && (size.Width == 0 || size.Height == 0)) {
+			return &processError{400, problem.BadSizeDimensions, fmt.Sprintf("Bad size dimensions: %s, %dx%d", size.Name, size.Width, size.Height)}
+		}
+	}
+	return nil
+}
+
+// generateVariants resizes an image to each requested size and stages the variants for publication; if anim is
+// set, every frame of the GIF is resized to preserve its animation
+func generateVariants(pub *publication, img image.Image, anim *gif.GIF, requestData RequestPayload, keyTemplate *keys.Template, fileType string) ([]VariantPayload, error) {
+	var variants []VariantPayload
+	for _, size := range requestData.Sizes {
+
+		// resize image, never enlarging it unless cropping
+		resize := func(img image.Image) image.Image {
+			if size.Crop {
+				return imageproc.ResizeCrop(img, size.Width, size.Height)
+			}
+			img, _ = imageproc.ResizeToFit(img, size.Width, size.Height)
+			return img
+		}
+
+		// save to local temp file
+		localFile := fmt.Sprintf("/tmp/%s-%s.%s", size.Name, requestData.FileID, requestData.FileExtension)
+		var width, height int
+		var still image.Image
+		if anim != nil {
+			variantAnim := imageproc.TransformGIF(anim, resize)
+			still = variantAnim.Image[0]
+			width, height = imageproc.Dimensions(still)
+			if err := imageproc.SaveGIF(variantAnim, localFile); err != nil {
+				return variants, err
+			}
+		} else {
+			still = resize(img)
+			width, height = imageproc.Dimensions(still)
+			if err := imageproc.Save(still, localFile); err != nil {
+				return variants, err
+			}
+		}
+		file, err := os.Open(localFile)
+		if err != nil {
+			return variants, err
+		}
+
+		// stage in bucket
+		fileKey, err := keyTemplate.Render(map[string]string{
+			"directory": requestData.Directory,
+			"size_name": size.Name,
+			"file_id":   requestData.FileID,
+			"ext":       requestData.FileExtension,
+		})
+		if err != nil {
+			close(file)
+			return variants, err
+		}
+		pub.placeholder(fileKey, still, width, height)
+		if err = pub.stage(file, fileKey, fileType); err != nil {
+			close(file)
+			return variants, err
+		}
+		fileInfo, err := file.Stat()
+		close(file)
+		if err != nil {
+			return variants, err
+		}
+
+		logger.Infow("Image variant staging complete.",
+			"bucket", pub.bucket,
+			"file_key", fileKey,
+			"width", width,
+			"height", height,
+		)
+
+		variants = append(variants, VariantPayload{
+			Name:      size.Name,
+			FileKey:   fileKey,
+			Height:    height,
+			SizeBytes: fileInfo.Size(),
+			Width:     width,
+		})
+	}
+	return variants, nil
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/webhooks"
+)
+
+// WebhookRequest defines the JSON schema for the payload received from a webhook subscription request; a PATCH
+// replaces only the fields it sets
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+// RotateRequest defines the JSON schema for the payload received from a secret rotation request; both fields
+// are optional
+type RotateRequest struct {
+	Secret        string `json:"secret"`
+	WindowMinutes *int   `json:"window_minutes"`
+}
+
+// WebhookPayload defines the JSON schema for a webhook subscription returned to the request; the secret is only
+// returned when it is set, by creating the subscription or rotating its secret
+type WebhookPayload struct {
+	webhooks.Subscription
+	Secret string `json:"secret,omitempty"`
+}
+
+// WebhooksPayload defines the JSON schema for the list of webhook subscriptions returned to the request
+type WebhooksPayload struct {
+	Webhooks []webhooks.Subscription `json:"webhooks"`
+}
+
+// webhook limits
+const (
+	minWebhookSecretLength       = 16
+	maxRotationWindowMinutes     = 7 * 24 * 60
+	defaultRotationWindowMinutes = 24 * 60
+)
+
+// webhookEvents are the events webhooks can subscribe to
+var webhookEvents = []string{"upload_processed", "upload_failed", "import_completed", webhooks.AllEvents}
+
+// GetWebhooks returns every webhook subscription, without their secrets
+func (s *Service) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+
+	// check API key
+	ok := authentication(r)
+	if !ok {
+		userErrorResponse(w, 403, problem.PermissionDenied, "Permission denied.")
+		return
+	}
+
+	// get environment parameters
+	table, ok := webhooksTable(w)
+	if !ok {
+		return
+	}
+
+	// read subscriptions
+	subscriptions, err := webhooks.List(s.Session, table)
+	if err != nil {
+		logger.Errorf("Failed to read webhook subscriptions: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+
+	// response
+	successResponse(w, 200, WebhooksPayload{Webhooks: subscriptions})
+}
+
+// PostWebhook creates a webhook subscription, posting the events it subscribes to to its URL, signed with its
+// secret instead of the CALLBACK_SECRET env parameter; the secret is generated if the request does not set one,
+// and only returned in this response
+func (s *Service) PostWebhook(w http.ResponseWriter, r *http.Request) {
+
+	// check API key
+	ok := authentication(r)
+	if !ok {
+		userErrorResponse(w, 403, problem.PermissionDenied, "Permission denied.")
+		return
+	}
+
+	// get environment parameters
+	table, ok := webhooksTable(w)
+	if !ok {
+		return
+	}
+
+	// get payload from request body
+	var requestData WebhookRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&requestData); err != nil {
+		logger.Errorf("Error unmarshalling request body: %v", err)
+		userErrorResponse(w, 400, problem.BadRequestBody, "Bad request body, must be a JSON object.")
+		return
+	}
+	defer r.Body.Close()
+
+	logger.Infow("Request data",
+		"url", requestData.URL,
+		"events", requestData.Events,
+	)
+
+	// check parameters
+	if requestData.URL == "" || len(requestData.Events) == 0 {
+		errorMessage := fmt.Sprintf("Missing parameters, cannot complete request; url: %s, events: %v", requestData.URL, requestData.Events)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.MissingParameters, errorMessage)
+		return
+	}
+	if perr := validateWebhook(requestData); perr != nil {
+		logger.Error(perr.message)
+		userErrorResponse(w, perr.code, perr.problem, perr.message)
+		return
+	}
+
+	// each URL has one subscription, whose secret signs every callback posted to it
+	sess := s.Session
+	existing, found, err := webhooks.GetByURL(sess, table, requestData.URL)
+	if err != nil {
+		logger.Errorf("Failed to read webhook subscriptions: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+	if found {
+		errorMessage := fmt.Sprintf("Webhook already exists, cannot complete request; url: %s, subscription_id: %s", requestData.URL, existing.SubscriptionID)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 409, problem.Conflict, errorMessage)
+		return
+	}
+
+	// create subscription
+	secret := requestData.Secret
+	if secret == "" {
+		if secret, err = webhooks.NewSecret(); err != nil {
+			logger.Errorf("Failed to generate webhook secret: %v", err)
+			serverErrorResponse(w)
+			return
+		}
+	}
+	now := s.Clock.Now().UTC().Format(time.RFC3339)
+	subscription := webhooks.Subscription{
+		SubscriptionID: s.IDs.New().String(),
+		URL:            requestData.URL,
+		Events:         requestData.Events,
+		Secret:         secret,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if err = webhooks.Put(sess, table, subscription); err != nil {
+		logger.Errorf("Failed to record webhook subscription: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+
+	logger.Infow("Webhook created.",
+		"subscription_id", subscription.SubscriptionID,
+		"url", subscription.URL,
+	)
+
+	// response
+	successResponse(w, 201, WebhookPayload{Subscription: subscription, Secret: secret})
+}
+
+// GetWebhook returns a webhook subscription, without its secret
+func (s *Service) GetWebhook(w http.ResponseWriter, r *http.Request) {
+
+	// check API key
+	ok := authentication(r)
+	if !ok {
+		userErrorResponse(w, 403, problem.PermissionDenied, "Permission denied.")
+		return
+	}
+
+	// get environment parameters
+	table, ok := webhooksTable(w)
+	if !ok {
+		return
+	}
+
+	// read subscription
+	subscription, ok := s.webhookSubscription(w, r, table)
+	if !ok {
+		return
+	}
+
+	// response
+	successResponse(w, 200, WebhookPayload{Subscription: subscription})
+}
+
+// PatchWebhook replaces the URL or events of a webhook subscription; secrets are replaced by rotating them
+func (s *Service) PatchWebhook(w http.ResponseWriter, r *http.Request) {
+
+	// check API key
+	ok := authentication(r)
+	if !ok {
+		userErrorResponse(w, 403, problem.PermissionDenied, "Permission denied.")
+		return
+	}
+
+	// get environment parameters
+	table, ok := webhooksTable(w)
+	if !ok {
+		return
+	}
+
+	// get payload from request body
+	var requestData WebhookRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&requestData); err != nil {
+		logger.Errorf("Error unmarshalling request body: %v", err)
+		userErrorResponse(w, 400, problem.BadRequestBody, "Bad request body, must be a JSON object.")
+		return
+	}
+	defer r.Body.Close()
+
+	logger.Infow("Request data",
+		"url", requestData.URL,
+		"events", requestData.Events,
+	)
+
+	// check parameters
+	if requestData.Secret != "" {
+		errorMessage := "Bad parameter value, cannot complete request; secret: rotate the secret instead"
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterValue, errorMessage)
+		return
+	}
+	if perr := validateWebhook(requestData); perr != nil {
+		logger.Error(perr.message)
+		userErrorResponse(w, perr.code, perr.problem, perr.message)
+		return
+	}
+
+	// read subscription
+	subscription, ok := s.webhookSubscription(w, r, table)
+	if !ok {
+		return
+	}
+
+	// update subscription, keeping one subscription per URL
+	sess := s.Session
+	if requestData.URL != "" && requestData.URL != subscription.URL {
+		existing, found, err := webhooks.GetByURL(sess, table, requestData.URL)
+		if err != nil {
+			logger.Errorf("Failed to read webhook subscriptions: %v", err)
+			serverErrorResponse(w)
+			return
+		}
+		if found {
+			errorMessage := fmt.Sprintf("Webhook already exists, cannot complete request; url: %s, subscription_id: %s", requestData.URL, existing.SubscriptionID)
+			logger.Error(errorMessage)
+			userErrorResponse(w, 409, problem.Conflict, errorMessage)
+			return
+		}
+		subscription.URL = requestData.URL
+	}
+	if len(requestData.Events) > 0 {
+		subscription.Events = requestData.Events
+	}
+	subscription.UpdatedAt = s.Clock.Now().UTC().Format(time.RFC3339)
+	if err := webhooks.Put(sess, table, subscription); err != nil {
+		logger.Errorf("Failed to record webhook subscription: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+
+	logger.Infow("Webhook updated.",
+		"subscription_id", subscription.SubscriptionID,
+		"url", subscription.URL,
+	)
+
+	// response
+	successResponse(w, 200, WebhookPayload{Subscription: subscription})
+}
+
+// DeleteWebhook deletes a webhook subscription
+func (s *Service) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+
+	// check API key
+	ok := authentication(r)
+	if !ok {
+		userErrorResponse(w, 403, problem.PermissionDenied, "Permission denied.")
+		return
+	}
+
+	// get environment parameters
+	table, ok := webhooksTable(w)
+	if !ok {
+		return
+	}
+
+	// get path parameters
+	subscriptionID := chi.URLParam(r, "subscription_id")
+
+	logger.Infow("Request parameters",
+		"subscription_id", subscriptionID,
+	)
+
+	// delete subscription
+	found, err := webhooks.Delete(s.Session, table, subscriptionID)
+	if err != nil {
+		logger.Errorf("Failed to delete webhook subscription: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+	if !found {
+		userErrorResponse(w, 404, problem.NotFound, "Not found.")
+		return
+	}
+
+	logger.Infow("Webhook deleted.",
+		"subscription_id", subscriptionID,
+	)
+
+	// response
+	successResponse(w, 204, nil)
+}
+
+// PostWebhookRotate replaces the secret of a webhook subscription with the request's secret, or a generated one;
+// callbacks are signed with both secrets, the previous in the X-Signature-Previous header, for window_minutes, by
+// default the WEBHOOK_ROTATION_WINDOW_MINUTES env parameter (default 1440), so receivers can switch secrets
+// without rejecting any callbacks; a window of 0 revokes the previous secret at once
+func (s *Service) PostWebhookRotate(w http.ResponseWriter, r *http.Request) {
+
+	// check API key
+	ok := authentication(r)
+	if !ok {
+		userErrorResponse(w, 403, problem.PermissionDenied, "Permission denied.")
+		return
+	}
+
+	// get environment parameters
+	table, ok := webhooksTable(w)
+	if !ok {
+		return
+	}
+	windowMinutes := defaultRotationWindowMinutes
+	if value := os.Getenv("WEBHOOK_ROTATION_WINDOW_MINUTES"); value != "" {
+		var err error
+		windowMinutes, err = strconv.Atoi(value)
+		if err != nil || windowMinutes < 0 || windowMinutes > maxRotationWindowMinutes {
+			logger.Errorf("Could not convert WEBHOOK_ROTATION_WINDOW_MINUTES to 0-%d: %s", maxRotationWindowMinutes, value)
+			serverErrorResponse(w)
+			return
+		}
+	}
+
+	// get payload from request body, which may be empty
+	var requestData RotateRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&requestData); err != nil && err != io.EOF {
+		logger.Errorf("Error unmarshalling request body: %v", err)
+		userErrorResponse(w, 400, problem.BadRequestBody, "Bad request body, must be a JSON object.")
+		return
+	}
+	defer r.Body.Close()
+
+	// check parameters
+	if requestData.WindowMinutes != nil {
+		windowMinutes = *requestData.WindowMinutes
+		if windowMinutes < 0 || windowMinutes > maxRotationWindowMinutes {
+			errorMessage := fmt.Sprintf("Bad parameter value, cannot complete request; window_minutes: %d, must be 0-%d", windowMinutes, maxRotationWindowMinutes)
+			logger.Error(errorMessage)
+			userErrorResponse(w, 400, problem.BadParameterValue, errorMessage)
+			return
+		}
+	}
+	if requestData.Secret != "" && len(requestData.Secret) < minWebhookSecretLength {
+		errorMessage := fmt.Sprintf("Bad parameter value, cannot complete request; secret: must be at least %d characters", minWebhookSecretLength)
+		logger.Error(errorMessage)
+		userErrorResponse(w, 400, problem.BadParameterValue, errorMessage)
+		return
+	}
+
+	// read subscription
+	subscription, ok := s.webhookSubscription(w, r, table)
+	if !ok {
+		return
+	}
+
+	// rotate secret
+	secret := requestData.Secret
+	if secret == "" {
+		var err error
+		if secret, err = webhooks.NewSecret(); err != nil {
+			logger.Errorf("Failed to generate webhook secret: %v", err)
+			serverErrorResponse(w)
+			return
+		}
+	}
+	subscription.Rotate(secret, s.Clock.Now(), time.Duration(windowMinutes)*time.Minute)
+	if err := webhooks.Put(s.Session, table, subscription); err != nil {
+		logger.Errorf("Failed to record webhook subscription: %v", err)
+		serverErrorResponse(w)
+		return
+	}
+
+	logger.Infow("Webhook secret rotated.",
+		"subscription_id", subscription.SubscriptionID,
+		"previous_secret_expires_at", subscription.PreviousSecretExpiresAt,
+	)
+
+	// response
+	successResponse(w, 200, WebhookPayload{Subscription: subscription, Secret: secret})
+}
+
+// webhooksTable returns the WEBHOOKS_TABLE env parameter, or writes a server error if it is not set
+func webhooksTable(w http.ResponseWriter) (string, bool) {
+	table := os.Getenv("WEBHOOKS_TABLE")
+	if table == "" {
+		logger.Error("WEBHOOKS_TABLE is not set")
+		serverErrorResponse(w)
+		return "", false
+	}
+	return table, true
+}
+
+// webhookSubscription reads the subscription named by the subscription_id path parameter, or writes a 404 if
+// there is none
+func (s *Service) webhookSubscription(w http.ResponseWriter, r *http.Request, table string) (webhooks.Subscription, bool) {
+	subscriptionID := chi.URLParam(r, "subscription_id")
+
+	logger.Infow("Request parameters",
+		"subscription_id", subscriptionID,
+	)
+
+	subscription, found, err := webhooks.Get(s.Session, table, subscriptionID)
+	if err != nil {
+		logger.Errorf("Failed to read webhook subscription: %v", err)
+		serverErrorResponse(w)
+		return subscription, false
+	}
+	if !found {
+		userErrorResponse(w, 404, problem.NotFound, "Not found.")
+		return subscription, false
+	}
+	return subscription, true
+}
+
+// validateWebhook checks the fields a webhook subscription request sets, returning an error if any are invalid:
+// the URL must be absolute HTTP(S), the events known, and the secret long enough
+func validateWebhook(requestData WebhookRequest) *processError {
+	if requestData.URL != "" {
+		u, err := url.Parse(requestData.URL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return &processError{400, problem.BadParameterFormat, fmt.Sprintf("Bad parameter format, cannot complete request; url: %s, must be an absolute HTTP(S) URL", requestData.URL)}
+		}
+	}
+	for _, event := range requestData.Events {
+		if !contains(webhookEvents, event) {
+			return &processError{400, problem.BadParameterValue, fmt.Sprintf("Bad parameter value, cannot complete request; events: %s, must be one of %s", event, strings.Join(webhookEvents, ", "))}
+		}
+	}
+	if requestData.Secret != "" && len(requestData.Secret) < minWebhookSecretLength {
+		return &processError{400, problem.BadParameterValue, fmt.Sprintf("Bad parameter value, cannot complete request; secret: must be at least %d characters", minWebhookSecretLength)}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/google/uuid"
+	"github.com/okebinda/internal/awsconfig"
+	"github.com/okebinda/internal/callbacks"
+	"github.com/okebinda/internal/failures"
+	"github.com/okebinda/internal/lifecycle"
+	"github.com/okebinda/internal/logging"
+	"github.com/okebinda/internal/notify"
+	"github.com/okebinda/internal/tracing"
+	"github.com/okebinda/internal/webhooks"
+	"go.uber.org/zap"
+)
+
+var logger *zap.SugaredLogger
+var requestID string
+
+// UploadMessage defines the fields of a process-upload message needed to report its failure
+type UploadMessage struct {
+	CallbackHeaders     map[string]string `json:"callback_headers"`
+	CallbackSNSTopicARN string            `json:"callback_sns_topic_arn"`
+	CallbackURL         string            `json:"callback_url"`
+	Context             json.RawMessage   `json:"context"`
+	Directory           string            `json:"directory"`
+	FileExtension       string            `json:"file_extension"`
+	FileID              string            `json:"file_id"`
+	Sandbox             bool              `json:"sandbox"`
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call, with upload messages that were
+// moved to the dead-letter queue after failing every retry; each is recorded as failed and reported to its
+// callback_url; messages whose failure could not be recorded or reported are retried
+func Handler(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+
+	// initialize logger
+	lc, _ := lambdacontext.FromContext(ctx)
+	requestID = lc.AwsRequestID
+	logger = logging.New(requestID)
+	defer logger.Sync()
+
+	// get environment parameters
+	table := os.Getenv("UPLOAD_FAILURES_TABLE")
+	retry, err := notify.RetryFromEnv()
+	if err != nil {
+		logger.Errorf("Could not read callback retry limits: %v", err)
+		return events.SQSEventResponse{}, err
+	}
+
+	// initialize AWS session
+	sess := awsconfig.NewContextSession(ctx)
+
+	var response events.SQSEventResponse
+	for _, message := range event.Records {
+
+		// continue the trace of the upload's request
+		msgCtx, segment := tracing.Continue(ctx, message.Attributes[tracing.SQSAttribute], "upload-dlq")
+		msgSess := sess
+		if segment != nil {
+			msgSess = awsconfig.WithContext(sess, msgCtx, 0)
+		}
+		err := reportFailure(msgCtx, msgSess, table, retry, message)
+		segment.End(err)
+		if err != nil {
+			logger.Errorf("Failed to report failure, will be retried: %s, %v", message.MessageId, err)
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{
+				ItemIdentifier: message.MessageId,
+			})
+		}
+	}
+	return response, nil
+}
+
+// reportFailure records a dead-lettered message as failed, emitting an ImageProcessFailed event and alerting the
+// ALERT_WEBHOOK_URL env parameter the first time, then posts a failures.Callback to its callback_url, signed with
+// the CALLBACK_SECRET env parameter, and publishes it to its callback_sns_topic_arn, recording each to be
+// replayed; callbacks that could not be delivered are returned as errors, re-queueing the message, unless the
+// callback URL rejected them
+func reportFailure(ctx context.Context, sess *session.Session, table string, retry notify.Retry, message events.SQSMessage) error {
+
+	// get payload from message body; messages that cannot be parsed are still recorded
+	var upload UploadMessage
+	if err := json.Unmarshal([]byte(message.Body), &upload); err != nil {
+		logger.Errorf("Error unmarshalling message body: %s, %v", message.MessageId, err)
+	}
+
+	// read the errors recorded while the message was retried
+	failure, _, err := failures.Get(sess, table, message.MessageId)
+	if err != nil {
+		return err
+	}
+	alreadyFailed := failure.Status == failures.StatusFailed
+	if failure.LastError == "" {
+		failure.LastError = "Processing failed."
+	}
+	if failure.FailedAt == "" {
+		failure.FailedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	failure.MessageID = message.MessageId
+	failure.FileID = upload.FileID
+	failure.Directory = upload.Directory
+	failure.FileExtension = upload.FileExtension
+	failure.Body = message.Body
+	failure.Status = failures.StatusFailed
+
+	logger.Infow("Upload failed.",
+		"message_id", message.MessageId,
+		"file_id", upload.FileID,
+		"attempts", failure.Attempts,
+		"last_error", failure.LastError,
+	)
+
+	callback := failures.Callback{
+		Event:         "upload_failed",
+		FileID:        upload.FileID,
+		Directory:     upload.Directory,
+		FileExtension: upload.FileExtension,
+		Error: failures.CallbackError{
+			Code:     "processing_failed",
+			Message:  failure.LastError,
+			Attempts: failure.Attempts,
+		},
+		FailedAt: failure.FailedAt,
+		Sandbox:  upload.Sandbox,
+		Context:  upload.Context,
+	}
+	body, err := json.Marshal(callback)
+	if err != nil {
+		return err
+	}
+
+	// post to the callback URL, unless it was delivered by a previous attempt
+	var callbackErr error
+	if upload.CallbackURL != "" && failure.CallbackStatus != "delivered" {
+		record := callbacks.Callback{
+			CallbackID: uuid.New().String(),
+			Event:      callback.Event,
+			FileID:     upload.FileID,
+			Directory:  upload.Directory,
+			URL:        upload.CallbackURL,
+			Headers:    upload.CallbackHeaders,
+			Payload:    string(body),
+		}
+		headers := map[string]string{}
+		for name, value := range upload.CallbackHeaders {
+			headers[name] = value
+		}
+		headers["X-Callback-ID"] = record.CallbackID
+		secrets, err := webhooks.SecretsForURL(sess, os.Getenv("WEBHOOKS_TABLE"), upload.CallbackURL, os.Getenv("CALLBACK_SECRET"), time.Now())
+		if err != nil {
+			return err
+		}
+		callbackErr = notify.Callback(ctx, upload.CallbackURL, secrets, headers, json.RawMessage(body), retry)
+		failure.CallbackStatus = "delivered"
+		if callbackErr != nil {
+			failure.CallbackStatus = fmt.Sprintf("failed: %v", callbackErr)
+			if !notify.Retryable(callbackErr) {
+				logger.Errorf("Callback rejected, will not be retried: %s, %v", message.MessageId, callbackErr)
+				callbackErr = nil
+			}
+		}
+		record.Status = failure.CallbackStatus
+		recordCallback(sess, record)
+	}
+
+	// publish to the callback topic, unless it was published by a previous attempt
+	if upload.CallbackSNSTopicARN != "" && failure.TopicStatus != "delivered" {
+		record := callbacks.Callback{
+			CallbackID: uuid.New().String(),
+			Event:      callback.Event,
+			FileID:     upload.FileID,
+			Directory:  upload.Directory,
+			TopicARN:   upload.CallbackSNSTopicARN,
+			Payload:    string(body),
+		}
+		failure.TopicStatus = "delivered"
+		if err = callbacks.Publish(ctx, sess, record, false); err != nil {
+			failure.TopicStatus = fmt.Sprintf("failed: %v", err)
+			callbackErr = err
+		}
+		record.Status = failure.TopicStatus
+		recordCallback(sess, record)
+	}
+
+	if err = failures.Put(sess, table, failure); err != nil {
+		return err
+	}
+
+	// emit the failure once per message
+	if !alreadyFailed {
+		err = lifecycle.Emit(sess, lifecycle.ImageProcessFailed, lifecycle.Detail{
+			Service:       "upload-dlq",
+			RequestID:     requestID,
+			FileID:        upload.FileID,
+			Directory:     upload.Directory,
+			FileExtension: upload.FileExtension,
+			Error: &lifecycle.Error{
+				Code:    "processing_failed",
+				Message: failure.LastError,
+			},
+			Context: upload.Context,
+		})
+		if err != nil {
+			logger.Errorf("Failed to emit lifecycle event: %v", err)
+		}
+	}
+
+	// alert the team once per message
+	if webhookURL := os.Getenv("ALERT_WEBHOOK_URL"); webhookURL != "" && !alreadyFailed {
+		alert := fmt.Sprintf("Upload processing failed permanently\nFile: %s/%s.%s\nAttempts: %d\nError: %s\nMessage: %s",
+			upload.Directory, upload.FileID, upload.FileExtension, failure.Attempts, failure.LastError, message.MessageId)
+		if err = notify.Webhook(ctx, webhookURL, alert); err != nil {
+			logger.Errorf("Failed to post alert: %v", err)
+		}
+	}
+	return callbackErr
+}
+
+// recordCallback records a callback in the CALLBACKS_TABLE env parameter's table, if set, to be replayed;
+// failures are logged
+func recordCallback(sess *session.Session, callback callbacks.Callback) {
+	table := os.Getenv("CALLBACKS_TABLE")
+	if table == "" {
+		return
+	}
+	retention, err := callbacks.RetentionFromEnv()
+	if err != nil {
+		logger.Errorf("Could not convert CALLBACK_RETENTION_DAYS to int: %v", err)
+		return
+	}
+	if err = callbacks.Put(sess, table, callback, retention); err != nil {
+		logger.Errorf("Failed to record callback: %v", err)
+	}
+}
+
+func main() {
+	lambda.Start(Handler)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/okebinda/internal/awsconfig"
+	"github.com/okebinda/internal/logging"
+	"github.com/okebinda/internal/storage"
+	"go.uber.org/zap"
+)
+
+var logger *zap.SugaredLogger
+
+// pageSize is the number of objects listed, and deleted, at a time
+const pageSize = 1000
+
+// deadlineMargin is how long before the function times out the janitor stops, leaving the rest to its next run
+const deadlineMargin = 10 * time.Second
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call, on a schedule
+func Handler(ctx context.Context, event events.CloudWatchEvent) error {
+
+	// initialize logger
+	lc, _ := lambdacontext.FromContext(ctx)
+	logger = logging.New(lc.AwsRequestID)
+	defer logger.Sync()
+
+	// get environment parameters
+	ttlHours, err := strconv.Atoi(os.Getenv("UPLOAD_TTL_HOURS"))
+	if err != nil || ttlHours < 1 {
+		err = fmt.Errorf("could not convert UPLOAD_TTL_HOURS to a positive int: %s", os.Getenv("UPLOAD_TTL_HOURS"))
+		logger.Error(err)
+		return err
+	}
+	buckets := []string{os.Getenv("AWS_S3_BUCKET_UPLOAD")}
+	for _, name := range []string{"SANDBOX_AWS_S3_BUCKET_UPLOAD", "AWS_S3_BUCKET_QUARANTINE", "SANDBOX_AWS_S3_BUCKET_QUARANTINE"} {
+		if bucket := os.Getenv(name); bucket != "" {
+			buckets = append(buckets, bucket)
+		}
+	}
+	kept := keptPrefixes()
+
+	// initialize S3 client
+	svc := storage.Client(awsconfig.NewContextSession(ctx))
+
+	cutoff := time.Now().Add(-time.Duration(ttlHours) * time.Hour)
+	for _, bucket := range buckets {
+		purged, complete, err := purgeBucket(ctx, svc, bucket, cutoff, kept)
+		logger.Infow("Uploads purged.",
+			"bucket", bucket,
+			"purged", purged,
+			"complete", complete,
+		)
+		if err != nil {
+			logger.Errorf("Failed to purge uploads: %s, %v", bucket, err)
+			return err
+		}
+		if !complete {
+			break
+		}
+	}
+	return nil
+}
+
+// purgeBucket deletes the objects in an upload bucket last modified before a cutoff, except those under the kept
+// prefixes, returning the number deleted, and false if the function ran out of time before the whole bucket was
+// listed
+func purgeBucket(ctx context.Context, svc s3iface.S3API, bucket string, cutoff time.Time, kept []string) (int, bool, error) {
+	purged := 0
+	token := ""
+	for {
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < deadlineMargin {
+			return purged, false, nil
+		}
+		objects, next, err := storage.ListObjects(svc, bucket, "", token, pageSize)
+		if err != nil {
+			return purged, true, err
+		}
+		var objectKeys []string
+		for _, object := range objects {
+			if object.LastModified.Before(cutoff) && !keptKey(object.Key, kept) {
+				objectKeys = append(objectKeys, object.ObjectKey)
+			}
+		}
+		if err = storage.DeleteObjects(svc, bucket, objectKeys); err != nil {
+			return purged, true, err
+		}
+		purged += len(objectKeys)
+		if next == "" {
+			return purged, true, nil
+		}
+		token = next
+	}
+}
+
+// keptPrefixes returns the prefixes of the upload bucket that hold objects kept on purpose, rather than unprocessed
+// uploads: quarantined and moderated uploads, held for review, and import reports, each set by the same env
+// parameter as in the image-upload function; they expire with the bucket's lifecycle rule instead
+func keptPrefixes() []string {
+	var prefixes []string
+	for name, prefix := range map[string]string{
+		"QUARANTINE_PREFIX":    "_quarantine",
+		"MODERATION_PREFIX":    "_moderation",
+		"IMPORT_REPORT_PREFIX": "_reports",
+	} {
+		if value := strings.Trim(os.Getenv(name), "/"); value != "" {
+			prefix = value
+		}
+		prefixes = append(prefixes, prefix+"/")
+	}
+	return prefixes
+}
+
+// keptKey tests if a key is under any of the kept prefixes
+func keptKey(fileKey string, kept []string) bool {
+	for _, prefix := range kept {
+		if strings.HasPrefix(fileKey, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func main() {
+	lambda.Start(Handler)
+}
@@ -0,0 +1,225 @@
+// Package analytics provides processing and serving event export to the analytics delivery stream
+package analytics
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/okebinda/internal/otlp"
+)
+
+// event types
+const (
+	EventUploadProcessed     = "upload_processed"
+	EventUploadRejected      = "upload_rejected"
+	EventDerivativeGenerated = "derivative_generated"
+	EventDerivativeRejected  = "derivative_rejected"
+	EventOriginalServed      = "original_served"
+)
+
+// Event defines the JSON schema for an analytics event, matching the Glue table the stream converts to Parquet
+type Event struct {
+	EventType      string  `json:"event_type"`
+	Service        string  `json:"service"`
+	RequestID      string  `json:"request_id"`
+	Bucket         string  `json:"bucket"`
+	FileKey        string  `json:"file_key"`
+	FileType       string  `json:"file_type,omitempty"`
+	SizeBytes      int64   `json:"size_bytes,omitempty"`
+	Width          int     `json:"width,omitempty"`
+	Height         int     `json:"height,omitempty"`
+	ColorSpace     string  `json:"color_space,omitempty"`
+	BitDepth       int     `json:"bit_depth,omitempty"`
+	ColorConverted bool    `json:"color_converted,omitempty"`
+	Quality        int     `json:"quality,omitempty"`
+	SSIM           float64 `json:"ssim,omitempty"`
+	Principal      string  `json:"principal,omitempty"`
+	Reason         string  `json:"reason,omitempty"`
+	Timestamp      int64   `json:"timestamp"`
+}
+
+// Record publishes an event as CloudWatch metrics, if the METRICS_NAMESPACE env parameter is set, exports the same
+// counts to an OpenTelemetry collector, if configured (see the otlp package), and sends it to the delivery stream
+// named by the ANALYTICS_STREAM env parameter, if set
+func Record(sess *session.Session, event Event) error {
+	if event.Timestamp == 0 {
+		event.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+
+	if namespace := os.Getenv("METRICS_NAMESPACE"); namespace != "" {
+		if err := writeMetrics(os.Stdout, namespace, event); err != nil {
+			return err
+		}
+	}
+
+	// an unavailable collector does not hold up the delivery stream
+	exportErr := exportMetrics(event)
+
+	streamName := os.Getenv("ANALYTICS_STREAM")
+	if streamName == "" {
+		return exportErr
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	// newline delimited so records are separable in the delivered files
+	_, err = firehose.New(sess).PutRecord(&firehose.PutRecordInput{
+		DeliveryStreamName: aws.String(streamName),
+		Record:             &firehose.Record{Data: append(data, '\n')},
+	})
+	if err != nil {
+		return err
+	}
+	return exportErr
+}
+
+// RecordDegradation publishes a CloudWatch metric, if the METRICS_NAMESPACE env parameter is set, counting work
+// a service completed in a degraded mode, e.g. synchronously because its queue was unavailable: a "Degradations"
+// count by service and operation, also exported to an OpenTelemetry collector, if configured
+func RecordDegradation(service, operation string) error {
+	exportErr := otlp.AddCounter("degradations", 1, map[string]interface{}{
+		"service":   service,
+		"operation": operation,
+	})
+	namespace := os.Getenv("METRICS_NAMESPACE")
+	if namespace == "" {
+		return exportErr
+	}
+	fields := map[string]interface{}{
+		"Service":      service,
+		"Operation":    operation,
+		"Degradations": 1,
+		"_aws": map[string]interface{}{
+			"Timestamp": time.Now().UnixNano() / int64(time.Millisecond),
+			"CloudWatchMetrics": []map[string]interface{}{
+				{
+					"Namespace":  namespace,
+					"Dimensions": [][]string{{"Service", "Operation"}},
+					"Metrics":    []map[string]string{{"Name": "Degradations", "Unit": "Count"}},
+				},
+			},
+		},
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	if _, err = os.Stdout.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return exportErr
+}
+
+// RecordQuality publishes a CloudWatch metric, if the METRICS_NAMESPACE env parameter is set, reporting the
+// encoder quality a service chose for a file type: an "EncoderQuality" value by service and file type
+func RecordQuality(service, fileType string, quality int) error {
+	namespace := os.Getenv("METRICS_NAMESPACE")
+	if namespace == "" {
+		return nil
+	}
+	fields := map[string]interface{}{
+		"Service":        service,
+		"FileType":       fileType,
+		"EncoderQuality": quality,
+		"_aws": map[string]interface{}{
+			"Timestamp": time.Now().UnixNano() / int64(time.Millisecond),
+			"CloudWatchMetrics": []map[string]interface{}{
+				{
+					"Namespace":  namespace,
+					"Dimensions": [][]string{{"Service", "FileType"}},
+					"Metrics":    []map[string]string{{"Name": "EncoderQuality", "Unit": "None"}},
+				},
+			},
+		},
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(append(data, '\n'))
+	return err
+}
+
+// writeMetrics writes an event as a CloudWatch embedded metric format log line: an "Events" count by service
+// and event type, plus a "Rejections" count by service and reason for rejected events, and the "SSIM" and
+// "BytesPerPixel" of encoded images whose quality was measured, by service, file type, and quality
+func writeMetrics(w io.Writer, namespace string, event Event) error {
+	directives := []map[string]interface{}{
+		{
+			"Namespace":  namespace,
+			"Dimensions": [][]string{{"Service", "EventType"}},
+			"Metrics":    []map[string]string{{"Name": "Events", "Unit": "Count"}},
+		},
+	}
+	fields := map[string]interface{}{
+		"Service":   event.Service,
+		"EventType": event.EventType,
+		"Events":    1,
+	}
+	if event.Reason != "" {
+		directives = append(directives, map[string]interface{}{
+			"Namespace":  namespace,
+			"Dimensions": [][]string{{"Service", "Reason"}},
+			"Metrics":    []map[string]string{{"Name": "Rejections", "Unit": "Count"}},
+		})
+		fields["Reason"] = event.Reason
+		fields["Rejections"] = 1
+	}
+	if event.SSIM > 0 {
+		directives = append(directives, map[string]interface{}{
+			"Namespace":  namespace,
+			"Dimensions": [][]string{{"Service", "FileType", "Quality"}},
+			"Metrics": []map[string]string{
+				{"Name": "SSIM", "Unit": "None"},
+				{"Name": "BytesPerPixel", "Unit": "None"},
+			},
+		})
+		fields["FileType"] = event.FileType
+		fields["Quality"] = strconv.Itoa(event.Quality)
+		fields["SSIM"] = event.SSIM
+		if pixels := event.Width * event.Height; pixels > 0 {
+			fields["BytesPerPixel"] = float64(event.SizeBytes) / float64(pixels)
+		}
+	}
+	fields["_aws"] = map[string]interface{}{
+		"Timestamp":         event.Timestamp,
+		"CloudWatchMetrics": directives,
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// exportMetrics exports an event's counts to an OpenTelemetry collector, if configured, as writeMetrics publishes
+// them: an "events" count by service and event type, plus a "rejections" count by service and reason
+func exportMetrics(event Event) error {
+	if !otlp.MetricsEnabled() {
+		return nil
+	}
+	if err := otlp.AddCounter("events", 1, map[string]interface{}{
+		"service":    event.Service,
+		"event_type": event.EventType,
+	}); err != nil {
+		return err
+	}
+	if event.Reason == "" {
+		return nil
+	}
+	return otlp.AddCounter("rejections", 1, map[string]interface{}{
+		"service": event.Service,
+		"reason":  event.Reason,
+	})
+}
@@ -0,0 +1,73 @@
+// Package apiversion negotiates the version of the response schemas returned by the services, so payloads can
+// evolve without breaking existing consumers: v1 is the original payloads, and v2 the richer payloads with URLs,
+// dimensions, and variants; a version is selected by a /v1 or /v2 path prefix, or else the Accept-Version header
+package apiversion
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Versions of the response schemas
+const (
+	V1 = 1
+	V2 = 2
+)
+
+// Default is the version of requests that select none, so existing consumers keep the original payloads
+const Default = V1
+
+// Latest is the most recent version
+const Latest = V2
+
+// Header is the request header selecting a version, e.g. "2" or "v2"
+const Header = "Accept-Version"
+
+// ResponseHeader is the response header reporting the version its payload follows, e.g. "v2"
+const ResponseHeader = "API-Version"
+
+// rePrefix matches a version path prefix, e.g. "/v2/", capturing the version
+var rePrefix = regexp.MustCompile(`^/[vV](\d+)(/|$)`)
+
+// Negotiate selects the version of a request from its path's version prefix, if any, else its Accept-Version
+// header, if set, else Default; returns the version and the path without its prefix, or an error if the version
+// selected is not supported
+func Negotiate(path, acceptVersion string) (int, string, error) {
+	if match := rePrefix.FindStringSubmatch(path); match != nil {
+		version, err := parse(match[1])
+		if err != nil {
+			return 0, path, err
+		}
+		return version, StripPrefix(path), nil
+	}
+	acceptVersion = strings.TrimSpace(acceptVersion)
+	if acceptVersion == "" {
+		return Default, path, nil
+	}
+	version, err := parse(strings.TrimPrefix(strings.ToLower(acceptVersion), "v"))
+	return version, path, err
+}
+
+// StripPrefix removes a version prefix from a path, e.g. the raw, escaped path of a request
+func StripPrefix(path string) string {
+	if match := rePrefix.FindStringSubmatch(path); match != nil {
+		return "/" + strings.TrimPrefix(path[len(match[0]):], "/")
+	}
+	return path
+}
+
+// String formats a version for the API-Version header, e.g. "v2"
+func String(version int) string {
+	return fmt.Sprintf("v%d", version)
+}
+
+// parse reads a version number, checking it is supported
+func parse(value string) (int, error) {
+	version, err := strconv.Atoi(value)
+	if err != nil || version < V1 || version > Latest {
+		return 0, fmt.Errorf("%s, must be 1-%d", value, Latest)
+	}
+	return version, nil
+}
@@ -0,0 +1,55 @@
+// Package auth authenticates requests to the services: with no authentication, signed URLs, JSON Web Tokens, or
+// the claims of an API Gateway Cognito authorizer
+package auth
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/okebinda/internal/problem"
+	"github.com/okebinda/internal/signing"
+)
+
+// Provider authenticates a request, returning a Denial if it may not be served, or another error if it could not
+// be authenticated
+type Provider interface {
+	Authenticate(r *http.Request) error
+}
+
+// Identifier is implemented by Providers that can name who made a request they authenticated, e.g. for audit logs
+type Identifier interface {
+	Subject(r *http.Request) string
+}
+
+// Denial defines why a request was denied: its status code, message, and problem code
+type Denial struct {
+	Code    int
+	Message string
+	Problem string
+}
+
+// Error returns the denial's message
+func (d Denial) Error() string {
+	return d.Message
+}
+
+// None authenticates every request
+type None struct{}
+
+// Authenticate allows the request
+func (None) Authenticate(r *http.Request) error {
+	return nil
+}
+
+// Signature authenticates requests signed with a shared secret, see signing.SignURL
+type Signature struct {
+	Secret string
+}
+
+// Authenticate verifies the signature in the request's query parameters, which must not have expired
+func (s Signature) Authenticate(r *http.Request) error {
+	if !signing.VerifyURL(s.Secret, r.URL.Path, r.URL.Query(), time.Now()) {
+		return Denial{Code: 403, Message: "Invalid or expired signature.", Problem: problem.InvalidSignature}
+	}
+	return nil
+}
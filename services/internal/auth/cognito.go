@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/okebinda/internal/problem"
+)
+
+// Cognito authenticates requests by the claims an API Gateway Cognito user pool authorizer verified, read from the
+// request by Claims; with Groups set, the user must belong to one of them (the cognito:groups claim)
+type Cognito struct {
+	Claims func(r *http.Request) map[string]interface{}
+	Groups []string
+}
+
+// Authenticate checks the request's claims
+func (c Cognito) Authenticate(r *http.Request) error {
+	claims := c.Claims(r)
+	if len(claims) == 0 {
+		return Denial{Code: 401, Message: "Authentication required.", Problem: problem.Unauthenticated}
+	}
+	if len(c.Groups) == 0 {
+		return nil
+	}
+	for _, group := range claimGroups(claims["cognito:groups"]) {
+		for _, required := range c.Groups {
+			if group == required {
+				return nil
+			}
+		}
+	}
+	return Denial{Code: 403, Message: "Permission denied.", Problem: problem.PermissionDenied}
+}
+
+// Subject returns the user name of the request's claims, else their sub claim
+func (c Cognito) Subject(r *http.Request) string {
+	claims := c.Claims(r)
+	for _, claim := range []string{"cognito:username", "sub"} {
+		if subject, ok := claims[claim].(string); ok && subject != "" {
+			return subject
+		}
+	}
+	return ""
+}
+
+// claimGroups reads the cognito:groups claim, which API Gateway passes as a list or as a string, either
+// comma-separated or in brackets and space-separated, e.g. "[admins editors]"
+func claimGroups(claim interface{}) []string {
+	switch value := claim.(type) {
+	case []interface{}:
+		groups := []string{}
+		for _, group := range value {
+			if name, ok := group.(string); ok {
+				groups = append(groups, name)
+			}
+		}
+		return groups
+	case string:
+		value = strings.Trim(value, "[]")
+		return strings.FieldsFunc(value, func(c rune) bool {
+			return c == ',' || c == ' '
+		})
+	}
+	return nil
+}
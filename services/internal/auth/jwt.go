@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/okebinda/internal/problem"
+)
+
+// TokenParameter is the query parameter that may carry a token, for requests that cannot send headers, e.g. images
+const TokenParameter = "token"
+
+// invalidToken is the denial of a request without a valid token
+var invalidToken = Denial{Code: 401, Message: "Invalid or expired token.", Problem: problem.InvalidToken}
+
+// DefaultMaxTokenLifetime is the longest a token may be valid for if JWT.MaxLifetime is not set
+const DefaultMaxTokenLifetime = 24 * time.Hour
+
+// JWT authenticates requests with a JSON Web Token, sent as a bearer token in the Authorization header or in the
+// token query parameter, signed with HS256 and a shared Secret or with RS256 and a PublicKey; the token must have
+// an expiry no more than MaxLifetime (default DefaultMaxTokenLifetime) after it was issued, or from now, must not
+// have expired, and must have been issued by Issuer and for Audience, if set
+type JWT struct {
+	Secret      string
+	PublicKey   *rsa.PublicKey
+	Issuer      string
+	Audience    string
+	MaxLifetime time.Duration
+}
+
+// jwtHeader defines the JSON schema of a token's header
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+}
+
+// jwtClaims defines the JSON schema of the registered claims of a token that are verified or read
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+	IssuedAt  *float64        `json:"iat"`
+}
+
+// ParseRSAPublicKey parses a PEM encoded RSA public key, in PKIX or PKCS #1 form
+func ParseRSAPublicKey(data string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("not an RSA public key: %T", key)
+	}
+	return rsaKey, nil
+}
+
+// requestToken reads a request's token from its Authorization header, else its token query parameter
+func requestToken(r *http.Request) string {
+	token := r.URL.Query().Get(TokenParameter)
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		token = strings.TrimPrefix(header, "Bearer ")
+	}
+	return token
+}
+
+// Authenticate verifies the request's token
+func (j JWT) Authenticate(r *http.Request) error {
+	token := requestToken(r)
+	if token == "" {
+		return invalidToken
+	}
+	if err := j.Verify(token, time.Now()); err != nil {
+		return invalidToken
+	}
+	return nil
+}
+
+// Subject returns the sub claim of the request's token, which must already be authenticated, or an empty string
+func (j JWT) Subject(r *http.Request) string {
+	parts := strings.Split(requestToken(r), ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return ""
+	}
+	return claims.Subject
+}
+
+// Verify verifies a token's signature and claims at a time
+func (j JWT) Verify(token string, now time.Time) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed token")
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return fmt.Errorf("malformed token header: %v", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("malformed token signature: %v", err)
+	}
+
+	// the algorithm must match the configured key, so a public key cannot be used as an HMAC secret
+	signed := []byte(parts[0] + "." + parts[1])
+	switch {
+	case header.Algorithm == "HS256" && j.Secret != "":
+		mac := hmac.New(sha256.New, []byte(j.Secret))
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return errors.New("invalid token signature")
+		}
+	case header.Algorithm == "RS256" && j.PublicKey != nil:
+		digest := sha256.Sum256(signed)
+		if err = rsa.VerifyPKCS1v15(j.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+			return errors.New("invalid token signature")
+		}
+	default:
+		return fmt.Errorf("unsupported token algorithm: %s", header.Algorithm)
+	}
+
+	var claims jwtClaims
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return fmt.Errorf("malformed token claims: %v", err)
+	}
+
+	// tokens grant access to images, so every token must expire, and none may be valid for too long
+	maxLifetime := j.MaxLifetime
+	if maxLifetime <= 0 {
+		maxLifetime = DefaultMaxTokenLifetime
+	}
+	if claims.ExpiresAt == nil {
+		return errors.New("token has no expiry")
+	}
+	if float64(now.Unix()) >= *claims.ExpiresAt {
+		return errors.New("token expired")
+	}
+	if *claims.ExpiresAt-float64(now.Unix()) > maxLifetime.Seconds() {
+		return fmt.Errorf("token valid for longer than %s", maxLifetime)
+	}
+	if claims.IssuedAt != nil && *claims.ExpiresAt-*claims.IssuedAt > maxLifetime.Seconds() {
+		return fmt.Errorf("token lifetime longer than %s", maxLifetime)
+	}
+	if claims.NotBefore != nil && float64(now.Unix()) < *claims.NotBefore {
+		return errors.New("token not yet valid")
+	}
+	if j.Issuer != "" && claims.Issuer != j.Issuer {
+		return fmt.Errorf("unexpected token issuer: %s", claims.Issuer)
+	}
+	if j.Audience != "" && !hasAudience(claims.Audience, j.Audience) {
+		return errors.New("unexpected token audience")
+	}
+	return nil
+}
+
+// decodeSegment decodes a base64url encoded JSON segment of a token
+func decodeSegment(segment string, value interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+// hasAudience tests whether a token's aud claim, a string or an array of strings, includes an audience
+func hasAudience(claim json.RawMessage, audience string) bool {
+	var single string
+	if err := json.Unmarshal(claim, &single); err == nil {
+		return single == audience
+	}
+	var multiple []string
+	if err := json.Unmarshal(claim, &multiple); err != nil {
+		return false
+	}
+	for _, value := range multiple {
+		if value == audience {
+			return true
+		}
+	}
+	return false
+}
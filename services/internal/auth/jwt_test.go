@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// signHS256 builds a token with claims, signed with HS256 and a secret
+func signHS256(t *testing.T, secret string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestJWTVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	unix := func(d time.Duration) int64 { return now.Add(d).Unix() }
+
+	tests := []struct {
+		name     string
+		provider JWT
+		secret   string
+		claims   map[string]interface{}
+		ok       bool
+	}{
+		{"valid", JWT{Secret: "s"}, "s", map[string]interface{}{"exp": unix(time.Hour)}, true},
+		{"no expiry", JWT{Secret: "s"}, "s", map[string]interface{}{"sub": "user"}, false},
+		{"expired", JWT{Secret: "s"}, "s", map[string]interface{}{"exp": unix(-time.Second)}, false},
+		{"expiry beyond default lifetime", JWT{Secret: "s"}, "s", map[string]interface{}{"exp": unix(25 * time.Hour)}, false},
+		{"expiry within max lifetime", JWT{Secret: "s", MaxLifetime: 48 * time.Hour}, "s", map[string]interface{}{"exp": unix(25 * time.Hour)}, true},
+		{"expiry beyond max lifetime", JWT{Secret: "s", MaxLifetime: time.Hour}, "s", map[string]interface{}{"exp": unix(2 * time.Hour)}, false},
+		{"issued too long before expiry", JWT{Secret: "s", MaxLifetime: time.Hour}, "s", map[string]interface{}{"iat": unix(-2 * time.Hour), "exp": unix(time.Minute)}, false},
+		{"not yet valid", JWT{Secret: "s"}, "s", map[string]interface{}{"nbf": unix(time.Minute), "exp": unix(time.Hour)}, false},
+		{"wrong secret", JWT{Secret: "s"}, "other", map[string]interface{}{"exp": unix(time.Hour)}, false},
+		{"wrong issuer", JWT{Secret: "s", Issuer: "a"}, "s", map[string]interface{}{"iss": "b", "exp": unix(time.Hour)}, false},
+		{"audience in list", JWT{Secret: "s", Audience: "a"}, "s", map[string]interface{}{"aud": []string{"b", "a"}, "exp": unix(time.Hour)}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.provider.Verify(signHS256(t, test.secret, test.claims), now)
+			if test.ok && err != nil {
+				t.Errorf("Verify() = %v, want nil", err)
+			}
+			if !test.ok && err == nil {
+				t.Error("Verify() = nil, want an error")
+			}
+		})
+	}
+}
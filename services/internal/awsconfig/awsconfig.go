@@ -0,0 +1,108 @@
+// Package awsconfig creates the AWS sessions shared by the services, with the endpoint overrides needed to run them
+// against LocalStack or MinIO
+package awsconfig
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/okebinda/internal/tracing"
+)
+
+// Config reads the overrides of every AWS client's config: the endpoint in the AWS_ENDPOINT_URL env parameter,
+// e.g. "http://localhost:4566" for LocalStack, and, if the S3_FORCE_PATH_STYLE env parameter is "true", path-style
+// bucket addressing, as LocalStack and MinIO require
+func Config() (*aws.Config, error) {
+	config := aws.NewConfig()
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		config.Endpoint = aws.String(endpoint)
+	}
+	if value := os.Getenv("S3_FORCE_PATH_STYLE"); value != "" {
+		pathStyle, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("could not convert S3_FORCE_PATH_STYLE to bool: %v", err)
+		}
+		config.S3ForcePathStyle = aws.Bool(pathStyle)
+	}
+	return config, nil
+}
+
+// NewSession creates a session with the overrides of Config, whose requests are traced (see tracing.Instrument);
+// like session.Must, it panics if the session cannot be created
+func NewSession() *session.Session {
+	config, err := Config()
+	if err != nil {
+		panic(err)
+	}
+	return tracing.Instrument(session.Must(session.NewSession(config)))
+}
+
+// OperationTimeout reads the longest an AWS operation may take, retries included, from the
+// AWS_OPERATION_TIMEOUT_MS env parameter, or 0 for no limit if it is not set
+func OperationTimeout() (time.Duration, error) {
+	value := os.Getenv("AWS_OPERATION_TIMEOUT_MS")
+	if value == "" {
+		return 0, nil
+	}
+	ms, err := strconv.Atoi(value)
+	if err != nil || ms < 0 {
+		return 0, fmt.Errorf("could not convert AWS_OPERATION_TIMEOUT_MS to a positive int: %s", value)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// WithContext returns a copy of a session whose clients send every request with a context, so requests are
+// abandoned once it is done, e.g. at the Lambda function's deadline, and, if timeout is not 0, once they have
+// taken that long; requests sent with their own context, by the clients' WithContext methods, keep it, and
+// presigned requests, which are not sent, are left alone
+func WithContext(sess *session.Session, ctx context.Context, timeout time.Duration) *session.Session {
+	return WithContextFunc(sess, func() context.Context { return ctx }, timeout)
+}
+
+// WithContextFunc returns a copy of a session like WithContext, whose clients send each request with the context
+// ctx returns as it is sent, e.g. that of the current Lambda invocation, so clients created once can be reused
+// across invocations
+func WithContextFunc(sess *session.Session, ctx func() context.Context, timeout time.Duration) *session.Session {
+	copied := sess.Copy()
+	copied.Handlers.Validate.PushFront(func(r *request.Request) {
+		if r.ExpireTime != 0 {
+			return
+		}
+		reqCtx := r.Context()
+		if reqCtx == aws.BackgroundContext() {
+			reqCtx = ctx()
+		}
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			reqCtx, cancel = context.WithTimeout(reqCtx, timeout)
+			r.Handlers.Complete.PushBack(func(*request.Request) {
+				cancel()
+			})
+		}
+		r.SetContext(reqCtx)
+	})
+	return copied
+}
+
+// NewContextSession creates a session with the overrides of Config whose requests are sent with a context, limited
+// to the AWS_OPERATION_TIMEOUT_MS env parameter (see WithContext); like session.Must, it panics if the session
+// cannot be created
+func NewContextSession(ctx context.Context) *session.Session {
+	return NewContextFuncSession(func() context.Context { return ctx })
+}
+
+// NewContextFuncSession creates a session like NewContextSession whose requests are sent with the context ctx
+// returns as they are sent (see WithContextFunc)
+func NewContextFuncSession(ctx func() context.Context) *session.Session {
+	timeout, err := OperationTimeout()
+	if err != nil {
+		panic(err)
+	}
+	return WithContextFunc(NewSession(), ctx, timeout)
+}
@@ -0,0 +1,193 @@
+// Package awstest provides the fake S3 and SQS clients, session, and clock shared by the services' tests
+package awstest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// Now is the time FixedClock tells by default in tests
+var Now = time.Date(2020, 11, 2, 15, 4, 5, 0, time.UTC)
+
+// FixedClock tells a fixed time
+type FixedClock struct {
+	Time time.Time
+}
+
+// Now returns the fixed time
+func (c FixedClock) Now() time.Time {
+	return c.Time
+}
+
+// NewSession creates a session with static credentials in us-east-1, which signs requests, e.g. presigned URLs,
+// without reading any credentials from the environment
+func NewSession() *session.Session {
+	return session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("AKIDEXAMPLE", "secret", ""),
+	}))
+}
+
+// FakeS3 is an S3 client whose objects' headers and contents are held in memory, by "{bucket}/{key}"; copied and
+// put objects are held like the others and recorded, and each operation fails with its error, if set. Other
+// operations, e.g. presigning, are left to the embedded client, which should be created from NewSession so it
+// signs URLs without sending any request
+type FakeS3 struct {
+	s3iface.S3API
+	Headers map[string]*s3.HeadObjectOutput
+	Objects map[string][]byte
+	Copied  []*s3.CopyObjectInput
+	Put     []*s3.PutObjectInput
+
+	HeadErr error
+	GetErr  error
+	CopyErr error
+	PutErr  error
+}
+
+// HeadObject returns the header of an object, or a NotFound error if it is not held
+func (f *FakeS3) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	if f.HeadErr != nil {
+		return nil, f.HeadErr
+	}
+	header, ok := f.Headers[aws.StringValue(input.Bucket)+"/"+aws.StringValue(input.Key)]
+	if !ok {
+		return nil, awserr.New("NotFound", "Not Found", nil)
+	}
+	return header, nil
+}
+
+// GetObject returns the content of an object, or of its requested range, or a NoSuchKey error if it is not held
+func (f *FakeS3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	return f.GetObjectWithContext(context.Background(), input)
+}
+
+// GetObjectWithContext returns the content of an object like GetObject
+func (f *FakeS3) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	if f.GetErr != nil {
+		return nil, f.GetErr
+	}
+	body, ok := f.Objects[aws.StringValue(input.Bucket)+"/"+aws.StringValue(input.Key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	size := len(body)
+	var first, last int
+	if _, err := fmt.Sscanf(aws.StringValue(input.Range), "bytes=%d-%d", &first, &last); err == nil && first < size {
+		if last >= size {
+			last = size - 1
+		}
+		body = body[first : last+1]
+	}
+	return &s3.GetObjectOutput{
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: aws.Int64(int64(len(body))),
+		ContentRange:  aws.String(fmt.Sprintf("bytes %d-%d/%d", first, first+len(body)-1, size)),
+	}, nil
+}
+
+// CopyObject copies an object with the metadata of the copy, or returns CopyErr, or a NoSuchKey error if the
+// source is not held
+func (f *FakeS3) CopyObject(input *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	if f.CopyErr != nil {
+		return nil, f.CopyErr
+	}
+	source, err := url.PathUnescape(aws.StringValue(input.CopySource))
+	if err != nil {
+		return nil, err
+	}
+	body, ok := f.Objects[source]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	f.hold(aws.StringValue(input.Bucket), aws.StringValue(input.Key), body, input.ContentType, input.Metadata)
+	f.Copied = append(f.Copied, input)
+	return &s3.CopyObjectOutput{}, nil
+}
+
+// PutObject holds an object, or returns PutErr
+func (f *FakeS3) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	if f.PutErr != nil {
+		return nil, f.PutErr
+	}
+	var body []byte
+	if input.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(input.Body); err != nil {
+			return nil, err
+		}
+	}
+	f.hold(aws.StringValue(input.Bucket), aws.StringValue(input.Key), body, input.ContentType, input.Metadata)
+	f.Put = append(f.Put, input)
+	return &s3.PutObjectOutput{}, nil
+}
+
+// DeleteObject deletes an object, if it is held
+func (f *FakeS3) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	key := aws.StringValue(input.Bucket) + "/" + aws.StringValue(input.Key)
+	delete(f.Objects, key)
+	delete(f.Headers, key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+// hold holds an object's content and header
+func (f *FakeS3) hold(bucket, key string, body []byte, contentType *string, metadata map[string]*string) {
+	if f.Objects == nil {
+		f.Objects = map[string][]byte{}
+	}
+	if f.Headers == nil {
+		f.Headers = map[string]*s3.HeadObjectOutput{}
+	}
+	f.Objects[bucket+"/"+key] = body
+	f.Headers[bucket+"/"+key] = &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(body))),
+		ContentType:   contentType,
+		Metadata:      metadata,
+	}
+}
+
+// FakeSQS is an SQS client recording the messages sent to it, or failing to send them with Err
+type FakeSQS struct {
+	sqsiface.SQSAPI
+	Sent []*sqs.SendMessageInput
+	Err  error
+}
+
+// SendMessageWithContext records a message, or returns Err
+func (f *FakeSQS) SendMessageWithContext(ctx aws.Context, input *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	f.Sent = append(f.Sent, input)
+	return &sqs.SendMessageOutput{MessageId: aws.String("message-id")}, nil
+}
+
+// Setenv sets an env parameter for the duration of a test
+func Setenv(t testing.TB, name, value string) {
+	t.Helper()
+	previous, ok := os.LookupEnv(name)
+	os.Setenv(name, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(name, previous)
+		} else {
+			os.Unsetenv(name)
+		}
+	})
+}
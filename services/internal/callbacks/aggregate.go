@@ -0,0 +1,72 @@
+package callbacks
+
+import (
+	"encoding/json"
+)
+
+// AggregatedEvent is the event of a callback aggregating the callbacks queued to one URL
+const AggregatedEvent = "callbacks_aggregated"
+
+// ManifestEntry defines the JSON schema for one callback in the manifest of an aggregated callback, with the
+// payload it would have been posted with
+type ManifestEntry struct {
+	CallbackID string          `json:"callback_id"`
+	Event      string          `json:"event"`
+	FileID     string          `json:"file_id"`
+	Directory  string          `json:"directory"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// AggregatedPayload defines the JSON schema for the payload of an aggregated callback: how many callbacks it
+// aggregates and a manifest of them, in the order they were queued
+type AggregatedPayload struct {
+	Event    string          `json:"event"`
+	Count    int             `json:"count"`
+	Manifest []ManifestEntry `json:"manifest"`
+}
+
+// Group is the callbacks to one URL with the same headers, delivered as one aggregated callback
+type Group struct {
+	URL       string
+	Headers   map[string]string
+	Callbacks []Callback
+}
+
+// Aggregate groups callbacks by URL and headers, in the order of each group's first callback
+func Aggregate(callbacks []Callback) []Group {
+	var groups []Group
+	index := map[string]int{}
+	for _, callback := range callbacks {
+
+		// encoding a map sorts its keys, so equal headers have equal keys
+		headers, _ := json.Marshal(callback.Headers)
+		key := callback.URL + "\n" + string(headers)
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, Group{URL: callback.URL, Headers: callback.Headers})
+		}
+		groups[i].Callbacks = append(groups[i].Callbacks, callback)
+	}
+	return groups
+}
+
+// Payload returns the payload of a group's aggregated callback
+func (g Group) Payload() AggregatedPayload {
+	payload := AggregatedPayload{
+		Event:    AggregatedEvent,
+		Count:    len(g.Callbacks),
+		Manifest: make([]ManifestEntry, len(g.Callbacks)),
+	}
+	for i, callback := range g.Callbacks {
+		payload.Manifest[i] = ManifestEntry{
+			CallbackID: callback.CallbackID,
+			Event:      callback.Event,
+			FileID:     callback.FileID,
+			Directory:  callback.Directory,
+			Payload:    json.RawMessage(callback.Payload),
+		}
+	}
+	return payload
+}
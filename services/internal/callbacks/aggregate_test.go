@@ -0,0 +1,43 @@
+package callbacks
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	callbacks := []Callback{
+		{CallbackID: "1", Event: "upload_processed", FileID: "a", Directory: "news", URL: "https://example.com/hook", Payload: `{"file_id":"a"}`},
+		{CallbackID: "2", Event: "upload_processed", FileID: "b", Directory: "news", URL: "https://example.org/hook", Payload: `{"file_id":"b"}`},
+		{CallbackID: "3", Event: "upload_failed", FileID: "c", Directory: "news", URL: "https://example.com/hook", Payload: `{"file_id":"c"}`},
+		{CallbackID: "4", Event: "upload_processed", FileID: "d", URL: "https://example.com/hook", Headers: map[string]string{"Authorization": "Bearer x"}, Payload: `{"file_id":"d"}`},
+	}
+
+	groups := Aggregate(callbacks)
+	var got [][]string
+	for _, group := range groups {
+		var ids []string
+		for _, callback := range group.Callbacks {
+			ids = append(ids, callback.CallbackID)
+		}
+		got = append(got, ids)
+	}
+	if want := [][]string{{"1", "3"}, {"2"}, {"4"}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("groups = %v, want %v", got, want)
+	}
+	if groups[2].URL != "https://example.com/hook" || groups[2].Headers["Authorization"] != "Bearer x" {
+		t.Errorf("group = %s %v, want https://example.com/hook with its Authorization header", groups[2].URL, groups[2].Headers)
+	}
+
+	body, err := json.Marshal(groups[0].Payload())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"event":"callbacks_aggregated","count":2,"manifest":[` +
+		`{"callback_id":"1","event":"upload_processed","file_id":"a","directory":"news","payload":{"file_id":"a"}},` +
+		`{"callback_id":"3","event":"upload_failed","file_id":"c","directory":"news","payload":{"file_id":"c"}}]}`
+	if string(body) != want {
+		t.Errorf("payload = %s, want %s", body, want)
+	}
+}
@@ -0,0 +1,194 @@
+// Package callbacks records the callbacks posted by the services in a DynamoDB table, partitioned by day, so they
+// can be replayed to receivers that missed them
+package callbacks
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/okebinda/internal/notify"
+	"github.com/okebinda/internal/queue"
+)
+
+// dayFormat formats the day partition of a callback
+const dayFormat = "2006-01-02"
+
+// Callback defines a posted callback: the URL or SNS topic it was sent to, its body, and whether it was delivered;
+// its attributes, e.g. the tenant and priority of the upload message it was sent for, travel as message attributes
+// rather than in its JSON body when it is enqueued or published. Retry marks a callback enqueued to be delivered
+// again because its first post failed, rather than replayed
+type Callback struct {
+	Day        string            `dynamodbav:"day" json:"-"`
+	SentKey    string            `dynamodbav:"sent_key" json:"-"`
+	CallbackID string            `dynamodbav:"callback_id" json:"callback_id"`
+	Event      string            `dynamodbav:"event" json:"event"`
+	FileID     string            `dynamodbav:"file_id" json:"file_id"`
+	Directory  string            `dynamodbav:"directory" json:"directory"`
+	URL        string            `dynamodbav:"url,omitempty" json:"url,omitempty"`
+	TopicARN   string            `dynamodbav:"topic_arn,omitempty" json:"topic_arn,omitempty"`
+	Headers    map[string]string `dynamodbav:"headers,omitempty" json:"headers,omitempty"`
+	Attributes map[string]string `dynamodbav:"attributes,omitempty" json:"-"`
+	Payload    string            `dynamodbav:"payload" json:"payload"`
+	Status     string            `dynamodbav:"status" json:"status"`
+	Retry      bool              `dynamodbav:"-" json:"retry,omitempty"`
+	SentAt     string            `dynamodbav:"sent_at" json:"sent_at"`
+	Expires    int64             `dynamodbav:"expires" json:"-"`
+}
+
+// Publish publishes a callback's payload to its SNS topic, with its event and callback ID as the "event" and
+// "callback_id" message attributes, for subscription filter policies, along with the callback's attributes;
+// replayed callbacks also have a "replay" attribute
+func Publish(ctx context.Context, sess *session.Session, callback Callback, replay bool) error {
+	q, err := queue.Open(sess, callback.TopicARN)
+	if err != nil {
+		return err
+	}
+	attributes := map[string]string{}
+	for name, value := range callback.Attributes {
+		attributes[name] = value
+	}
+	attributes["event"] = callback.Event
+	attributes["callback_id"] = callback.CallbackID
+	if replay {
+		attributes["replay"] = "true"
+	}
+	_, err = q.Publish(ctx, queue.Message{
+		Body:       []byte(callback.Payload),
+		Attributes: attributes,
+	})
+	return err
+}
+
+// Replay sends a recorded callback again: publishes it to its SNS topic, or posts it to its URL with its original
+// headers, plus X-Callback-ID and, unless it is a retry, "X-Callback-Replay: true" headers, signed with secrets
+// (see notify.Callback)
+func Replay(ctx context.Context, sess *session.Session, callback Callback, secrets []string, retry notify.Retry) error {
+	if callback.TopicARN != "" {
+		return Publish(ctx, sess, callback, !callback.Retry)
+	}
+	headers := map[string]string{}
+	for name, value := range callback.Headers {
+		headers[name] = value
+	}
+	if !callback.Retry {
+		headers["X-Callback-Replay"] = "true"
+	}
+	headers["X-Callback-ID"] = callback.CallbackID
+	return notify.Callback(ctx, callback.URL, secrets, headers, json.RawMessage(callback.Payload), retry)
+}
+
+// Filter selects the callbacks to replay: those sent from From until To, optionally only for a directory or file
+type Filter struct {
+	From      time.Time
+	To        time.Time
+	Directory string
+	FileID    string
+}
+
+// RetentionFromEnv reads how long callbacks are kept from the CALLBACK_RETENTION_DAYS env parameter (default 14)
+func RetentionFromEnv() (time.Duration, error) {
+	days := 14
+	if value := os.Getenv("CALLBACK_RETENTION_DAYS"); value != "" {
+		var err error
+		if days, err = strconv.Atoi(value); err != nil {
+			return 0, err
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
+// Put records a callback, kept for the retention period before the table's TTL deletes it
+func Put(sess *session.Session, table string, callback Callback, retention time.Duration) error {
+	sentAt := time.Now().UTC()
+	if callback.SentAt != "" {
+		if t, err := time.Parse(time.RFC3339, callback.SentAt); err == nil {
+			sentAt = t.UTC()
+		}
+	}
+	callback.SentAt = sentAt.Format(time.RFC3339)
+	callback.Day = sentAt.Format(dayFormat)
+	callback.SentKey = callback.SentAt + "#" + callback.CallbackID
+	callback.Expires = sentAt.Add(retention).Unix()
+	item, err := dynamodbattribute.MarshalMap(callback)
+	if err != nil {
+		return err
+	}
+	_, err = dynamodb.New(sess).PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item:      item,
+	})
+	return err
+}
+
+// Query reads the callbacks matching a filter, oldest first, querying each day's partition in the time range
+func Query(sess *session.Session, table string, filter Filter) ([]Callback, error) {
+	from := filter.From.UTC()
+	to := filter.To.UTC()
+
+	// "~" sorts after every character of a callback ID, so callbacks sent in the last second are included
+	keyFrom := from.Format(time.RFC3339)
+	keyTo := to.Format(time.RFC3339) + "#~"
+
+	var condition expression.ConditionBuilder
+	hasCondition := false
+	if filter.Directory != "" {
+		condition = expression.Name("directory").Equal(expression.Value(filter.Directory))
+		hasCondition = true
+	}
+	if filter.FileID != "" {
+		fileCondition := expression.Name("file_id").Equal(expression.Value(filter.FileID))
+		if hasCondition {
+			condition = condition.And(fileCondition)
+		} else {
+			condition = fileCondition
+		}
+		hasCondition = true
+	}
+
+	var callbacks []Callback
+	client := dynamodb.New(sess)
+	for day := from.Truncate(24 * time.Hour); !day.After(to); day = day.Add(24 * time.Hour) {
+		builder := expression.NewBuilder().WithKeyCondition(
+			expression.Key("day").Equal(expression.Value(day.Format(dayFormat))).
+				And(expression.Key("sent_key").Between(expression.Value(keyFrom), expression.Value(keyTo))),
+		)
+		if hasCondition {
+			builder = builder.WithFilter(condition)
+		}
+		expr, err := builder.Build()
+		if err != nil {
+			return nil, err
+		}
+
+		var pageErr error
+		err = client.QueryPages(&dynamodb.QueryInput{
+			TableName:                 aws.String(table),
+			KeyConditionExpression:    expr.KeyCondition(),
+			FilterExpression:          expr.Filter(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+		}, func(output *dynamodb.QueryOutput, lastPage bool) bool {
+			var page []Callback
+			if pageErr = dynamodbattribute.UnmarshalListOfMaps(output.Items, &page); pageErr != nil {
+				return false
+			}
+			callbacks = append(callbacks, page...)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if pageErr != nil {
+			return nil, pageErr
+		}
+	}
+	return callbacks, nil
+}
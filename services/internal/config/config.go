@@ -0,0 +1,127 @@
+// Package config reads configuration documents stored outside of a service's environment
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/appconfig"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/okebinda/internal/storage"
+)
+
+// Load reads a configuration document from a source, either an S3 object ("s3://bucket/key", never sharded), an
+// SSM parameter ("ssm:/parameter/name"), decrypting secure string parameters, a Secrets Manager secret's string
+// ("secretsmanager:name"), or an AppConfig configuration's deployed content ("appconfig:application/environment/profile")
+func Load(sess *session.Session, source string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(source, "s3://"):
+		location := strings.SplitN(strings.TrimPrefix(source, "s3://"), "/", 2)
+		if len(location) != 2 || location[0] == "" || location[1] == "" {
+			return nil, fmt.Errorf("invalid S3 config source: %s", source)
+		}
+		output, err := storage.Client(sess).GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(location[0]),
+			Key:    aws.String(location[1]),
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer output.Body.Close()
+		return ioutil.ReadAll(output.Body)
+	case strings.HasPrefix(source, "ssm:"):
+		output, err := ssm.New(sess).GetParameter(&ssm.GetParameterInput{
+			Name:           aws.String(strings.TrimPrefix(source, "ssm:")),
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []byte(aws.StringValue(output.Parameter.Value)), nil
+	case strings.HasPrefix(source, "secretsmanager:"):
+		output, err := secretsmanager.New(sess).GetSecretValue(&secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(strings.TrimPrefix(source, "secretsmanager:")),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []byte(aws.StringValue(output.SecretString)), nil
+	case strings.HasPrefix(source, "appconfig:"):
+		location := strings.SplitN(strings.TrimPrefix(source, "appconfig:"), "/", 3)
+		if len(location) != 3 || location[0] == "" || location[1] == "" || location[2] == "" {
+			return nil, fmt.Errorf("invalid AppConfig config source: %s", source)
+		}
+		output, err := appconfig.New(sess).GetConfiguration(&appconfig.GetConfigurationInput{
+			Application:   aws.String(location[0]),
+			Environment:   aws.String(location[1]),
+			Configuration: aws.String(location[2]),
+			ClientId:      aws.String(appConfigClientID()),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return output.Content, nil
+	}
+	return nil, fmt.Errorf("unknown config source: %s", source)
+}
+
+// Save writes a configuration document to a source that can be written, either an S3 object ("s3://bucket/key",
+// never sharded) or an SSM parameter ("ssm:/parameter/name", stored as a string parameter, created if missing);
+// documents in Secrets Manager and AppConfig are managed outside of the services
+func Save(sess *session.Session, source string, document []byte) error {
+	switch {
+	case strings.HasPrefix(source, "s3://"):
+		location := strings.SplitN(strings.TrimPrefix(source, "s3://"), "/", 2)
+		if len(location) != 2 || location[0] == "" || location[1] == "" {
+			return fmt.Errorf("invalid S3 config source: %s", source)
+		}
+		_, err := storage.Client(sess).PutObject(&s3.PutObjectInput{
+			Bucket:      aws.String(location[0]),
+			Key:         aws.String(location[1]),
+			Body:        bytes.NewReader(document),
+			ContentType: aws.String("application/json"),
+		})
+		return err
+	case strings.HasPrefix(source, "ssm:"):
+		_, err := ssm.New(sess).PutParameter(&ssm.PutParameterInput{
+			Name:      aws.String(strings.TrimPrefix(source, "ssm:")),
+			Value:     aws.String(string(document)),
+			Type:      aws.String(ssm.ParameterTypeString),
+			Overwrite: aws.Bool(true),
+		})
+		return err
+	}
+	return fmt.Errorf("config source cannot be written: %s", source)
+}
+
+// IsNotFound tests if an error loading a configuration document is because the S3 object or SSM parameter does not
+// exist
+func IsNotFound(err error) bool {
+	if storage.IsNotFound(err) {
+		return true
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == ssm.ErrCodeParameterNotFound
+	}
+	return false
+}
+
+// appConfigClientID identifies the function instance to AppConfig, which tracks deployments by client: the Lambda
+// function's name, or else the host name
+func appConfigClientID() string {
+	if name := os.Getenv("AWS_LAMBDA_FUNCTION_NAME"); name != "" {
+		return name
+	}
+	if host, err := os.Hostname(); err == nil {
+		return host
+	}
+	return "okebinda"
+}
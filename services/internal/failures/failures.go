@@ -0,0 +1,117 @@
+// Package failures records upload messages that could not be processed in a DynamoDB table, keyed by message ID
+package failures
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/okebinda/internal/moderation"
+)
+
+// failure statuses
+const (
+	StatusRetrying = "retrying"
+	StatusFailed   = "failed"
+)
+
+// retention is how long failures are kept before the table's TTL deletes them
+const retention = 90 * 24 * time.Hour
+
+// Failure defines a message that could not be processed
+type Failure struct {
+	MessageID      string `dynamodbav:"message_id" json:"message_id"`
+	FileID         string `dynamodbav:"file_id" json:"file_id"`
+	Directory      string `dynamodbav:"directory" json:"directory"`
+	FileExtension  string `dynamodbav:"file_extension" json:"file_extension"`
+	Body           string `dynamodbav:"body" json:"-"`
+	Status         string `dynamodbav:"status" json:"status"`
+	LastError      string `dynamodbav:"last_error" json:"last_error"`
+	Attempts       int    `dynamodbav:"attempts" json:"attempts"`
+	FailedAt       string `dynamodbav:"failed_at" json:"failed_at"`
+	CallbackStatus string `dynamodbav:"callback_status,omitempty" json:"-"`
+	TopicStatus    string `dynamodbav:"topic_status,omitempty" json:"-"`
+	Expires        int64  `dynamodbav:"expires" json:"-"`
+}
+
+// Callback defines the JSON schema for the payload posted to an upload's callback_url when it fails
+type Callback struct {
+	Event         string          `json:"event"`
+	FileID        string          `json:"file_id"`
+	Directory     string          `json:"directory"`
+	FileExtension string          `json:"file_extension"`
+	Error         CallbackError   `json:"error"`
+	FailedAt      string          `json:"failed_at"`
+	Sandbox       bool            `json:"sandbox,omitempty"`
+	Context       json.RawMessage `json:"context,omitempty"`
+}
+
+// CallbackError defines the JSON schema for the error in a Callback; the class further classifies some codes, and
+// the labels list the content images were flagged for by moderation
+type CallbackError struct {
+	Code     string             `json:"code"`
+	Class    string             `json:"class,omitempty"`
+	Message  string             `json:"message"`
+	Labels   []moderation.Label `json:"labels,omitempty"`
+	Attempts int                `json:"attempts"`
+}
+
+// RecordError records the latest error processing a message that will be retried, counting the attempt
+func RecordError(sess *session.Session, table, messageID, message string) error {
+	_, err := dynamodb.New(sess).UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"message_id": {S: aws.String(messageID)},
+		},
+		UpdateExpression: aws.String("SET last_error = :error, #status = :status, expires = :expires ADD attempts :one"),
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":error":   {S: aws.String(message)},
+			":status":  {S: aws.String(StatusRetrying)},
+			":expires": {N: aws.String(expires())},
+			":one":     {N: aws.String("1")},
+		},
+	})
+	return err
+}
+
+// Get reads the failure recorded for a message; returns false if there is none
+func Get(sess *session.Session, table, messageID string) (Failure, bool, error) {
+	var failure Failure
+	output, err := dynamodb.New(sess).GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"message_id": {S: aws.String(messageID)},
+		},
+	})
+	if err != nil || output.Item == nil {
+		return failure, false, err
+	}
+	err = dynamodbattribute.UnmarshalMap(output.Item, &failure)
+	return failure, err == nil, err
+}
+
+// Put records a failure, replacing any previous record for its message
+func Put(sess *session.Session, table string, failure Failure) error {
+	failure.Expires = time.Now().Add(retention).Unix()
+	item, err := dynamodbattribute.MarshalMap(failure)
+	if err != nil {
+		return err
+	}
+	_, err = dynamodb.New(sess).PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item:      item,
+	})
+	return err
+}
+
+// expires returns the TTL for a failure recorded now, as a DynamoDB number
+func expires() string {
+	return strconv.FormatInt(time.Now().Add(retention).Unix(), 10)
+}
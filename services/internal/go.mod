@@ -0,0 +1,10 @@
+module github.com/okebinda/internal
+
+go 1.15
+
+require (
+	github.com/aws/aws-sdk-go v1.35.19
+	github.com/disintegration/imaging v1.6.2
+	go.uber.org/zap v1.16.0
+	golang.org/x/image v0.0.0-20191009234506-e7c1f5e7dbb8
+)
@@ -0,0 +1,95 @@
+// Package hashes records the perceptual hashes of published images in a DynamoDB table, keyed by file key, so
+// similar images can be found
+package hashes
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// Hash defines the perceptual hash of a published image, as 16 hex digits
+type Hash struct {
+	FileKey  string `dynamodbav:"file_key" json:"file_key"`
+	Hash     string `dynamodbav:"hash" json:"hash"`
+	HashedAt string `dynamodbav:"hashed_at" json:"hashed_at"`
+}
+
+// Format formats a hash value as 16 hex digits
+func Format(value uint64) string {
+	return fmt.Sprintf("%016x", value)
+}
+
+// Value parses the hash's value
+func (h Hash) Value() (uint64, error) {
+	return strconv.ParseUint(h.Hash, 16, 64)
+}
+
+// Put records the hash of an image, replacing any previous hash of the same key
+func Put(sess *session.Session, table string, hash Hash) error {
+	if hash.HashedAt == "" {
+		hash.HashedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	item, err := dynamodbattribute.MarshalMap(hash)
+	if err != nil {
+		return err
+	}
+	_, err = dynamodb.New(sess).PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item:      item,
+	})
+	return err
+}
+
+// Get reads the hash of an image; returns false if there is none
+func Get(sess *session.Session, table, fileKey string) (Hash, bool, error) {
+	var hash Hash
+	output, err := dynamodb.New(sess).GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"file_key": {S: aws.String(fileKey)},
+		},
+	})
+	if err != nil || output.Item == nil {
+		return hash, false, err
+	}
+	err = dynamodbattribute.UnmarshalMap(output.Item, &hash)
+	return hash, err == nil, err
+}
+
+// Delete deletes the hash of an image, if any
+func Delete(sess *session.Session, table, fileKey string) error {
+	_, err := dynamodb.New(sess).DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"file_key": {S: aws.String(fileKey)},
+		},
+	})
+	return err
+}
+
+// Scan visits the hash of every image, page by page
+func Scan(sess *session.Session, table string, visit func(Hash)) error {
+	var pageErr error
+	err := dynamodb.New(sess).ScanPages(&dynamodb.ScanInput{
+		TableName: aws.String(table),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		var items []Hash
+		if pageErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &items); pageErr != nil {
+			return false
+		}
+		for _, item := range items {
+			visit(item)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return pageErr
+}
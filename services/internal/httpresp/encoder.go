@@ -0,0 +1,53 @@
+package httpresp
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// maxPooledBuffer is the largest buffer returned to the pool, so a rare large response does not stay in memory
+const maxPooledBuffer = 64 * 1024
+
+// encoder is a JSON encoder writing to its own buffer, reused across responses and warm invocations
+type encoder struct {
+	buffer bytes.Buffer
+	json   *json.Encoder
+}
+
+// encoders pools the encoders, so responses are encoded without allocating a new buffer and encoder each time
+var encoders = sync.Pool{
+	New: func() interface{} {
+		e := &encoder{}
+		e.json = json.NewEncoder(&e.buffer)
+
+		// responses are served as application/json, so HTML characters need not be escaped
+		e.json.SetEscapeHTML(false)
+		return e
+	},
+}
+
+// writeJSON encodes a value with a pooled encoder and writes it as an HTTP JSON response of a content type, or a
+// server error response if the value cannot be encoded
+func writeJSON(w http.ResponseWriter, statusCode int, contentType string, value interface{}) error {
+	e := encoders.Get().(*encoder)
+	defer func() {
+		if e.buffer.Cap() <= maxPooledBuffer {
+			e.buffer.Reset()
+			encoders.Put(e)
+		}
+	}()
+
+	if err := e.json.Encode(value); err != nil {
+		ServerError(w, "")
+		return err
+	}
+
+	// drop the newline the encoder appends
+	body := e.buffer.Bytes()
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(statusCode)
+	_, err := w.Write(body[:len(body)-1])
+	return err
+}
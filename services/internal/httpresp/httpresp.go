@@ -0,0 +1,73 @@
+// Package httpresp provides the JSON HTTP responses shared by the services
+package httpresp
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/okebinda/internal/i18n"
+	"github.com/okebinda/internal/problem"
+)
+
+// jsonContentType is the content type of JSON responses
+const jsonContentType = "application/json; charset=utf-8"
+
+// Success generates a success (2xx) response
+func Success(w http.ResponseWriter, code int, fields interface{}) error {
+	return writeJSON(w, code, jsonContentType, fields)
+}
+
+// UserError generates a user error (4xx) response, as problem details with a code, e.g. problem.NotFound, and the
+// message localized to a language
+func UserError(w http.ResponseWriter, language, requestID string, status int, code, errorMessage string) error {
+	return Reject(w, language, requestID, status, code, errorMessage, nil)
+}
+
+// Reject generates a user error (4xx) response, as problem details with a code and any details, with the message
+// localized to a language
+func Reject(w http.ResponseWriter, language, requestID string, status int, code, errorMessage string, details map[string]interface{}) error {
+	w.Header().Set("Content-Language", language)
+	return writeJSON(w, status, problem.ContentType, problem.New(status, code, i18n.Localize(language, code, errorMessage), details, requestID))
+}
+
+// ServerError generates a server error (500) response, as problem details that do not disclose the cause
+func ServerError(w http.ResponseWriter, requestID string) error {
+	body, err := json.Marshal(problem.New(500, problem.InternalError, "Server error", nil, requestID))
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", problem.ContentType)
+	w.WriteHeader(500)
+	_, err = w.Write(body)
+	return err
+}
+
+// Redirect generates a redirect (301) response
+func Redirect(w http.ResponseWriter, r *http.Request, redirectURL string) {
+	http.Redirect(w, r, redirectURL, http.StatusMovedPermanently)
+}
+
+// RedirectTemporary generates a temporary redirect (302) response, for URLs that must not be cached, e.g. presigned
+// URLs that expire
+func RedirectTemporary(w http.ResponseWriter, r *http.Request, redirectURL string) {
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+}
+
+// Binary generates a response with a raw body, e.g. an image
+func Binary(w http.ResponseWriter, statusCode int, contentType, cacheControl string, body []byte) error {
+	w.Header().Set("Content-Type", contentType)
+	if cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+	w.WriteHeader(statusCode)
+	_, err := w.Write(body)
+	return err
+}
+
+// Write writes an HTTP JSON response to return to the user
+func Write(w http.ResponseWriter, statusCode int, body []byte) error {
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(statusCode)
+	_, err := w.Write(body)
+	return err
+}
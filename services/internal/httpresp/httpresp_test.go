@@ -0,0 +1,146 @@
+package httpresp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/okebinda/internal/problem"
+)
+
+func TestSuccess(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		fields interface{}
+		want   string
+	}{
+		{"ok", 200, map[string]interface{}{"file_key": "a.png"}, `{"file_key":"a.png"}`},
+		{"created", 201, map[string]interface{}{"url": "https://example.com/a.png?x=1&y=<2>"}, `{"url":"https://example.com/a.png?x=1&y=<2>"}`},
+		{"list", 200, []string{"a", "b"}, `["a","b"]`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if err := Success(w, test.status, test.fields); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != test.status || w.Header().Get("Content-Type") != jsonContentType || w.Body.String() != test.want {
+				t.Errorf("Success() = %d %q %s, want %d %q %s", w.Code, w.Header().Get("Content-Type"), w.Body, test.status, jsonContentType, test.want)
+			}
+		})
+	}
+}
+
+func TestSuccessUnencodable(t *testing.T) {
+	w := httptest.NewRecorder()
+	if err := Success(w, 200, map[string]interface{}{"f": func() {}}); err == nil {
+		t.Error("Success() = nil, want an error")
+	}
+	if w.Code != 500 {
+		t.Errorf("Success() status = %d, want 500", w.Code)
+	}
+}
+
+func TestReject(t *testing.T) {
+	tests := []struct {
+		name     string
+		language string
+		status   int
+		code     string
+		message  string
+		details  map[string]interface{}
+		want     problem.Problem
+	}{
+		{
+			"not found", "en", 404, problem.NotFound, "Not found.", nil,
+			problem.Problem{Type: "urn:okebinda:problem:not-found", Title: "Not Found", Status: 404, Detail: "Not found.", Code: problem.NotFound, Message: "Not found.", RequestID: "request"},
+		},
+		{
+			"localized", "fr", 413, problem.FileTooLarge, "File is too large: 7340032", nil,
+			problem.Problem{Type: "urn:okebinda:problem:file-too-large", Title: http.StatusText(413), Status: 413, Detail: "Le fichier est trop volumineux: 7340032", Code: problem.FileTooLarge, Message: "Le fichier est trop volumineux: 7340032", RequestID: "request"},
+		},
+		{
+			"details", "en", 429, problem.RateLimited, "Rate limited.", map[string]interface{}{"retry_after": float64(30)},
+			problem.Problem{Type: "urn:okebinda:problem:rate-limited", Title: "Too Many Requests", Status: 429, Detail: "Rate limited.", Code: problem.RateLimited, Message: "Rate limited.", Details: map[string]interface{}{"retry_after": float64(30)}, RequestID: "request"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if err := Reject(w, test.language, "request", test.status, test.code, test.message, test.details); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != test.status {
+				t.Errorf("Reject() status = %d, want %d", w.Code, test.status)
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != problem.ContentType {
+				t.Errorf("Reject() Content-Type = %q, want %q", contentType, problem.ContentType)
+			}
+			if language := w.Header().Get("Content-Language"); language != test.language {
+				t.Errorf("Reject() Content-Language = %q, want %q", language, test.language)
+			}
+			var got problem.Problem
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Reject() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUserError(t *testing.T) {
+	w := httptest.NewRecorder()
+	if err := UserError(w, "en", "request", 400, problem.MissingParameters, "Missing parameters"); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != 400 || got["code"] != problem.MissingParameters || got["status"] != float64(400) {
+		t.Errorf("UserError() = %d %v, want 400 %s", w.Code, got, problem.MissingParameters)
+	}
+	if _, ok := got["details"]; ok {
+		t.Errorf("UserError() = %v, want no details", got)
+	}
+}
+
+func TestServerError(t *testing.T) {
+	tests := []struct {
+		name, requestID string
+	}{
+		{"request ID", "request"},
+		{"no request ID", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if err := ServerError(w, test.requestID); err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]interface{}{
+				"type":    "urn:okebinda:problem:internal-error",
+				"title":   "Internal Server Error",
+				"status":  float64(500),
+				"detail":  "Server error",
+				"code":    problem.InternalError,
+				"message": "Server error",
+			}
+			if test.requestID != "" {
+				want["request_id"] = test.requestID
+			}
+			if w.Code != 500 || w.Header().Get("Content-Type") != problem.ContentType || !reflect.DeepEqual(got, want) {
+				t.Errorf("ServerError() = %d %q %v, want 500 %q %v", w.Code, w.Header().Get("Content-Type"), got, problem.ContentType, want)
+			}
+		})
+	}
+}
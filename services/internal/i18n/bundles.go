@@ -0,0 +1,149 @@
+package i18n
+
+import "github.com/okebinda/internal/problem"
+
+// bundles maps each supported language to its translations of the error messages' leading phrases, keyed by
+// problem code; the DefaultLanguage bundle holds the phrases as written in the code
+var bundles = map[string]map[string]string{
+	"en": {
+		problem.PermissionDenied:      "Permission denied.",
+		problem.NotFound:              "Not found.",
+		problem.MissingParameters:     "Missing parameters, cannot complete request",
+		problem.BadParameterFormat:    "Bad parameter format, cannot complete request",
+		problem.BadParameterValue:     "Bad parameter value, cannot complete request",
+		problem.UnreadableParameter:   "Could not read parameter format, cannot complete request",
+		problem.SizeNotAllowed:        "Size not allowed, cannot complete request",
+		problem.BadWidth:              "Could not convert width to int.",
+		problem.BadHeight:             "Could not convert height to int.",
+		problem.BadRequestBody:        "Bad request body, must be a JSON object.",
+		problem.UnreadableRequestBody: "Could not read request body.",
+		problem.UnsupportedExtension:  "Unsupported extension",
+		problem.UnsupportedType:       "Unsupported file type",
+		problem.UnsupportedACL:        "Unsupported ACL",
+		problem.FileTooLarge:          "File is too large",
+		problem.UploadNotIssued:       "Upload was not issued",
+		problem.UploadWindowClosed:    "Upload window has closed",
+		problem.ChecksumMismatch:      "Checksum mismatch",
+		problem.MalwareDetected:       "Malware detected",
+		problem.ModerationFlagged:     "Image was flagged by moderation",
+		problem.UnprocessableImage:    "Image could not be decoded",
+		problem.TooManySizes:          "Too many sizes",
+		problem.BadSizeName:           "Bad size name format",
+		problem.DuplicateSizeName:     "Duplicate size name",
+		problem.BadSizeDimensions:     "Bad size dimensions",
+		problem.TooManyCallbacks:      "Too many callbacks",
+		problem.PublishedKeyFailed:    "Could not generate published key",
+		problem.DerivativeKeyFailed:   "Could not generate derivative key",
+		problem.AnimationTooLarge:     "Animation is too large",
+		problem.ImageTooLarge:         "Image is too large",
+		problem.OriginalTooLarge:      "Original is too large to serve",
+		problem.RateLimited:           "Too many requests, try again later.",
+		problem.Embargoed:             "Image is not available yet.",
+		problem.GeoRestricted:         "Image is not available in your region",
+		problem.CountryUnknown:        "Image is not available in your region",
+		problem.Hotlinked:             "Image may not be embedded on this site",
+		problem.InvalidSignature:      "Invalid or expired signature.",
+		problem.InvalidToken:          "Invalid or expired token.",
+		problem.Unauthenticated:       "Authentication required.",
+		problem.SourceBusy:            "Image is busy, try again.",
+		problem.DerivativePending:     "Image is being generated, try again.",
+		problem.UploadRejected:        "Not found.",
+		problem.UnsupportedVersion:    "Unsupported API version",
+		problem.LoadShed:              "Service is shedding load, try again later.",
+		problem.EncryptedFile:         "Encrypted files can only be downloaded",
+	},
+	"es": {
+		problem.PermissionDenied:      "Permiso denegado.",
+		problem.NotFound:              "No encontrado.",
+		problem.MissingParameters:     "Faltan parámetros, no se puede completar la solicitud",
+		problem.BadParameterFormat:    "Formato de parámetro incorrecto, no se puede completar la solicitud",
+		problem.BadParameterValue:     "Valor de parámetro incorrecto, no se puede completar la solicitud",
+		problem.UnreadableParameter:   "No se pudo leer el formato del parámetro, no se puede completar la solicitud",
+		problem.SizeNotAllowed:        "Tamaño no permitido, no se puede completar la solicitud",
+		problem.BadWidth:              "No se pudo convertir el ancho a un entero.",
+		problem.BadHeight:             "No se pudo convertir el alto a un entero.",
+		problem.BadRequestBody:        "Cuerpo de la solicitud incorrecto, debe ser un objeto JSON.",
+		problem.UnreadableRequestBody: "No se pudo leer el cuerpo de la solicitud.",
+		problem.UnsupportedExtension:  "Extensión no admitida",
+		problem.UnsupportedType:       "Tipo de archivo no admitido",
+		problem.UnsupportedACL:        "ACL no admitida",
+		problem.FileTooLarge:          "El archivo es demasiado grande",
+		problem.UploadNotIssued:       "La carga no fue emitida",
+		problem.UploadWindowClosed:    "El plazo de la carga ha terminado",
+		problem.ChecksumMismatch:      "Las sumas de verificación no coinciden",
+		problem.MalwareDetected:       "Se detectó malware",
+		problem.ModerationFlagged:     "La imagen fue marcada por la moderación",
+		problem.UnprocessableImage:    "No se pudo decodificar la imagen",
+		problem.TooManySizes:          "Demasiados tamaños",
+		problem.BadSizeName:           "Formato de nombre de tamaño incorrecto",
+		problem.DuplicateSizeName:     "Nombre de tamaño duplicado",
+		problem.BadSizeDimensions:     "Dimensiones de tamaño incorrectas",
+		problem.TooManyCallbacks:      "Demasiadas notificaciones",
+		problem.PublishedKeyFailed:    "No se pudo generar la clave publicada",
+		problem.DerivativeKeyFailed:   "No se pudo generar la clave derivada",
+		problem.AnimationTooLarge:     "La animación es demasiado grande",
+		problem.ImageTooLarge:         "La imagen es demasiado grande",
+		problem.OriginalTooLarge:      "El original es demasiado grande para servirlo",
+		problem.RateLimited:           "Demasiadas solicitudes, inténtelo más tarde.",
+		problem.Embargoed:             "La imagen aún no está disponible.",
+		problem.GeoRestricted:         "La imagen no está disponible en su región",
+		problem.CountryUnknown:        "La imagen no está disponible en su región",
+		problem.Hotlinked:             "La imagen no se puede insertar en este sitio",
+		problem.InvalidSignature:      "Firma no válida o caducada.",
+		problem.InvalidToken:          "Token no válido o caducado.",
+		problem.Unauthenticated:       "Se requiere autenticación.",
+		problem.SourceBusy:            "La imagen está ocupada, inténtelo de nuevo.",
+		problem.DerivativePending:     "La imagen se está generando, inténtelo de nuevo.",
+		problem.UploadRejected:        "No encontrado.",
+		problem.UnsupportedVersion:    "Versión de API no admitida",
+		problem.LoadShed:              "El servicio está reduciendo la carga, inténtelo de nuevo más tarde.",
+		problem.EncryptedFile:         "Los archivos cifrados solo se pueden descargar",
+	},
+	"fr": {
+		problem.PermissionDenied:      "Autorisation refusée.",
+		problem.NotFound:              "Introuvable.",
+		problem.MissingParameters:     "Paramètres manquants, impossible de traiter la requête",
+		problem.BadParameterFormat:    "Format de paramètre incorrect, impossible de traiter la requête",
+		problem.BadParameterValue:     "Valeur de paramètre incorrecte, impossible de traiter la requête",
+		problem.UnreadableParameter:   "Impossible de lire le format du paramètre, impossible de traiter la requête",
+		problem.SizeNotAllowed:        "Taille non autorisée, impossible de traiter la requête",
+		problem.BadWidth:              "Impossible de convertir la largeur en entier.",
+		problem.BadHeight:             "Impossible de convertir la hauteur en entier.",
+		problem.BadRequestBody:        "Corps de requête incorrect, doit être un objet JSON.",
+		problem.UnreadableRequestBody: "Impossible de lire le corps de la requête.",
+		problem.UnsupportedExtension:  "Extension non prise en charge",
+		problem.UnsupportedType:       "Type de fichier non pris en charge",
+		problem.UnsupportedACL:        "ACL non prise en charge",
+		problem.FileTooLarge:          "Le fichier est trop volumineux",
+		problem.UploadNotIssued:       "Le téléversement n'a pas été émis",
+		problem.UploadWindowClosed:    "Le délai du téléversement est écoulé",
+		problem.ChecksumMismatch:      "Les sommes de contrôle ne correspondent pas",
+		problem.MalwareDetected:       "Logiciel malveillant détecté",
+		problem.ModerationFlagged:     "L'image a été signalée par la modération",
+		problem.UnprocessableImage:    "L'image n'a pas pu être décodée",
+		problem.TooManySizes:          "Trop de tailles",
+		problem.BadSizeName:           "Format de nom de taille incorrect",
+		problem.DuplicateSizeName:     "Nom de taille en double",
+		problem.BadSizeDimensions:     "Dimensions de taille incorrectes",
+		problem.TooManyCallbacks:      "Trop de notifications",
+		problem.PublishedKeyFailed:    "Impossible de générer la clé publiée",
+		problem.DerivativeKeyFailed:   "Impossible de générer la clé dérivée",
+		problem.AnimationTooLarge:     "L'animation est trop volumineuse",
+		problem.ImageTooLarge:         "L'image est trop volumineuse",
+		problem.OriginalTooLarge:      "L'original est trop volumineux pour être servi",
+		problem.RateLimited:           "Trop de requêtes, réessayez plus tard.",
+		problem.Embargoed:             "L'image n'est pas encore disponible.",
+		problem.GeoRestricted:         "L'image n'est pas disponible dans votre région",
+		problem.CountryUnknown:        "L'image n'est pas disponible dans votre région",
+		problem.Hotlinked:             "L'image ne peut pas être intégrée sur ce site",
+		problem.InvalidSignature:      "Signature invalide ou expirée.",
+		problem.InvalidToken:          "Jeton invalide ou expiré.",
+		problem.Unauthenticated:       "Authentification requise.",
+		problem.SourceBusy:            "L'image est occupée, réessayez.",
+		problem.DerivativePending:     "L'image est en cours de génération, réessayez.",
+		problem.UploadRejected:        "Introuvable.",
+		problem.UnsupportedVersion:    "Version d'API non prise en charge",
+		problem.LoadShed:              "Le service réduit sa charge, réessayez plus tard.",
+		problem.EncryptedFile:         "Les fichiers chiffrés peuvent seulement être téléchargés",
+	},
+}
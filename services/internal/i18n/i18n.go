@@ -0,0 +1,59 @@
+// Package i18n localizes the error messages returned by the services, selecting a language from the request's
+// Accept-Language header; messages are identified by their problem code
+package i18n
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLanguage is the language of the messages in the code, used when no supported language is accepted
+const DefaultLanguage = "en"
+
+// Negotiate selects the supported language most preferred by an Accept-Language header, e.g. "fr-CA,fr;q=0.9"
+func Negotiate(acceptLanguage string) string {
+	type preference struct {
+		language string
+		quality  float64
+	}
+	var preferences []preference
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		language := strings.ToLower(strings.SplitN(strings.TrimSpace(fields[0]), "-", 2)[0])
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if _, ok := bundles[language]; ok && quality > 0 {
+			preferences = append(preferences, preference{language, quality})
+		}
+	}
+	if len(preferences) == 0 {
+		return DefaultLanguage
+	}
+	sort.SliceStable(preferences, func(i, j int) bool {
+		return preferences[i].quality > preferences[j].quality
+	})
+	return preferences[0].language
+}
+
+// Localize translates the message of a problem code to a language: the code's leading phrase, as written in the
+// code, is replaced by its translation, and any details following it (parameter names and values) are kept;
+// messages that do not start with their code's phrase are returned unchanged
+func Localize(language, code, message string) string {
+	phrase, ok := bundles[DefaultLanguage][code]
+	if !ok || !strings.HasPrefix(message, phrase) {
+		return message
+	}
+	translation, ok := bundles[language][code]
+	if !ok {
+		return message
+	}
+	return translation + message[len(phrase):]
+}
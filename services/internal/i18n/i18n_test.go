@@ -0,0 +1,59 @@
+package i18n
+
+import (
+	"testing"
+
+	"github.com/okebinda/internal/problem"
+)
+
+func TestBundlesComplete(t *testing.T) {
+	for language, bundle := range bundles {
+		for code := range bundles[DefaultLanguage] {
+			if _, ok := bundle[code]; !ok {
+				t.Errorf("bundle %s has no translation of %s", language, code)
+			}
+		}
+		for code := range bundle {
+			if _, ok := bundles[DefaultLanguage][code]; !ok {
+				t.Errorf("bundle %s translates unknown code %s", language, code)
+			}
+		}
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	tests := []struct {
+		name, language, code, message, want string
+	}{
+		{"default language", "en", problem.FileTooLarge, "File is too large: 7340032", "File is too large: 7340032"},
+		{"details kept", "fr", problem.FileTooLarge, "File is too large: 7340032", "Le fichier est trop volumineux: 7340032"},
+		{"shared phrase", "es", problem.UploadRejected, "Not found.", "No encontrado."},
+		{"message without the code's phrase", "fr", problem.FileTooLarge, "Not found.", "Not found."},
+		{"status code", "fr", problem.BadRequest, "Upload is not encrypted: a.png", "Upload is not encrypted: a.png"},
+		{"unsupported language", "de", problem.NotFound, "Not found.", "Not found."},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Localize(test.language, test.code, test.message); got != test.want {
+				t.Errorf("Localize() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		header, want string
+	}{
+		{"", "en"},
+		{"fr-CA,fr;q=0.9", "fr"},
+		{"de,es;q=0.5", "es"},
+		{"es;q=0.4,fr;q=0.8", "fr"},
+		{"fr;q=0", "en"},
+	}
+	for _, test := range tests {
+		if got := Negotiate(test.header); got != test.want {
+			t.Errorf("Negotiate(%q) = %q, want %q", test.header, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package imageproc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Animation summarizes the frames of a GIF: the number of frames, the pixels decoded to composite every frame
+// onto the canvas, and the total duration of one loop
+type Animation struct {
+	Frames   int
+	Pixels   int64
+	Duration time.Duration
+}
+
+// AnimationLimits defines the largest animations that may be processed; a limit of 0 is not checked
+type AnimationLimits struct {
+	Frames   int
+	Pixels   int64
+	Duration time.Duration
+}
+
+// AnimationLimitsFromEnv reads the animation limits from the ANIMATION_MAX_FRAMES (default 1000),
+// ANIMATION_MAX_PIXELS (default 200000000), and ANIMATION_MAX_DURATION_MS (default 120000) env parameters
+func AnimationLimitsFromEnv() (AnimationLimits, error) {
+	frames, err := envInt64("ANIMATION_MAX_FRAMES", 1000)
+	if err != nil {
+		return AnimationLimits{}, fmt.Errorf("could not convert ANIMATION_MAX_FRAMES to int: %v", err)
+	}
+	pixels, err := envInt64("ANIMATION_MAX_PIXELS", 200000000)
+	if err != nil {
+		return AnimationLimits{}, fmt.Errorf("could not convert ANIMATION_MAX_PIXELS to int: %v", err)
+	}
+	duration, err := envInt64("ANIMATION_MAX_DURATION_MS", 120000)
+	if err != nil {
+		return AnimationLimits{}, fmt.Errorf("could not convert ANIMATION_MAX_DURATION_MS to int: %v", err)
+	}
+	return AnimationLimits{
+		Frames:   int(frames),
+		Pixels:   pixels,
+		Duration: time.Duration(duration) * time.Millisecond,
+	}, nil
+}
+
+// Check returns an error describing the first limit an animation exceeds, or nil if it is within the limits
+func (l AnimationLimits) Check(a Animation) error {
+	switch {
+	case l.Frames > 0 && a.Frames > l.Frames:
+		return fmt.Errorf("too many frames: %d, maximum: %d", a.Frames, l.Frames)
+	case l.Pixels > 0 && a.Pixels > l.Pixels:
+		return fmt.Errorf("too many decoded pixels: %d, maximum: %d", a.Pixels, l.Pixels)
+	case l.Duration > 0 && a.Duration > l.Duration:
+		return fmt.Errorf("too long: %v, maximum: %v", a.Duration, l.Duration)
+	}
+	return nil
+}
+
+// ScanGIF reads the block structure of a GIF in a local file, without decoding any frames, so animations can be
+// checked against limits before they are opened
+func ScanGIF(localFile string) (Animation, error) {
+	var a Animation
+	file, err := os.Open(localFile)
+	if err != nil {
+		return a, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+
+	// header and logical screen descriptor
+	header := make([]byte, 13)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return a, err
+	}
+	if string(header[:3]) != "GIF" {
+		return a, fmt.Errorf("gif: not a GIF file")
+	}
+	canvas := int64(binary.LittleEndian.Uint16(header[6:8])) * int64(binary.LittleEndian.Uint16(header[8:10]))
+	if err = skipColorTable(r, header[10]); err != nil {
+		return a, err
+	}
+
+	for {
+		introducer, err := r.ReadByte()
+		if err != nil {
+			return a, err
+		}
+		switch introducer {
+
+		// extension; graphic control extensions hold the delay of the next frame, in hundredths of a second
+		case 0x21:
+			label, err := r.ReadByte()
+			if err != nil {
+				return a, err
+			}
+			first, err := readSubBlocks(r)
+			if err != nil {
+				return a, err
+			}
+			if label == 0xF9 && len(first) >= 3 {
+				a.Duration += time.Duration(binary.LittleEndian.Uint16(first[1:3])) * 10 * time.Millisecond
+			}
+
+		// image descriptor, optional local color table, LZW minimum code size, and image data
+		case 0x2C:
+			descriptor := make([]byte, 9)
+			if _, err = io.ReadFull(r, descriptor); err != nil {
+				return a, err
+			}
+			if err = skipColorTable(r, descriptor[8]); err != nil {
+				return a, err
+			}
+			if _, err = r.ReadByte(); err != nil {
+				return a, err
+			}
+			if _, err = readSubBlocks(r); err != nil {
+				return a, err
+			}
+			frameArea := int64(binary.LittleEndian.Uint16(descriptor[4:6])) * int64(binary.LittleEndian.Uint16(descriptor[6:8]))
+			if frameArea > canvas {
+				canvas = frameArea
+			}
+			a.Frames++
+			a.Pixels = int64(a.Frames) * canvas
+
+		// trailer
+		case 0x3B:
+			return a, nil
+
+		default:
+			return a, fmt.Errorf("gif: unknown block type: 0x%02x", introducer)
+		}
+	}
+}
+
+// skipColorTable skips the color table following a descriptor, if its packed fields flag one
+func skipColorTable(r *bufio.Reader, packed byte) error {
+	if packed&0x80 == 0 {
+		return nil
+	}
+	_, err := io.CopyN(ioutil.Discard, r, 3<<((packed&0x07)+1))
+	return err
+}
+
+// readSubBlocks reads a sequence of data sub-blocks up to its terminator, returning the first sub-block
+func readSubBlocks(r *bufio.Reader) ([]byte, error) {
+	var first []byte
+	for i := 0; ; i++ {
+		size, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return first, nil
+		}
+		block := make([]byte, size)
+		if _, err = io.ReadFull(r, block); err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			first = block
+		}
+	}
+}
+
+// envInt64 reads an int64 env parameter, or returns a default value if it is not set
+func envInt64(name string, defaultValue int64) (int64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
@@ -0,0 +1,177 @@
+package imageproc
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// resize target of every benchmark, a typical derivative size
+const (
+	targetWidth  = 400
+	targetHeight = 300
+)
+
+// benchmarkSizes are the source image sizes benchmarked, from a small upload to a camera photo
+var benchmarkSizes = []image.Point{{640, 480}, {1920, 1080}, {4000, 3000}}
+
+// sample is a source image encoded in one format, the input of a set of benchmarks
+type sample struct {
+	name     string
+	fileType string
+	file     string
+	img      image.Image
+}
+
+// samples are encoded once, in samplesDir, by the first benchmark run
+var (
+	samplesOnce sync.Once
+	samplesDir  string
+	samples     []sample
+	samplesErr  error
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if samplesDir != "" {
+		os.RemoveAll(samplesDir)
+	}
+	os.Exit(code)
+}
+
+// benchmarkSamples returns a synthetic source image of each benchmarked size in each compiled-in format
+func benchmarkSamples(b *testing.B) []sample {
+	b.Helper()
+	samplesOnce.Do(func() {
+		samplesDir, samplesErr = ioutil.TempDir("", "imageproc")
+		if samplesErr != nil {
+			return
+		}
+		fileTypes := ValidFormats()
+		sort.Strings(fileTypes)
+		for _, size := range benchmarkSizes {
+			img := syntheticImage(size.X, size.Y)
+			for _, fileType := range fileTypes {
+				extension := Extension(fileType)
+				s := sample{
+					name:     fmt.Sprintf("%s-%dx%d", strings.TrimPrefix(extension, "."), size.X, size.Y),
+					fileType: fileType,
+					file:     filepath.Join(samplesDir, fmt.Sprintf("source-%dx%d%s", size.X, size.Y, extension)),
+				}
+				if samplesErr = Save(img, s.file); samplesErr != nil {
+					return
+				}
+
+				// resize the image as decoded, e.g. paletted for GIFs
+				if s.img, samplesErr = OpenPage(s.file, s.fileType, 0); samplesErr != nil {
+					return
+				}
+				samples = append(samples, s)
+			}
+		}
+	})
+	if samplesErr != nil {
+		b.Fatalf("Could not generate samples: %v", samplesErr)
+	}
+	return samples
+}
+
+// syntheticImage draws a photo-like image: smooth gradients with noise, so it compresses like a real photo rather
+// than a flat color
+func syntheticImage(width, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	random := rand.New(rand.NewSource(1))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			noise := random.Intn(32)
+			img.SetNRGBA(x, y, color.NRGBA{
+				R: uint8((x*255/width + noise) % 256),
+				G: uint8((y*255/height + noise) % 256),
+				B: uint8(((x+y)*255/(width+height) + noise) % 256),
+				A: 255,
+			})
+		}
+	}
+	return img
+}
+
+// outputFile returns the file a sample's derivative is encoded to
+func outputFile(s sample) string {
+	return filepath.Join(samplesDir, "output-"+s.name+Extension(s.fileType))
+}
+
+func BenchmarkDecode(b *testing.B) {
+	for _, s := range benchmarkSamples(b) {
+		b.Run(s.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := OpenPage(s.file, s.fileType, 0); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkResize(b *testing.B) {
+	for _, s := range benchmarkSamples(b) {
+		b.Run(s.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				ResizeRatio(s.img, targetWidth, targetHeight)
+			}
+		})
+	}
+}
+
+func BenchmarkCrop(b *testing.B) {
+	img := syntheticImage(1920, 1080)
+	for _, gravity := range []string{GravityCenter, GravityEntropy, GravityAttention} {
+		b.Run(gravity, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				CropGravity(targetWidth, targetHeight, gravity)(img)
+			}
+		})
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	for _, s := range benchmarkSamples(b) {
+		resized := ResizeRatio(s.img, targetWidth, targetHeight)
+		b.Run(s.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := Save(resized, outputFile(s)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkPipeline decodes, resizes, and encodes each sample, as image-serve derives an image
+func BenchmarkPipeline(b *testing.B) {
+	for _, s := range benchmarkSamples(b) {
+		b.Run(s.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				img, err := OpenPage(s.file, s.fileType, 0)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err = Save(ResizeRatio(img, targetWidth, targetHeight), outputFile(s)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package imageproc
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Codec defines an image format supported for processing: its mime type, short name (used by the IMAGE_FORMATS
+// env parameter), file extension, the signatures used to detect files that http.DetectContentType does not
+// recognize, and its own decoder, tried if the imaging package cannot open a file; images are otherwise decoded
+// and encoded by the imaging package, which picks the encoder by file extension
+type Codec struct {
+	MimeType  string
+	Name      string
+	Extension string
+	Magic     [][]byte
+	Decode    func(io.Reader) (image.Image, error)
+}
+
+// codecs holds the registered codecs by mime type
+var codecs = map[string]Codec{}
+
+// Register adds a codec; each format is registered by its own codec file, selected by build tags, so deployments
+// only compile in the formats they need
+func Register(codec Codec) {
+	codecs[codec.MimeType] = codec
+}
+
+// ValidFormats returns the mime types supported for processing: the registered codecs, limited to those named
+// in the IMAGE_FORMATS env parameter (a comma separated list, e.g. "png,jpeg") if it is set
+func ValidFormats() []string {
+	var formats []string
+	for fileType := range codecs {
+		if IsValidFormat(fileType) {
+			formats = append(formats, fileType)
+		}
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// IsValidFormat tests if a mime type is supported for processing
+func IsValidFormat(fileType string) bool {
+	codec, ok := codecs[fileType]
+	if !ok {
+		return false
+	}
+	enabled := os.Getenv("IMAGE_FORMATS")
+	if enabled == "" {
+		return true
+	}
+	for _, name := range strings.Split(enabled, ",") {
+		if strings.TrimSpace(name) == codec.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// Extension returns the file extension for a mime type, or an empty string if it is not supported
+func Extension(fileType string) string {
+	if !IsValidFormat(fileType) {
+		return ""
+	}
+	return codecs[fileType].Extension
+}
+
+// FileType returns the mime type for a file extension (without the dot), matching a codec's extension or name,
+// and false if it is not supported
+func FileType(extension string) (string, bool) {
+	extension = strings.ToLower(extension)
+	for fileType, codec := range codecs {
+		if (codec.Extension == "."+extension || codec.Name == extension) && IsValidFormat(fileType) {
+			return fileType, true
+		}
+	}
+	return "", false
+}
+
+// detectRegistered matches the start of a file against the signatures of the registered codecs, returning the
+// mime type of the first match, or fileType if none match
+func detectRegistered(buff []byte, fileType string) string {
+	for _, codec := range codecs {
+		for _, magic := range codec.Magic {
+			if bytes.HasPrefix(buff, magic) {
+				return codec.MimeType
+			}
+		}
+	}
+	return fileType
+}
+
+// OpenTolerant opens an image from a local file like Open, falling back to the registered decoder for its mime
+// type, without orientation, if that fails; returns the first error if both fail
+func OpenTolerant(localFile, fileType string) (image.Image, error) {
+	img, err := Open(localFile)
+	if err == nil {
+		return img, nil
+	}
+	codec, ok := codecs[fileType]
+	if !ok || codec.Decode == nil {
+		return nil, err
+	}
+	file, openErr := os.Open(localFile)
+	if openErr != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if img, decodeErr := codec.Decode(file); decodeErr == nil {
+		return img, nil
+	}
+	return nil, err
+}
+
+// decode error classes
+const (
+	DecodeTruncated   = "truncated"
+	DecodeUnsupported = "unsupported_feature"
+	DecodeUnknown     = "unknown_format"
+	DecodeCorrupt     = "corrupt"
+)
+
+// DecodeErrorClass classifies an error decoding an image: truncated data, a format feature the decoder does not
+// support, an unrecognized format, or otherwise corrupt data
+func DecodeErrorClass(err error) string {
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF):
+		return DecodeTruncated
+	case errors.Is(err, image.ErrFormat):
+		return DecodeUnknown
+	case strings.Contains(err.Error(), "unsupported"):
+		return DecodeUnsupported
+	}
+	return DecodeCorrupt
+}
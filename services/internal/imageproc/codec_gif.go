@@ -0,0 +1,16 @@
+//go:build !no_gif
+// +build !no_gif
+
+package imageproc
+
+import "image/gif"
+
+// the gif codec is included unless built with the no_gif tag
+func init() {
+	Register(Codec{
+		MimeType:  "image/gif",
+		Name:      "gif",
+		Extension: ".gif",
+		Decode:    gif.Decode,
+	})
+}
@@ -0,0 +1,16 @@
+//go:build !no_jpeg
+// +build !no_jpeg
+
+package imageproc
+
+import "image/jpeg"
+
+// the jpeg codec is included unless built with the no_jpeg tag
+func init() {
+	Register(Codec{
+		MimeType:  "image/jpeg",
+		Name:      "jpeg",
+		Extension: ".jpg",
+		Decode:    jpeg.Decode,
+	})
+}
@@ -0,0 +1,16 @@
+//go:build !no_png
+// +build !no_png
+
+package imageproc
+
+import "image/png"
+
+// the png codec is included unless built with the no_png tag
+func init() {
+	Register(Codec{
+		MimeType:  "image/png",
+		Name:      "png",
+		Extension: ".png",
+		Decode:    png.Decode,
+	})
+}
@@ -0,0 +1,17 @@
+//go:build tiff
+// +build tiff
+
+package imageproc
+
+import "golang.org/x/image/tiff"
+
+// the tiff codec is only included when built with the tiff tag
+func init() {
+	Register(Codec{
+		MimeType:  "image/tiff",
+		Name:      "tiff",
+		Extension: ".tif",
+		Magic:     [][]byte{[]byte("II*\x00"), []byte("MM\x00*")},
+		Decode:    tiff.Decode,
+	})
+}
@@ -0,0 +1,76 @@
+package imageproc
+
+import (
+	"image"
+	"image/color"
+	"io"
+	"os"
+
+	"github.com/disintegration/imaging"
+)
+
+// color spaces of source images
+const (
+	ColorSpaceSRGB    = "srgb"
+	ColorSpaceGray    = "gray"
+	ColorSpaceCMYK    = "cmyk"
+	ColorSpaceIndexed = "indexed"
+)
+
+// ColorInfo describes the color space and bit depth (per channel) of an image's pixels
+type ColorInfo struct {
+	ColorSpace string
+	BitDepth   int
+}
+
+// DescribeColor returns the color space and bit depth of a color model, e.g. of a decoded image or its
+// image.Config; unrecognized models are described as 8-bit sRGB
+func DescribeColor(model color.Model) ColorInfo {
+	switch model {
+	case color.RGBA64Model, color.NRGBA64Model:
+		return ColorInfo{ColorSpaceSRGB, 16}
+	case color.GrayModel:
+		return ColorInfo{ColorSpaceGray, 8}
+	case color.Gray16Model:
+		return ColorInfo{ColorSpaceGray, 16}
+	case color.CMYKModel:
+		return ColorInfo{ColorSpaceCMYK, 8}
+	}
+	if _, ok := model.(color.Palette); ok {
+		return ColorInfo{ColorSpaceIndexed, 8}
+	}
+	return ColorInfo{ColorSpaceSRGB, 8}
+}
+
+// Normalized tests if pixels are already 8-bit sRGB (or indexed, e.g. GIFs), needing no conversion for output
+func (c ColorInfo) Normalized() bool {
+	return (c.ColorSpace == ColorSpaceSRGB || c.ColorSpace == ColorSpaceIndexed) && c.BitDepth == 8
+}
+
+// ReadColor reads the color space and bit depth of an image from its header
+func ReadColor(r io.Reader) (ColorInfo, error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return ColorInfo{}, err
+	}
+	return DescribeColor(config.ColorModel), nil
+}
+
+// FileColor reads the color space and bit depth of the given file
+func FileColor(file *os.File) (ColorInfo, error) {
+	info, err := ReadColor(file)
+	if _, seekErr := file.Seek(0, 0); seekErr != nil {
+		return ColorInfo{}, seekErr
+	}
+	return info, err
+}
+
+// Normalize converts an image of a source's color space and bit depth to 8-bit sRGB, returning it unchanged if it
+// is already normalized, and if it was converted; 16-bit channels are reduced to 8 bits, grayscale is expanded to
+// RGB, and CMYK is converted without a color profile
+func Normalize(img image.Image, info ColorInfo) (image.Image, bool) {
+	if info.Normalized() {
+		return img, false
+	}
+	return imaging.Clone(img), true
+}
@@ -0,0 +1,174 @@
+package imageproc
+
+import (
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// crop gravities
+const (
+	GravityCenter    = "center"
+	GravityNorth     = "north"
+	GravitySouth     = "south"
+	GravityEast      = "east"
+	GravityWest      = "west"
+	GravityNorthEast = "northeast"
+	GravityNorthWest = "northwest"
+	GravitySouthEast = "southeast"
+	GravitySouthWest = "southwest"
+	GravityEntropy   = "entropy"
+	GravityAttention = "attention"
+)
+
+// gravityAnchors maps fixed gravities to the imaging anchors they crop around
+var gravityAnchors = map[string]imaging.Anchor{
+	GravityCenter:    imaging.Center,
+	GravityNorth:     imaging.Top,
+	GravitySouth:     imaging.Bottom,
+	GravityEast:      imaging.Right,
+	GravityWest:      imaging.Left,
+	GravityNorthEast: imaging.TopRight,
+	GravityNorthWest: imaging.TopLeft,
+	GravitySouthEast: imaging.BottomRight,
+	GravitySouthWest: imaging.BottomLeft,
+}
+
+// cropCandidates is the number of crop windows, evenly spaced along the cropped axis, scored by content-aware
+// gravities
+const cropCandidates = 10
+
+// cropSampleStride is the distance in pixels between the samples used to score a crop window
+const cropSampleStride = 4
+
+// IsValidGravity tests if a crop gravity is supported
+func IsValidGravity(gravity string) bool {
+	_, ok := gravityAnchors[gravity]
+	return ok || gravity == GravityEntropy || gravity == GravityAttention
+}
+
+// CropGravity returns a transformation that resizes an image, cropping it to widthxheight around a gravity; the
+// entropy gravity keeps the most detailed window, and attention the window with the most edges and saturated
+// color (e.g. faces and subjects against plain backgrounds); content-aware windows are chosen from the first
+// image transformed, so every frame of an animation is cropped alike
+func CropGravity(width, height int, gravity string) func(image.Image) image.Image {
+	if anchor, ok := gravityAnchors[gravity]; ok {
+		return func(img image.Image) image.Image {
+			return imaging.Fill(img, width, height, anchor, imaging.Lanczos)
+		}
+	}
+	score := entropy
+	if gravity == GravityAttention {
+		score = attention
+	}
+	var window *image.Rectangle
+	return func(img image.Image) image.Image {
+		scaled := cover(img, width, height)
+		if window == nil {
+			best := bestWindow(scaled, width, height, score)
+			window = &best
+		}
+		return imaging.Crop(scaled, *window)
+	}
+}
+
+// CropFocalPoint returns a transformation that resizes an image, cropping it to widthxheight centered as closely
+// as possible on a focal point, given as fractions (0-1) of the image's width and height
+func CropFocalPoint(width, height int, x, y float64) func(image.Image) image.Image {
+	return func(img image.Image) image.Image {
+		scaled := cover(img, width, height)
+		scaledWidth, scaledHeight := Dimensions(scaled)
+		left := clamp(int(x*float64(scaledWidth))-width/2, 0, scaledWidth-width)
+		top := clamp(int(y*float64(scaledHeight))-height/2, 0, scaledHeight-height)
+		return imaging.Crop(scaled, image.Rect(left, top, left+width, top+height))
+	}
+}
+
+// cover resizes an image to the smallest size that covers widthxheight, maintaining its aspect ratio
+func cover(img image.Image, width, height int) image.Image {
+	srcWidth, srcHeight := Dimensions(img)
+	ratio := math.Max(float64(width)/float64(srcWidth), float64(height)/float64(srcHeight))
+	newWidth := int(math.Max(math.Ceil(float64(srcWidth)*ratio), float64(width)))
+	newHeight := int(math.Max(math.Ceil(float64(srcHeight)*ratio), float64(height)))
+	return imaging.Resize(img, newWidth, newHeight, imaging.Lanczos)
+}
+
+// bestWindow slides a widthxheight window along the cropped axis of an image, returning the highest scoring one
+func bestWindow(img image.Image, width, height int, score func(*image.NRGBA, image.Rectangle) float64) image.Rectangle {
+	nrgba := imaging.Clone(img)
+	excessX := nrgba.Rect.Dx() - width
+	excessY := nrgba.Rect.Dy() - height
+	best := image.Rect(excessX/2, excessY/2, excessX/2+width, excessY/2+height)
+	bestScore := math.Inf(-1)
+	for i := 0; i <= cropCandidates; i++ {
+		left := excessX * i / cropCandidates
+		top := excessY * i / cropCandidates
+		window := image.Rect(left, top, left+width, top+height)
+		if s := score(nrgba, window); s > bestScore {
+			best, bestScore = window, s
+		}
+	}
+	return best
+}
+
+// entropy scores a window of an image by the Shannon entropy of its luminance histogram
+func entropy(img *image.NRGBA, window image.Rectangle) float64 {
+	var histogram [256]float64
+	total := 0.0
+	for y := window.Min.Y; y < window.Max.Y; y += cropSampleStride {
+		for x := window.Min.X; x < window.Max.X; x += cropSampleStride {
+			histogram[int(luminance(img, x, y))]++
+			total++
+		}
+	}
+	result := 0.0
+	for _, count := range histogram {
+		if count > 0 {
+			p := count / total
+			result -= p * math.Log2(p)
+		}
+	}
+	return result
+}
+
+// attention scores a window of an image by the strength of its edges and the saturation of its colors
+func attention(img *image.NRGBA, window image.Rectangle) float64 {
+	result := 0.0
+	for y := window.Min.Y; y < window.Max.Y-cropSampleStride; y += cropSampleStride {
+		for x := window.Min.X; x < window.Max.X-cropSampleStride; x += cropSampleStride {
+			l := luminance(img, x, y)
+			edge := math.Abs(luminance(img, x+cropSampleStride, y)-l) + math.Abs(luminance(img, x, y+cropSampleStride)-l)
+			result += edge + saturation(img, x, y)/2
+		}
+	}
+	return result
+}
+
+// luminance returns the luminance (0-255) of a pixel
+func luminance(img *image.NRGBA, x, y int) float64 {
+	i := img.PixOffset(x, y)
+	return 0.299*float64(img.Pix[i]) + 0.587*float64(img.Pix[i+1]) + 0.114*float64(img.Pix[i+2])
+}
+
+// saturation returns the saturation (0-255) of a pixel
+func saturation(img *image.NRGBA, x, y int) float64 {
+	i := img.PixOffset(x, y)
+	r, g, b := float64(img.Pix[i]), float64(img.Pix[i+1]), float64(img.Pix[i+2])
+	max := math.Max(r, math.Max(g, b))
+	if max == 0 {
+		return 0
+	}
+	return (max - math.Min(r, math.Min(g, b))) / max * 255
+}
+
+// clamp limits an int to the range min-max
+func clamp(n, min, max int) int {
+	if n < min {
+		return min
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
@@ -0,0 +1,331 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+	"time"
+)
+
+// ErrNoEXIF is returned when an image has no EXIF metadata, e.g. it is not a JPEG or its metadata was stripped
+var ErrNoEXIF = errors.New("no EXIF metadata")
+
+// EXIF defines the capture metadata read from an image's EXIF tags; tags that are missing are left empty
+type EXIF struct {
+	Make         string
+	Model        string
+	LensModel    string
+	Orientation  int
+	ExposureTime string
+	FNumber      float64
+	ISO          int
+	FocalLength  float64
+	TakenAt      string
+	GPS          *GPS
+}
+
+// GPS defines the location an image was captured at, in decimal degrees, and the altitude in meters, if known
+type GPS struct {
+	Latitude  float64
+	Longitude float64
+	Altitude  *float64
+}
+
+// EXIF tags read, from the image (IFD0), Exif, and GPS IFDs
+const (
+	tagMake               = 0x010f
+	tagModel              = 0x0110
+	tagOrientation        = 0x0112
+	tagExifIFD            = 0x8769
+	tagGPSIFD             = 0x8825
+	tagExposureTime       = 0x829a
+	tagFNumber            = 0x829d
+	tagISO                = 0x8827
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+	tagFocalLength        = 0x920a
+	tagLensModel          = 0xa434
+	tagGPSLatitudeRef     = 0x0001
+	tagGPSLatitude        = 0x0002
+	tagGPSLongitudeRef    = 0x0003
+	tagGPSLongitude       = 0x0004
+	tagGPSAltitudeRef     = 0x0005
+	tagGPSAltitude        = 0x0006
+)
+
+// exifTypeSizes maps EXIF field types to the size of one value in bytes
+var exifTypeSizes = map[uint16]uint32{
+	1:  1, // BYTE
+	2:  1, // ASCII
+	3:  2, // SHORT
+	4:  4, // LONG
+	5:  8, // RATIONAL
+	7:  1, // UNDEFINED
+	9:  4, // SLONG
+	10: 8, // SRATIONAL
+}
+
+// exifField defines a field of an IFD: its type, number of values, and the bytes of its values
+type exifField struct {
+	kind  uint16
+	count uint32
+	value []byte
+}
+
+// ReadEXIF reads the capture metadata from the EXIF (APP1) segment of a JPEG image; returns ErrNoEXIF if there is
+// none, or an error if it is malformed
+func ReadEXIF(r io.Reader) (EXIF, error) {
+	tiff, err := readEXIFSegment(r)
+	if err != nil {
+		return EXIF{}, err
+	}
+
+	// read the byte order and the first IFD
+	if len(tiff) < 8 {
+		return EXIF{}, fmt.Errorf("EXIF header too short")
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "MM":
+		order = binary.BigEndian
+	case "II":
+		order = binary.LittleEndian
+	default:
+		return EXIF{}, fmt.Errorf("unknown EXIF byte order: %x", tiff[:2])
+	}
+	if order.Uint16(tiff[2:4]) != 42 {
+		return EXIF{}, fmt.Errorf("bad EXIF header")
+	}
+	ifd0, err := readIFD(tiff, order, order.Uint32(tiff[4:8]))
+	if err != nil {
+		return EXIF{}, err
+	}
+
+	exif := EXIF{
+		Make:        ifd0.string(tagMake),
+		Model:       ifd0.string(tagModel),
+		Orientation: ifd0.int(order, tagOrientation),
+	}
+	if exif.Orientation < 1 || exif.Orientation > 8 {
+		exif.Orientation = OrientationUnspecified
+	}
+
+	// read the exposure from the Exif IFD
+	if offset := ifd0.int(order, tagExifIFD); offset > 0 {
+		sub, err := readIFD(tiff, order, uint32(offset))
+		if err != nil {
+			return EXIF{}, err
+		}
+		exif.LensModel = sub.string(tagLensModel)
+		exif.ExposureTime = formatExposureTime(sub.rational(order, tagExposureTime, 0))
+		exif.FNumber = round(sub.rational(order, tagFNumber, 0), 1)
+		exif.ISO = sub.int(order, tagISO)
+		exif.FocalLength = round(sub.rational(order, tagFocalLength, 0), 1)
+		exif.TakenAt = formatTakenAt(sub.string(tagDateTimeOriginal), sub.string(tagOffsetTimeOriginal))
+	}
+
+	// read the location from the GPS IFD
+	if offset := ifd0.int(order, tagGPSIFD); offset > 0 {
+		sub, err := readIFD(tiff, order, uint32(offset))
+		if err != nil {
+			return EXIF{}, err
+		}
+		if _, ok := sub[tagGPSLatitude]; ok {
+			if _, ok = sub[tagGPSLongitude]; ok {
+				exif.GPS = &GPS{
+					Latitude:  gpsDegrees(sub, order, tagGPSLatitude, sub.string(tagGPSLatitudeRef) == "S"),
+					Longitude: gpsDegrees(sub, order, tagGPSLongitude, sub.string(tagGPSLongitudeRef) == "W"),
+				}
+				if _, ok = sub[tagGPSAltitude]; ok {
+					altitude := round(sub.rational(order, tagGPSAltitude, 0), 1)
+					if sub.int(order, tagGPSAltitudeRef) == 1 {
+						altitude = -altitude
+					}
+					exif.GPS.Altitude = &altitude
+				}
+			}
+		}
+	}
+
+	return exif, nil
+}
+
+// readEXIFSegment finds the EXIF APP1 segment of a JPEG image, returning its TIFF data (after the "Exif" header)
+func readEXIFSegment(r io.Reader) ([]byte, error) {
+	const (
+		markerSOI  = 0xffd8
+		markerAPP1 = 0xffe1
+		markerSOS  = 0xffda
+		markerEOI  = 0xffd9
+	)
+
+	// check for the JPEG SOI marker
+	var soi uint16
+	if err := binary.Read(r, binary.BigEndian, &soi); err != nil || soi != markerSOI {
+		return nil, ErrNoEXIF
+	}
+
+	// read segments until the image data, which metadata precedes
+	for {
+		var marker, size uint16
+		if err := binary.Read(r, binary.BigEndian, &marker); err != nil {
+			return nil, ErrNoEXIF
+		}
+		if marker>>8 != 0xff || marker == markerSOS || marker == markerEOI {
+			return nil, ErrNoEXIF
+		}
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil || size < 2 {
+			return nil, ErrNoEXIF
+		}
+		if marker != markerAPP1 {
+			if _, err := io.CopyN(ioutil.Discard, r, int64(size-2)); err != nil {
+				return nil, ErrNoEXIF
+			}
+			continue
+		}
+
+		// APP1 segments hold EXIF or XMP metadata
+		segment := make([]byte, size-2)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return nil, ErrNoEXIF
+		}
+		if bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:], nil
+		}
+	}
+}
+
+// exifIFD defines the fields of an IFD by tag
+type exifIFD map[uint16]exifField
+
+// readIFD reads the fields of the IFD at an offset in TIFF data
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32) (exifIFD, error) {
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return nil, fmt.Errorf("EXIF IFD out of bounds")
+	}
+	numFields := uint32(order.Uint16(tiff[offset:]))
+	start := offset + 2
+	if uint64(start)+uint64(numFields)*12 > uint64(len(tiff)) {
+		return nil, fmt.Errorf("EXIF IFD out of bounds")
+	}
+
+	ifd := exifIFD{}
+	for i := uint32(0); i < numFields; i++ {
+		entry := tiff[start+i*12 : start+i*12+12]
+		field := exifField{
+			kind:  order.Uint16(entry[2:4]),
+			count: order.Uint32(entry[4:8]),
+		}
+		size, ok := exifTypeSizes[field.kind]
+		if !ok {
+			continue
+		}
+		length := uint64(size) * uint64(field.count)
+
+		// values of up to 4 bytes are stored in the entry itself, others at an offset
+		if length <= 4 {
+			field.value = entry[8 : 8+length]
+		} else {
+			valueOffset := uint64(order.Uint32(entry[8:12]))
+			if valueOffset+length > uint64(len(tiff)) {
+				continue
+			}
+			field.value = tiff[valueOffset : valueOffset+length]
+		}
+		ifd[order.Uint16(entry[0:2])] = field
+	}
+	return ifd, nil
+}
+
+// string reads an ASCII field, without its NUL terminator and padding
+func (ifd exifIFD) string(tag uint16) string {
+	field, ok := ifd[tag]
+	if !ok || field.kind != 2 {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(field.value), "\x00"))
+}
+
+// int reads the first value of a BYTE, SHORT, or LONG field, or 0 if it is missing
+func (ifd exifIFD) int(order binary.ByteOrder, tag uint16) int {
+	field, ok := ifd[tag]
+	if !ok || field.count < 1 {
+		return 0
+	}
+	switch field.kind {
+	case 1:
+		return int(field.value[0])
+	case 3:
+		return int(order.Uint16(field.value))
+	case 4:
+		return int(order.Uint32(field.value))
+	}
+	return 0
+}
+
+// rational reads a value of a RATIONAL or SRATIONAL field, or 0 if it is missing or undefined
+func (ifd exifIFD) rational(order binary.ByteOrder, tag uint16, i uint32) float64 {
+	field, ok := ifd[tag]
+	if !ok || i >= field.count || (field.kind != 5 && field.kind != 10) {
+		return 0
+	}
+	value := field.value[i*8:]
+	if field.kind == 10 {
+		num, den := int32(order.Uint32(value[0:4])), int32(order.Uint32(value[4:8]))
+		if den == 0 {
+			return 0
+		}
+		return float64(num) / float64(den)
+	}
+	num, den := order.Uint32(value[0:4]), order.Uint32(value[4:8])
+	if den == 0 {
+		return 0
+	}
+	return float64(num) / float64(den)
+}
+
+// gpsDegrees converts a GPS coordinate field, in degrees, minutes, and seconds, to decimal degrees, negated for
+// the south and west
+func gpsDegrees(ifd exifIFD, order binary.ByteOrder, tag uint16, negative bool) float64 {
+	degrees := ifd.rational(order, tag, 0) + ifd.rational(order, tag, 1)/60 + ifd.rational(order, tag, 2)/3600
+	if negative {
+		degrees = -degrees
+	}
+	return round(degrees, 6)
+}
+
+// formatExposureTime formats an exposure time in seconds as photographers write it, e.g. "1/250" or "2"
+func formatExposureTime(seconds float64) string {
+	switch {
+	case seconds <= 0:
+		return ""
+	case seconds < 1:
+		return fmt.Sprintf("1/%d", int(math.Round(1/seconds)))
+	}
+	return fmt.Sprintf("%g", round(seconds, 1))
+}
+
+// formatTakenAt converts an EXIF date and time, e.g. "2006:01:02 15:04:05", and its UTC offset, if any, to
+// RFC 3339; times without an offset are local to the camera, and are formatted without a zone
+func formatTakenAt(dateTime, offset string) string {
+	taken, err := time.Parse("2006:01:02 15:04:05", dateTime)
+	if err != nil {
+		return ""
+	}
+	if zone, err := time.Parse("-07:00", offset); err == nil {
+		return time.Date(taken.Year(), taken.Month(), taken.Day(), taken.Hour(), taken.Minute(), taken.Second(), 0,
+			zone.Location()).Format(time.RFC3339)
+	}
+	return taken.Format("2006-01-02T15:04:05")
+}
+
+// round rounds a value to a number of decimal places
+func round(value float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(value*scale) / scale
+}
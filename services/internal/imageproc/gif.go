@@ -0,0 +1,121 @@
+package imageproc
+
+import (
+	"image"
+	"image/draw"
+	"image/gif"
+	"os"
+)
+
+// IsGIF tests if a mime type is a GIF, which must be processed frame by frame to preserve animation
+func IsGIF(fileType string) bool {
+	return fileType == "image/gif"
+}
+
+// OpenGIF opens every frame of an animated or still GIF from a local file
+func OpenGIF(localFile string) (*gif.GIF, error) {
+	file, err := os.Open(localFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return gif.DecodeAll(file)
+}
+
+// SaveGIF saves every frame of a GIF to a local file
+func SaveGIF(g *gif.GIF, localFile string) error {
+	file, err := os.Create(localFile)
+	if err != nil {
+		return err
+	}
+	if err = gif.EncodeAll(file, g); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// TransformGIF applies a transformation, e.g. a resize, to every frame of a GIF; frames are composited onto the
+// canvas first, following their disposal methods, so partial frames are transformed consistently, and each
+// transformed frame is stored whole, re-quantized to its original palette
+func TransformGIF(g *gif.GIF, transform func(image.Image) image.Image) *gif.GIF {
+	result := &gif.GIF{LoopCount: g.LoopCount}
+	compositeGIF(g, func(i int, canvas *image.RGBA) bool {
+		transformed := transform(canvas)
+		paletted := image.NewPaletted(image.Rect(0, 0, transformed.Bounds().Dx(), transformed.Bounds().Dy()), g.Image[i].Palette)
+		draw.FloydSteinberg.Draw(paletted, paletted.Rect, transformed, transformed.Bounds().Min)
+
+		result.Image = append(result.Image, paletted)
+		result.Delay = append(result.Delay, delay(g, i))
+		result.Disposal = append(result.Disposal, gif.DisposalBackground)
+		return true
+	})
+	return result
+}
+
+// GIFFrame returns a copy of a frame of a GIF (0-based), composited onto the canvas
+func GIFFrame(g *gif.GIF, index int) image.Image {
+	var frame *image.RGBA
+	compositeGIF(g, func(i int, canvas *image.RGBA) bool {
+		if i < index {
+			return true
+		}
+		frame = image.NewRGBA(canvas.Rect)
+		copy(frame.Pix, canvas.Pix)
+		return false
+	})
+	return frame
+}
+
+// compositeGIF composites each frame of a GIF onto its canvas in turn, following their disposal methods, calling
+// visit with the frame index and the composited canvas until it returns false
+func compositeGIF(g *gif.GIF, visit func(int, *image.RGBA) bool) {
+	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	if canvas.Rect.Empty() && len(g.Image) > 0 {
+		canvas = image.NewRGBA(g.Image[0].Bounds())
+	}
+
+	for i, frame := range g.Image {
+
+		// composite frame onto canvas
+		var previous *image.RGBA
+		disposal := byte(0)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(canvas.Rect)
+			copy(previous.Pix, canvas.Pix)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		if !visit(i, canvas) {
+			return
+		}
+
+		// dispose of frame
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			copy(canvas.Pix, previous.Pix)
+		}
+	}
+}
+
+// TransformGIFFile applies a transformation to every frame of a GIF in a local file, saving the result to the file
+func TransformGIFFile(localFile string, transform func(image.Image) image.Image) error {
+	g, err := OpenGIF(localFile)
+	if err != nil {
+		return err
+	}
+	return SaveGIF(TransformGIF(g, transform), localFile)
+}
+
+// delay returns the delay of a GIF frame, or 0 if not set
+func delay(g *gif.GIF, i int) int {
+	if i < len(g.Delay) {
+		return g.Delay[i]
+	}
+	return 0
+}
@@ -0,0 +1,37 @@
+package imageproc
+
+import (
+	"image"
+	"math/bits"
+
+	"github.com/disintegration/imaging"
+)
+
+// hashSize is the width and height in pixels images are reduced to for hashing, one bit per pixel
+const hashSize = 8
+
+// AverageHash computes the perceptual average hash of an image: the image is reduced to 8x8 grayscale pixels, and
+// each bit is set if its pixel is brighter than the mean. Similar images, e.g. resized or recompressed copies,
+// have hashes a small Hamming distance apart
+func AverageHash(img image.Image) uint64 {
+	small := imaging.Grayscale(imaging.Resize(img, hashSize, hashSize, imaging.Box))
+
+	var total int
+	for i := 0; i < hashSize*hashSize; i++ {
+		total += int(small.Pix[i*4])
+	}
+	mean := total / (hashSize * hashSize)
+
+	var hash uint64
+	for i := 0; i < hashSize*hashSize; i++ {
+		if int(small.Pix[i*4]) > mean {
+			hash |= 1 << uint(hashSize*hashSize-1-i)
+		}
+	}
+	return hash
+}
+
+// HammingDistance counts the bits that differ between two hashes, from 0 (identical) to 64
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
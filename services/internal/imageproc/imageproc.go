@@ -0,0 +1,166 @@
+// Package imageproc provides the image detection and resize operations shared by the services
+package imageproc
+
+import (
+	"image"
+	"io"
+	"math"
+	"net/http"
+	"os"
+
+	"github.com/disintegration/imaging"
+)
+
+// GetFileType detects the mime type of the given file
+func GetFileType(file *os.File) (string, error) {
+	buff := make([]byte, 512)
+	n, err := file.Read(buff)
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		return "", err
+	}
+	return DetectFileType(buff[:n]), nil
+}
+
+// DetectFileType detects the mime type of a file from its first bytes, up to 512 of which are considered
+func DetectFileType(buff []byte) string {
+	fileType := http.DetectContentType(buff)
+	if fileType == "application/octet-stream" {
+		fileType = detectRegistered(buff, fileType)
+	}
+	return fileType
+}
+
+// Open opens an image from a local file, rotating and flipping it to match its EXIF orientation
+func Open(localFile string) (image.Image, error) {
+	return imaging.Open(localFile, imaging.AutoOrientation(true))
+}
+
+// Decode decodes an image from a reader, rotating and flipping it to match its EXIF orientation
+func Decode(r io.Reader) (image.Image, error) {
+	return imaging.Decode(r, imaging.AutoOrientation(true))
+}
+
+// Save saves an image to a local file, encoding it by the file extension
+func Save(img image.Image, localFile string) error {
+	return imaging.Save(img, localFile)
+}
+
+// SaveQuality saves an image to a local file, encoding it by the file extension, with a JPEG quality (1-100);
+// DefaultQuality is used if it is 0
+func SaveQuality(img image.Image, localFile string, quality int) error {
+	if quality == 0 {
+		return Save(img, localFile)
+	}
+	return imaging.Save(img, localFile, imaging.JPEGQuality(quality))
+}
+
+// Dimensions returns the width and height of an image
+func Dimensions(img image.Image) (int, int) {
+	return img.Bounds().Dx(), img.Bounds().Dy()
+}
+
+// Fits tests if widthxheight fits within maxWidth and maxHeight, where a maximum of 0 leaves that axis
+// unconstrained
+func Fits(width, height, maxWidth, maxHeight int) bool {
+	return (maxWidth == 0 || width <= maxWidth) && (maxHeight == 0 || height <= maxHeight)
+}
+
+// ResizeToFit shrinks an image to fit within maxWidth and maxHeight, preserving its aspect ratio, where a
+// maximum of 0 leaves that axis unconstrained; the image is returned unchanged, with false, if it already fits
+func ResizeToFit(img image.Image, maxWidth, maxHeight int) (image.Image, bool) {
+	width, height := Dimensions(img)
+	if Fits(width, height, maxWidth, maxHeight) {
+		return img, false
+	}
+	switch {
+	case maxWidth == 0:
+		return ResizeHeight(img, maxHeight), true
+	case maxHeight == 0:
+		return ResizeWidth(img, maxWidth), true
+	}
+	return ResizeRatio(img, maxWidth, maxHeight), true
+}
+
+// ResizeRatio resizes an image to fit widthxheight, maintaining its aspect ratio
+func ResizeRatio(img image.Image, width, height int) image.Image {
+	srcWidth, srcHeight := Dimensions(img)
+
+	ratioX := float64(width) / float64(srcWidth)
+	ratioY := float64(height) / float64(srcHeight)
+	ratio := math.Min(ratioX, ratioY)
+
+	newWidth := int(float64(srcWidth) * ratio)
+	newHeight := int(float64(srcHeight) * ratio)
+
+	return imaging.Resize(img, newWidth, newHeight, imaging.Lanczos)
+}
+
+// ResizeWidth resizes an image to width, scaling its height to maintain its aspect ratio
+func ResizeWidth(img image.Image, width int) image.Image {
+	return imaging.Resize(img, width, 0, imaging.Lanczos)
+}
+
+// ResizeHeight resizes an image to height, scaling its width to maintain its aspect ratio
+func ResizeHeight(img image.Image, height int) image.Image {
+	return imaging.Resize(img, 0, height, imaging.Lanczos)
+}
+
+// IsValidRotation tests if an angle, in degrees, is supported for rotation
+func IsValidRotation(degrees int) bool {
+	return degrees == 90 || degrees == 180 || degrees == 270
+}
+
+// Rotate rotates an image counter-clockwise by 90, 180, or 270 degrees
+func Rotate(img image.Image, degrees int) image.Image {
+	switch degrees {
+	case 90:
+		return imaging.Rotate90(img)
+	case 180:
+		return imaging.Rotate180(img)
+	case 270:
+		return imaging.Rotate270(img)
+	}
+	return img
+}
+
+// IsValidFlip tests if a direction is supported for flipping
+func IsValidFlip(direction string) bool {
+	return direction == "horizontal" || direction == "vertical"
+}
+
+// Flip mirrors an image horizontally (left to right) or vertically (top to bottom)
+func Flip(img image.Image, direction string) image.Image {
+	switch direction {
+	case "horizontal":
+		return imaging.FlipH(img)
+	case "vertical":
+		return imaging.FlipV(img)
+	}
+	return img
+}
+
+// Watermark overlays a watermark image, scaled to half the image's width, on the center of an image at an
+// opacity (0-1)
+func Watermark(img, watermark image.Image, opacity float64) image.Image {
+	width, _ := Dimensions(img)
+	if width > 1 {
+		watermark = imaging.Resize(watermark, width/2, 0, imaging.Lanczos)
+	}
+	return imaging.OverlayCenter(img, watermark, opacity)
+}
+
+// ResizeCrop resizes an image, cropping to widthxheight
+func ResizeCrop(img image.Image, width, height int) image.Image {
+	return imaging.Fill(img, width, height, imaging.Center, imaging.Lanczos)
+}
+
+// Min returns the lesser of two ints
+func Min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
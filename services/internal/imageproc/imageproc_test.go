@@ -0,0 +1,202 @@
+package imageproc
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// testImage returns an image of widthxheight
+func testImage(width, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.NRGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	return img
+}
+
+// encoded returns an image encoded as a mime type
+func encoded(t *testing.T, fileType string) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	var err error
+	switch fileType {
+	case "image/png":
+		err = png.Encode(&buffer, testImage(8, 8))
+	case "image/jpeg":
+		err = jpeg.Encode(&buffer, testImage(8, 8), nil)
+	case "image/gif":
+		err = gif.Encode(&buffer, testImage(8, 8), nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+// requireCodec skips a test if the codec of a mime type is not registered by the build tags
+func requireCodec(t *testing.T, fileType string) {
+	t.Helper()
+	if _, ok := codecs[fileType]; !ok {
+		t.Skipf("%s codec not built", fileType)
+	}
+}
+
+func TestGetFileType(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+		want    string
+	}{
+		{"png", encoded(t, "image/png"), "image/png"},
+		{"jpeg", encoded(t, "image/jpeg"), "image/jpeg"},
+		{"gif", encoded(t, "image/gif"), "image/gif"},
+		{"text", []byte("hello, world"), "text/plain; charset=utf-8"},
+		{"unknown binary", []byte{0x00, 0x01, 0x02, 0x03}, "application/octet-stream"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file, err := ioutil.TempFile("", "imageproc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(file.Name())
+			defer file.Close()
+			if _, err = file.Write(test.content); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = file.Seek(0, 0); err != nil {
+				t.Fatal(err)
+			}
+
+			fileType, err := GetFileType(file)
+			if err != nil || fileType != test.want {
+				t.Errorf("GetFileType() = %q, %v, want %q", fileType, err, test.want)
+			}
+
+			// the file is rewound to be read again
+			if offset, _ := file.Seek(0, 1); offset != 0 {
+				t.Errorf("GetFileType() left the file at offset %d", offset)
+			}
+		})
+	}
+}
+
+func TestDetectFileTypeTIFF(t *testing.T) {
+	requireCodec(t, "image/tiff")
+	for _, magic := range []string{"II*\x00", "MM\x00*"} {
+		if fileType := DetectFileType([]byte(magic + "rest of file")); fileType != "image/tiff" {
+			t.Errorf("DetectFileType(%q) = %q, want image/tiff", magic, fileType)
+		}
+	}
+}
+
+func TestIsValidFormat(t *testing.T) {
+	tests := []struct {
+		name, fileType, formats string
+		want                    bool
+	}{
+		{"all enabled", "image/png", "", true},
+		{"enabled", "image/png", "jpeg, png", true},
+		{"not enabled", "image/gif", "jpeg,png", false},
+		{"no codec", "image/webp", "", false},
+		{"not an image", "text/plain; charset=utf-8", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.want {
+				requireCodec(t, test.fileType)
+			}
+			os.Setenv("IMAGE_FORMATS", test.formats)
+			defer os.Unsetenv("IMAGE_FORMATS")
+			if got := IsValidFormat(test.fileType); got != test.want {
+				t.Errorf("IsValidFormat(%q) = %v, want %v", test.fileType, got, test.want)
+			}
+		})
+	}
+}
+
+func TestResize(t *testing.T) {
+	tests := []struct {
+		name                  string
+		resize                func(image.Image) image.Image
+		width, height         int
+		wantWidth, wantHeight int
+	}{
+		{"ratio landscape", func(img image.Image) image.Image { return ResizeRatio(img, 100, 100) }, 400, 200, 100, 50},
+		{"ratio portrait", func(img image.Image) image.Image { return ResizeRatio(img, 100, 100) }, 200, 400, 50, 100},
+		{"ratio enlarges", func(img image.Image) image.Image { return ResizeRatio(img, 100, 100) }, 20, 10, 100, 50},
+		{"width", func(img image.Image) image.Image { return ResizeWidth(img, 100) }, 400, 200, 100, 50},
+		{"height", func(img image.Image) image.Image { return ResizeHeight(img, 100) }, 400, 200, 200, 100},
+		{"crop", func(img image.Image) image.Image { return ResizeCrop(img, 100, 100) }, 400, 200, 100, 100},
+		{"rotate", func(img image.Image) image.Image { return Rotate(img, 90) }, 400, 200, 200, 400},
+		{"flip", func(img image.Image) image.Image { return Flip(img, "horizontal") }, 400, 200, 400, 200},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			width, height := Dimensions(test.resize(testImage(test.width, test.height)))
+			if width != test.wantWidth || height != test.wantHeight {
+				t.Errorf("Dimensions() = %dx%d, want %dx%d", width, height, test.wantWidth, test.wantHeight)
+			}
+		})
+	}
+}
+
+func TestResizeToFit(t *testing.T) {
+	tests := []struct {
+		name                  string
+		width, height         int
+		maxWidth, maxHeight   int
+		wantWidth, wantHeight int
+		wantResized           bool
+	}{
+		{"fits", 100, 50, 200, 200, 100, 50, false},
+		{"exactly fits", 200, 200, 200, 200, 200, 200, false},
+		{"too wide", 400, 200, 200, 200, 200, 100, true},
+		{"too tall", 200, 400, 200, 200, 100, 200, true},
+		{"width only", 400, 200, 100, 0, 100, 50, true},
+		{"height only", 400, 2000, 0, 1000, 200, 1000, true},
+		{"unconstrained", 4000, 2000, 0, 0, 4000, 2000, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img, resized := ResizeToFit(testImage(test.width, test.height), test.maxWidth, test.maxHeight)
+			width, height := Dimensions(img)
+			if width != test.wantWidth || height != test.wantHeight || resized != test.wantResized {
+				t.Errorf("ResizeToFit() = %dx%d, %v, want %dx%d, %v", width, height, resized, test.wantWidth, test.wantHeight, test.wantResized)
+			}
+		})
+	}
+}
+
+func TestPlaceholder(t *testing.T) {
+	p := NewPlaceholder(testImage(40, 20), 400, 200)
+	encoded := p.String()
+	if len(encoded) > 128 {
+		t.Errorf("encoded placeholder is %d bytes, want at most 128", len(encoded))
+	}
+	parsed, err := ParsePlaceholder(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed != p {
+		t.Errorf("ParsePlaceholder(%q) = %+v, want %+v", encoded, parsed, p)
+	}
+	if svg := string(parsed.SVG()); !bytes.Contains([]byte(svg), []byte(`width="400" height="200"`)) {
+		t.Errorf("SVG() = %s, want the image's dimensions", svg)
+	}
+
+	for _, value := range []string{"", "400x200", "0x200:000000:" + string(bytes.Repeat([]byte("0"), 96)), "400x200:00000g:" + string(bytes.Repeat([]byte("0"), 96)), "400x200:000000:000"} {
+		if _, err := ParsePlaceholder(value); err == nil {
+			t.Errorf("ParsePlaceholder(%q) succeeded, want an error", value)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package imageproc
+
+import (
+	"io"
+	"os"
+)
+
+// EXIF orientation values; values 2 through 8 require the image to be flipped and/or rotated for display
+const (
+	OrientationUnspecified = 0
+	OrientationNormal      = 1
+)
+
+// IsOriented tests if an EXIF orientation value requires no correction for display
+func IsOriented(orientation int) bool {
+	return orientation == OrientationUnspecified || orientation == OrientationNormal
+}
+
+// FileOrientation reads the EXIF orientation of the given file
+func FileOrientation(file *os.File) (int, error) {
+	orientation := ReadOrientation(file)
+	if _, err := file.Seek(0, 0); err != nil {
+		return OrientationUnspecified, err
+	}
+	return orientation, nil
+}
+
+// ReadOrientation reads the EXIF orientation tag of a JPEG image, returning OrientationUnspecified if it is
+// missing or cannot be read
+func ReadOrientation(r io.Reader) int {
+	exif, err := ReadEXIF(r)
+	if err != nil {
+		return OrientationUnspecified
+	}
+	return exif.Orientation
+}
@@ -0,0 +1,37 @@
+package imageproc
+
+import (
+	"fmt"
+	"image"
+)
+
+// PageRangeError is returned when a page beyond the last page of an image is requested
+type PageRangeError struct {
+	Page  int
+	Pages int
+}
+
+// Error describes the page requested and the pages available
+func (e *PageRangeError) Error() string {
+	return fmt.Sprintf("page %d is out of range, the image has %d page(s)", e.Page, e.Pages)
+}
+
+// OpenPage opens a page (1-based) of an image from a local file; the pages of a GIF are its frames, composited
+// onto the canvas, and other formats have a single page; page 0 opens the image like Open; returns a
+// *PageRangeError if the page does not exist
+func OpenPage(localFile, fileType string, page int) (image.Image, error) {
+	if page == 0 || (page == 1 && !IsGIF(fileType)) {
+		return Open(localFile)
+	}
+	if !IsGIF(fileType) {
+		return nil, &PageRangeError{page, 1}
+	}
+	g, err := OpenGIF(localFile)
+	if err != nil {
+		return nil, err
+	}
+	if page > len(g.Image) {
+		return nil, &PageRangeError{page, len(g.Image)}
+	}
+	return GIFFrame(g, page-1), nil
+}
@@ -0,0 +1,41 @@
+package imageproc
+
+import (
+	"fmt"
+	"image"
+	"os"
+)
+
+// MaxPixelsFromEnv reads the largest number of pixels, width times height, an image may have to be decoded from
+// the MAX_PIXELS env parameter (default 100000000); a limit of 0 is not checked
+func MaxPixelsFromEnv() (int64, error) {
+	maxPixels, err := envInt64("MAX_PIXELS", 100000000)
+	if err != nil {
+		return 0, fmt.Errorf("could not convert MAX_PIXELS to int: %v", err)
+	}
+	return maxPixels, nil
+}
+
+// ScanDimensions reads the width and height of an image in a local file from its header, without decoding any
+// pixels, so images can be checked against the pixel limit before they are opened
+func ScanDimensions(localFile string) (int, int, error) {
+	file, err := os.Open(localFile)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	return config.Width, config.Height, nil
+}
+
+// CheckPixels returns an error if an image of widthxheight has more pixels than maxPixels, or nil if it is
+// within the limit
+func CheckPixels(width, height int, maxPixels int64) error {
+	if pixels := int64(width) * int64(height); maxPixels > 0 && pixels > maxPixels {
+		return fmt.Errorf("too many pixels: %dx%d, %d, maximum: %d", width, height, pixels, maxPixels)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// placeholderGrid is the number of color cells across each side of a placeholder gradient
+const placeholderGrid = 4
+
+// Placeholder describes a tiny placeholder for an image: the image's dimensions, its dominant (average) color, and
+// a grid of its colors, row by row
+type Placeholder struct {
+	Width    int
+	Height   int
+	Dominant color.NRGBA
+	Grid     [placeholderGrid * placeholderGrid]color.NRGBA
+}
+
+// NewPlaceholder computes the placeholder of an image to be published with a width and height, e.g. after it is
+// resized to fit
+func NewPlaceholder(img image.Image, width, height int) Placeholder {
+	p := Placeholder{
+		Width:    width,
+		Height:   height,
+		Dominant: opaque(imaging.Resize(img, 1, 1, imaging.Box).NRGBAAt(0, 0)),
+	}
+	grid := imaging.Resize(img, placeholderGrid, placeholderGrid, imaging.Box)
+	for y := 0; y < placeholderGrid; y++ {
+		for x := 0; x < placeholderGrid; x++ {
+			p.Grid[y*placeholderGrid+x] = opaque(grid.NRGBAAt(x, y))
+		}
+	}
+	return p
+}
+
+// String encodes a placeholder compactly, to be stored as object metadata:
+// "{width}x{height}:{dominant}:{grid}", with colors as 6 hex digits and the grid's colors concatenated
+func (p Placeholder) String() string {
+	var grid strings.Builder
+	for _, c := range p.Grid {
+		grid.WriteString(hexDigits(c))
+	}
+	return fmt.Sprintf("%dx%d:%s:%s", p.Width, p.Height, hexDigits(p.Dominant), grid.String())
+}
+
+// ParsePlaceholder decodes a placeholder encoded by Placeholder.String
+func ParsePlaceholder(value string) (Placeholder, error) {
+	var p Placeholder
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 || len(parts[2]) != 6*len(p.Grid) {
+		return p, errors.New("placeholder must be {width}x{height}:{dominant}:{grid}")
+	}
+	if _, err := fmt.Sscanf(parts[0], "%dx%d", &p.Width, &p.Height); err != nil || p.Width < 1 || p.Height < 1 {
+		return p, fmt.Errorf("bad placeholder dimensions: %s", parts[0])
+	}
+	var err error
+	if p.Dominant, err = parseHexDigits(parts[1]); err != nil {
+		return p, err
+	}
+	for i := range p.Grid {
+		if p.Grid[i], err = parseHexDigits(parts[2][i*6 : i*6+6]); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// SVG renders a placeholder, filled with its dominant color and overlaid with a blurred grid of its colors, scaled
+// to the image's dimensions
+func (p Placeholder) SVG() []byte {
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" preserveAspectRatio="none">`, p.Width, p.Height, placeholderGrid, placeholderGrid)
+	fmt.Fprintf(&buffer, `<filter id="b"><feGaussianBlur stdDeviation="0.5"/></filter>`)
+	fmt.Fprintf(&buffer, `<rect width="%d" height="%d" fill="#%s"/>`, placeholderGrid, placeholderGrid, hexDigits(p.Dominant))
+	fmt.Fprintf(&buffer, `<g filter="url(#b)">`)
+	for y := 0; y < placeholderGrid; y++ {
+		for x := 0; x < placeholderGrid; x++ {
+			fmt.Fprintf(&buffer, `<rect x="%d" y="%d" width="1" height="1" fill="#%s"/>`, x, y, hexDigits(p.Grid[y*placeholderGrid+x]))
+		}
+	}
+	fmt.Fprintf(&buffer, `</g></svg>`)
+	return buffer.Bytes()
+}
+
+// opaque returns a color without its alpha channel, which placeholders do not keep
+func opaque(c color.NRGBA) color.NRGBA {
+	c.A = 0xff
+	return c
+}
+
+// hexDigits formats a color as 6 hex digits
+func hexDigits(c color.NRGBA) string {
+	return fmt.Sprintf("%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// parseHexDigits parses a color formatted by hexDigits, as opaque
+func parseHexDigits(value string) (color.NRGBA, error) {
+	rgb, err := hex.DecodeString(value)
+	if err != nil || len(rgb) != 3 {
+		return color.NRGBA{}, fmt.Errorf("bad placeholder color: %s", value)
+	}
+	return color.NRGBA{R: rgb[0], G: rgb[1], B: rgb[2], A: 0xff}, nil
+}
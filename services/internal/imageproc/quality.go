@@ -0,0 +1,100 @@
+package imageproc
+
+import (
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+// DefaultQuality is the JPEG quality images are encoded with if none is set
+const DefaultQuality = 95
+
+// ssimSize is the largest width and height images are compared at by SSIM
+const ssimSize = 256
+
+// ssimWindow is the width and height of the windows SSIM compares
+const ssimWindow = 8
+
+// SSIM stabilizing constants, for 8-bit luma
+const (
+	ssimC1 = (0.01 * 255) * (0.01 * 255)
+	ssimC2 = (0.03 * 255) * (0.03 * 255)
+)
+
+// IsLossy tests if images of a type are encoded with a quality, i.e. JPEG
+func IsLossy(fileType string) bool {
+	return fileType == "image/jpeg"
+}
+
+// SSIM estimates the structural similarity of an encoded image to the image it was encoded from, from 1 for
+// identical images down to 0: the mean SSIM of their luma over 8x8 windows, after both are shrunk to fit
+// 256x256, so it is cheap enough to measure on live traffic; images of different sizes score 0
+func SSIM(original, encoded image.Image) float64 {
+	width, height := Dimensions(original)
+	if encodedWidth, encodedHeight := Dimensions(encoded); width != encodedWidth || height != encodedHeight || width == 0 || height == 0 {
+		return 0
+	}
+	if width > ssimSize || height > ssimSize {
+		original = imaging.Fit(original, ssimSize, ssimSize, imaging.Box)
+		encoded = imaging.Fit(encoded, ssimSize, ssimSize, imaging.Box)
+		width, height = Dimensions(original)
+	}
+	a, b := luma(original), luma(encoded)
+
+	// images smaller than a window are compared whole
+	windowWidth, windowHeight := ssimWindow, ssimWindow
+	if width < windowWidth {
+		windowWidth = width
+	}
+	if height < windowHeight {
+		windowHeight = height
+	}
+
+	var total float64
+	windows := 0
+	for y := 0; y+windowHeight <= height; y += windowHeight {
+		for x := 0; x+windowWidth <= width; x += windowWidth {
+			total += windowSSIM(a, b, width, x, y, windowWidth, windowHeight)
+			windows++
+		}
+	}
+	return total / float64(windows)
+}
+
+// windowSSIM computes the SSIM of a window of two luma planes of the same width
+func windowSSIM(a, b []float64, width, x0, y0, windowWidth, windowHeight int) float64 {
+	n := float64(windowWidth * windowHeight)
+	var sumA, sumB float64
+	for y := y0; y < y0+windowHeight; y++ {
+		for x := x0; x < x0+windowWidth; x++ {
+			sumA += a[y*width+x]
+			sumB += b[y*width+x]
+		}
+	}
+	meanA, meanB := sumA/n, sumB/n
+	var varA, varB, covariance float64
+	for y := y0; y < y0+windowHeight; y++ {
+		for x := x0; x < x0+windowWidth; x++ {
+			da, db := a[y*width+x]-meanA, b[y*width+x]-meanB
+			varA += da * da
+			varB += db * db
+			covariance += da * db
+		}
+	}
+	varA, varB, covariance = varA/n, varB/n, covariance/n
+	return ((2*meanA*meanB + ssimC1) * (2*covariance + ssimC2)) /
+		((meanA*meanA + meanB*meanB + ssimC1) * (varA + varB + ssimC2))
+}
+
+// luma returns the 8-bit luma of each pixel of an image, row by row
+func luma(img image.Image) []float64 {
+	bounds := img.Bounds()
+	values := make([]float64, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			values = append(values, (0.299*float64(r)+0.587*float64(g)+0.114*float64(b))/257)
+		}
+	}
+	return values
+}
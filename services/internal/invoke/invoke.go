@@ -0,0 +1,78 @@
+// Package invoke calls the internal actions of another service's Lambda function directly, or any function with an
+// event, authorized by IAM (lambda:InvokeFunction) rather than a shared secret
+package invoke
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// actions
+const (
+	GetRejection = "get_rejection"
+	Reprocess    = "reprocess"
+	Import       = "import"
+)
+
+// Request defines the JSON schema for the event of an internal action: the action to run and its input
+type Request struct {
+	Action string          `json:"action"`
+	Input  json.RawMessage `json:"input"`
+}
+
+// RejectionInput defines the JSON schema for the input of the get_rejection action
+type RejectionInput struct {
+	FileID string `json:"file_id"`
+}
+
+// Error is returned for an action the function failed; Type is the name of the error type it returned
+type Error struct {
+	Type    string `json:"errorType"`
+	Message string `json:"errorMessage"`
+}
+
+// Error returns the failure message
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Type, e.Message)
+}
+
+// Call runs an action of a function, unmarshalling its result into output, if not nil
+func Call(sess *session.Session, functionName, action string, input, output interface{}) error {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	return Event(sess, functionName, Request{Action: action, Input: data}, output)
+}
+
+// Event invokes a function synchronously with an event, e.g. a hook another team's function handles, unmarshalling
+// its result into output, if not nil
+func Event(sess *session.Session, functionName string, event, output interface{}) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	result, err := lambda.New(sess).Invoke(&lambda.InvokeInput{
+		FunctionName: aws.String(functionName),
+		Payload:      payload,
+	})
+	if err != nil {
+		return err
+	}
+	if result.FunctionError != nil {
+		funcErr := &Error{Type: aws.StringValue(result.FunctionError)}
+		if err = json.Unmarshal(result.Payload, funcErr); err != nil {
+			funcErr.Message = string(result.Payload)
+		}
+		return funcErr
+	}
+	if output == nil {
+		return nil
+	}
+	return json.Unmarshal(result.Payload, output)
+}
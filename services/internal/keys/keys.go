@@ -0,0 +1,211 @@
+// Package keys provides the configurable object key layouts shared by the services
+package keys
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// default key templates
+const (
+	DefaultPublishedTemplate  = "{{if .directory}}{{.directory}}/{{end}}{{.file_id}}.{{.ext}}"
+	DefaultVariantTemplate    = "{{if .directory}}{{.directory}}/{{end}}{{.size_name}}/{{.file_id}}.{{.ext}}"
+	DefaultDerivativeTemplate = "{{.operation}}/{{.size}}/{{.key}}"
+)
+
+// variables available to each key template
+var (
+	PublishedVariables  = []string{"directory", "file_id", "ext"}
+	VariantVariables    = []string{"directory", "size_name", "file_id", "ext"}
+	DerivativeVariables = []string{"operation", "size", "key"}
+)
+
+// DerivativeOperations are the operations image-serve caches derivatives under, the "operation" variable of the
+// derivative key template
+var DerivativeOperations = []string{"ratio", "crop", "width", "height", "rotate", "flip", "preset"}
+
+// patternMarker stands in for the variables left open by Pattern and Matcher; it cannot appear in a rendered key
+const patternMarker = "\x00"
+
+// Template renders object keys from a Go template using a fixed set of variables
+type Template struct {
+	tmpl *template.Template
+}
+
+// Parse parses a key template, rejecting templates that reference variables not in allowed
+func Parse(name, text string, allowed []string) (*Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	allowedSet := map[string]bool{}
+	for _, variable := range allowed {
+		allowedSet[variable] = true
+	}
+	for _, variable := range fields(tmpl.Tree.Root) {
+		if !allowedSet[variable] {
+			return nil, fmt.Errorf("key template %s: unknown variable: %s, allowed: %s", name, variable, strings.Join(allowed, ", "))
+		}
+	}
+	return &Template{tmpl: tmpl}, nil
+}
+
+// FromEnv parses the key template in an env parameter, or the default template if it is not set
+func FromEnv(envName, defaultText string, allowed []string) (*Template, error) {
+	text := os.Getenv(envName)
+	if text == "" {
+		text = defaultText
+	}
+	return Parse(envName, text, allowed)
+}
+
+// Render renders a key, rejecting empty keys and keys that are absolute or contain relative path segments
+func (t *Template) Render(vars map[string]string) (string, error) {
+	var buffer bytes.Buffer
+	if err := t.tmpl.Execute(&buffer, vars); err != nil {
+		return "", err
+	}
+	key := buffer.String()
+	if key == "" || strings.HasPrefix(key, "/") {
+		return "", fmt.Errorf("key template %s: invalid key: %q", t.tmpl.Name(), key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", fmt.Errorf("key template %s: invalid key: %q", t.tmpl.Name(), key)
+		}
+	}
+	return key, nil
+}
+
+// Pattern renders a key with one variable left open, returning the parts of the key before and after it, so keys
+// with any value of the variable can be matched, e.g. the prefix "ratio/" and suffix "/test/image.png" for every
+// size of a derivative
+func (t *Template) Pattern(vars map[string]string, open string) (string, string, error) {
+	values := map[string]string{}
+	for name, value := range vars {
+		values[name] = value
+	}
+	values[open] = patternMarker
+	key, err := t.Render(values)
+	if err != nil {
+		return "", "", err
+	}
+	parts := strings.SplitN(key, patternMarker, 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("key template %s: does not use variable: %s", t.tmpl.Name(), open)
+	}
+	return parts[0], parts[1], nil
+}
+
+// Matcher parses keys rendered by a template back into the values of the variables left open
+type Matcher struct {
+	re     *regexp.Regexp
+	names  []string
+	prefix string
+}
+
+// Matcher renders a key with some variables left open, returning a Matcher for the keys rendered with any values
+// of them, e.g. the size "200x200" and key "test/image.png" of "crop/200x200/test/image.png" with the operation
+// "crop"; each open value is matched as the shortest value that fits, except the last, so only the last one may
+// contain a slash where the template puts one after it
+func (t *Template) Matcher(vars map[string]string, open ...string) (*Matcher, error) {
+	values := map[string]string{}
+	for name, value := range vars {
+		values[name] = value
+	}
+	for i, name := range open {
+		values[name] = fmt.Sprintf("%s%d%s", patternMarker, i, patternMarker)
+	}
+	key, err := t.Render(values)
+	if err != nil {
+		return nil, err
+	}
+
+	// the parts between markers alternate with the indexes of the open variables
+	parts := strings.Split(key, patternMarker)
+	if len(parts) == 1 {
+		return nil, fmt.Errorf("key template %s: does not use variables: %s", t.tmpl.Name(), strings.Join(open, ", "))
+	}
+	pattern := "^" + regexp.QuoteMeta(parts[0])
+	var names []string
+	for i := 1; i+1 < len(parts); i += 2 {
+		var index int
+		if _, err := fmt.Sscan(parts[i], &index); err != nil {
+			return nil, err
+		}
+		names = append(names, open[index])
+		pattern += "(.+?)" + regexp.QuoteMeta(parts[i+1])
+	}
+	re, err := regexp.Compile(pattern + "$")
+	if err != nil {
+		return nil, err
+	}
+	return &Matcher{re: re, names: names, prefix: parts[0]}, nil
+}
+
+// Prefix returns the part of every matched key before the first open variable, to list them by
+func (m *Matcher) Prefix() string {
+	return m.prefix
+}
+
+// Match returns the values of the open variables a key was rendered with, or false if the template could not
+// have rendered it, including when a variable used more than once has different values
+func (m *Matcher) Match(key string) (map[string]string, bool) {
+	groups := m.re.FindStringSubmatch(key)
+	if groups == nil {
+		return nil, false
+	}
+	values := map[string]string{}
+	for i, name := range m.names {
+		if value, ok := values[name]; ok && value != groups[i+1] {
+			return nil, false
+		}
+		values[name] = groups[i+1]
+	}
+	return values, true
+}
+
+// fields lists the variables referenced by a template parse tree
+func fields(node parse.Node) []string {
+	var names []string
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			names = append(names, fields(child)...)
+		}
+	case *parse.ActionNode:
+		names = append(names, fields(n.Pipe)...)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, cmd := range n.Cmds {
+			names = append(names, fields(cmd)...)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			names = append(names, fields(arg)...)
+		}
+	case *parse.FieldNode:
+		names = append(names, n.Ident[0])
+	case *parse.IfNode:
+		names = append(names, fields(&n.BranchNode)...)
+	case *parse.RangeNode:
+		names = append(names, fields(&n.BranchNode)...)
+	case *parse.WithNode:
+		names = append(names, fields(&n.BranchNode)...)
+	case *parse.BranchNode:
+		names = append(names, fields(n.Pipe)...)
+		names = append(names, fields(n.List)...)
+		names = append(names, fields(n.ElseList)...)
+	}
+	return names
+}
@@ -0,0 +1,57 @@
+package keys
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// key validation modes
+const (
+	ValidationStrict = "strict"
+	ValidationLegacy = "legacy"
+)
+
+// reStrictSegment matches the key segments allowed in strict validation mode
+var reStrictSegment = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// ValidationFromEnv reads the key validation mode in the KEY_VALIDATION env parameter, or strict if it is not set
+func ValidationFromEnv() (string, error) {
+	mode := os.Getenv("KEY_VALIDATION")
+	if mode == "" {
+		return ValidationStrict, nil
+	}
+	if mode != ValidationStrict && mode != ValidationLegacy {
+		return "", fmt.Errorf("unknown key validation mode: %s", mode)
+	}
+	return mode, nil
+}
+
+// Validate checks an object key requested by a client; in strict mode keys may only contain letters, digits,
+// '.', '_', '-', and '/' separators, in legacy mode keys may contain any printable characters, e.g.
+// "2014/05/photo (1).jpg"; neither may be absolute or contain empty or relative path segments
+func Validate(key, mode string) error {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return fmt.Errorf("invalid key: %q", key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("invalid key: %q", key)
+		}
+		if mode == ValidationStrict && !reStrictSegment.MatchString(segment) {
+			return fmt.Errorf("invalid key: %q", key)
+		}
+		if strings.IndexFunc(segment, func(r rune) bool { return !unicode.IsPrint(r) }) >= 0 {
+			return fmt.Errorf("invalid key: %q", key)
+		}
+	}
+	return nil
+}
+
+// EscapePath escapes an object key for use as a URL path, e.g. "photo (1).jpg" becomes "photo%20%281%29.jpg"
+func EscapePath(key string) string {
+	return (&url.URL{Path: key}).EscapedPath()
+}
@@ -0,0 +1,50 @@
+package keys
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name, key, mode string
+		ok              bool
+	}{
+		{"strict", "2014/05/photo-1_a.jpg", ValidationStrict, true},
+		{"strict space", "2014/05/photo (1).jpg", ValidationStrict, false},
+		{"legacy space", "2014/05/photo (1).jpg", ValidationLegacy, true},
+		{"empty", "", ValidationLegacy, false},
+		{"absolute", "/photo.jpg", ValidationLegacy, false},
+		{"empty segment", "2014//photo.jpg", ValidationLegacy, false},
+		{"trailing slash", "2014/", ValidationLegacy, false},
+		{"current directory", "2014/./photo.jpg", ValidationLegacy, false},
+		{"parent directory", "../photo.jpg", ValidationLegacy, false},
+		{"dots in name", "photo..jpg", ValidationStrict, true},
+		{"control character", "photo\n.jpg", ValidationLegacy, false},
+		{"unicode", "café.jpg", ValidationStrict, false},
+		{"unicode legacy", "café.jpg", ValidationLegacy, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Validate(test.key, test.mode)
+			if test.ok && err != nil {
+				t.Errorf("Validate() = %v, want nil", err)
+			}
+			if !test.ok && err == nil {
+				t.Error("Validate() = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestEscapePath(t *testing.T) {
+	tests := []struct {
+		key, want string
+	}{
+		{"path/photo.jpg", "path/photo.jpg"},
+		{"photo (1).jpg", "photo%20%281%29.jpg"},
+		{"a?b#c.jpg", "a%3Fb%23c.jpg"},
+	}
+	for _, test := range tests {
+		if got := EscapePath(test.key); got != test.want {
+			t.Errorf("EscapePath(%q) = %q, want %q", test.key, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Package lifecycle provides the image lifecycle events published to EventBridge for downstream subscribers
+package lifecycle
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+)
+
+// Source is the source of every lifecycle event
+const Source = "okebinda.image-storage"
+
+// detail types
+const (
+	ImageUploaded      = "ImageUploaded"
+	ImageProcessed     = "ImageProcessed"
+	ImageDeleted       = "ImageDeleted"
+	ImageProcessFailed = "ImageProcessFailed"
+)
+
+// Detail defines the JSON schema for the detail of a lifecycle event; properties that do not apply to an event's
+// detail type are omitted
+type Detail struct {
+	Service       string          `json:"service"`
+	RequestID     string          `json:"request_id"`
+	Bucket        string          `json:"bucket,omitempty"`
+	FileKey       string          `json:"file_key,omitempty"`
+	FileID        string          `json:"file_id,omitempty"`
+	Directory     string          `json:"directory,omitempty"`
+	FileExtension string          `json:"file_extension,omitempty"`
+	ContentType   string          `json:"content_type,omitempty"`
+	Width         int             `json:"width,omitempty"`
+	Height        int             `json:"height,omitempty"`
+	SizeBytes     int64           `json:"size_bytes,omitempty"`
+	Variants      []Variant       `json:"variants,omitempty"`
+	Error         *Error          `json:"error,omitempty"`
+	Context       json.RawMessage `json:"context,omitempty"`
+	OccurredAt    string          `json:"occurred_at"`
+}
+
+// Variant defines the JSON schema for a published variant in a Detail
+type Variant struct {
+	Name    string `json:"name"`
+	FileKey string `json:"file_key"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+}
+
+// Error defines the JSON schema for the error in an ImageProcessFailed Detail
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Emit publishes an event to the event bus named by the EVENT_BUS_NAME env parameter, if set
+func Emit(sess *session.Session, detailType string, detail Detail) error {
+	busName := os.Getenv("EVENT_BUS_NAME")
+	if busName == "" {
+		return nil
+	}
+	if detail.OccurredAt == "" {
+		detail.OccurredAt = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	data, err := json.Marshal(detail)
+	if err != nil {
+		return err
+	}
+	output, err := eventbridge.New(sess).PutEvents(&eventbridge.PutEventsInput{
+		Entries: []*eventbridge.PutEventsRequestEntry{{
+			EventBusName: aws.String(busName),
+			Source:       aws.String(Source),
+			DetailType:   aws.String(detailType),
+			Detail:       aws.String(string(data)),
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	if aws.Int64Value(output.FailedEntryCount) > 0 {
+		return fmt.Errorf("event rejected: %s", aws.StringValue(output.Entries[0].ErrorMessage))
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package lock
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Increment adds one to the counter for a name in the current window of a fixed length, shared by all function
+// instances, e.g. to rate limit a client; returns the counter's new value, and when the window ends and the
+// counter starts again from 0
+func Increment(sess *session.Session, table, name string, window time.Duration) (int64, time.Time, error) {
+	start := time.Now().Truncate(window)
+	end := start.Add(window)
+	output, err := dynamodb.New(sess).UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(fmt.Sprintf("%s@%d", name, start.Unix()))},
+		},
+		UpdateExpression: aws.String("ADD #count :one SET expires = :expires"),
+		ExpressionAttributeNames: map[string]*string{
+			"#count": aws.String("count"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one":     {N: aws.String("1")},
+			":expires": {N: aws.String(strconv.FormatInt(end.Unix(), 10))},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueUpdatedNew),
+	})
+	if err != nil {
+		return 0, end, err
+	}
+	count, ok := output.Attributes["count"]
+	if !ok {
+		return 0, end, fmt.Errorf("counter not returned: %s", name)
+	}
+	value, err := strconv.ParseInt(aws.StringValue(count.N), 10, 64)
+	return value, end, err
+}
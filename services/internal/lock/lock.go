@@ -0,0 +1,92 @@
+// Package lock provides short-lived distributed locks and counters, shared by all function instances, in a
+// DynamoDB table with a string "id" key and an "expires" (unix timestamp) TTL attribute
+package lock
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// pollInterval is the time between attempts to acquire a lock while waiting for it
+const pollInterval = 200 * time.Millisecond
+
+// Lock is a held lock, or one of a limited number of slots sharing a name
+type Lock struct {
+	sess  *session.Session
+	table string
+	id    string
+	owner string
+}
+
+// Acquire tries to take one of limit slots for a name, held by an owner (e.g. a request ID) until released or ttl
+// passes; returns nil if every slot is held
+func Acquire(sess *session.Session, table, name, owner string, limit int, ttl time.Duration) (*Lock, error) {
+	now := time.Now()
+	for slot := 0; slot < limit; slot++ {
+		id := fmt.Sprintf("%s#%d", name, slot)
+		_, err := dynamodb.New(sess).PutItem(&dynamodb.PutItemInput{
+			TableName: aws.String(table),
+			Item: map[string]*dynamodb.AttributeValue{
+				"id":      {S: aws.String(id)},
+				"owner":   {S: aws.String(owner)},
+				"expires": {N: aws.String(strconv.FormatInt(now.Add(ttl).Unix(), 10))},
+			},
+			ConditionExpression: aws.String("attribute_not_exists(id) OR expires < :now"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":now": {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+			},
+		})
+		if err == nil {
+			return &Lock{sess: sess, table: table, id: id, owner: owner}, nil
+		}
+		if !isConditionFailed(err) {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// Wait polls Acquire until a slot is taken or wait passes; returns nil if every slot is still held
+func Wait(sess *session.Session, table, name, owner string, limit int, ttl, wait time.Duration) (*Lock, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		l, err := Acquire(sess, table, name, owner, limit, ttl)
+		if err != nil || l != nil || time.Now().After(deadline) {
+			return l, err
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// Release frees a lock, unless it expired and was taken by another owner
+func (l *Lock) Release() error {
+	_, err := dynamodb.New(l.sess).DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(l.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(l.id)},
+		},
+		ConditionExpression: aws.String("#owner = :owner"),
+		ExpressionAttributeNames: map[string]*string{
+			"#owner": aws.String("owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner": {S: aws.String(l.owner)},
+		},
+	})
+	if isConditionFailed(err) {
+		return nil
+	}
+	return err
+}
+
+// isConditionFailed tests if a DynamoDB error is a failed write condition
+func isConditionFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
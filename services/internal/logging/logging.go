@@ -0,0 +1,21 @@
+// Package logging provides the structured logger shared by the services
+package logging
+
+import (
+	"log"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// New initializes the zap sugar logger for a request
+func New(requestID string) *zap.SugaredLogger {
+	// zapLogger, err := zap.NewDevelopment()
+	zapLogger, err := zap.NewProduction()
+	if err != nil {
+		log.Fatalf("can't initialize zap logger: %v", err)
+	}
+	return zapLogger.
+		With(zap.Field{Key: "request_id", Type: zapcore.StringType, String: requestID}).
+		Sugar()
+}
@@ -0,0 +1,25 @@
+// Package moderation detects unsafe content, e.g. nudity or violence, in uploaded images, with AWS Rekognition or
+// another provider
+package moderation
+
+// Label defines content a moderator detected in an image, e.g. "Explicit Nudity", with its parent category, if
+// any, and the moderator's confidence in percent
+type Label struct {
+	Name       string  `json:"name"`
+	ParentName string  `json:"parent_name,omitempty"`
+	Confidence float64 `json:"confidence"`
+}
+
+// Image defines an image to moderate, by its object in an S3 bucket
+type Image struct {
+	Bucket      string
+	Key         string
+	ContentType string
+}
+
+// Moderator detects unsafe content in an image, returning the labels detected with at least a confidence; images
+// of types it does not support cannot be moderated
+type Moderator interface {
+	Supports(contentType string) bool
+	Moderate(image Image, minConfidence float64) ([]Label, error)
+}
@@ -0,0 +1,50 @@
+package moderation
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/rekognition"
+	"github.com/okebinda/internal/storage"
+)
+
+// rekognitionTypes are the image types Rekognition can moderate
+var rekognitionTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+}
+
+// Rekognition moderates images with AWS Rekognition's DetectModerationLabels, which reads them from S3 directly;
+// the bucket must be in the same region
+type Rekognition struct {
+	Session *session.Session
+}
+
+// Supports tests whether Rekognition can moderate an image type
+func (r Rekognition) Supports(contentType string) bool {
+	return rekognitionTypes[contentType]
+}
+
+// Moderate detects moderation labels in an image
+func (r Rekognition) Moderate(image Image, minConfidence float64) ([]Label, error) {
+	output, err := rekognition.New(r.Session).DetectModerationLabels(&rekognition.DetectModerationLabelsInput{
+		Image: &rekognition.Image{
+			S3Object: &rekognition.S3Object{
+				Bucket: aws.String(image.Bucket),
+				Name:   aws.String(storage.ObjectKey(image.Bucket, image.Key)),
+			},
+		},
+		MinConfidence: aws.Float64(minConfidence),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var labels []Label
+	for _, label := range output.ModerationLabels {
+		labels = append(labels, Label{
+			Name:       aws.StringValue(label.Name),
+			ParentName: aws.StringValue(label.ParentName),
+			Confidence: aws.Float64Value(label.Confidence),
+		})
+	}
+	return labels, nil
+}
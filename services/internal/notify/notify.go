@@ -0,0 +1,243 @@
+// Package notify provides the Slack and email notifications shared by the services
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/okebinda/internal/signing"
+	"github.com/okebinda/internal/tracing"
+)
+
+// Webhook posts a message to a Slack-compatible webhook, abandoning the post if ctx is done
+func Webhook(ctx context.Context, webhookURL, message string) error {
+	return PostJSON(ctx, webhookURL, map[string]interface{}{
+		"text": message,
+	})
+}
+
+// SignatureHeader is the header carrying the hex encoded HMAC-SHA256 signature of a callback's body
+const SignatureHeader = "X-Signature"
+
+// PreviousSignatureHeader is the header carrying the signature of a callback's body with the previous secret,
+// while a rotated secret is still valid
+const PreviousSignatureHeader = "X-Signature-Previous"
+
+// defaultTimeout is how long a post may take if no timeout is given
+const defaultTimeout = 10 * time.Second
+
+// Retry limits the delivery attempts of a callback: up to Attempts posts are made, each abandoned after Timeout,
+// waiting Backoff before the first retry and doubling the wait after each, and no retry is started after Budget
+// has elapsed
+type Retry struct {
+	Attempts int
+	Backoff  time.Duration
+	Budget   time.Duration
+	Timeout  time.Duration
+}
+
+// RetryFromEnv reads the retry limits for callbacks from the CALLBACK_MAX_ATTEMPTS (default 4),
+// CALLBACK_BACKOFF_MS (default 500), CALLBACK_RETRY_BUDGET_MS (default 10000), and CALLBACK_TIMEOUT_MS
+// (default 10000) env parameters
+func RetryFromEnv() (Retry, error) {
+	attempts, err := envInt("CALLBACK_MAX_ATTEMPTS", 4)
+	if err != nil {
+		return Retry{}, fmt.Errorf("could not convert CALLBACK_MAX_ATTEMPTS to int: %v", err)
+	}
+	backoff, err := envInt("CALLBACK_BACKOFF_MS", 500)
+	if err != nil {
+		return Retry{}, fmt.Errorf("could not convert CALLBACK_BACKOFF_MS to int: %v", err)
+	}
+	budget, err := envInt("CALLBACK_RETRY_BUDGET_MS", 10000)
+	if err != nil {
+		return Retry{}, fmt.Errorf("could not convert CALLBACK_RETRY_BUDGET_MS to int: %v", err)
+	}
+	timeout, err := envInt("CALLBACK_TIMEOUT_MS", int(defaultTimeout/time.Millisecond))
+	if err != nil {
+		return Retry{}, fmt.Errorf("could not convert CALLBACK_TIMEOUT_MS to int: %v", err)
+	}
+	return Retry{
+		Attempts: attempts,
+		Backoff:  time.Duration(backoff) * time.Millisecond,
+		Budget:   time.Duration(budget) * time.Millisecond,
+		Timeout:  time.Duration(timeout) * time.Millisecond,
+	}, nil
+}
+
+// envInt reads an int env parameter, or returns a default value if it is not set
+func envInt(name string, defaultValue int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// statusError is returned for posts that were answered with an unsuccessful status
+type statusError struct {
+	code int
+}
+
+// Error returns the unsuccessful status
+func (e *statusError) Error() string {
+	return fmt.Sprintf("webhook responded with status %d", e.code)
+}
+
+// PostJSON posts a payload, encoded as JSON, to a URL, abandoning the post if ctx is done
+func PostJSON(ctx context.Context, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return post(ctx, url, body, nil, defaultTimeout)
+}
+
+// Callback posts a payload, encoded as JSON, to a URL with custom headers, signing the body with the first of
+// secrets in the X-Signature header if it is set, and with the second, the previous secret of a rotation, in the
+// X-Signature-Previous header; posts that fail with a network error, a 429, or a 5xx status are retried with
+// exponential backoff within the retry limits, returning the last error if none succeed; posts and waits are
+// abandoned once ctx is done, e.g. at the Lambda function's deadline, and no retry is started that would outlast it
+func Callback(ctx context.Context, url string, secrets []string, customHeaders map[string]string, payload interface{}, retry Retry) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{}
+	for name, value := range customHeaders {
+		headers[http.CanonicalHeaderKey(name)] = value
+	}
+	if len(secrets) > 0 && secrets[0] != "" {
+		headers[SignatureHeader] = signing.Sign(secrets[0], string(body))
+		if len(secrets) > 1 && secrets[1] != "" {
+			headers[PreviousSignatureHeader] = signing.Sign(secrets[1], string(body))
+		}
+	}
+
+	start := time.Now()
+	backoff := retry.Backoff
+	for attempt := 1; ; attempt++ {
+		err = post(ctx, url, body, headers, retry.Timeout)
+		if err == nil || !Retryable(err) || attempt >= retry.Attempts || time.Since(start)+backoff > retry.Budget {
+			return err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post posts a JSON body to a URL with additional headers, abandoning the post after timeout, if not 0, or once
+// ctx is done
+func post(ctx context.Context, url string, body []byte, headers map[string]string, timeout time.Duration) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	// trace the post, propagating the trace to the receiver
+	ctx, segment := tracing.Begin(ctx, req.URL.Host, tracing.NamespaceRemote)
+	if header, ok := tracing.FromContext(ctx); ok {
+		req.Header.Set(tracing.Header, header.String())
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		segment.EndHTTP(http.MethodPost, url, 0, err)
+		return err
+	}
+	defer resp.Body.Close()
+	segment.EndHTTP(http.MethodPost, url, resp.StatusCode, nil)
+	if resp.StatusCode >= 300 {
+		return &statusError{resp.StatusCode}
+	}
+	return nil
+}
+
+// Retryable tests if a failed post may succeed if retried: network errors, rate limits, and server errors
+func Retryable(err error) bool {
+	if se, ok := err.(*statusError); ok {
+		return se.code == http.StatusTooManyRequests || se.code >= 500
+	}
+	return true
+}
+
+// Email sends a plain text email through SES
+func Email(sess *session.Session, from string, to []string, subject, message string) error {
+	_, err := ses.New(sess).SendEmail(&ses.SendEmailInput{
+		Source: aws.String(from),
+		Destination: &ses.Destination{
+			ToAddresses: aws.StringSlice(to),
+		},
+		Message: &ses.Message{
+			Subject: &ses.Content{Data: aws.String(subject)},
+			Body: &ses.Body{
+				Text: &ses.Content{Data: aws.String(message)},
+			},
+		},
+	})
+	return err
+}
+
+// Upload summarizes a published upload for notifications
+type Upload struct {
+	Uploader     string `json:"uploader"`
+	Directory    string `json:"directory"`
+	FileKey      string `json:"file_key"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	SizeBytes    int64  `json:"size_bytes"`
+	ThumbnailURL string `json:"thumbnail_url"`
+}
+
+// Message formats the notification for an upload, returning its subject and message
+func (u Upload) Message() (string, string) {
+	subject := fmt.Sprintf("New upload to %s", u.Directory)
+	return subject, fmt.Sprintf("%s\nUploader: %s\nDirectory: %s\nFile: %s (%dx%d, %d bytes)\nThumbnail: %s",
+		subject, u.Uploader, u.Directory, u.FileKey, u.Width, u.Height, u.SizeBytes, u.ThumbnailURL)
+}
+
+// Digest formats one notification for a batch of uploads, counting them by directory and listing each of them
+// in a manifest, returning its subject and message
+func Digest(uploads []Upload) (string, string) {
+	var directories []string
+	counts := map[string]int{}
+	for _, u := range uploads {
+		if counts[u.Directory] == 0 {
+			directories = append(directories, u.Directory)
+		}
+		counts[u.Directory]++
+	}
+
+	subject := fmt.Sprintf("%d new uploads", len(uploads))
+	var message strings.Builder
+	message.WriteString(subject)
+	for _, directory := range directories {
+		fmt.Fprintf(&message, "\n%s: %d", directory, counts[directory])
+	}
+	message.WriteString("\n\nManifest:")
+	for _, u := range uploads {
+		fmt.Fprintf(&message, "\n%s (%dx%d, %d bytes) by %s: %s", u.FileKey, u.Width, u.Height, u.SizeBytes, u.Uploader, u.ThumbnailURL)
+	}
+	return subject, message.String()
+}
@@ -0,0 +1,262 @@
+// Package otlp exports the services' traces and metrics to an OpenTelemetry collector over OTLP/HTTP, encoded as
+// JSON, for teams not on X-Ray and CloudWatch; it is configured by the standard OTEL_EXPORTER_OTLP_* env parameters,
+// and exports nothing unless an endpoint is set. Each span and metric is exported as it is recorded, so a collector
+// close to the function, e.g. the AWS Distro for OpenTelemetry Lambda layer, should receive them
+package otlp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scopeName is the instrumentation scope of the spans and metrics exported
+const scopeName = "github.com/okebinda/internal"
+
+// defaultTimeout is the longest an export may take if OTEL_EXPORTER_OTLP_TIMEOUT is not set
+const defaultTimeout = 10 * time.Second
+
+// Span kinds
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+	KindProducer = 4
+	KindConsumer = 5
+)
+
+// Span defines a span to export: its trace and span IDs, and its parent's, as hex, its name and kind, when it
+// started and ended, its attributes, and the error it failed with, if any
+type Span struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Name         string
+	Kind         int
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]interface{}
+	Error        string
+}
+
+// TracesEnabled tests if spans are exported, if the OTEL_EXPORTER_OTLP_TRACES_ENDPOINT or
+// OTEL_EXPORTER_OTLP_ENDPOINT env parameter is set
+func TracesEnabled() bool {
+	return endpoint("TRACES", "traces") != ""
+}
+
+// MetricsEnabled tests if metrics are exported, if the OTEL_EXPORTER_OTLP_METRICS_ENDPOINT or
+// OTEL_EXPORTER_OTLP_ENDPOINT env parameter is set
+func MetricsEnabled() bool {
+	return endpoint("METRICS", "metrics") != ""
+}
+
+// ExportSpan exports a span, if traces are enabled
+func ExportSpan(span Span) error {
+	target := endpoint("TRACES", "traces")
+	if target == "" {
+		return nil
+	}
+	document := map[string]interface{}{
+		"traceId":           span.TraceID,
+		"spanId":            span.SpanID,
+		"name":              span.Name,
+		"kind":              span.Kind,
+		"startTimeUnixNano": unixNano(span.Start),
+		"endTimeUnixNano":   unixNano(span.End),
+		"attributes":        attributes(span.Attributes),
+	}
+	if span.ParentSpanID != "" {
+		document["parentSpanId"] = span.ParentSpanID
+	}
+	if span.Error != "" {
+		document["status"] = map[string]interface{}{"code": 2, "message": span.Error}
+	}
+	return post(target, map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{"attributes": attributes(resource())},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": scopeName},
+						"spans": []interface{}{document},
+					},
+				},
+			},
+		},
+	})
+}
+
+// AddCounter exports a delta of a monotonic counter, e.g. 1 for each event counted, with its attributes, if
+// metrics are enabled
+func AddCounter(name string, value int64, attrs map[string]interface{}) error {
+	target := endpoint("METRICS", "metrics")
+	if target == "" {
+		return nil
+	}
+	now := time.Now()
+	return post(target, map[string]interface{}{
+		"resourceMetrics": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{"attributes": attributes(resource())},
+				"scopeMetrics": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": scopeName},
+						"metrics": []interface{}{
+							map[string]interface{}{
+								"name": name,
+								"unit": "1",
+								"sum": map[string]interface{}{
+									"aggregationTemporality": 1,
+									"isMonotonic":            true,
+									"dataPoints": []interface{}{
+										map[string]interface{}{
+											"attributes":        attributes(attrs),
+											"startTimeUnixNano": unixNano(now),
+											"timeUnixNano":      unixNano(now),
+											"asInt":             strconv.FormatInt(value, 10),
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+}
+
+// endpoint returns the URL a signal is exported to: the OTEL_EXPORTER_OTLP_{SIGNAL}_ENDPOINT env parameter, as is,
+// or else the OTEL_EXPORTER_OTLP_ENDPOINT env parameter with the signal's path, e.g. "/v1/traces", or "" if neither
+// is set
+func endpoint(signal, path string) string {
+	if value := os.Getenv("OTEL_EXPORTER_OTLP_" + signal + "_ENDPOINT"); value != "" {
+		return value
+	}
+	if value := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); value != "" {
+		return strings.TrimSuffix(value, "/") + "/v1/" + path
+	}
+	return ""
+}
+
+// resource returns the attributes of the service exporting: service.name, from the OTEL_SERVICE_NAME env parameter,
+// or else the Lambda function's name, its cloud and region, and those in the OTEL_RESOURCE_ATTRIBUTES env parameter
+// ("key=value,key=value")
+func resource() map[string]interface{} {
+	attrs := map[string]interface{}{
+		"service.name":   "unknown_service",
+		"cloud.provider": "aws",
+		"cloud.platform": "aws_lambda",
+	}
+	if name := os.Getenv("AWS_LAMBDA_FUNCTION_NAME"); name != "" {
+		attrs["service.name"] = name
+		attrs["faas.name"] = name
+	}
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		attrs["cloud.region"] = region
+	}
+	for key, value := range keyValues(os.Getenv("OTEL_RESOURCE_ATTRIBUTES")) {
+		attrs[key] = value
+	}
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		attrs["service.name"] = name
+	}
+	return attrs
+}
+
+// keyValues parses a list of percent-encoded keys and values, as the OTEL_EXPORTER_OTLP_HEADERS and
+// OTEL_RESOURCE_ATTRIBUTES env parameters are set, e.g. "api-key=secret,team=images"
+func keyValues(list string) map[string]string {
+	values := map[string]string{}
+	for _, pair := range strings.Split(list, ",") {
+		fields := strings.SplitN(pair, "=", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		key, err := url.PathUnescape(strings.TrimSpace(fields[0]))
+		if err != nil || key == "" {
+			continue
+		}
+		value, err := url.PathUnescape(strings.TrimSpace(fields[1]))
+		if err != nil {
+			continue
+		}
+		values[key] = value
+	}
+	return values
+}
+
+// attributes converts attributes to OTLP key-values; strings, bools, ints, and floats keep their types, and any
+// other value is formatted as a string
+func attributes(attrs map[string]interface{}) []interface{} {
+	converted := make([]interface{}, 0, len(attrs))
+	for key, value := range attrs {
+		var typed map[string]interface{}
+		switch v := value.(type) {
+		case string:
+			typed = map[string]interface{}{"stringValue": v}
+		case bool:
+			typed = map[string]interface{}{"boolValue": v}
+		case int:
+			typed = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			typed = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			typed = map[string]interface{}{"doubleValue": v}
+		default:
+			typed = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		converted = append(converted, map[string]interface{}{"key": key, "value": typed})
+	}
+	return converted
+}
+
+// unixNano formats a time as OTLP JSON encodes it: nanoseconds since the epoch, as a string
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// post sends an export request to a collector, with the headers in the OTEL_EXPORTER_OTLP_HEADERS env parameter,
+// waiting up to the OTEL_EXPORTER_OTLP_TIMEOUT env parameter's milliseconds (default 10000)
+func post(target string, request interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	timeout := defaultTimeout
+	if value := os.Getenv("OTEL_EXPORTER_OTLP_TIMEOUT"); value != "" {
+		ms, err := strconv.Atoi(value)
+		if err != nil || ms < 1 {
+			return fmt.Errorf("could not convert OTEL_EXPORTER_OTLP_TIMEOUT to a positive int: %s", value)
+		}
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range keyValues(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")) {
+		req.Header.Set(key, value)
+	}
+	resp, err := (&http.Client{Timeout: timeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("OTLP export failed: %s, status %d", target, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+// Package presigns records the upload URLs issued in a DynamoDB table, keyed by file key, so only uploads made
+// through an issued URL are processed, within its window
+package presigns
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// retention is how long presigns are kept after their processing window closes, before the table's TTL deletes
+// them
+const retention = 24 * time.Hour
+
+// Presign defines an issued upload URL: the key it uploads to, who it was issued to, and until when the upload may
+// be processed
+type Presign struct {
+	FileKey       string `dynamodbav:"file_key" json:"file_key"`
+	Issuer        string `dynamodbav:"issuer" json:"issuer"`
+	IssuedAt      string `dynamodbav:"issued_at" json:"issued_at"`
+	ExpiresAt     string `dynamodbav:"expires_at" json:"expires_at"`
+	ProcessBefore string `dynamodbav:"process_before" json:"process_before"`
+	Expires       int64  `dynamodbav:"expires" json:"-"`
+}
+
+// Put records an issued upload URL, which may be processed until processBefore
+func Put(sess *session.Session, table string, presign Presign, processBefore time.Time) error {
+	if presign.IssuedAt == "" {
+		presign.IssuedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	presign.ProcessBefore = processBefore.UTC().Format(time.RFC3339)
+	presign.Expires = processBefore.Add(retention).Unix()
+	item, err := dynamodbattribute.MarshalMap(presign)
+	if err != nil {
+		return err
+	}
+	_, err = dynamodb.New(sess).PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item:      item,
+	})
+	return err
+}
+
+// Get reads the presign of a file key; returns false if there is none
+func Get(sess *session.Session, table, fileKey string) (Presign, bool, error) {
+	var presign Presign
+	output, err := dynamodb.New(sess).GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"file_key": {S: aws.String(fileKey)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || output.Item == nil {
+		return presign, false, err
+	}
+	err = dynamodbattribute.UnmarshalMap(output.Item, &presign)
+	return presign, err == nil, err
+}
+
+// Open tests whether an upload may still be processed at a time
+func (p Presign) Open(now time.Time) bool {
+	processBefore, err := time.Parse(time.RFC3339, p.ProcessBefore)
+	return err == nil && now.Before(processBefore)
+}
@@ -0,0 +1,104 @@
+// Package problem defines the error responses shared by the services: RFC 7807 problem details, extended with a
+// stable, machine-readable code that API consumers can branch on, the message, any details, and the request ID
+package problem
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ContentType is the media type of problem details
+const ContentType = "application/problem+json"
+
+// TypePrefix is the URN namespace of the problems' types, followed by the code in lower case with hyphens, e.g.
+// "urn:okebinda:problem:file-too-large"
+const TypePrefix = "urn:okebinda:problem:"
+
+// Codes of the problems the services return; each is set explicitly where the problem is returned, and keys the
+// translations of its message, so rewording a message never changes its code. Codes must never be changed once
+// released
+const (
+	// codes of the HTTP statuses, for problems without a more specific code
+	BadRequest    = "BAD_REQUEST"
+	Conflict      = "CONFLICT"
+	InternalError = "INTERNAL_ERROR"
+
+	PermissionDenied      = "PERMISSION_DENIED"
+	NotFound              = "NOT_FOUND"
+	MissingParameters     = "MISSING_PARAMETERS"
+	BadParameterFormat    = "BAD_PARAMETER_FORMAT"
+	BadParameterValue     = "BAD_PARAMETER_VALUE"
+	UnreadableParameter   = "UNREADABLE_PARAMETER"
+	SizeNotAllowed        = "SIZE_NOT_ALLOWED"
+	BadWidth              = "BAD_WIDTH"
+	BadHeight             = "BAD_HEIGHT"
+	BadRequestBody        = "BAD_REQUEST_BODY"
+	UnreadableRequestBody = "UNREADABLE_REQUEST_BODY"
+	UnsupportedExtension  = "UNSUPPORTED_EXTENSION"
+	UnsupportedType       = "UNSUPPORTED_TYPE"
+	UnsupportedACL        = "UNSUPPORTED_ACL"
+	FileTooLarge          = "FILE_TOO_LARGE"
+	UploadNotIssued       = "UPLOAD_NOT_ISSUED"
+	UploadWindowClosed    = "UPLOAD_WINDOW_CLOSED"
+	ChecksumMismatch      = "CHECKSUM_MISMATCH"
+	MalwareDetected       = "MALWARE_DETECTED"
+	ModerationFlagged     = "MODERATION_FLAGGED"
+	UnprocessableImage    = "UNPROCESSABLE_IMAGE"
+	TooManySizes          = "TOO_MANY_SIZES"
+	BadSizeName           = "BAD_SIZE_NAME"
+	DuplicateSizeName     = "DUPLICATE_SIZE_NAME"
+	BadSizeDimensions     = "BAD_SIZE_DIMENSIONS"
+	TooManyCallbacks      = "TOO_MANY_CALLBACKS"
+	PublishedKeyFailed    = "PUBLISHED_KEY_FAILED"
+	DerivativeKeyFailed   = "DERIVATIVE_KEY_FAILED"
+	AnimationTooLarge     = "ANIMATION_TOO_LARGE"
+	ImageTooLarge         = "IMAGE_TOO_LARGE"
+	OriginalTooLarge      = "ORIGINAL_TOO_LARGE"
+	RateLimited           = "RATE_LIMITED"
+	Embargoed             = "EMBARGOED"
+	GeoRestricted         = "GEO_RESTRICTED"
+	CountryUnknown        = "COUNTRY_UNKNOWN"
+	Hotlinked             = "HOTLINKED"
+	InvalidSignature      = "INVALID_SIGNATURE"
+	InvalidToken          = "INVALID_TOKEN"
+	Unauthenticated       = "UNAUTHENTICATED"
+	SourceBusy            = "SOURCE_BUSY"
+	DerivativePending     = "DERIVATIVE_PENDING"
+	UploadRejected        = "UPLOAD_REJECTED"
+	UnsupportedVersion    = "UNSUPPORTED_VERSION"
+	LoadShed              = "LOAD_SHED"
+	EncryptedFile         = "ENCRYPTED_FILE"
+)
+
+// Problem defines the JSON schema of an error response: the RFC 7807 members, where type is the URN of the code
+// and title the status text, then the code, e.g. "FILE_TOO_LARGE", the localized message, the details specific to
+// the code, e.g. "available_from", and the ID of the request, for support
+type Problem struct {
+	Type      string                 `json:"type"`
+	Title     string                 `json:"title"`
+	Status    int                    `json:"status"`
+	Detail    string                 `json:"detail"`
+	Code      string                 `json:"code"`
+	Message   string                 `json:"message"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"`
+}
+
+// New returns the problem details of an error: its status, code, and message
+func New(status int, code, message string, details map[string]interface{}, requestID string) Problem {
+	return Problem{
+		Type:      Type(code),
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    message,
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: requestID,
+	}
+}
+
+// Type returns the RFC 7807 type of a code, e.g. "urn:okebinda:problem:file-too-large" for "FILE_TOO_LARGE"
+func Type(code string) string {
+	return TypePrefix + strings.ReplaceAll(strings.ToLower(code), "_", "-")
+}
@@ -0,0 +1,28 @@
+package problem
+
+import "testing"
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		status    int
+		code      string
+		wantType  string
+		wantTitle string
+	}{
+		{413, FileTooLarge, "urn:okebinda:problem:file-too-large", "Request Entity Too Large"},
+		{400, UnsupportedType, "urn:okebinda:problem:unsupported-type", "Bad Request"},
+		{404, UploadRejected, "urn:okebinda:problem:upload-rejected", "Not Found"},
+		{500, InternalError, "urn:okebinda:problem:internal-error", "Internal Server Error"},
+	}
+	for _, test := range tests {
+		t.Run(test.code, func(t *testing.T) {
+			p := New(test.status, test.code, "message", nil, "request")
+			if p.Type != test.wantType || p.Title != test.wantTitle || p.Code != test.code || p.Status != test.status {
+				t.Errorf("New() = %+v, want type %s, title %s", p, test.wantType, test.wantTitle)
+			}
+			if p.Detail != "message" || p.Message != "message" || p.RequestID != "request" {
+				t.Errorf("New() = %+v, want the message and request ID", p)
+			}
+		})
+	}
+}
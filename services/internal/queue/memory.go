@@ -0,0 +1,72 @@
+package queue
+
+import (
+	"context"
+	"strconv"
+	"sync"
+)
+
+// MemoryQueue is an in-process queue, for local use; received messages are held in flight until acknowledged
+// and are not redelivered
+type MemoryQueue struct {
+	mu       sync.Mutex
+	nextID   int
+	pending  []Message
+	inFlight map[string]Message
+}
+
+// memoryQueues holds the named in-memory queues so producers and consumers share them
+var memoryQueues = map[string]*MemoryQueue{}
+var memoryQueuesMu sync.Mutex
+
+// Memory returns the named in-memory queue, creating it if needed
+func Memory(name string) *MemoryQueue {
+	memoryQueuesMu.Lock()
+	defer memoryQueuesMu.Unlock()
+	q, ok := memoryQueues[name]
+	if !ok {
+		q = &MemoryQueue{inFlight: map[string]Message{}}
+		memoryQueues[name] = q
+	}
+	return q
+}
+
+// Publish appends a message to the queue, returning its message ID
+func (q *MemoryQueue) Publish(ctx context.Context, message Message) (string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.nextID++
+	message.ID = strconv.Itoa(q.nextID)
+	message.ReceiptHandle = message.ID
+	q.pending = append(q.pending, message)
+	return message.ID, nil
+}
+
+// Receive removes up to maxMessages messages from the queue
+func (q *MemoryQueue) Receive(ctx context.Context, maxMessages int) ([]Message, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := len(q.pending)
+	if maxMessages < n {
+		n = maxMessages
+	}
+	messages := q.pending[:n:n]
+	q.pending = q.pending[n:]
+	for _, message := range messages {
+		q.inFlight[message.ReceiptHandle] = message
+	}
+	return messages, nil
+}
+
+// Ack releases a received message
+func (q *MemoryQueue) Ack(ctx context.Context, message Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.inFlight, message.ReceiptHandle)
+	return nil
+}
+
+// Check always succeeds, as in-memory queues are created on demand
+func (q *MemoryQueue) Check(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,127 @@
+// Package queue provides a broker-agnostic message queue with SQS, SNS, and in-memory implementations
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// ErrUnsupported is returned by implementations that cannot perform an operation, e.g. receiving from SNS
+var ErrUnsupported = errors.New("queue: operation not supported")
+
+// Message defines the envelope for a message published to or received from a queue
+type Message struct {
+	ID            string
+	Body          []byte
+	Attributes    map[string]string
+	ReceiptHandle string
+}
+
+// Queue defines a message queue; messages received must be acknowledged with Ack once processed, otherwise
+// they are redelivered; Check verifies the queue exists and is accessible
+type Queue interface {
+	Publish(ctx context.Context, message Message) (string, error)
+	Receive(ctx context.Context, maxMessages int) ([]Message, error)
+	Ack(ctx context.Context, message Message) error
+	Check(ctx context.Context) error
+}
+
+// resolved holds the destinations Open has resolved to SQS queue URLs, so they are reused for the life of the
+// process
+var resolved = map[string]string{}
+var resolvedMu sync.Mutex
+
+// New creates a queue for a destination: an SQS queue URL or ARN, "sqs://{name}" for an SQS queue in the
+// session's account and region, an SNS topic ARN, or "memory://{name}" for an in-memory queue
+func New(sess *session.Session, destination string) (Queue, error) {
+	return NewWithClient(sess, sqs.New(sess), destination)
+}
+
+// NewWithClient creates a queue for a destination like New, sending to SQS queues with an SQS client, e.g. a fake
+// in tests
+func NewWithClient(sess *session.Session, svc sqsiface.SQSAPI, destination string) (Queue, error) {
+	switch {
+	case strings.HasPrefix(destination, "arn:aws:sns:"):
+		return NewSNS(sess, destination), nil
+	case strings.HasPrefix(destination, "arn:aws:sqs:"):
+		queueURL, err := sqsURL(destination)
+		if err != nil {
+			return nil, err
+		}
+		return NewSQS(svc, queueURL), nil
+	case strings.HasPrefix(destination, "sqs://"):
+		output, err := svc.GetQueueUrl(&sqs.GetQueueUrlInput{
+			QueueName: aws.String(strings.TrimPrefix(destination, "sqs://")),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return NewSQS(svc, aws.StringValue(output.QueueUrl)), nil
+	case strings.HasPrefix(destination, "memory://"):
+		return Memory(strings.TrimPrefix(destination, "memory://")), nil
+	case strings.HasPrefix(destination, "https://"):
+		return NewSQS(svc, destination), nil
+	}
+	return nil, errors.New("queue: unsupported destination: " + destination)
+}
+
+// Open returns the queue for a destination, as New, resolving "sqs://{name}" destinations on first use and
+// reusing their queue URLs afterwards, so queue names are only resolved once per process; the queue is created
+// with sess, so its requests are sent with the session's context
+func Open(sess *session.Session, destination string) (Queue, error) {
+	return OpenWithClient(sess, sqs.New(sess), destination)
+}
+
+// OpenWithClient returns the queue for a destination like Open, sending to SQS queues with an SQS client, e.g. a
+// fake in tests
+func OpenWithClient(sess *session.Session, svc sqsiface.SQSAPI, destination string) (Queue, error) {
+	resolvedMu.Lock()
+	defer resolvedMu.Unlock()
+	if queueURL, ok := resolved[destination]; ok {
+		return NewSQS(svc, queueURL), nil
+	}
+	q, err := NewWithClient(sess, svc, destination)
+	if err != nil {
+		return nil, err
+	}
+	if sqsQueue, ok := q.(*SQS); ok && strings.HasPrefix(destination, "sqs://") {
+		resolved[destination] = sqsQueue.queueURL
+	}
+	return q, nil
+}
+
+// sqsURL converts an SQS queue ARN, "arn:aws:sqs:{region}:{account}:{name}", to its queue URL
+func sqsURL(queueArn string) (string, error) {
+	parts := strings.Split(queueArn, ":")
+	if len(parts) != 6 || parts[3] == "" || parts[4] == "" || parts[5] == "" {
+		return "", errors.New("queue: invalid SQS queue ARN: " + queueArn)
+	}
+	return fmt.Sprintf("https://sqs.%s.amazonaws.com/%s/%s", parts[3], parts[4], parts[5]), nil
+}
+
+// PublishRetry publishes a message, retrying failed publishes up to attempts times in all, waiting backoff before
+// the first retry and doubling the wait after each, returning the last error if none succeed; waits are
+// abandoned once ctx is done
+func PublishRetry(ctx context.Context, q Queue, message Message, attempts int, backoff time.Duration) (string, error) {
+	for attempt := 1; ; attempt++ {
+		messageID, err := q.Publish(ctx, message)
+		if err == nil || attempt >= attempts {
+			return messageID, err
+		}
+		select {
+		case <-ctx.Done():
+			return "", err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,61 @@
+package queue
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+// SNS is a publish-only queue backed by an SNS topic; subscribers receive messages through their own queues
+type SNS struct {
+	svc      *sns.SNS
+	topicArn string
+}
+
+// NewSNS creates a queue for an SNS topic ARN
+func NewSNS(sess *session.Session, topicArn string) *SNS {
+	return &SNS{svc: sns.New(sess), topicArn: topicArn}
+}
+
+// Publish publishes a message to the topic, returning its message ID
+func (q *SNS) Publish(ctx context.Context, message Message) (string, error) {
+	var attributes map[string]*sns.MessageAttributeValue
+	if len(message.Attributes) > 0 {
+		attributes = map[string]*sns.MessageAttributeValue{}
+		for name, value := range message.Attributes {
+			attributes[name] = &sns.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(value),
+			}
+		}
+	}
+	output, err := q.svc.PublishWithContext(ctx, &sns.PublishInput{
+		TopicArn:          aws.String(q.topicArn),
+		Message:           aws.String(string(message.Body)),
+		MessageAttributes: attributes,
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(output.MessageId), nil
+}
+
+// Receive is not supported by SNS
+func (q *SNS) Receive(ctx context.Context, maxMessages int) ([]Message, error) {
+	return nil, ErrUnsupported
+}
+
+// Ack is not supported by SNS
+func (q *SNS) Ack(ctx context.Context, message Message) error {
+	return ErrUnsupported
+}
+
+// Check verifies the topic exists and is accessible
+func (q *SNS) Check(ctx context.Context) error {
+	_, err := q.svc.GetTopicAttributesWithContext(ctx, &sns.GetTopicAttributesInput{
+		TopicArn: aws.String(q.topicArn),
+	})
+	return err
+}
@@ -0,0 +1,109 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// SQS is a queue backed by an SQS queue
+type SQS struct {
+	svc      sqsiface.SQSAPI
+	queueURL string
+}
+
+// NewSQS creates a queue for an SQS queue URL, sending its requests with an SQS client
+func NewSQS(svc sqsiface.SQSAPI, queueURL string) *SQS {
+	return &SQS{svc: svc, queueURL: queueURL}
+}
+
+// Publish sends a message to the queue, returning its message ID
+func (q *SQS) Publish(ctx context.Context, message Message) (string, error) {
+	output, err := q.svc.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+		QueueUrl:          aws.String(q.queueURL),
+		MessageBody:       aws.String(string(message.Body)),
+		MessageAttributes: sqsAttributes(message.Attributes),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(output.MessageId), nil
+}
+
+// PublishDelayed sends a message to the queue, delivered once delay (up to 15 minutes) passes, returning its
+// message ID; FIFO queues do not support delaying single messages
+func (q *SQS) PublishDelayed(ctx context.Context, message Message, delay time.Duration) (string, error) {
+	output, err := q.svc.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+		QueueUrl:          aws.String(q.queueURL),
+		MessageBody:       aws.String(string(message.Body)),
+		MessageAttributes: sqsAttributes(message.Attributes),
+		DelaySeconds:      aws.Int64(int64(delay / time.Second)),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(output.MessageId), nil
+}
+
+// Receive long-polls the queue for up to maxMessages messages
+func (q *SQS) Receive(ctx context.Context, maxMessages int) ([]Message, error) {
+	output, err := q.svc.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:              aws.String(q.queueURL),
+		MaxNumberOfMessages:   aws.Int64(int64(maxMessages)),
+		MessageAttributeNames: []*string{aws.String("All")},
+		WaitTimeSeconds:       aws.Int64(20),
+	})
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]Message, 0, len(output.Messages))
+	for _, m := range output.Messages {
+		attributes := map[string]string{}
+		for name, value := range m.MessageAttributes {
+			attributes[name] = aws.StringValue(value.StringValue)
+		}
+		messages = append(messages, Message{
+			ID:            aws.StringValue(m.MessageId),
+			Body:          []byte(aws.StringValue(m.Body)),
+			Attributes:    attributes,
+			ReceiptHandle: aws.StringValue(m.ReceiptHandle),
+		})
+	}
+	return messages, nil
+}
+
+// Ack deletes a received message from the queue
+func (q *SQS) Ack(ctx context.Context, message Message) error {
+	_, err := q.svc.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(q.queueURL),
+		ReceiptHandle: aws.String(message.ReceiptHandle),
+	})
+	return err
+}
+
+// Check verifies the queue exists and is accessible
+func (q *SQS) Check(ctx context.Context) error {
+	_, err := q.svc.GetQueueAttributesWithContext(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(q.queueURL),
+		AttributeNames: []*string{aws.String(sqs.QueueAttributeNameQueueArn)},
+	})
+	return err
+}
+
+// sqsAttributes converts message attributes to SQS string attributes
+func sqsAttributes(attributes map[string]string) map[string]*sqs.MessageAttributeValue {
+	if len(attributes) == 0 {
+		return nil
+	}
+	values := map[string]*sqs.MessageAttributeValue{}
+	for name, value := range attributes {
+		values[name] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(value),
+		}
+	}
+	return values
+}
@@ -0,0 +1,62 @@
+// Package rejections records why uploads were rejected in a DynamoDB table, keyed by file ID, so integrators can
+// look up the details themselves
+package rejections
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// retention is how long rejections are kept before the table's TTL deletes them
+const retention = 30 * 24 * time.Hour
+
+// Rejection defines an upload that was rejected: the rule it failed and what was measured
+type Rejection struct {
+	FileID       string `dynamodbav:"file_id" json:"file_id"`
+	FileKey      string `dynamodbav:"file_key" json:"file_key"`
+	Rule         string `dynamodbav:"rule" json:"rule"`
+	Message      string `dynamodbav:"message" json:"message"`
+	DetectedType string `dynamodbav:"detected_type,omitempty" json:"detected_type,omitempty"`
+	MagicBytes   string `dynamodbav:"magic_bytes,omitempty" json:"magic_bytes,omitempty"`
+	SizeBytes    int64  `dynamodbav:"size_bytes" json:"size_bytes"`
+	Limit        string `dynamodbav:"limit,omitempty" json:"limit,omitempty"`
+	RejectedAt   string `dynamodbav:"rejected_at" json:"rejected_at"`
+	Expires      int64  `dynamodbav:"expires" json:"-"`
+}
+
+// Put records a rejection, replacing any previous rejection of the same file
+func Put(sess *session.Session, table string, rejection Rejection) error {
+	if rejection.RejectedAt == "" {
+		rejection.RejectedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	rejection.Expires = time.Now().Add(retention).Unix()
+	item, err := dynamodbattribute.MarshalMap(rejection)
+	if err != nil {
+		return err
+	}
+	_, err = dynamodb.New(sess).PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item:      item,
+	})
+	return err
+}
+
+// Get reads the latest rejection of a file; returns false if there is none
+func Get(sess *session.Session, table, fileID string) (Rejection, bool, error) {
+	var rejection Rejection
+	output, err := dynamodb.New(sess).GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"file_id": {S: aws.String(fileID)},
+		},
+	})
+	if err != nil || output.Item == nil {
+		return rejection, false, err
+	}
+	err = dynamodbattribute.UnmarshalMap(output.Item, &rejection)
+	return rejection, err == nil, err
+}
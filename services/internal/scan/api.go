@@ -0,0 +1,54 @@
+package scan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// maxResponseBytes limits how much of a scanning API's response is read
+const maxResponseBytes = 65536
+
+// API scans files with an external scanning API: the file is POSTed as the request body, with any headers, and
+// the API responds with a Result as JSON, e.g. {"infected": true, "signature": "Eicar-Signature"}
+type API struct {
+	URL     string
+	Headers map[string]string
+	Timeout time.Duration
+}
+
+// Scan posts content to the scanning API
+func (a API) Scan(ctx context.Context, content io.Reader) (Result, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", a.URL, content)
+	if err != nil {
+		return Result{}, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	for name, value := range a.Headers {
+		req.Header.Set(name, value)
+	}
+
+	client := &http.Client{Timeout: a.Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return Result{}, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return Result{}, fmt.Errorf("scanning API responded with %d: %s", resp.StatusCode, body)
+	}
+
+	var result Result
+	if err = json.Unmarshal(body, &result); err != nil {
+		return Result{}, fmt.Errorf("could not parse scanning API response: %v", err)
+	}
+	return result, nil
+}
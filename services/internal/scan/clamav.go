@@ -0,0 +1,66 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// clamscan exit codes
+const (
+	clamscanClean    = 0
+	clamscanInfected = 1
+)
+
+// ClamAV scans files with the clamscan command, e.g. from a Lambda layer, against a signature database directory
+type ClamAV struct {
+	Path     string
+	Database string
+	Timeout  time.Duration
+}
+
+// Scan streams content to clamscan
+func (c ClamAV) Scan(ctx context.Context, content io.Reader) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.Path, "--no-summary", "--stdout", "--database="+c.Database, "-")
+	cmd.Stdin = content
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+
+	code := clamscanClean
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		code = exitErr.ExitCode()
+	} else if err != nil {
+		return Result{}, err
+	}
+	switch code {
+	case clamscanClean:
+		return Result{}, nil
+	case clamscanInfected:
+		return Result{Infected: true, Signature: clamscanSignature(stdout.String())}, nil
+	}
+	return Result{}, fmt.Errorf("clamscan exited with %d: %s", code, strings.TrimSpace(stderr.String()))
+}
+
+// clamscanSignature reads the signature name from clamscan's report, e.g. "stdin: Eicar-Signature FOUND"
+func clamscanSignature(report string) string {
+	for _, line := range strings.Split(report, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasSuffix(line, " FOUND") {
+			line = strings.TrimSuffix(line, " FOUND")
+			if i := strings.LastIndex(line, ": "); i >= 0 {
+				return line[i+2:]
+			}
+			return line
+		}
+	}
+	return ""
+}
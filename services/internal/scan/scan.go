@@ -0,0 +1,18 @@
+// Package scan scans uploaded files for viruses and malware, with ClamAV or an external scanning API
+package scan
+
+import (
+	"context"
+	"io"
+)
+
+// Result defines the verdict of a scan: whether the file is infected, and the name of the signature it matched
+type Result struct {
+	Infected  bool   `json:"infected"`
+	Signature string `json:"signature"`
+}
+
+// Scanner scans a file's content, abandoning the scan if ctx is done
+type Scanner interface {
+	Scan(ctx context.Context, content io.Reader) (Result, error)
+}
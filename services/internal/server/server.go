@@ -0,0 +1,107 @@
+// Package server runs a service's HTTP router as a standalone server, for containers outside Lambda
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultAddr is the address served if LISTEN_ADDR is not set
+const DefaultAddr = ":8080"
+
+// RequestIDHeader is the request header a load balancer or proxy may pass the request ID in
+const RequestIDHeader = "X-Request-Id"
+
+// shutdownTimeout is how long in-flight requests may take to finish once the server is stopped
+const shutdownTimeout = 30 * time.Second
+
+// Addr returns the address to serve, from the LISTEN_ADDR env parameter
+func Addr() string {
+	if addr := os.Getenv("LISTEN_ADDR"); addr != "" {
+		return addr
+	}
+	return DefaultAddr
+}
+
+// Serial wraps a handler so requests are served one at a time, as a Lambda instance serves them, calling begin
+// with each request's context and ID first and the function it returns after; the services keep per-request
+// state in globals, so this must wrap their routers. Scale out by running more containers
+func Serial(handler http.Handler, begin func(ctx context.Context, requestID string) func()) http.Handler {
+	var mu sync.Mutex
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		end := begin(r.Context(), RequestID(r))
+		defer end()
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// RequestID returns the ID of a request from its X-Request-Id header, or else a random ID
+func RequestID(r *http.Request) string {
+	if id := r.Header.Get(RequestIDHeader); id != "" {
+		return id
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
+
+// Serve serves a handler on an address until the process receives SIGINT or SIGTERM, then waits for in-flight
+// requests to finish; the pprof profiles are also served on the PPROF_ADDR env parameter's address, if set
+func Serve(addr string, handler http.Handler) error {
+	if debugAddr := os.Getenv("PPROF_ADDR"); debugAddr != "" {
+		go serveProfiles(debugAddr)
+	}
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-errs:
+		return err
+	case <-stop:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(ctx)
+}
+
+// serveProfiles serves the pprof profiles under /debug/pprof/ on an address, kept off the service's own address
+// so they are never exposed with it, e.g. for `go tool pprof http://localhost:6060/debug/pprof/profile`
+func serveProfiles(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.Printf("Could not serve pprof profiles on %s: %v", addr, srv.ListenAndServe())
+}
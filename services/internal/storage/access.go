@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/okebinda/internal/keys"
+)
+
+// ACLNone is the OBJECT_ACL value that writes objects without any ACL, for buckets with ACLs disabled (S3 Object
+// Ownership "bucket owner enforced"), which refuse requests that set one
+const ACLNone = "none"
+
+// URL modes of the OBJECT_URL_MODE env parameter
+const (
+	URLModePublic     = "public"
+	URLModeCloudFront = "cloudfront"
+	URLModePresigned  = "presigned"
+)
+
+// defaultURLExpiryMinutes is how long presigned object URLs are valid if OBJECT_URL_EXPIRY_MINUTES is not set
+const defaultURLExpiryMinutes = 60
+
+// PublicACL returns the canned ACL objects served to anyone are written with, from the OBJECT_ACL env parameter,
+// "public-read" if it is not set, or "" if it is "none", so objects are only readable as their bucket policy allows
+func PublicACL() string {
+	switch acl := os.Getenv("OBJECT_ACL"); acl {
+	case "":
+		return "public-read"
+	case ACLNone:
+		return ""
+	default:
+		return acl
+	}
+}
+
+// ACLsEnabled tests if objects may be written with an ACL, unless the OBJECT_ACL env parameter is "none"
+func ACLsEnabled() bool {
+	return os.Getenv("OBJECT_ACL") != ACLNone
+}
+
+// cannedACL returns the ACL an object is written with, or "" if ACLs are disabled and none may be sent, not even
+// "private"; new objects are private by default
+func cannedACL(acl string) string {
+	if !ACLsEnabled() {
+		return ""
+	}
+	return acl
+}
+
+// URLsExpire tests if the OBJECT_URL_MODE env parameter is "presigned", so object URLs must not be cached for
+// longer than they are valid
+func URLsExpire() bool {
+	return os.Getenv("OBJECT_URL_MODE") == URLModePresigned
+}
+
+// ObjectURL returns the URL an object is served from, as set by the OBJECT_URL_MODE env parameter: for "public"
+// (the default), publicURL, the URL of the object in its public bucket; for "cloudfront", the object's URL under
+// CLOUDFRONT_DOMAIN, a distribution reading the buckets listed in CLOUDFRONT_BUCKETS (comma separated) through an
+// origin access control; and for "presigned", or buckets not behind the distribution, a GET URL valid for
+// OBJECT_URL_EXPIRY_MINUTES (default 60)
+func ObjectURL(svc s3iface.S3API, bucketName, fileKey, publicURL string) (string, error) {
+	mode := os.Getenv("OBJECT_URL_MODE")
+	switch mode {
+	case "", URLModePublic:
+		return publicURL, nil
+	case URLModeCloudFront:
+		domain := os.Getenv("CLOUDFRONT_DOMAIN")
+		if domain == "" {
+			return "", fmt.Errorf("OBJECT_URL_MODE %s requires CLOUDFRONT_DOMAIN", mode)
+		}
+		if listedBucket("CLOUDFRONT_BUCKETS", bucketName) {
+			return fmt.Sprintf("https://%s/%s", strings.TrimSuffix(domain, "/"), keys.EscapePath(ObjectKey(bucketName, fileKey))), nil
+		}
+	case URLModePresigned:
+	default:
+		return "", fmt.Errorf("OBJECT_URL_MODE: %s, must be %s, %s, or %s", mode, URLModePublic, URLModeCloudFront, URLModePresigned)
+	}
+
+	// SigV4 presigned URLs are valid for a week at most
+	minutes := defaultURLExpiryMinutes
+	if value := os.Getenv("OBJECT_URL_EXPIRY_MINUTES"); value != "" {
+		var err error
+		minutes, err = strconv.Atoi(value)
+		if err != nil || minutes < 1 || minutes > 10080 {
+			return "", fmt.Errorf("OBJECT_URL_EXPIRY_MINUTES: %s, must be 1-10080", value)
+		}
+	}
+	return PresignGet(svc, bucketName, fileKey, time.Duration(minutes)*time.Minute)
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ObjectKey returns the key an object is stored under in an S3 bucket; if the bucket is listed in the
+// KEY_SHARD_BUCKETS env parameter (comma separated), a prefix of KEY_SHARD_DEPTH two-character segments derived
+// from a hash of the key is inserted before it, spreading objects across prefixes, e.g. "3f/a2/path/image.png"
+func ObjectKey(bucketName, fileKey string) string {
+	depth, err := strconv.Atoi(os.Getenv("KEY_SHARD_DEPTH"))
+	if err != nil || depth <= 0 || !isShardedBucket(bucketName) {
+		return fileKey
+	}
+	hash := md5.Sum([]byte(fileKey))
+	digest := hex.EncodeToString(hash[:])
+	if depth > len(digest)/2 {
+		depth = len(digest) / 2
+	}
+	segments := make([]string, 0, depth+1)
+	for i := 0; i < depth; i++ {
+		segments = append(segments, digest[i*2:i*2+2])
+	}
+	return strings.Join(append(segments, fileKey), "/")
+}
+
+// FileKey returns the key of an object as requests and responses use it, removing the prefix ObjectKey inserts
+// in sharded buckets
+func FileKey(bucketName, objectKey string) string {
+	depth, err := strconv.Atoi(os.Getenv("KEY_SHARD_DEPTH"))
+	if err != nil || depth <= 0 || !isShardedBucket(bucketName) {
+		return objectKey
+	}
+	if depth > md5.Size {
+		depth = md5.Size
+	}
+	segments := strings.SplitN(objectKey, "/", depth+1)
+	return segments[len(segments)-1]
+}
+
+// isShardedBucket tests if an S3 bucket is listed in the KEY_SHARD_BUCKETS env parameter
+func isShardedBucket(bucketName string) bool {
+	return listedBucket("KEY_SHARD_BUCKETS", bucketName)
+}
+
+// listedBucket tests if an S3 bucket is listed in an env parameter of comma separated bucket names
+func listedBucket(envName, bucketName string) bool {
+	for _, name := range strings.Split(os.Getenv(envName), ",") {
+		if strings.TrimSpace(name) == bucketName && bucketName != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"os"
+	"testing"
+)
+
+// setenv sets an env parameter for the duration of a test
+func setenv(t *testing.T, name, value string) {
+	t.Helper()
+	previous, ok := os.LookupEnv(name)
+	os.Setenv(name, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(name, previous)
+		} else {
+			os.Unsetenv(name)
+		}
+	})
+}
+
+func TestObjectKey(t *testing.T) {
+	setenv(t, "KEY_SHARD_BUCKETS", "sharded, other")
+
+	tests := []struct {
+		name, depth, bucket, key, want string
+	}{
+		{"bucket not sharded", "2", "plain", "path/image.png", "path/image.png"},
+		{"no depth", "", "sharded", "path/image.png", "path/image.png"},
+		{"bad depth", "x", "sharded", "path/image.png", "path/image.png"},
+		{"one segment", "1", "sharded", "path/image.png", "81/path/image.png"},
+		{"two segments", "2", "sharded", "path/image.png", "81/e9/path/image.png"},
+		{"listed with spaces", "1", "other", "path/image.png", "81/path/image.png"},
+		{"depth beyond hash", "20", "sharded", "a", "0c/c1/75/b9/c0/f1/b6/a8/31/c3/99/e2/69/77/26/61/a"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setenv(t, "KEY_SHARD_DEPTH", test.depth)
+			objectKey := ObjectKey(test.bucket, test.key)
+			if objectKey != test.want {
+				t.Errorf("ObjectKey() = %q, want %q", objectKey, test.want)
+			}
+			if fileKey := FileKey(test.bucket, objectKey); fileKey != test.key {
+				t.Errorf("FileKey(%q) = %q, want %q", objectKey, fileKey, test.key)
+			}
+		})
+	}
+}
@@ -0,0 +1,540 @@
+// Package storage provides the S3 object operations shared by the services
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// Client returns an S3 client for a session, sending requests to the session's endpoint, overridden for every
+// client by awsconfig.Config
+func Client(sess *session.Session) *s3.S3 {
+	return s3.New(sess)
+}
+
+// DownloadFile downloads a file from an S3 bucket
+func DownloadFile(svc s3iface.S3API, file *os.File, bucketName, fileKey string) (int64, error) {
+	downloader := s3manager.NewDownloaderWithClient(svc)
+	numBytes, err := downloader.Download(file,
+		&s3.GetObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(ObjectKey(bucketName, fileKey)),
+		})
+	return numBytes, err
+}
+
+// UploadFile uploads a publicly readable file to an S3 bucket, with the PublicACL
+func UploadFile(svc s3iface.S3API, file *os.File, bucketName, fileKey, fileType string) error {
+	return UploadFileACL(svc, file, bucketName, fileKey, fileType, PublicACL())
+}
+
+// UploadFileACL uploads a file to an S3 bucket with a canned ACL, to be downloaded as an attachment
+func UploadFileACL(svc s3iface.S3API, file *os.File, bucketName, fileKey, fileType, acl string) error {
+	return UploadFileMetadata(svc, file, bucketName, fileKey, ObjectMetadata{
+		ACL:                acl,
+		ContentDisposition: "attachment",
+		ContentType:        fileType,
+	})
+}
+
+// UploadFileMetadata uploads a file to an S3 bucket with the given metadata; empty values are not set, nor is the
+// ACL if ACLs are disabled
+func UploadFileMetadata(svc s3iface.S3API, file *os.File, bucketName, fileKey string, metadata ObjectMetadata) error {
+
+	// Get file size and read the file content into a buffer
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	var size int64 = fileInfo.Size()
+	buffer := make([]byte, size)
+	if _, err := file.Read(buffer); err != nil {
+		return err
+	}
+
+	// upload to bucket
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(bucketName),
+		Key:           aws.String(ObjectKey(bucketName, fileKey)),
+		Body:          bytes.NewReader(buffer),
+		ContentLength: aws.Int64(size),
+	}
+	if acl := cannedACL(metadata.ACL); acl != "" {
+		input.ACL = aws.String(acl)
+	}
+	if metadata.CacheControl != "" {
+		input.CacheControl = aws.String(metadata.CacheControl)
+	}
+	if metadata.ContentDisposition != "" {
+		input.ContentDisposition = aws.String(metadata.ContentDisposition)
+	}
+	if metadata.ContentType != "" {
+		input.ContentType = aws.String(metadata.ContentType)
+	}
+	if len(metadata.Metadata) > 0 {
+		input.Metadata = metadata.Metadata
+	}
+	_, err = svc.PutObject(input)
+	return err
+}
+
+// PutObject writes a private object to an S3 bucket from its content in memory
+func PutObject(svc s3iface.S3API, bucketName, fileKey string, body []byte, fileType string) error {
+	_, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket:        aws.String(bucketName),
+		Key:           aws.String(ObjectKey(bucketName, fileKey)),
+		Body:          bytes.NewReader(body),
+		ContentLength: aws.Int64(int64(len(body))),
+		ContentType:   aws.String(fileType),
+	})
+	return err
+}
+
+// DeleteObject deletes a file from an S3 bucket
+func DeleteObject(svc s3iface.S3API, bucketName, fileKey string) error {
+	_, err := svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(ObjectKey(bucketName, fileKey)),
+	})
+	return err
+}
+
+// deleteBatchSize is the most keys S3 deletes in one request
+const deleteBatchSize = 1000
+
+// DeleteObjects deletes objects from an S3 bucket by their raw keys, in batches; keys of missing objects are
+// ignored
+func DeleteObjects(svc s3iface.S3API, bucketName string, objectKeys []string) error {
+	for start := 0; start < len(objectKeys); start += deleteBatchSize {
+		end := start + deleteBatchSize
+		if end > len(objectKeys) {
+			end = len(objectKeys)
+		}
+		objects := make([]*s3.ObjectIdentifier, 0, end-start)
+		for _, key := range objectKeys[start:end] {
+			objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(key)})
+		}
+		output, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(bucketName),
+			Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+		if len(output.Errors) > 0 {
+			return fmt.Errorf("could not delete %d objects, first: %s, %s", len(output.Errors), aws.StringValue(output.Errors[0].Key), aws.StringValue(output.Errors[0].Message))
+		}
+	}
+	return nil
+}
+
+// ListKeys lists the raw keys of every object in an S3 bucket whose key starts with a prefix
+func ListKeys(svc s3iface.S3API, bucketName, prefix string) ([]string, error) {
+	var objectKeys []string
+	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(prefix),
+	}, func(output *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range output.Contents {
+			objectKeys = append(objectKeys, aws.StringValue(object.Key))
+		}
+		return true
+	})
+	return objectKeys, err
+}
+
+// ListFolders lists the "folders" directly under a prefix in an S3 bucket: the distinct prefixes of its objects'
+// keys up to the next "/", e.g. "ratio/400x300/" under "ratio/"
+func ListFolders(svc s3iface.S3API, bucketName, prefix string) ([]string, error) {
+	var folders []string
+	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucketName),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}, func(output *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, folder := range output.CommonPrefixes {
+			folders = append(folders, aws.StringValue(folder.Prefix))
+		}
+		return true
+	})
+	return folders, err
+}
+
+// HeadObject retrieves the metadata of an object in an S3 bucket
+func HeadObject(svc s3iface.S3API, bucketName, fileKey string) (*s3.HeadObjectOutput, error) {
+	return svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(ObjectKey(bucketName, fileKey)),
+	})
+}
+
+// WaitForObject polls an object in an S3 bucket until it is retrievable with the expected size, making up to
+// attempts requests with delay between them
+func WaitForObject(svc s3iface.S3API, bucketName, fileKey string, size int64, attempts int, delay time.Duration) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			time.Sleep(delay)
+		}
+		var header *s3.HeadObjectOutput
+		header, err = HeadObject(svc, bucketName, fileKey)
+		if err == nil {
+			if aws.Int64Value(header.ContentLength) == size {
+				return nil
+			}
+			err = fmt.Errorf("unexpected object size: %d, expected: %d", aws.Int64Value(header.ContentLength), size)
+		}
+	}
+	return err
+}
+
+// ReadRange reads the first numBytes bytes of an object in an S3 bucket
+func ReadRange(svc s3iface.S3API, bucketName, fileKey string, numBytes int) ([]byte, error) {
+	output, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(ObjectKey(bucketName, fileKey)),
+		Range:  aws.String(fmt.Sprintf("bytes=0-%d", numBytes-1)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer output.Body.Close()
+	return ioutil.ReadAll(output.Body)
+}
+
+// OpenObject opens an object in an S3 bucket to stream its content; the caller must close it
+func OpenObject(svc s3iface.S3API, bucketName, fileKey string) (io.ReadCloser, error) {
+	output, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(ObjectKey(bucketName, fileKey)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.Body, nil
+}
+
+// CopyObject copies an object between S3 buckets, replacing its metadata; the copy is private
+func CopyObject(svc s3iface.S3API, sourceBucket, sourceKey, destinationBucket, destinationKey, fileType string) error {
+	return CopyObjectMetadata(svc, sourceBucket, sourceKey, destinationBucket, destinationKey, ObjectMetadata{
+		ContentDisposition: "attachment",
+		ContentType:        fileType,
+	})
+}
+
+// CopyObjectMetadata copies an object between S3 buckets, replacing its metadata with the given values
+func CopyObjectMetadata(svc s3iface.S3API, sourceBucket, sourceKey, destinationBucket, destinationKey string, metadata ObjectMetadata) error {
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(destinationBucket),
+		Key:               aws.String(ObjectKey(destinationBucket, destinationKey)),
+		CopySource:        aws.String(copySource(sourceBucket, sourceKey)),
+		MetadataDirective: aws.String("REPLACE"),
+	}
+	applyMetadata(input, metadata)
+	_, err := svc.CopyObject(input)
+	return err
+}
+
+// MoveObject moves an object between S3 buckets, keeping its metadata, tags, and server-side encryption
+func MoveObject(svc s3iface.S3API, sourceBucket, sourceKey, destinationBucket, destinationKey string) error {
+	header, err := HeadObject(svc, sourceBucket, sourceKey)
+	if err != nil {
+		return err
+	}
+	_, err = svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:               aws.String(destinationBucket),
+		Key:                  aws.String(ObjectKey(destinationBucket, destinationKey)),
+		CopySource:           aws.String(copySource(sourceBucket, sourceKey)),
+		MetadataDirective:    aws.String("COPY"),
+		TaggingDirective:     aws.String("COPY"),
+		ServerSideEncryption: header.ServerSideEncryption,
+		SSEKMSKeyId:          header.SSEKMSKeyId,
+	})
+	if err != nil {
+		return err
+	}
+	return DeleteObject(svc, sourceBucket, sourceKey)
+}
+
+// copySource returns the source of a copy request, the bucket and the object's key in it, URL-encoded as S3
+// requires; '+' is encoded too, as S3 would otherwise decode it as a space
+func copySource(bucketName, fileKey string) string {
+	source := url.PathEscape(fmt.Sprintf("%s/%s", bucketName, ObjectKey(bucketName, fileKey)))
+	return strings.ReplaceAll(source, "+", "%2B")
+}
+
+// PutConditions defines the headers a presigned upload must send with exactly the signed values: its content
+// type, any user metadata, and, if set, its tags (URL query encoded, e.g. "source=upload&team=web") and server-side
+// encryption ("AES256" or "aws:kms", with an optional KMS key ID)
+type PutConditions struct {
+	ContentType          string
+	Metadata             map[string]string
+	Tagging              string
+	ServerSideEncryption string
+	SSEKMSKeyID          string
+}
+
+// PresignPut generates a presigned upload URL for an S3 bucket, and the signed headers the upload must send with
+// the same values; uploads that omit or change any of them are rejected by S3
+func PresignPut(svc s3iface.S3API, bucketName, fileKey string, conditions PutConditions, expires time.Duration) (string, map[string]string, error) {
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(ObjectKey(bucketName, fileKey)),
+		ContentType: aws.String(conditions.ContentType),
+	}
+	if len(conditions.Metadata) > 0 {
+		input.Metadata = aws.StringMap(conditions.Metadata)
+	}
+	if conditions.Tagging != "" {
+		input.Tagging = aws.String(conditions.Tagging)
+	}
+	if conditions.ServerSideEncryption != "" {
+		input.ServerSideEncryption = aws.String(conditions.ServerSideEncryption)
+	}
+	if conditions.SSEKMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(conditions.SSEKMSKeyID)
+	}
+	req, _ := svc.PutObjectRequest(input)
+	signedURL, signedHeaders, err := req.PresignRequest(expires)
+	if err != nil {
+		return "", nil, err
+	}
+	headers := map[string]string{}
+	for name := range signedHeaders {
+		headers[http.CanonicalHeaderKey(name)] = signedHeaders.Get(name)
+	}
+	return signedURL, headers, nil
+}
+
+// PresignGet generates a presigned download URL for an object in an S3 bucket
+func PresignGet(svc s3iface.S3API, bucketName, fileKey string, expires time.Duration) (string, error) {
+	req, _ := svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(ObjectKey(bucketName, fileKey)),
+	})
+	return req.Presign(expires)
+}
+
+// PresignDownload generates a presigned GET URL for an object like PresignGet, overriding the Content-Disposition
+// S3 sends with it (signed into the URL as response-content-disposition), unless contentDisposition is empty
+func PresignDownload(svc s3iface.S3API, bucketName, fileKey, contentDisposition string, expires time.Duration) (string, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(ObjectKey(bucketName, fileKey)),
+	}
+	if contentDisposition != "" {
+		input.ResponseContentDisposition = aws.String(contentDisposition)
+	}
+	req, _ := svc.GetObjectRequest(input)
+	return req.Presign(expires)
+}
+
+// ObjectInfo describes an object listed in an S3 bucket, by its unsharded key
+type ObjectInfo struct {
+	Key          string
+	ObjectKey    string
+	Size         int64
+	LastModified time.Time
+}
+
+// ListObjects lists a page of up to maxKeys objects in an S3 bucket whose keys start with a prefix, continuing
+// from a token returned with the previous page, if any; returns the token for the next page, or an empty string
+// for the last page; in sharded buckets, where keys with the same prefix are spread across shards, the whole
+// bucket is paged through and filtered, so pages may have fewer objects than maxKeys
+func ListObjects(svc s3iface.S3API, bucketName, prefix, token string, maxKeys int64) ([]ObjectInfo, string, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucketName),
+		MaxKeys: aws.Int64(maxKeys),
+	}
+	sharded := ObjectKey(bucketName, prefix) != prefix
+	if !sharded {
+		input.Prefix = aws.String(prefix)
+	}
+	if token != "" {
+		input.ContinuationToken = aws.String(token)
+	}
+	output, err := svc.ListObjectsV2(input)
+	if err != nil {
+		return nil, "", err
+	}
+
+	objects := []ObjectInfo{}
+	for _, object := range output.Contents {
+		objectKey := aws.StringValue(object.Key)
+		fileKey := FileKey(bucketName, objectKey)
+		if sharded && !strings.HasPrefix(fileKey, prefix) {
+			continue
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          fileKey,
+			ObjectKey:    objectKey,
+			Size:         aws.Int64Value(object.Size),
+			LastModified: aws.TimeValue(object.LastModified),
+		})
+	}
+	return objects, aws.StringValue(output.NextContinuationToken), nil
+}
+
+// IsNotFound tests if an S3 error indicates a missing object
+func IsNotFound(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, "NotFound":
+			return true
+		}
+	}
+	return false
+}
+
+// ObjectMetadata defines the replaceable metadata of an object
+type ObjectMetadata struct {
+	ACL                string
+	CacheControl       string
+	ContentDisposition string
+	ContentType        string
+	Metadata           map[string]*string
+}
+
+// ReplaceMetadata copies an object in an S3 bucket onto itself, replacing its metadata
+func ReplaceMetadata(svc s3iface.S3API, bucketName, fileKey string, metadata ObjectMetadata) error {
+	return CopyObjectMetadata(svc, bucketName, fileKey, bucketName, fileKey, metadata)
+}
+
+// applyMetadata sets the non-empty metadata values on a copy request, except the ACL if ACLs are disabled
+func applyMetadata(input *s3.CopyObjectInput, metadata ObjectMetadata) {
+	if acl := cannedACL(metadata.ACL); acl != "" {
+		input.ACL = aws.String(acl)
+	}
+	if metadata.CacheControl != "" {
+		input.CacheControl = aws.String(metadata.CacheControl)
+	}
+	if metadata.ContentDisposition != "" {
+		input.ContentDisposition = aws.String(metadata.ContentDisposition)
+	}
+	if metadata.ContentType != "" {
+		input.ContentType = aws.String(metadata.ContentType)
+	}
+	if len(metadata.Metadata) > 0 {
+		input.Metadata = metadata.Metadata
+	}
+}
+
+// MetadataAvailableFrom is the user metadata key holding the RFC 3339 time an embargoed object may be served from
+const MetadataAvailableFrom = "Available-From"
+
+// AvailableFrom returns the time an embargoed object may be served from, and false if it is not embargoed
+func AvailableFrom(header *s3.HeadObjectOutput) (time.Time, bool) {
+	for name, value := range header.Metadata {
+		if strings.EqualFold(name, MetadataAvailableFrom) {
+			availableFrom, err := time.Parse(time.RFC3339, aws.StringValue(value))
+			return availableFrom, err == nil
+		}
+	}
+	return time.Time{}, false
+}
+
+// MetadataPlaceholder is the user metadata key holding an image's placeholder, computed when it is published and
+// encoded by imageproc.Placeholder.String
+const MetadataPlaceholder = "Placeholder"
+
+// Placeholder returns the encoded placeholder of an object, and false if it was published without one
+func Placeholder(header *s3.HeadObjectOutput) (string, bool) {
+	for name, value := range header.Metadata {
+		if strings.EqualFold(name, MetadataPlaceholder) {
+			return aws.StringValue(value), true
+		}
+	}
+	return "", false
+}
+
+// EncryptedExtension is the file extension of client-side encrypted uploads, which are stored as ciphertext
+const EncryptedExtension = "enc"
+
+// EncryptedContentType is the content type client-side encrypted objects are uploaded and stored with
+const EncryptedContentType = "application/octet-stream"
+
+// user metadata keys of client-side encrypted objects: the cipher the client encrypted them with, e.g.
+// "AES-256-GCM", and the ID of the client's key, if any, so the client can decrypt them; the services never hold
+// the key
+const (
+	MetadataEncryption      = "Encryption"
+	MetadataEncryptionKeyID = "Encryption-Key-Id"
+)
+
+// Encryption returns the cipher and key ID of a client-side encrypted object, and false if it is not encrypted
+func Encryption(header *s3.HeadObjectOutput) (string, string, bool) {
+	var cipher, keyID string
+	for name, value := range header.Metadata {
+		switch {
+		case strings.EqualFold(name, MetadataEncryption):
+			cipher = aws.StringValue(value)
+		case strings.EqualFold(name, MetadataEncryptionKeyID):
+			keyID = aws.StringValue(value)
+		}
+	}
+	return cipher, keyID, cipher != ""
+}
+
+// maxUserMetadataBytes is the most user metadata S3 stores with an object, counting keys and values
+const maxUserMetadataBytes = 2048
+
+// ValidateMetadata checks metadata set by a request or configuration before objects are written with it: the
+// Content-Disposition must be "inline" or "attachment", with any parameters, e.g. a filename; values must be
+// printable ASCII; and user metadata keys must be letters, digits, and hyphens, other than the keys the services
+// set themselves, fitting within S3's 2 KB limit
+func ValidateMetadata(metadata ObjectMetadata) error {
+	if metadata.ContentDisposition != "" {
+		disposition, _, err := mime.ParseMediaType(metadata.ContentDisposition)
+		if err != nil || (disposition != "inline" && disposition != "attachment") {
+			return fmt.Errorf("content disposition must be inline or attachment: %s", metadata.ContentDisposition)
+		}
+	}
+	if !printable(metadata.CacheControl) || !printable(metadata.ContentDisposition) {
+		return errors.New("cache control and content disposition must be printable ASCII")
+	}
+	size := 0
+	for key, value := range metadata.Metadata {
+		if key == "" || strings.Trim(key, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-") != "" {
+			return fmt.Errorf("metadata key must be letters, digits, and hyphens: %q", key)
+		}
+		for _, reserved := range []string{MetadataAvailableFrom, MetadataPlaceholder, MetadataEncryption, MetadataEncryptionKeyID} {
+			if strings.EqualFold(key, reserved) {
+				return fmt.Errorf("metadata key is reserved: %s", key)
+			}
+		}
+		if !printable(aws.StringValue(value)) {
+			return fmt.Errorf("metadata value must be printable ASCII: %s", key)
+		}
+		size += len(key) + len(aws.StringValue(value))
+	}
+	if size > maxUserMetadataBytes {
+		return fmt.Errorf("metadata is too large: %d bytes, maximum: %d", size, maxUserMetadataBytes)
+	}
+	return nil
+}
+
+// printable tests if a header value is printable ASCII
+func printable(value string) bool {
+	for _, c := range value {
+		if c < ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestValidateMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata ObjectMetadata
+		ok       bool
+	}{
+		{"empty", ObjectMetadata{}, true},
+		{"inline", ObjectMetadata{ContentDisposition: "inline"}, true},
+		{"attachment with filename", ObjectMetadata{ContentDisposition: `attachment; filename="photo.jpg"`}, true},
+		{"unknown disposition", ObjectMetadata{ContentDisposition: "form-data"}, false},
+		{"malformed disposition", ObjectMetadata{ContentDisposition: "attachment; filename"}, false},
+		{"cache control", ObjectMetadata{CacheControl: "max-age=3600"}, true},
+		{"cache control not printable", ObjectMetadata{CacheControl: "max-age=3600\r\nX-Injected: 1"}, false},
+		{"user metadata", ObjectMetadata{Metadata: map[string]*string{"Photographer-Id": aws.String("42")}}, true},
+		{"empty key", ObjectMetadata{Metadata: map[string]*string{"": aws.String("42")}}, false},
+		{"key with underscore", ObjectMetadata{Metadata: map[string]*string{"photographer_id": aws.String("42")}}, false},
+		{"reserved key", ObjectMetadata{Metadata: map[string]*string{"available-from": aws.String("2021-01-01T00:00:00Z")}}, false},
+		{"reserved placeholder key", ObjectMetadata{Metadata: map[string]*string{"placeholder": aws.String("1x1:000000:")}}, false},
+		{"reserved encryption key", ObjectMetadata{Metadata: map[string]*string{MetadataEncryption: aws.String("AES-256-GCM")}}, false},
+		{"value not printable", ObjectMetadata{Metadata: map[string]*string{"Caption": aws.String("café")}}, false},
+		{"at size limit", ObjectMetadata{Metadata: map[string]*string{"Caption": aws.String(strings.Repeat("a", maxUserMetadataBytes-len("Caption")))}}, true},
+		{"over size limit", ObjectMetadata{Metadata: map[string]*string{"Caption": aws.String(strings.Repeat("a", maxUserMetadataBytes))}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateMetadata(test.metadata)
+			if test.ok && err != nil {
+				t.Errorf("ValidateMetadata() = %v, want nil", err)
+			}
+			if !test.ok && err == nil {
+				t.Error("ValidateMetadata() = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestCopySource(t *testing.T) {
+	setenv(t, "KEY_SHARD_BUCKETS", "sharded")
+	setenv(t, "KEY_SHARD_DEPTH", "1")
+
+	tests := []struct {
+		name, bucket, key, want string
+	}{
+		{"plain", "images", "path/image.png", "images%2Fpath%2Fimage.png"},
+		{"space", "images", "photo (1).jpg", "images%2Fphoto%20%281%29.jpg"},
+		{"plus", "images", "a+b.jpg", "images%2Fa%2Bb.jpg"},
+		{"query and fragment", "images", "a?b#c.jpg", "images%2Fa%3Fb%23c.jpg"},
+		{"percent", "images", "100%.jpg", "images%2F100%25.jpg"},
+		{"unicode", "images", "café.jpg", "images%2Fcaf%C3%A9.jpg"},
+		{"sharded", "sharded", "path/image.png", "sharded%2F81%2Fpath%2Fimage.png"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := copySource(test.bucket, test.key); got != test.want {
+				t.Errorf("copySource() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAvailableFrom(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata map[string]*string
+		want     time.Time
+		ok       bool
+	}{
+		{"not embargoed", nil, time.Time{}, false},
+		{"embargoed", map[string]*string{"Available-From": aws.String("2021-01-01T12:00:00Z")}, time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC), true},
+		{"key case", map[string]*string{"available-from": aws.String("2021-01-01T12:00:00Z")}, time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC), true},
+		{"unreadable time", map[string]*string{"Available-From": aws.String("tomorrow")}, time.Time{}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := AvailableFrom(&s3.HeadObjectOutput{Metadata: test.metadata})
+			if !got.Equal(test.want) || ok != test.ok {
+				t.Errorf("AvailableFrom() = %v, %v, want %v, %v", got, ok, test.want, test.ok)
+			}
+		})
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	tests := []struct {
+		name          string
+		metadata      map[string]*string
+		cipher, keyID string
+		ok            bool
+	}{
+		{"not encrypted", nil, "", "", false},
+		{"cipher", map[string]*string{"Encryption": aws.String("AES-256-GCM")}, "AES-256-GCM", "", true},
+		{"cipher and key", map[string]*string{"encryption": aws.String("AES-256-GCM"), "encryption-key-id": aws.String("k1")}, "AES-256-GCM", "k1", true},
+		{"key only", map[string]*string{"Encryption-Key-Id": aws.String("k1")}, "", "k1", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cipher, keyID, ok := Encryption(&s3.HeadObjectOutput{Metadata: test.metadata})
+			if cipher != test.cipher || keyID != test.keyID || ok != test.ok {
+				t.Errorf("Encryption() = %q, %q, %v, want %q, %q, %v", cipher, keyID, ok, test.cipher, test.keyID, test.ok)
+			}
+		})
+	}
+}
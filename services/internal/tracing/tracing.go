@@ -0,0 +1,410 @@
+// Package tracing records AWS X-Ray subsegments for the services' AWS requests and HTTP callbacks, and propagates
+// their trace headers to the services and SQS messages they send to, so an upload can be traced across functions;
+// segment documents are sent to the X-Ray daemon at the AWS_XRAY_DAEMON_ADDRESS env parameter, which Lambda sets
+// when tracing is active, and nothing is recorded for requests that are not sampled. If OTLP export is configured
+// (see the otlp package), every segment is also exported as a span, whether or not X-Ray sampled it
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/okebinda/internal/otlp"
+)
+
+// Header is the HTTP header a trace is propagated in
+const Header = "X-Amzn-Trace-Id"
+
+// SQSAttribute is the SQS message system attribute a trace is propagated in
+const SQSAttribute = "AWSTraceHeader"
+
+// Namespaces of subsegments, for calls to AWS services and to other HTTP APIs
+const (
+	NamespaceAWS    = "aws"
+	NamespaceRemote = "remote"
+)
+
+// lambdaTraceKey is the context key the Lambda runtime sets the invocation's trace header under
+const lambdaTraceKey = "x-amzn-trace-id"
+
+// defaultDaemonAddress is the address of the X-Ray daemon if AWS_XRAY_DAEMON_ADDRESS is not set
+const defaultDaemonAddress = "127.0.0.1:2000"
+
+// daemonHeader precedes each segment document sent to the daemon
+const daemonHeader = `{"format": "json", "version": 1}` + "\n"
+
+// TraceHeader defines a trace header: the trace's root ID, the ID of the segment that sent it, which segments
+// recorded for it are nested under, and if the trace is sampled
+type TraceHeader struct {
+	Root    string
+	Parent  string
+	Sampled bool
+}
+
+// ParseHeader reads a trace header, e.g. "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1"
+func ParseHeader(value string) TraceHeader {
+	var header TraceHeader
+	for _, part := range strings.Split(value, ";") {
+		fields := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "Root":
+			header.Root = fields[1]
+		case "Parent":
+			header.Parent = fields[1]
+		case "Sampled":
+			header.Sampled = fields[1] == "1"
+		}
+	}
+	return header
+}
+
+// String formats a trace header to propagate it
+func (h TraceHeader) String() string {
+	value := "Root=" + h.Root
+	if h.Parent != "" {
+		value += ";Parent=" + h.Parent
+	}
+	if h.Sampled {
+		return value + ";Sampled=1"
+	}
+	return value + ";Sampled=0"
+}
+
+// traceKey is the context key of the trace header set by WithHeader
+type traceKey struct{}
+
+// WithHeader returns a copy of ctx whose trace header is header
+func WithHeader(ctx context.Context, header TraceHeader) context.Context {
+	return context.WithValue(ctx, traceKey{}, header)
+}
+
+// FromContext returns the trace header of ctx: the one set by WithHeader, else the Lambda invocation's, if any
+func FromContext(ctx context.Context) (TraceHeader, bool) {
+	if header, ok := ctx.Value(traceKey{}).(TraceHeader); ok {
+		return header, true
+	}
+	if value, ok := ctx.Value(lambdaTraceKey).(string); ok && value != "" {
+		header := ParseHeader(value)
+		return header, header.Root != ""
+	}
+	return TraceHeader{}, false
+}
+
+// Segment defines an X-Ray segment document; subsegments are sent as their own documents, nested under their
+// parent by ID
+type Segment struct {
+	TraceID     string                 `json:"trace_id"`
+	ID          string                 `json:"id"`
+	ParentID    string                 `json:"parent_id,omitempty"`
+	Type        string                 `json:"type,omitempty"`
+	Name        string                 `json:"name"`
+	Origin      string                 `json:"origin,omitempty"`
+	Namespace   string                 `json:"namespace,omitempty"`
+	StartTime   float64                `json:"start_time"`
+	EndTime     float64                `json:"end_time"`
+	Error       bool                   `json:"error,omitempty"`
+	Fault       bool                   `json:"fault,omitempty"`
+	HTTP        *HTTP                  `json:"http,omitempty"`
+	AWS         map[string]interface{} `json:"aws,omitempty"`
+	Annotations map[string]string      `json:"annotations,omitempty"`
+	Cause       *Cause                 `json:"cause,omitempty"`
+
+	// sampled is if X-Ray sampled the trace, so the segment is sent to the daemon
+	sampled bool
+}
+
+// HTTP defines the HTTP request and response a segment records
+type HTTP struct {
+	Request  HTTPRequest   `json:"request"`
+	Response *HTTPResponse `json:"response,omitempty"`
+}
+
+// HTTPRequest defines the HTTP request a segment records
+type HTTPRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+}
+
+// HTTPResponse defines the HTTP response a segment records
+type HTTPResponse struct {
+	Status int `json:"status"`
+}
+
+// Cause defines the error a segment failed with
+type Cause struct {
+	Exceptions []Exception `json:"exceptions"`
+}
+
+// Exception defines an error recorded in a Cause
+type Exception struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
+
+// Begin starts a subsegment of ctx's trace, returning it, and a copy of ctx whose trace header names it as the
+// parent, so subsegments started and headers propagated with that context are nested under it; if ctx has no
+// trace, or it is not sampled and no spans are exported, ctx and a nil subsegment, which records nothing, are returned
+func Begin(ctx context.Context, name, namespace string) (context.Context, *Segment) {
+	header, ok := FromContext(ctx)
+	if !ok || !recorded(header) || header.Parent == "" {
+		return ctx, nil
+	}
+	segment := &Segment{
+		TraceID:   header.Root,
+		ID:        newID(),
+		ParentID:  header.Parent,
+		Type:      "subsegment",
+		Name:      name,
+		Namespace: namespace,
+		StartTime: epochSeconds(time.Now()),
+		sampled:   header.Sampled,
+	}
+	header.Parent = segment.ID
+	return WithHeader(ctx, header), segment
+}
+
+// Continue starts a segment, named for the service, of the trace in a header received from another function, e.g.
+// an SQS message's AWSTraceHeader attribute, so work done for it is traced with the request that sent it, returning
+// it and a copy of ctx whose trace header names it as the parent; if the header is empty, or not sampled and no
+// spans are exported, ctx and a nil segment are returned
+func Continue(ctx context.Context, value, name string) (context.Context, *Segment) {
+	header := ParseHeader(value)
+	if header.Root == "" || !recorded(header) {
+		return ctx, nil
+	}
+	segment := &Segment{
+		TraceID:   header.Root,
+		ID:        newID(),
+		ParentID:  header.Parent,
+		Name:      name,
+		Origin:    "AWS::Lambda::Function",
+		StartTime: epochSeconds(time.Now()),
+		sampled:   header.Sampled,
+	}
+	header.Parent = segment.ID
+	return WithHeader(ctx, header), segment
+}
+
+// recorded tests if segments are recorded for a trace: if X-Ray sampled it, or spans are exported
+func recorded(header TraceHeader) bool {
+	return header.Sampled || otlp.TracesEnabled()
+}
+
+// Annotate adds an annotation to a segment, indexed for filtering traces, e.g. an upload's file ID
+func (s *Segment) Annotate(key, value string) {
+	if s == nil {
+		return
+	}
+	if s.Annotations == nil {
+		s.Annotations = map[string]string{}
+	}
+	s.Annotations[key] = value
+}
+
+// End ends a segment, recording err as its cause, if set, and the segment as a fault, unless it is already recorded
+// as an error, and sends it to the daemon and exports it; failures to send are ignored, so tracing never affects the
+// work traced
+func (s *Segment) End(err error) {
+	if s == nil {
+		return
+	}
+	s.EndTime = epochSeconds(time.Now())
+	if err != nil {
+		s.Fault = !s.Error
+		s.Cause = &Cause{Exceptions: []Exception{{ID: newID(), Message: err.Error()}}}
+	}
+	send(s)
+}
+
+// EndHTTP ends a segment recording an HTTP request, with the response's status code, or 0 if there was no
+// response; 4xx responses are recorded as errors, and 5xx responses and failed requests as faults. The URL is
+// recorded without its query, which may carry credentials
+func (s *Segment) EndHTTP(method, url string, status int, err error) {
+	if s == nil {
+		return
+	}
+	if i := strings.IndexByte(url, '?'); i >= 0 {
+		url = url[:i]
+	}
+	s.HTTP = &HTTP{Request: HTTPRequest{Method: method, URL: url}}
+	if status != 0 {
+		s.HTTP.Response = &HTTPResponse{Status: status}
+	}
+	s.Error = status >= 400 && status < 500
+	if err == nil && status >= 500 {
+		err = fmt.Errorf("HTTP status %d", status)
+	}
+	s.End(err)
+}
+
+// Instrument returns a copy of a session whose requests are each recorded as a subsegment of the trace of their
+// context, with the operation, region, and request ID, and sent with its trace header; messages sent to SQS also
+// carry the trace in their AWSTraceHeader attribute, so the functions processing them can continue it
+func Instrument(sess *session.Session) *session.Session {
+	copied := sess.Copy()
+	copied.Handlers.Validate.PushBack(beginRequest)
+	copied.Handlers.Build.PushBack(propagateRequest)
+	copied.Handlers.Complete.PushBack(endRequest)
+	return copied
+}
+
+// requestKey is the context key of an AWS request's subsegment
+type requestKey struct{}
+
+// beginRequest starts the subsegment of an AWS request, unless it is presigned, as it is not sent
+func beginRequest(r *request.Request) {
+	if r.ExpireTime != 0 {
+		return
+	}
+	ctx, segment := Begin(r.Context(), r.ClientInfo.ServiceName, NamespaceAWS)
+	if segment == nil {
+		return
+	}
+	r.SetContext(context.WithValue(ctx, requestKey{}, segment))
+	if input, ok := r.Params.(*sqs.SendMessageInput); ok {
+		header, _ := FromContext(ctx)
+		if input.MessageSystemAttributes == nil {
+			input.MessageSystemAttributes = map[string]*sqs.MessageSystemAttributeValue{}
+		}
+		if _, ok := input.MessageSystemAttributes[SQSAttribute]; !ok {
+			input.MessageSystemAttributes[SQSAttribute] = &sqs.MessageSystemAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(header.String()),
+			}
+		}
+	}
+}
+
+// propagateRequest sets the trace header of an AWS request, so the service can continue the trace
+func propagateRequest(r *request.Request) {
+	if header, ok := FromContext(r.Context()); ok && r.ExpireTime == 0 {
+		r.HTTPRequest.Header.Set(Header, header.String())
+	}
+}
+
+// endRequest ends the subsegment of an AWS request
+func endRequest(r *request.Request) {
+	segment, ok := r.Context().Value(requestKey{}).(*Segment)
+	if !ok {
+		return
+	}
+	segment.AWS = map[string]interface{}{
+		"operation":  r.Operation.Name,
+		"region":     aws.StringValue(r.Config.Region),
+		"request_id": r.RequestID,
+		"retries":    r.RetryCount,
+	}
+	status := 0
+	if r.HTTPResponse != nil {
+		status = r.HTTPResponse.StatusCode
+	}
+	segment.EndHTTP(r.Operation.HTTPMethod, r.HTTPRequest.URL.String(), status, r.Error)
+}
+
+// daemon is the connection to the X-Ray daemon, opened on first use
+var daemon struct {
+	sync.Mutex
+	conn net.Conn
+}
+
+// send sends a segment document to the X-Ray daemon, if its trace is sampled, and exports it as a span, if enabled
+func send(s *Segment) {
+	if otlp.TracesEnabled() {
+		_ = otlp.ExportSpan(s.span())
+	}
+	if !s.sampled {
+		return
+	}
+	document, err := json.Marshal(s)
+	if err != nil {
+		return
+	}
+	daemon.Lock()
+	defer daemon.Unlock()
+	if daemon.conn == nil {
+		address := os.Getenv("AWS_XRAY_DAEMON_ADDRESS")
+		if address == "" {
+			address = defaultDaemonAddress
+		}
+
+		// the address may name separate UDP and TCP addresses, e.g. "udp:127.0.0.1:2000 tcp:127.0.0.1:2000"
+		for _, part := range strings.Fields(address) {
+			if strings.HasPrefix(part, "udp:") {
+				address = strings.TrimPrefix(part, "udp:")
+			}
+		}
+		if daemon.conn, err = net.Dial("udp", address); err != nil {
+			return
+		}
+	}
+	_, _ = daemon.conn.Write(append([]byte(daemonHeader), document...))
+}
+
+// span converts a segment to an OpenTelemetry span: the trace ID is the X-Ray trace ID's hex digits, subsegments are
+// client spans, and segments continuing a trace from a queue are consumer spans
+func (s *Segment) span() otlp.Span {
+	span := otlp.Span{
+		TraceID:      strings.ReplaceAll(strings.TrimPrefix(s.TraceID, "1-"), "-", ""),
+		SpanID:       s.ID,
+		ParentSpanID: s.ParentID,
+		Name:         s.Name,
+		Kind:         otlp.KindConsumer,
+		Start:        fromEpochSeconds(s.StartTime),
+		End:          fromEpochSeconds(s.EndTime),
+		Attributes:   map[string]interface{}{},
+	}
+	if s.Type == "subsegment" {
+		span.Kind = otlp.KindClient
+	}
+	for key, value := range s.Annotations {
+		span.Attributes[key] = value
+	}
+	for key, value := range s.AWS {
+		span.Attributes["aws."+key] = value
+	}
+	if s.HTTP != nil {
+		span.Attributes["http.method"] = s.HTTP.Request.Method
+		span.Attributes["http.url"] = s.HTTP.Request.URL
+		if s.HTTP.Response != nil {
+			span.Attributes["http.status_code"] = s.HTTP.Response.Status
+		}
+	}
+	if s.Cause != nil && len(s.Cause.Exceptions) > 0 {
+		span.Error = s.Cause.Exceptions[0].Message
+	} else if s.Error && s.HTTP != nil && s.HTTP.Response != nil {
+		span.Error = fmt.Sprintf("HTTP status %d", s.HTTP.Response.Status)
+	}
+	return span
+}
+
+// newID generates a random segment ID, 16 hex digits
+func newID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// epochSeconds converts a time to seconds since the epoch, as segment documents record it
+func epochSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// fromEpochSeconds converts seconds since the epoch, as segment documents record them, to a time
+func fromEpochSeconds(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
@@ -0,0 +1,227 @@
+// Package webhooks keeps the webhook subscriptions of the services in a DynamoDB table, keyed by subscription ID
+// and indexed by URL: the URL that events are posted to, and the secrets they are signed with
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// AllEvents subscribes to every event
+const AllEvents = "*"
+
+// URLIndex is the name of the table's global secondary index on the url attribute, so the subscription for a URL
+// is read without scanning the table
+const URLIndex = "url-index"
+
+// secretBytes is the number of random bytes in a generated secret
+const secretBytes = 32
+
+// Subscription defines a webhook subscription: the URL its events are posted to and the secret they are signed
+// with; after a rotation the previous secret also signs them until PreviousSecretExpiresAt, so receivers can
+// switch to the new secret without rejecting any callbacks
+type Subscription struct {
+	SubscriptionID          string   `dynamodbav:"subscription_id" json:"subscription_id"`
+	URL                     string   `dynamodbav:"url" json:"url"`
+	Events                  []string `dynamodbav:"events" json:"events"`
+	Secret                  string   `dynamodbav:"secret" json:"-"`
+	PreviousSecret          string   `dynamodbav:"previous_secret,omitempty" json:"-"`
+	PreviousSecretExpiresAt string   `dynamodbav:"previous_secret_expires_at,omitempty" json:"previous_secret_expires_at,omitempty"`
+	CreatedAt               string   `dynamodbav:"created_at" json:"created_at"`
+	UpdatedAt               string   `dynamodbav:"updated_at" json:"updated_at"`
+}
+
+// Subscribed tests if a subscription receives an event
+func (s Subscription) Subscribed(event string) bool {
+	for _, e := range s.Events {
+		if e == event || e == AllEvents {
+			return true
+		}
+	}
+	return false
+}
+
+// Secrets returns the secrets a subscription's callbacks are signed with at a time: the current secret, then the
+// previous secret while it is still valid
+func (s Subscription) Secrets(now time.Time) []string {
+	secrets := []string{s.Secret}
+	if s.PreviousSecret == "" {
+		return secrets
+	}
+	if expires, err := time.Parse(time.RFC3339, s.PreviousSecretExpiresAt); err == nil && now.Before(expires) {
+		secrets = append(secrets, s.PreviousSecret)
+	}
+	return secrets
+}
+
+// Rotate replaces a subscription's secret, keeping the current secret valid for window
+func (s *Subscription) Rotate(secret string, now time.Time, window time.Duration) {
+	s.PreviousSecret = ""
+	s.PreviousSecretExpiresAt = ""
+	if window > 0 {
+		s.PreviousSecret = s.Secret
+		s.PreviousSecretExpiresAt = now.Add(window).UTC().Format(time.RFC3339)
+	}
+	s.Secret = secret
+	s.UpdatedAt = now.UTC().Format(time.RFC3339)
+}
+
+// NewSecret generates a random secret, hex encoded
+func NewSecret() (string, error) {
+	secret := make([]byte, secretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// Put records a subscription, replacing any with the same ID
+func Put(sess *session.Session, table string, subscription Subscription) error {
+	item, err := dynamodbattribute.MarshalMap(subscription)
+	if err != nil {
+		return err
+	}
+	_, err = dynamodb.New(sess).PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item:      item,
+	})
+	forget(table)
+	return err
+}
+
+// Get reads a subscription; returns false if there is none
+func Get(sess *session.Session, table, subscriptionID string) (Subscription, bool, error) {
+	var subscription Subscription
+	output, err := dynamodb.New(sess).GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"subscription_id": {S: aws.String(subscriptionID)},
+		},
+	})
+	if err != nil || output.Item == nil {
+		return subscription, false, err
+	}
+	err = dynamodbattribute.UnmarshalMap(output.Item, &subscription)
+	return subscription, err == nil, err
+}
+
+// GetByURL reads the subscription for a URL from the table's URLIndex; returns false if there is none
+func GetByURL(sess *session.Session, table, url string) (Subscription, bool, error) {
+	var subscription Subscription
+	output, err := dynamodb.New(sess).Query(&dynamodb.QueryInput{
+		TableName:              aws.String(table),
+		IndexName:              aws.String(URLIndex),
+		KeyConditionExpression: aws.String("#url = :url"),
+		ExpressionAttributeNames: map[string]*string{
+			"#url": aws.String("url"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":url": {S: aws.String(url)},
+		},
+		Limit: aws.Int64(1),
+	})
+	if err != nil || len(output.Items) == 0 {
+		return subscription, false, err
+	}
+	err = dynamodbattribute.UnmarshalMap(output.Items[0], &subscription)
+	return subscription, err == nil, err
+}
+
+// List reads every subscription
+func List(sess *session.Session, table string) ([]Subscription, error) {
+	subscriptions := []Subscription{}
+	var pageErr error
+	err := dynamodb.New(sess).ScanPages(&dynamodb.ScanInput{
+		TableName: aws.String(table),
+	}, func(output *dynamodb.ScanOutput, lastPage bool) bool {
+		var page []Subscription
+		if pageErr = dynamodbattribute.UnmarshalListOfMaps(output.Items, &page); pageErr != nil {
+			return false
+		}
+		subscriptions = append(subscriptions, page...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return subscriptions, pageErr
+}
+
+// Delete deletes a subscription; returns false if there was none
+func Delete(sess *session.Session, table, subscriptionID string) (bool, error) {
+	output, err := dynamodb.New(sess).DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"subscription_id": {S: aws.String(subscriptionID)},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+	})
+	forget(table)
+	if err != nil {
+		return false, err
+	}
+	return output.Attributes != nil, nil
+}
+
+// cache holds the subscriptions last read by ListCached, for each table
+var cache = struct {
+	sync.Mutex
+	tables map[string]cachedList
+}{tables: map[string]cachedList{}}
+
+// cachedList defines the subscriptions of a table read at a time
+type cachedList struct {
+	subscriptions []Subscription
+	readAt        time.Time
+}
+
+// ListCached reads every subscription like List, but only scans the table if it was not read within maxAge, so
+// events fanned out to every subscription do not each read the whole table; subscriptions changed by other
+// function instances are seen once the cache is older than maxAge
+func ListCached(sess *session.Session, table string, maxAge time.Duration, now time.Time) ([]Subscription, error) {
+	cache.Lock()
+	cached, ok := cache.tables[table]
+	cache.Unlock()
+	if ok && now.Sub(cached.readAt) < maxAge {
+		return cached.subscriptions, nil
+	}
+	subscriptions, err := List(sess, table)
+	if err != nil {
+		return nil, err
+	}
+	cache.Lock()
+	cache.tables[table] = cachedList{subscriptions: subscriptions, readAt: now}
+	cache.Unlock()
+	return subscriptions, nil
+}
+
+// forget drops a table's cached subscriptions once they are changed by this function instance
+func forget(table string) {
+	cache.Lock()
+	delete(cache.tables, table)
+	cache.Unlock()
+}
+
+// SecretsForURL returns the secrets to sign a callback to a URL with at a time: those of the URL's subscription in
+// table, if table is set and there is one, otherwise fallback, e.g. the CALLBACK_SECRET env parameter; callbacks
+// without a URL, e.g. published to a topic, are signed with fallback
+func SecretsForURL(sess *session.Session, table, url, fallback string, now time.Time) ([]string, error) {
+	if table == "" || url == "" {
+		return []string{fallback}, nil
+	}
+	subscription, ok, err := GetByURL(sess, table, url)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return subscription.Secrets(now), nil
+	}
+	return []string{fallback}, nil
+}
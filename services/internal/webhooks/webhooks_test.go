@@ -0,0 +1,65 @@
+package webhooks
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSubscriptionSecrets(t *testing.T) {
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		subscription Subscription
+		want         []string
+	}{
+		{"current only", Subscription{Secret: "new"}, []string{"new"}},
+		{"previous in window", Subscription{Secret: "new", PreviousSecret: "old", PreviousSecretExpiresAt: "2021-01-01T13:00:00Z"}, []string{"new", "old"}},
+		{"previous expired", Subscription{Secret: "new", PreviousSecret: "old", PreviousSecretExpiresAt: "2021-01-01T11:00:00Z"}, []string{"new"}},
+		{"previous without expiry", Subscription{Secret: "new", PreviousSecret: "old"}, []string{"new"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.subscription.Secrets(now); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Secrets() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSecretsForURLWithoutTable(t *testing.T) {
+	tests := []struct {
+		name, table, url string
+	}{
+		{"no table", "", "https://example.com/hook"},
+		{"no URL", "webhooks", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			// neither case may read the table, so no session is needed
+			secrets, err := SecretsForURL(nil, test.table, test.url, "fallback", time.Now())
+			if err != nil || !reflect.DeepEqual(secrets, []string{"fallback"}) {
+				t.Errorf("SecretsForURL() = %v, %v, want [fallback]", secrets, err)
+			}
+		})
+	}
+}
+
+func TestListCached(t *testing.T) {
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	cached := []Subscription{{SubscriptionID: "a", URL: "https://example.com/hook"}}
+	cache.tables["webhooks"] = cachedList{subscriptions: cached, readAt: now.Add(-30 * time.Second)}
+	defer forget("webhooks")
+
+	// a fresh list is returned without reading the table, so no session is needed
+	subscriptions, err := ListCached(nil, "webhooks", time.Minute, now)
+	if err != nil || !reflect.DeepEqual(subscriptions, cached) {
+		t.Errorf("ListCached() = %v, %v, want %v", subscriptions, err, cached)
+	}
+
+	forget("webhooks")
+	if _, ok := cache.tables["webhooks"]; ok {
+		t.Error("forget() kept the cached list")
+	}
+}